@@ -20,23 +20,73 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/grafeas/kritis/pkg/kritis/admission"
+	"github.com/grafeas/kritis/pkg/kritis/constants"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
 	"github.com/grafeas/kritis/pkg/kritis/cron"
 	kubernetesutil "github.com/grafeas/kritis/pkg/kritis/kubernetes"
 	"github.com/grafeas/kritis/pkg/kritis/metadata/containeranalysis"
+	"github.com/grafeas/kritis/pkg/kritis/metrics"
+	"github.com/grafeas/kritis/pkg/kritis/sbom"
+	"github.com/grafeas/kritis/pkg/kritis/util"
 	"github.com/pkg/errors"
 	"k8s.io/client-go/kubernetes"
 )
 
 var (
-	tlsCertFile  string
-	tlsKeyFile   string
-	cronInterval string
+	tlsCertFile      string
+	tlsKeyFile       string
+	clientCAFile     string
+	minTLSVersion    string
+	cipherSuites     string
+	cronInterval     string
+	cronWorkers      int
+	cronChecksPerSec float64
+	cronPrune        bool
+	asyncAttestation bool
+	requirePolicy    bool
+	imageWhitelist   string
+	pauseImages      string
+	exemptMirrorPods bool
+	requirePullable  bool
+	suggestUpgrades  bool
+	resolveTags      bool
+	denyOtherOps     bool
+	failurePolicy    string
+	neverPullPolicy  string
+	unresolvedPolicy string
+	exemptNamespaces string
+	exemptSelectors  string
+	kevFile          string
+	sbomDBFile       string
+	defaultPolicyNs  string
+	maxExplained     int
+	policyBundle     string
+	bundleKeyFile    string
+	policySignKey    string
+	opaPolicyFile    string
+	metricsNs        string
+	metricsNsLimit   int
+	buildTokenKey    string
+	configTokenFile  string
+	decisionLogFile  string
+	captureSize      int
+	registryCAFile   string
+	registryCredFile string
+	inClusterHosts   string
+	inClusterPolicy  string
+	configMap        string
+	metadataBackends string
 )
 
 const (
@@ -46,29 +96,249 @@ const (
 func main() {
 	flag.StringVar(&tlsCertFile, "tls-cert-file", "/var/tls/tls.crt", "TLS certificate file.")
 	flag.StringVar(&tlsKeyFile, "tls-key-file", "/var/tls/tls.key", "TLS key file.")
+	flag.StringVar(&clientCAFile, "client-ca-file", "", "CA bundle which callers' client certificates must be signed by. By default client certificates aren't required.")
+	flag.StringVar(&minTLSVersion, "min-tls-version", "", "Minimum TLS version, 1.0, 1.1, 1.2 or 1.3. By default the Go default applies.")
+	flag.StringVar(&cipherSuites, "tls-cipher-suites", "", "Comma separated TLS 1.0 to 1.2 cipher suites allowed, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. By default the Go defaults apply.")
 	flag.Set("logtostderr", "true")
 	flag.StringVar(&cronInterval, "cron-interval", "1h", "Cron Job time interval as Duration e.g. 1h, 2s")
+	flag.IntVar(&cronWorkers, "cron-workers", 1, "Number of images the cron job checks at once.")
+	flag.Float64Var(&cronChecksPerSec, "cron-checks-per-second", 0, "Maximum images the cron job checks per second, or 0 for no limit.")
+	flag.BoolVar(&cronPrune, "cron-prune-expired-whitelists", false, "Remove expired CVE whitelist entries from ImageSecurityPolicies, instead of only reporting them.")
+	flag.BoolVar(&asyncAttestation, "async-attestation", false, "Create attestations in the background after admitting a pod.")
+	flag.BoolVar(&requirePolicy, "require-policy", false, "Deny pods in namespaces without an ImageSecurityPolicy.")
+	flag.BoolVar(&exemptMirrorPods, "exempt-mirror-pods", false, "Admit mirror pods of static pods without validating them.")
+	flag.BoolVar(&requirePullable, "require-pullable-images", false, "Deny pods with images which can't be pulled with their imagePullSecrets, such as images which don't exist.")
+	flag.BoolVar(&suggestUpgrades, "suggest-image-upgrades", false, "Warn, when admitting pods, of images whose repository has a newer patch release of their tag.")
+	flag.BoolVar(&resolveTags, "resolve-tags", false, "Validate images referenced by tag as the digest the tag points to.")
+	flag.BoolVar(&denyOtherOps, "deny-other-operations", false, "Deny requests for operations other than CREATE and UPDATE, such as DELETE or CONNECT, instead of admitting them.")
+	flag.StringVar(&imageWhitelist, "image-whitelist", strings.Join(constants.GlobalImageWhitelist, ","), "Comma separated kritis infrastructure images which are always admitted.")
+	flag.StringVar(&pauseImages, "pause-images", strings.Join(constants.PauseImages, ","), "Comma separated pod sandbox images which are never validated.")
+	flag.StringVar(&failurePolicy, "failure-policy", "", "Fail or Ignore to deny or admit pods which couldn't be validated. By default the webhook's failurePolicy applies.")
+	flag.StringVar(&neverPullPolicy, "never-pull-policy", "", "Deny, Allow or Validate to deny, admit or validate as best as possible images with imagePullPolicy Never. By default they are validated like other images.")
+	flag.StringVar(&unresolvedPolicy, "unresolved-image-policy", "", "Deny or Allow to deny, or admit without validation, images which aren't literal image references, such as unsubstituted $(IMAGE) placeholders. By default they are denied.")
+	flag.StringVar(&exemptNamespaces, "exempt-namespaces", strings.Join(constants.DefaultExemptNamespaces, ","), "Comma separated namespaces whose pods are admitted without validation. Set it to \"\" to validate pods in every namespace, including kube-system.")
+	flag.StringVar(&exemptSelectors, "exempt-pod-selectors", "", "Semicolon separated namespaces and label selectors of system pods in them which are admitted without validation, and logged, e.g. kube-system:app.kubernetes.io/component=csi-driver.")
+	flag.StringVar(&kevFile, "known-exploited-cves-file", "", "File with the Known Exploited Vulnerabilities list, as the CISA catalog JSON or one CVE ID per line.")
+	flag.StringVar(&sbomDBFile, "sbom-vulnerability-db-file", "", "File with the vulnerability database SBOM components are cross-referenced against by policies with evaluateSBOM, as a JSON list of advisories.")
+	flag.StringVar(&defaultPolicyNs, "default-policy-namespace", "", "Namespace whose ImageSecurityPolicies apply to namespaces without their own.")
+	flag.IntVar(&maxExplained, "max-explained-violations", 100, "Maximum violations listed by /explain, or 0 for no limit.")
+	flag.StringVar(&policyBundle, "policy-bundle", "", "OCI reference of a signed policy bundle whose ImageSecurityPolicies are used instead of those in the cluster.")
+	flag.StringVar(&bundleKeyFile, "policy-bundle-key-file", "", "File with the base64 encoded, armored PGP public key the policy bundle must be signed by.")
+	flag.StringVar(&policySignKey, "policy-signing-key-file", "", "File with the base64 encoded, armored PGP public key ImageSecurityPolicies in the cluster must be signed by. Pods are denied if a policy of their namespace isn't. By default policies aren't verified.")
+	flag.StringVar(&metricsNs, "metrics-namespaces", "", "Comma separated namespaces, or patterns such as team-*, whose metrics are labeled with their namespace. By default the first --metrics-namespace-limit namespaces are. Other namespaces' metrics are labeled _other.")
+	flag.IntVar(&metricsNsLimit, "metrics-namespace-limit", 0, "Maximum namespaces metrics are labeled with without --metrics-namespaces, or 0 for the default of 50.")
+	flag.StringVar(&opaPolicyFile, "opa-policy-file", "", "File with the Rego policy whose decisions ImageSecurityPolicies with an opaDecision delegate to.")
+	flag.StringVar(&buildTokenKey, "build-token-key-file", "", "File with the base64 encoded, armored PGP public key CI signs build tokens with. By default build tokens are ignored.")
+	flag.StringVar(&configTokenFile, "config-token-file", "", "File with the bearer token required by /config, /explain and the /debug endpoints. By default they don't require one.")
+	flag.StringVar(&decisionLogFile, "decision-log-file", "", "File every admission decision is appended to as a JSON line, for audit.")
+	flag.IntVar(&captureSize, "capture-size", 0, "Number of the most recent admission requests captured, with secrets redacted, for /debug/admissions and /debug/replay. By default none are.")
+	flag.StringVar(&registryCAFile, "registry-ca-file", "", "File with PEM encoded CAs registries' certificates may be signed by, in addition to the system's. Registries are reached through the HTTPS_PROXY and HTTP_PROXY proxies, except for NO_PROXY hosts.")
+	flag.StringVar(&registryCredFile, "registry-credentials-file", "", "File with a Docker config, such as the .dockerconfigjson of a secret, whose credentials registries are authenticated with to fetch image manifests, configs and SBOMs. By default registries are reached anonymously.")
+	flag.StringVar(&inClusterHosts, "in-cluster-registries", "", "Comma separated hosts of registries running in the cluster, e.g. registry.kube-system.svc:5000, whose images are validated against --in-cluster-registry-policy instead of the pod's ImageSecurityPolicies.")
+	flag.StringVar(&inClusterPolicy, "in-cluster-registry-policy", "", "namespace/name of the ImageSecurityPolicy images from --in-cluster-registries are validated against.")
+	flag.StringVar(&metadataBackends, "metadata-backends", "", "Comma separated names and Grafeas API endpoints of metadata backends ImageSecurityPolicies can select with metadataBackend, e.g. grafeas=grafeas.security.svc:8080.")
+	flag.StringVar(&configMap, "config-map", "", "namespace/name of a ConfigMap overriding these flags with its config.yaml key.")
 	flag.Parse()
 
+	exemptPodSelectors, err := parseExemptPodSelectors(exemptSelectors)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "invalid flags"))
+	}
+	backends, err := parseMetadataBackends(metadataBackends)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "invalid flags"))
+	}
+	options := admission.Options{
+		AsyncAttestation:        asyncAttestation,
+		RequirePolicy:           requirePolicy,
+		ExemptMirrorPods:        exemptMirrorPods,
+		RequirePullableImages:   requirePullable,
+		SuggestImageUpgrades:    suggestUpgrades,
+		ResolveTags:             resolveTags,
+		DenyOtherOperations:     denyOtherOps,
+		FailurePolicy:           failurePolicy,
+		NeverPullPolicy:         neverPullPolicy,
+		UnresolvedImagePolicy:   unresolvedPolicy,
+		ExemptNamespaces:        splitList(exemptNamespaces),
+		ExemptPodSelectors:      exemptPodSelectors,
+		ImageWhitelist:          splitList(imageWhitelist),
+		PauseImages:             splitList(pauseImages),
+		DefaultPolicyNamespace:  defaultPolicyNs,
+		MaxExplainedViolations:  maxExplained,
+		PolicyBundle:            policyBundle,
+		CaptureSize:             captureSize,
+		InClusterRegistries:     splitList(inClusterHosts),
+		InClusterRegistryPolicy: inClusterPolicy,
+		MetricsNamespaces:       splitList(metricsNs),
+		MetricsNamespaceLimit:   metricsNsLimit,
+		MetadataBackends:        backends,
+	}
+	if bundleKeyFile != "" {
+		key, err := ioutil.ReadFile(bundleKeyFile)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "reading policy bundle key"))
+		}
+		options.PolicyBundlePublicKey = strings.TrimSpace(string(key))
+	}
+	if policySignKey != "" {
+		key, err := ioutil.ReadFile(policySignKey)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "reading policy signing key"))
+		}
+		options.PolicySigningPublicKey = strings.TrimSpace(string(key))
+	}
+	if buildTokenKey != "" {
+		key, err := ioutil.ReadFile(buildTokenKey)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "reading build token key"))
+		}
+		options.BuildTokenPublicKey = strings.TrimSpace(string(key))
+	}
+	if opaPolicyFile != "" {
+		policy, err := ioutil.ReadFile(opaPolicyFile)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "reading OPA policy"))
+		}
+		options.OPAPolicy = string(policy)
+	}
+	transport, err := util.NewRegistryTransport(util.RegistryTransportOptions{CAFile: registryCAFile})
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "configuring registry transport"))
+	}
+	util.SetRegistryTransport(transport)
+	if registryCredFile != "" {
+		data, err := ioutil.ReadFile(registryCredFile)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "reading registry credentials"))
+		}
+		creds, err := util.ParseDockerConfig(data)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "parsing registry credentials"))
+		}
+		util.SetRegistryCredentials(creds)
+	}
+	if kevFile != "" {
+		cves, err := securitypolicy.LoadKnownExploitedCVEs(kevFile)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "loading known exploited CVEs"))
+		}
+		options.KnownExploitedCVEs = cves
+	}
+	if sbomDBFile != "" {
+		db, err := sbom.LoadDatabase(sbomDBFile)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "loading SBOM vulnerability database"))
+		}
+		securitypolicy.SetSBOMDatabase(db)
+	}
+	if err := options.Validate(); err != nil {
+		logrus.Fatal(errors.Wrap(err, "invalid flags"))
+	}
+	admission.SetOptions(options)
+	if flag.Arg(0) == "attest-all" {
+		attestAll(flag.Args()[1:])
+		return
+	}
+	if decisionLogFile != "" {
+		sink, err := admission.NewFileSink(decisionLogFile)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "opening decision log"))
+		}
+		admission.SetDecisionSink(sink)
+	}
+
+	// Override flags with the ConfigMap, and keep them up to date with it.
+	if configMap != "" {
+		parts := strings.Split(configMap, "/")
+		if len(parts) != 2 {
+			logrus.Fatalf("--config-map must be namespace/name, got %q", configMap)
+		}
+		go admission.WatchConfigMap(context.Background(), parts[0], parts[1])
+	}
+
 	// Kick off back ground cron job.
 	if err := StartCronJob(); err != nil {
 		logrus.Fatal(errors.Wrap(err, "starting background job"))
 	}
 
+	// Serve policies from a cache instead of listing them on every admission.
+	if err := admission.CacheImageSecurityPolicies(context.Background()); err != nil {
+		logrus.Warnf("error caching image security policies, listing them on every admission: %v", err)
+	}
+
+	// Flush cached admission decisions when policies change.
+	go admission.WatchImageSecurityPolicies(context.Background())
+
 	// Start the Kritis Server.
 	logrus.Println("Running the server")
 	http.HandleFunc("/", admission.AdmissionReviewHandler)
-	httpsServer := NewServer(Addr)
+	http.HandleFunc("/explain", admission.ExplainHandler(configToken()))
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/config", admission.ConfigHandler(configToken()))
+	http.HandleFunc("/debug/admissions", admission.CapturesHandler(configToken()))
+	http.HandleFunc("/debug/replay", admission.ReplayHandler(configToken()))
+	tlsConfig, err := admission.TLSConfig(admission.TLSOptions{
+		ClientCAFile: clientCAFile,
+		MinVersion:   minTLSVersion,
+		CipherSuites: splitList(cipherSuites),
+	})
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "configuring TLS"))
+	}
+	httpsServer := NewServer(Addr, tlsConfig)
 	logrus.Fatal(httpsServer.ListenAndServeTLS(tlsCertFile, tlsKeyFile))
 }
 
-func NewServer(addr string) *http.Server {
+// splitList returns the non-empty items of a comma separated list
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseExemptPodSelectors parses a semicolon separated list of namespaces
+// and label selectors, which may themselves contain commas, e.g.
+// kube-system:app=csi-driver,tier=system;storage:app=ceph
+func parseExemptPodSelectors(list string) ([]admission.ExemptPodSelector, error) {
+	selectors := []admission.ExemptPodSelector{}
+	for _, s := range strings.Split(list, ";") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("exempt pod selector %q is not of the form namespace:selector", s)
+		}
+		selectors = append(selectors, admission.ExemptPodSelector{Namespace: parts[0], Selector: parts[1]})
+	}
+	return selectors, nil
+}
+
+// parseMetadataBackends parses a comma separated list of metadata backend
+// names and endpoints, e.g. grafeas=grafeas.security.svc:8080
+func parseMetadataBackends(list string) (map[string]string, error) {
+	backends := map[string]string{}
+	for _, b := range splitList(list) {
+		parts := strings.SplitN(b, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("metadata backend %q is not of the form name=endpoint", b)
+		}
+		backends[parts[0]] = parts[1]
+	}
+	return backends, nil
+}
+
+func NewServer(addr string, tlsConfig *tls.Config) *http.Server {
 	return &http.Server{
-		Addr: addr,
-		TLSConfig: &tls.Config{
-			// TODO: Change this to tls.RequireAndVerifyClientCert
-			ClientAuth: tls.NoClientCert,
-		},
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+		// Log rejected client certificates and other TLS handshake errors
+		// as warnings rather than to stderr
+		ErrorLog: log.New(logrus.StandardLogger().WriterLevel(logrus.WarnLevel), "", 0),
 	}
 }
 
@@ -87,6 +357,50 @@ func StartCronJob() error {
 	if err != nil {
 		return err
 	}
-	go cron.Start(ctx, *cron.NewCronConfig(kcs, *metadataClient), checkInterval)
+	cfg := cron.NewCronConfig(kcs, *metadataClient)
+	cfg.Workers = cronWorkers
+	cfg.ChecksPerSecond = cronChecksPerSec
+	cfg.PruneExpiredWhitelists = cronPrune
+	go cron.Start(ctx, *cfg, checkInterval)
 	return nil
 }
+
+// attestAll runs the attest-all command, which attests the clean images
+// already running in the cluster instead of serving admissions. It exits
+// with an error if any image couldn't be validated or attested.
+func attestAll(args []string) {
+	fs := flag.NewFlagSet("attest-all", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "Namespace whose running images are attested. By default those of every namespace are.")
+	dryRun := fs.Bool("dry-run", false, "List the images which would be attested without attesting them.")
+	fs.Parse(args)
+	summary, err := admission.AttestAll(*namespace, *dryRun)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "attesting running images"))
+	}
+	for _, image := range summary.Attested {
+		fmt.Printf("attested %s\n", image)
+	}
+	for _, image := range summary.Violating {
+		fmt.Printf("not attested, violates a policy: %s\n", image)
+	}
+	for _, image := range summary.Failed {
+		fmt.Printf("failed %s\n", image)
+	}
+	fmt.Printf("%d attested, %d already attested, %d violating, %d failed\n", len(summary.Attested), len(summary.AlreadyAttested), len(summary.Violating), len(summary.Failed))
+	if len(summary.Failed) != 0 {
+		os.Exit(1)
+	}
+}
+
+// configToken returns the bearer token required by /config, /explain and
+// the /debug endpoints, if any
+func configToken() string {
+	if configTokenFile == "" {
+		return ""
+	}
+	token, err := ioutil.ReadFile(configTokenFile)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "reading config token"))
+	}
+	return strings.TrimSpace(string(token))
+}
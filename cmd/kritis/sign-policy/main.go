@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// sign-policy signs an ImageSecurityPolicy for kritis run with
+// --policy-signing-key-file, writing it to stdout with its
+// kritis.grafeas.io/policy-signature annotation set, e.g.
+//
+//	sign-policy --public-key-file=pub.b64 --private-key-file=priv.b64 -f isp.yaml | kubectl apply -f -
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/constants"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	policyFile     string
+	publicKeyFile  string
+	privateKeyFile string
+)
+
+func main() {
+	flag.StringVar(&policyFile, "f", "-", "File with the YAML ImageSecurityPolicy to sign, or - for stdin.")
+	flag.StringVar(&publicKeyFile, "public-key-file", "", "File with the base64 encoded, armored PGP public key kritis verifies policies with.")
+	flag.StringVar(&privateKeyFile, "private-key-file", "", "File with the base64 encoded, armored PGP private key of that public key.")
+	flag.Parse()
+
+	if publicKeyFile == "" || privateKeyFile == "" {
+		logrus.Fatal("--public-key-file and --private-key-file are required")
+	}
+	publicKey, err := readKey(publicKeyFile)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "reading public key"))
+	}
+	privateKey, err := readKey(privateKeyFile)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "reading private key"))
+	}
+	var data []byte
+	if policyFile == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(policyFile)
+	}
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "reading policy"))
+	}
+	isp := v1beta1.ImageSecurityPolicy{}
+	if err := yaml.Unmarshal(data, &isp); err != nil {
+		logrus.Fatal(errors.Wrap(err, "parsing policy"))
+	}
+	signature, err := securitypolicy.SignImageSecurityPolicy(isp, publicKey, privateKey)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "signing policy"))
+	}
+	if isp.Annotations == nil {
+		isp.Annotations = map[string]string{}
+	}
+	isp.Annotations[constants.PolicySignatureAnnotation] = signature
+	signed, err := yaml.Marshal(isp)
+	if err != nil {
+		logrus.Fatal(errors.Wrap(err, "encoding policy"))
+	}
+	fmt.Print(string(signed))
+}
+
+// readKey returns the key in file, without surrounding whitespace
+func readKey(file string) (string, error) {
+	key, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(key)), nil
+}
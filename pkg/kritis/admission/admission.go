@@ -19,8 +19,10 @@ package admission
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -28,117 +30,1103 @@ import (
 	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
 	kritisconstants "github.com/grafeas/kritis/pkg/kritis/constants"
 	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/imagestream"
+	"github.com/grafeas/kritis/pkg/kritis/kubectl/plugins/resolve"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
 	"github.com/grafeas/kritis/pkg/kritis/metadata/containeranalysis"
+	"github.com/grafeas/kritis/pkg/kritis/metrics"
 	"github.com/grafeas/kritis/pkg/kritis/pods"
 	"github.com/grafeas/kritis/pkg/kritis/util"
 	"github.com/grafeas/kritis/pkg/kritis/violation"
 	"k8s.io/api/admission/v1beta1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
 type config struct {
 	retrievePod                 func(r *http.Request) (*v1.Pod, error)
+	retrieveReview              func(r *http.Request) (*review, error)
 	fetchMetadataClient         func() (metadata.MetadataFetcher, error)
+	fetchMetadataBackend        func(name string) (metadata.MetadataFetcher, error)
 	fetchImageSecurityPolicies  func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error)
-	validateImageSecurityPolicy func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) ([]securitypolicy.SecurityPolicyViolation, error)
+	fetchPolicyBundle           func(ref string, publicKey string) ([]kritisv1beta1.ImageSecurityPolicy, error)
+	validateImageSecurityPolicy func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error)
+	watchImageSecurityPolicies  func() (watch.Interface, error)
+	watchConfigMap              func(namespace string, name string) (watch.Interface, error)
+	fetchNamespace              func(name string) (*v1.Namespace, error)
+	fetchSecret                 func(namespace string, name string) (*v1.Secret, error)
+	checkPullable               func(image string, creds map[string]util.RegistryCredentials) error
+	newerPatchTag               func(image string, creds map[string]util.RegistryCredentials) (string, error)
+	listPods                    func(namespace string) ([]v1.Pod, error)
+	resolveImage                func(image string) (string, error)
+	digestResolver              util.DigestResolver
+	resolveFailures             *negativeCache
+	verifyAttestations          func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error)
+	fetchBuildTime              func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (time.Time, error)
+	createAttestations          func(namespace string, image string, client metadata.MetadataFetcher) error
+	attestationQueue            *attestationQueue
+	decisions                   *decisionQueue
+	captures                    *captureBuffer
+	cache                       *allowCache
+	responses                   *responseCache
+	options                     Options
 }
 
 var (
 	// For testing
 	admissionConfig = config{
 		retrievePod:                 unmarshalPod,
-		fetchMetadataClient:         metadataClient,
+		retrieveReview:              unmarshalReview,
+		fetchMetadataClient:         metadataClients.get,
+		fetchMetadataBackend:        metadataBackend,
 		fetchImageSecurityPolicies:  securitypolicy.ImageSecurityPolicies,
+		fetchPolicyBundle:           securitypolicy.PolicyBundle,
 		validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		watchImageSecurityPolicies:  securitypolicy.WatchImageSecurityPolicies,
+		watchConfigMap:              watchConfigMap,
+		fetchNamespace:              fetchNamespace,
+		fetchSecret:                 fetchSecret,
+		checkPullable:               util.CheckPullable,
+		newerPatchTag:               util.NewerPatchTag,
+		listPods:                    pods.Pods,
+		resolveImage:                imagestream.Resolve,
+		digestResolver:              util.RegistryResolver{},
+		resolveFailures:             newNegativeCache(defaultNegativeCacheTTL),
+		verifyAttestations:          verifyAttestations,
+		fetchBuildTime:              latestBuildTime,
+		createAttestations:          createAttestations,
+		attestationQueue:            newAttestationQueue(createAttestations),
+		cache:                       newAllowCache(defaultCacheTTL),
+		responses:                   newResponseCache(defaultResponseCacheTTL),
+		captures:                    newCaptureBuffer(),
 	}
 
-	defaultViolationStrategy = violation.LoggingStrategy{}
+	// metadataClients is the metadata client shared by all admissions
+	metadataClients = newSharedMetadataClient(metadataClient)
+
+	// severityStrategy routes violations as set by Options.ViolationRoutes,
+	// logging those which aren't routed
+	severityStrategy = violation.NewSeverityStrategy(&violation.LoggingStrategy{})
+
+	// dedupeStrategy suppresses repeated violations of an image within
+	// Options.ViolationDedupeWindow. Violations are handled in the
+	// background, so slow notifications don't delay admission responses.
+	dedupeStrategy = violation.NewDedupeStrategy(violation.NewQueueStrategy(severityStrategy, violation.DefaultQueueSize, violation.DefaultQueueWorkers))
+
+	defaultViolationStrategy violation.Strategy = dedupeStrategy
 )
 
 // This admission controller looks for the breakglass annotation
+// If enforcement is disabled, every pod is admitted and denials are only logged
 // If one is not found, it validates against image security policies
-// TODO: Check for attestations
+// Workloads such as Deployments and CronJobs are validated via their pod template
+// On updates, only images which weren't already in the old object are validated
+// Images with a valid attestation for their exact digest skip vulnerability checks
 func AdmissionReviewHandler(w http.ResponseWriter, r *http.Request) {
+	handleAdmissionReview(w, captureRequest(r))
+}
+
+func handleAdmissionReview(w http.ResponseWriter, r *http.Request) {
 	logrus.Info("Starting admission review handler...")
-	pod, err := admissionConfig.retrievePod(r)
+	timer := newPhaseTimer()
+	defer func() {
+		logrus.WithFields(timer.fields()).Info("finished admission review")
+	}()
+	rv, err := retrieveReview(r)
+	timer.observe(phaseDecode)
 	if err != nil {
-		logrus.Error(err)
-		w.WriteHeader(http.StatusBadRequest)
+		returnError(newError(ErrMalformedRequest, err), w)
+		return
+	}
+	rv.received = timer.start
+	if !validatedOperation(rv.operation) {
+		handleOtherOperation(rv.operation, w)
+		return
+	}
+	pod := rv.pod
+	// Respond to a retry of a request as before, without validating the pod
+	// again. Dry runs, such as replays, are always validated.
+	if cached, ok := admissionConfig.responses.get(rv.key); ok && !rv.dryRun && !currentOptions().DisableEnforcement {
+		logrus.Infof("responding to a retry of admission request %s with the cached response", rv.uid)
+		returnStatusWithWarnings(cached.status, cached.reason, cached.message, cached.warnings, w)
+		recordDecision(rv, cached.status == constants.SuccessStatus, cached.reason, cached.message, nil)
+		return
+	}
+	status, reason, message, err := validatePod(rv, timer)
+	if err != nil {
+		metadataClientFailed(err)
+	}
+	if currentOptions().DisableEnforcement {
+		admitUnenforced(pod, status, message, err, w)
+		recordDecision(rv, true, "", constants.SuccessMessage, err)
+		return
+	}
+	if err != nil {
+		switch currentOptions().FailurePolicy {
+		case FailurePolicyIgnore:
+			logrus.Errorf("admitting pod which couldn't be validated: %v", err)
+			returnStatus(constants.SuccessStatus, "", constants.SuccessMessage, w)
+			recordDecision(rv, true, "", constants.SuccessMessage, err)
+		case FailurePolicyFail:
+			logrus.Errorf("denying pod which couldn't be validated: %v", err)
+			if temporary(err) {
+				returnTemporaryFailure(err, w)
+				recordDecision(rv, false, constants.ReasonTemporarilyUnavailable, err.Error(), err)
+			} else {
+				returnStatus(constants.FailureStatus, constants.ReasonValidationError, err.Error(), w)
+				recordDecision(rv, false, constants.ReasonValidationError, err.Error(), err)
+			}
+		default:
+			returnError(err, w)
+			recordDecision(rv, false, "", "", err)
+		}
 		return
 	}
+	returnStatusWithWarnings(status, reason, message, rv.warnings, w)
+	recordDecision(rv, status == constants.SuccessStatus, reason, message, nil)
+	if !rv.dryRun {
+		admissionConfig.responses.add(rv.key, cachedResponse{status: status, reason: reason, message: message, warnings: rv.warnings})
+	}
+}
+
+// validatedOperation returns true if pods of requests for operation are
+// validated. Requests without an operation are validated as creations.
+func validatedOperation(operation v1beta1.Operation) bool {
+	switch operation {
+	case "", v1beta1.Create, v1beta1.Update:
+		return true
+	}
+	return false
+}
+
+// handleOtherOperation responds to a request for an operation which isn't
+// validated, such as DELETE or CONNECT, which the webhook shouldn't be
+// registered for. It is admitted, unless DenyOtherOperations is set.
+func handleOtherOperation(operation v1beta1.Operation, w http.ResponseWriter) {
+	if currentOptions().DenyOtherOperations {
+		logrus.Warnf("denying %s request, which kritis doesn't validate", operation)
+		returnStatus(constants.FailureStatus, constants.ReasonUnsupportedOperation, unsupportedOperationMessage(operation), w)
+		return
+	}
+	logrus.Warnf("admitting %s request, which kritis doesn't validate", operation)
+	returnStatus(constants.SuccessStatus, "", constants.SuccessMessage, w)
+}
+
+func unsupportedOperationMessage(operation v1beta1.Operation) string {
+	return fmt.Sprintf("kritis only validates CREATE and UPDATE requests, got %s", operation)
+}
+
+// recordDecision records the admission response for rv in the metrics and
+// with the decision sink, unless rv is a dry run, such as a replay.
+// err is why the pod couldn't be validated, if it couldn't.
+func recordDecision(rv *review, allowed bool, reason constants.Reason, message string, err error) {
+	if rv.dryRun {
+		return
+	}
+	metrics.ObserveAdmission(rv.pod.Namespace, allowed, time.Since(rv.received))
+	if admissionConfig.decisions == nil {
+		return
+	}
+	name := rv.pod.Name
+	if name == "" {
+		name = rv.pod.GenerateName
+	}
+	r := DecisionRecord{
+		Time:       time.Now().UTC(),
+		Namespace:  rv.pod.Namespace,
+		Pod:        name,
+		Requester:  rv.requester,
+		Images:     pods.Images(*rv.pod),
+		Policies:   rv.policies,
+		Allowed:    allowed,
+		Reason:     string(reason),
+		Message:    message,
+		Violations: rv.violations,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	admissionConfig.decisions.record(r)
+}
+
+// admitUnenforced admits pod while enforcement is disabled, logging the
+// decision which would otherwise have been made
+func admitUnenforced(pod *v1.Pod, status constants.Status, message string, err error, w http.ResponseWriter) {
+	switch {
+	case err != nil:
+		logrus.Warnf("enforcement disabled, admitting pod %s in namespace %s which couldn't be validated: %v", pod.Name, pod.Namespace, err)
+	case status != constants.SuccessStatus:
+		logrus.Warnf("enforcement disabled, admitting pod %s in namespace %s which would have been denied: %s", pod.Name, pod.Namespace, message)
+	}
+	returnStatus(constants.SuccessStatus, "", constants.SuccessMessage, w)
+}
+
+// review is the admission request for a pod
+type review struct {
+	pod *v1.Pod
+	// oldImages are the images the pod had before an update
+	oldImages []string
+	// dryRun means the request must not have side effects, such as
+	// creating attestations, handling violations, caching its decision or
+	// recording it
+	dryRun bool
+	// operation is the operation of the request, such as CREATE. Only the
+	// pods of CREATE and UPDATE requests are decoded.
+	operation v1beta1.Operation
+	// requester is the user who made the request
+	requester string
+	// uid identifies the request, and key its content too, as the key of its
+	// cached response. Both are empty if the request has no UID.
+	uid types.UID
+	key string
+	// received is when the request was received
+	received time.Time
+	// policies and violations are the ImageSecurityPolicies the pod was
+	// validated against and the reasons of the violations it was denied for,
+	// as recorded in its DecisionRecord
+	policies   []string
+	violations []string
+	// warnings are returned to the client with the admission response, if
+	// the pod is admitted
+	warnings []string
+}
+
+// retrieveReview returns the admission request in r
+func retrieveReview(r *http.Request) (*review, error) {
+	if admissionConfig.retrieveReview != nil {
+		return admissionConfig.retrieveReview(r)
+	}
+	pod, err := admissionConfig.retrievePod(r)
+	if err != nil {
+		return nil, err
+	}
+	return &review{pod: pod}, nil
+}
+
+// ValidatePod decides whether pod should be admitted.
+// It returns the status, reason and message of the admission response, or an
+// *Error if the pod couldn't be validated.
+func ValidatePod(pod *v1.Pod) (constants.Status, constants.Reason, string, error) {
+	return validatePod(&review{pod: pod}, nil)
+}
+
+// validatePod validates the pod of rv as ValidatePod does, except that on
+// updates only images the update introduces need validating, and dry runs
+// have no side effects. The duration of each phase is observed by timer.
+func validatePod(rv *review, timer *phaseTimer) (constants.Status, constants.Reason, string, error) {
+	pod := rv.pod
 	// First, check for a breakglass annotation on the pod
-	if checkBreakglass(pod) {
+	breakglass := checkBreakglass(pod)
+	timer.observe(phaseBreakglass)
+	if breakglass {
 		logrus.Debugf("found breakglass annotation, returning successful status")
-		returnStatus(constants.SuccessStatus, constants.SuccessMessage, w)
-		return
+		return constants.SuccessStatus, "", constants.SuccessMessage, nil
+	}
+	exempt := namespaceExempt(pod.Namespace)
+	selector := matchingExemptPodSelector(pod)
+	timer.observe(phaseExemptions)
+	if exempt {
+		logrus.Debugf("namespace %s is exempt, returning successful status", pod.Namespace)
+		return constants.SuccessStatus, "", constants.SuccessMessage, nil
+	}
+	if selector != nil {
+		logrus.WithFields(logrus.Fields{
+			"namespace": pod.Namespace,
+			"pod":       pod.Name,
+			"selector":  selector.Selector,
+			"images":    pods.Images(*pod),
+		}).Info("pod matches an exempt pod selector, admitting its images without validation")
+		return constants.SuccessStatus, "", constants.SuccessMessage, nil
+	}
+	if isMirrorPod(pod) {
+		if currentOptions().ExemptMirrorPods {
+			logrus.Debugf("%s is a mirror pod, returning successful status", pod.Name)
+			return constants.SuccessStatus, "", constants.SuccessMessage, nil
+		}
+		logrus.Infof("validating mirror pod %s of a static pod", pod.Name)
 	}
 
-	// TODO: Fetch Attestations for the given images to see if the image is already verified
-	images := pods.Images(*pod)
-	if util.CheckGlobalWhitelist(images) {
+	requested := withoutPauseImages(newImages(pods.Images(*pod), rv.oldImages))
+	literal := []string{}
+	for _, image := range requested {
+		if !pods.Unresolved(image) {
+			literal = append(literal, image)
+			continue
+		}
+		if currentOptions().UnresolvedImagePolicy == UnresolvedImageAllow {
+			logrus.Debugf("%q is not an image reference, admitting it without validation", image)
+			continue
+		}
+		logrus.Infof("%q is not an image reference, denying pod", image)
+		return constants.FailureStatus, constants.ReasonUnresolvedImage, unresolvedImageMessage(image), nil
+	}
+	requested = literal
+	bestEffort := map[string]bool{}
+	switch currentOptions().NeverPullPolicy {
+	case NeverPullDeny:
+		for _, image := range requested {
+			if pods.NeverPulled(*pod, image) && !util.CheckGlobalWhitelist([]string{image}) {
+				logrus.Infof("%s is never pulled, denying pod", image)
+				return constants.FailureStatus, constants.ReasonNeverPulled, neverPulledMessage(image), nil
+			}
+		}
+	case NeverPullAllow:
+		pulled := []string{}
+		for _, image := range requested {
+			if pods.NeverPulled(*pod, image) {
+				logrus.Debugf("%s is never pulled, admitting it without validation", image)
+				continue
+			}
+			pulled = append(pulled, image)
+		}
+		requested = pulled
+	case NeverPullValidate:
+		for _, image := range requested {
+			bestEffort[image] = pods.NeverPulled(*pod, image)
+		}
+	}
+	// The credentials of the pod's imagePullSecrets, if they were needed
+	var creds map[string]util.RegistryCredentials
+	if currentOptions().RequirePullableImages {
+		var err error
+		if creds, err = pullCredentials(pod); err != nil {
+			return "", "", "", newError(ErrMetadataUnavailable, err)
+		}
+		image, err := unpullableImage(pod, requested, creds)
+		if image != "" {
+			logrus.Infof("%s can't be pulled, denying pod: %v", image, err)
+			return constants.FailureStatus, constants.ReasonImageNotPullable, unpullableMessage(image, err), nil
+		}
+		if err != nil {
+			return "", "", "", newError(ErrMetadataUnavailable, err)
+		}
+	}
+	images, err := resolveImages(requested, bestEffort, true)
+	timer.observe(phaseResolve)
+	if err != nil {
+		return "", "", "", newError(ErrMetadataUnavailable, err)
+	}
+	// The images as the pod's containers reference them
+	containerImages := map[string]string{}
+	for i, image := range images {
+		containerImages[image] = requested[i]
+	}
+	whitelisted := util.CheckGlobalWhitelist(images)
+	timer.observe(phaseWhitelist)
+	if whitelisted {
 		logrus.Debugf("%s are all whitelisted, returning successful status", images)
-		returnStatus(constants.SuccessStatus, constants.SuccessMessage, w)
-		return
+		return constants.SuccessStatus, "", constants.SuccessMessage, nil
 	}
 	// Next, validate images in the pod against ImageSecurityPolicies in the same namespace
-	isps, err := admissionConfig.fetchImageSecurityPolicies(pod.Namespace)
+	isps, err := imageSecurityPolicies(pod.Namespace)
+	timer.observe(phasePolicies)
+	if untrusted, ok := err.(*untrustedPolicyError); ok {
+		logrus.Warnf("denying pod in namespace %s: %v", pod.Namespace, untrusted)
+		return constants.FailureStatus, constants.ReasonUntrustedPolicy, untrusted.Error(), nil
+	}
 	if err != nil {
-		logrus.Errorf("error getting image security policies: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		return "", "", "", newError(ErrPolicyLoad, err)
 	}
+	securitypolicy.Sort(isps)
 	logrus.Debugf("Got isps %v", isps)
+	for _, isp := range isps {
+		rv.policies = append(rv.policies, fmt.Sprintf("%s/%s", isp.Namespace, isp.Name))
+	}
+	if len(isps) == 0 && currentOptions().RequirePolicy {
+		logrus.Infof("no image security policies in namespace %s, denying pod", pod.Namespace)
+		return constants.FailureStatus, constants.ReasonNoPolicy, noPolicyMessage(pod.Namespace), nil
+	}
+	// Check init containers by how the pod runs them, so even if their images
+	// were admitted before, or are attested
+	if violations := initContainerViolations(pod, requested, isps); len(violations) != 0 {
+		logrus.Info(violations[0].Reason)
+		rv.violations = violationDetails(violations)
+		addViolations(rv, len(violations))
+		return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+	}
+	// Check the images are all from the namespace's tenant, even if they
+	// were admitted before, or are attested
+	if violations := tenantViolations(pod.Namespace, images, isps); len(violations) != 0 {
+		logrus.Info(violations[0].Reason)
+		rv.violations = violationDetails(violations)
+		addViolations(rv, len(violations))
+		return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+	}
+	// Check sensitive images run under the service accounts bound to them,
+	// even if they were admitted before, or are attested
+	if violations := serviceAccountViolations(pod, images, isps); len(violations) != 0 {
+		logrus.Info(violations[0].Reason)
+		rv.violations = violationDetails(violations)
+		addViolations(rv, len(violations))
+		return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+	}
+	// Check images no policy matches are allowed by default, even if they
+	// were admitted before, or are attested
+	violations, err := securitypolicy.ValidateUnmatchedImages(isps, images)
+	if err != nil {
+		return "", "", "", newError(ErrPolicyLoad, err)
+	}
+	if len(violations) != 0 {
+		logrus.Info(violations[0].Reason)
+		rv.violations = violationDetails(violations)
+		addViolations(rv, len(violations))
+		return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+	}
 	// get the client we will get vulnz from
 	metadataClient, err := admissionConfig.fetchMetadataClient()
+	timer.observe(phaseMetadataClient)
 	if err != nil {
-		logrus.Errorf("error getting metadata client: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		return "", "", "", newError(ErrMetadataUnavailable, err)
+	}
+	// Check pods with elevated privileges have attestations for all of their
+	// images, even those which were admitted before
+	if violations := privilegedViolations(pod, images, isps, metadataClient); len(violations) != 0 {
+		logrus.Info(violations[0].Reason)
+		rv.violations = violationDetails(violations)
+		addViolations(rv, len(violations))
+		return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+	}
+	// Check the images were built recently, even those which were admitted
+	// before, as they age
+	if violations, err := staleImageViolations(pod.Namespace, images, isps, metadataClient); err != nil {
+		return "", "", "", newError(ErrMetadataUnavailable, err)
+	} else if len(violations) != 0 {
+		logrus.Info(violations[0].Reason)
+		rv.violations = violationDetails(violations)
+		addViolations(rv, len(violations))
+		return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+	}
+	// Validate images from the in-cluster registries against their dedicated
+	// policy instead of the pod's
+	inCluster, images := splitInClusterImages(images)
+	if len(inCluster) != 0 {
+		violations, err := inClusterViolations(rv, inCluster, metadataClient)
+		if err != nil {
+			return "", "", "", err
+		}
+		if len(violations) != 0 {
+			logrus.Info(violations[0].Reason)
+			rv.violations = violationDetails(violations)
+			addViolations(rv, len(violations))
+			return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+		}
+	}
+	// Skip images which were recently admitted in this namespace
+	uncached := []string{}
+	for _, image := range images {
+		if admissionConfig.cache.allowed(pod.Namespace, image) {
+			logrus.Debugf("%s was recently admitted, skipping validation", image)
+			continue
+		}
+		uncached = append(uncached, image)
+	}
+	if len(isps) != 0 {
+		// Skip images which are already attested
+		uncached = unattestedImages(pod.Namespace, uncached, isps, metadataClient)
+		violations, err := requiredAttestationViolations(pod.Namespace, isps, uncached)
+		if err != nil {
+			return "", "", "", newError(ErrPolicyLoad, err)
+		}
+		if len(violations) != 0 {
+			logrus.Info(violations[0].Reason)
+			rv.violations = violationDetails(violations)
+			addViolations(rv, len(violations))
+			return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+		}
+		// Skip the vulnerabilities of images which CI already validated
+		uncached = unvalidatedImages(pod, uncached)
+		// Fetch vulnerabilities for all images in one query if the client supports it
+		if client, err := metadata.Prefetch(metadataClient, qualifiedImages(uncached)); err != nil {
+			logrus.Warnf("error fetching vulnerabilities in a batch, fetching them per image: %v", err)
+		} else {
+			metadataClient = client
+		}
+		timer.observe(phaseAttestations)
+	}
+	// Query the metadata backend each policy selects
+	clients, err := policyMetadataClients(isps, metadataClient)
+	if err != nil {
+		return "", "", "", newError(ErrMetadataUnavailable, err)
+	}
+	// Respond as soon as an image violates a policy, and log the findings of
+	// the images validated after it in the background
+	findings := evaluateImages(pod, isps, clients, uncached, containerImages)
+	// Images only admitted because of how this pod runs them
+	overridden := map[string]bool{}
+	for f := range findings {
+		timer.observeImage(f.image)
+		if f.err != nil {
+			go collectSoftFindings(pod, findings)
+			return "", "", "", newError(ErrMetadataUnavailable, f.err)
+		}
+		logSoftFindings(pod, f)
+		if len(f.overridden) != 0 {
+			overridden[f.image] = true
+		}
+		image, violations := f.image, f.violations
+		if len(violations) == 0 {
+			continue
+		}
+		go collectSoftFindings(pod, findings)
+		addViolations(rv, len(violations))
+		rv.violations = violationDetails(violations)
+		// Check if one of the violations is that the image is not fully qualified
+		for _, v := range violations {
+			if v.Violation == securitypolicy.UnqualifiedImageViolation || v.Violation == securitypolicy.TagReferenceViolation {
+				logrus.Info(v.Reason)
+				return constants.FailureStatus, violationsReason(violations), violationsMessage(image, violations), nil
+			}
+		}
+		if rv.dryRun {
+			logrus.Debugf("not handling violations of %s in a dry run", image)
+		} else {
+			defaultViolationStrategy.HandleViolation(image, pod, violations)
+		}
+		return constants.FailureStatus, violationsReason(violations), violationsMessage(image, violations), nil
+	}
+	// Check the namespace's vulnerability budgets, and the consistency of the
+	// images' provenance and their source repository, with all of the pod's
+	// images, since cached or attested images count towards them too
+	for i, isp := range isps {
+		violations, err := securitypolicy.ValidateNamespaceBudget(isp, pod.Namespace, images, clients[i])
+		if err != nil {
+			return "", "", "", newError(ErrMetadataUnavailable, err)
+		}
+		if len(violations) == 0 {
+			if violations, err = securitypolicy.ValidatePodProvenance(isp, images, clients[i]); err != nil {
+				return "", "", "", newError(ErrMetadataUnavailable, err)
+			}
+		}
+		if len(violations) == 0 {
+			source := pod.Annotations[kritisconstants.SourceRepositoryAnnotation]
+			if violations, err = securitypolicy.ValidateSourceRepository(isp, source, images, clients[i]); err != nil {
+				return "", "", "", newError(ErrMetadataUnavailable, err)
+			}
+		}
+		if len(violations) != 0 {
+			logrus.Info(violations[0].Reason)
+			rv.violations = violationDetails(violations)
+			addViolations(rv, len(violations))
+			return constants.FailureStatus, violationsReason(violations), string(violations[0].Reason), nil
+		}
+	}
+	// Cache and create Attestations as Occurrences for the admitted images,
+	// except those other pods could run without the overrides of this one
+	admitted := []string{}
+	for _, image := range uncached {
+		if overridden[image] {
+			logrus.Debugf("not caching or attesting %s, which was only admitted as the pod runs it", image)
+			continue
+		}
+		admitted = append(admitted, image)
+	}
+	if rv.dryRun {
+		logrus.Debugf("not caching or attesting %s in a dry run", admitted)
+	} else {
+		for _, image := range admitted {
+			admissionConfig.cache.add(pod.Namespace, image)
+		}
+		attestImages(pod.Namespace, admitted, metadataClient)
+	}
+	timer.observe(phaseAttest)
+	rv.warnings = upgradeWarnings(pod, requested, creds)
+	// At this point, we can return a success status
+	return constants.SuccessStatus, "", constants.SuccessMessage, nil
+}
+
+// addViolations counts n violations of the pod of rv in the metrics, unless
+// rv is a dry run
+func addViolations(rv *review, n int) {
+	if !rv.dryRun {
+		metrics.AddViolations(rv.pod.Namespace, n)
+	}
+}
+
+// violationDetails returns the detailed reasons of violations
+func violationDetails(violations []securitypolicy.SecurityPolicyViolation) []string {
+	reasons := []string{}
+	for _, v := range violations {
+		reasons = append(reasons, string(v.Reason))
 	}
+	return reasons
+}
+
+// neverPulledMessage returns the message of pods denied because image is
+// never pulled
+func neverPulledMessage(image string) string {
+	return fmt.Sprintf("%s has imagePullPolicy Never, so it can't be validated", image)
+}
+
+// unresolvedImageMessage returns the message of pods denied because image
+// isn't a literal image reference
+func unresolvedImageMessage(image string) string {
+	return fmt.Sprintf("%s is not an image reference, it may be a placeholder which was never substituted", image)
+}
+
+// initContainerViolations returns the violations of the init container
+// requirements of isps by the init containers of pod running any of images
+func initContainerViolations(pod *v1.Pod, images []string, isps []kritisv1beta1.ImageSecurityPolicy) []securitypolicy.SecurityPolicyViolation {
+	validated := map[string]bool{}
+	for _, image := range images {
+		validated[image] = true
+	}
+	var violations []securitypolicy.SecurityPolicyViolation
 	for _, isp := range isps {
-		for _, image := range images {
-			logrus.Infof("Getting vulnz for %s", image)
-			violations, err := admissionConfig.validateImageSecurityPolicy(isp, image, metadataClient)
-			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
+		for _, c := range pod.Spec.InitContainers {
+			if validated[c.Image] {
+				violations = append(violations, securitypolicy.ValidateInitContainerImage(isp, c.Image)...)
 			}
-			// Check if one of the violations is that the image is not fully qualified
-			for _, v := range violations {
-				if v.Violation == securitypolicy.UnqualifiedImageViolation {
-					logrus.Infof("%s is not a fully qualified image", image)
-					returnStatus(constants.FailureStatus, fmt.Sprintf("%s is not a fully qualified image", image), w)
-					return
+		}
+	}
+	return violations
+}
+
+// tenantViolations returns the violations of the tenant requirements of isps
+// by images of a pod in namespace
+func tenantViolations(namespace string, images []string, isps []kritisv1beta1.ImageSecurityPolicy) []securitypolicy.SecurityPolicyViolation {
+	var violations []securitypolicy.SecurityPolicyViolation
+	for _, isp := range isps {
+		violations = append(violations, securitypolicy.ValidateTenantImages(isp, namespace, images)...)
+	}
+	return violations
+}
+
+// serviceAccountViolations returns the violations of the service account
+// bindings of isps by images of pod
+func serviceAccountViolations(pod *v1.Pod, images []string, isps []kritisv1beta1.ImageSecurityPolicy) []securitypolicy.SecurityPolicyViolation {
+	var violations []securitypolicy.SecurityPolicyViolation
+	for _, isp := range isps {
+		violations = append(violations, securitypolicy.ValidateServiceAccount(isp, pod.Spec.ServiceAccountName, images)...)
+	}
+	return violations
+}
+
+// privilegedViolations returns a violation for every image without a valid
+// attestation which any of isps that is StrictForPrivileged governs, if pod
+// has elevated privileges
+func privilegedViolations(pod *v1.Pod, images []string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) []securitypolicy.SecurityPolicyViolation {
+	var strict []kritisv1beta1.ImageSecurityPolicy
+	for _, isp := range isps {
+		if isp.Spec.StrictForPrivileged {
+			strict = append(strict, isp)
+		}
+	}
+	if len(strict) == 0 {
+		return nil
+	}
+	privileges := pods.Privileges(*pod)
+	if len(privileges) == 0 {
+		return nil
+	}
+	var violations []securitypolicy.SecurityPolicyViolation
+	for _, image := range images {
+		if util.CheckGlobalWhitelist([]string{image}) || !governed(strict, image) {
+			continue
+		}
+		attested := false
+		if admissionConfig.verifyAttestations != nil && resolve.FullyQualifiedImage(image) {
+			var err error
+			if attested, err = admissionConfig.verifyAttestations(pod.Namespace, image, isps, client); err != nil {
+				logrus.Warnf("error verifying attestations for %s: %v", image, err)
+			}
+		}
+		if !attested {
+			violations = append(violations, securitypolicy.SecurityPolicyViolation{
+				Violation: securitypolicy.UnattestedPrivilegedViolation,
+				Reason:    securitypolicy.UnattestedPrivilegedViolationReason(image, privileges),
+			})
+		}
+	}
+	return violations
+}
+
+// staleImageViolations returns a violation for every image whose signed build
+// timestamp is missing, or older than the smallest MaxImageAge of the isps
+// governing it, or an error if the build timestamps couldn't be fetched
+func staleImageViolations(namespace string, images []string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) ([]securitypolicy.SecurityPolicyViolation, error) {
+	var violations []securitypolicy.SecurityPolicyViolation
+	for _, image := range images {
+		maxAge := maxImageAge(isps, image)
+		if maxAge == nil || util.CheckGlobalWhitelist([]string{image}) {
+			continue
+		}
+		var built time.Time
+		if admissionConfig.fetchBuildTime != nil && resolve.FullyQualifiedImage(image) {
+			var err error
+			if built, err = admissionConfig.fetchBuildTime(namespace, image, isps, client); err != nil {
+				return nil, fmt.Errorf("error verifying the build timestamp of %s: %v", image, err)
+			}
+		}
+		switch {
+		case built.IsZero():
+			violations = append(violations, securitypolicy.SecurityPolicyViolation{
+				Violation: securitypolicy.UnsignedBuildTimestampViolation,
+				Reason:    securitypolicy.UnsignedBuildTimestampViolationReason(image),
+			})
+		case time.Since(built) > *maxAge:
+			violations = append(violations, securitypolicy.SecurityPolicyViolation{
+				Violation: securitypolicy.StaleImageViolation,
+				Reason:    securitypolicy.StaleImageViolationReason(image, built, *maxAge),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// maxImageAge returns the smallest MaxImageAge of the isps governing image,
+// or nil if none of them has one
+func maxImageAge(isps []kritisv1beta1.ImageSecurityPolicy, image string) *time.Duration {
+	var maxAge *time.Duration
+	for _, isp := range isps {
+		if age := isp.Spec.MaxImageAge; age != nil && securitypolicy.Governs(isp, image) && (maxAge == nil || age.Duration < *maxAge) {
+			maxAge = &age.Duration
+		}
+	}
+	return maxAge
+}
+
+// governed returns true if any of isps governs image
+func governed(isps []kritisv1beta1.ImageSecurityPolicy, image string) bool {
+	for _, isp := range isps {
+		if securitypolicy.Governs(isp, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyMetadataClients returns the client of the metadata backend each of
+// isps selects, or client for those which don't select one
+func policyMetadataClients(isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) ([]metadata.MetadataFetcher, error) {
+	clients := make([]metadata.MetadataFetcher, len(isps))
+	for i, isp := range isps {
+		if isp.Spec.MetadataBackend == "" {
+			clients[i] = client
+			continue
+		}
+		c, err := admissionConfig.fetchMetadataBackend(isp.Spec.MetadataBackend)
+		if err != nil {
+			return nil, err
+		}
+		clients[i] = c
+	}
+	return clients, nil
+}
+
+// requiredAttestationViolations returns a violation for every one of
+// unattested which isn't whitelisted, if any of isps governing it requires
+// attestations in namespace
+func requiredAttestationViolations(namespace string, isps []kritisv1beta1.ImageSecurityPolicy, unattested []string) ([]securitypolicy.SecurityPolicyViolation, error) {
+	var violations []securitypolicy.SecurityPolicyViolation
+	for _, isp := range isps {
+		required, err := attestationRequired(isp, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if !required {
+			continue
+		}
+		for _, image := range unattested {
+			if util.CheckGlobalWhitelist([]string{image}) || !securitypolicy.Governs(isp, image) {
+				continue
+			}
+			violations = append(violations, securitypolicy.SecurityPolicyViolation{
+				Violation: securitypolicy.MissingAttestationViolation,
+				Reason:    securitypolicy.MissingAttestationViolationReason(image),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// splitOverriddenViolations splits violations of image into those which
+// stand and those which pod's spec overrides, i.e. a root image whose
+// containers are run as non-root
+func splitOverriddenViolations(pod *v1.Pod, image string, violations []securitypolicy.SecurityPolicyViolation) ([]securitypolicy.SecurityPolicyViolation, []securitypolicy.SecurityPolicyViolation) {
+	var kept, overridden []securitypolicy.SecurityPolicyViolation
+	for _, v := range violations {
+		if v.Violation == securitypolicy.RootImageViolation && pods.RunsAsNonRoot(*pod, image) {
+			logrus.Debugf("%s runs as root, but the pod runs it as non-root", image)
+			overridden = append(overridden, v)
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept, overridden
+}
+
+// newImages returns the images which aren't in oldImages
+func newImages(images []string, oldImages []string) []string {
+	if len(oldImages) == 0 {
+		return images
+	}
+	old := map[string]bool{}
+	for _, image := range oldImages {
+		old[image] = true
+	}
+	added := []string{}
+	for _, image := range images {
+		if !old[image] {
+			added = append(added, image)
+		} else {
+			logrus.Debugf("%s is unchanged by the update, skipping validation", image)
+		}
+	}
+	return added
+}
+
+// withoutPauseImages returns images without pod sandbox images
+func withoutPauseImages(images []string) []string {
+	filtered := []string{}
+	for _, image := range images {
+		if util.IsPauseImage(image) {
+			logrus.Debugf("%s is a pause image, skipping validation", image)
+			continue
+		}
+		filtered = append(filtered, image)
+	}
+	return filtered
+}
+
+// resolveImages maps images pulled from OpenShift ImageStreams to the
+// external images they were imported from, so their metadata can be found.
+// If ResolveTags is set, images referenced by tag are resolved to digests.
+// Images in bestEffort which can't be resolved are kept as they are. Images
+// which fail to resolve to a digest are only remembered if cacheFailures.
+func resolveImages(images []string, bestEffort map[string]bool, cacheFailures bool) ([]string, error) {
+	resolved := []string{}
+	for _, image := range images {
+		r := image
+		if admissionConfig.resolveImage != nil {
+			var err error
+			if r, err = admissionConfig.resolveImage(image); err != nil {
+				if !bestEffort[image] {
+					return nil, fmt.Errorf("error resolving %s: %v", image, err)
 				}
+				logrus.Debugf("validating %s as referenced, since it couldn't be resolved: %v", image, err)
+				r = image
 			}
-			if len(violations) != 0 {
-				defaultViolationStrategy.HandleViolation(image, pod, violations)
-				returnStatus(constants.FailureStatus, fmt.Sprintf("found violations in %s", image), w)
-				return
+		}
+		if currentOptions().ResolveTags && !resolve.FullyQualifiedImage(r) {
+			digest, err := resolveDigest(r, cacheFailures)
+			switch {
+			case err == nil:
+				r = digest
+			case bestEffort[image]:
+				logrus.Debugf("validating %s as referenced, since it couldn't be resolved to a digest: %v", image, err)
+			default:
+				return nil, fmt.Errorf("error resolving %s to a digest: %v", image, err)
 			}
 		}
+		if r != image {
+			logrus.Debugf("resolved %s to %s", image, r)
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// resolveDigest resolves image to a digest, failing fast if it recently
+// failed to resolve. If cacheFailures, a failure is remembered.
+func resolveDigest(image string, cacheFailures bool) (string, error) {
+	if err := admissionConfig.resolveFailures.failed(image); err != nil {
+		return "", err
+	}
+	if admissionConfig.digestResolver == nil {
+		return "", fmt.Errorf("no digest resolver configured")
+	}
+	digest, err := admissionConfig.digestResolver.ResolveDigest(image)
+	if err != nil {
+		if cacheFailures {
+			admissionConfig.resolveFailures.add(image, err)
+		}
+		return "", err
+	}
+	return digest, nil
+}
+
+// unattestedImages returns the images without a valid attestation.
+// Attested images are cached as admitted.
+func unattestedImages(namespace string, images []string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) []string {
+	if admissionConfig.verifyAttestations == nil {
+		return images
+	}
+	unattested := []string{}
+	for _, image := range images {
+		if !resolve.FullyQualifiedImage(image) {
+			unattested = append(unattested, image)
+			continue
+		}
+		attested, err := admissionConfig.verifyAttestations(namespace, image, isps, client)
+		if err != nil {
+			logrus.Warnf("error verifying attestations for %s: %v", image, err)
+		}
+		if !attested {
+			unattested = append(unattested, image)
+			continue
+		}
+		logrus.Infof("%s is attested, skipping validation", image)
+		admissionConfig.cache.add(namespace, image)
+	}
+	return unattested
+}
+
+func qualifiedImages(images []string) []string {
+	qualified := []string{}
+	for _, image := range images {
+		if resolve.FullyQualifiedImage(image) {
+			qualified = append(qualified, image)
+		}
+	}
+	return qualified
+}
+
+// attestImages creates attestations for images that are fully qualified,
+// either inline or on the attestation queue if AsyncAttestation is set.
+// Attestations which fail inline are retried on the attestation queue.
+func attestImages(namespace string, images []string, client metadata.MetadataFetcher) {
+	if admissionConfig.createAttestations == nil {
+		return
+	}
+	for _, image := range images {
+		if !resolve.FullyQualifiedImage(image) {
+			logrus.Debugf("not attesting %s as it is not fully qualified", image)
+			continue
+		}
+		if currentOptions().AsyncAttestation {
+			admissionConfig.attestationQueue.enqueue(namespace, image, client)
+			continue
+		}
+		attestInline(admissionConfig.createAttestations, admissionConfig.attestationQueue, attestationJob{namespace: namespace, image: image, client: client})
 	}
-	// TODO: Create Attestations as Occurrences for the given images.
-	// At this point, we can return a success status
-	returnStatus(constants.SuccessStatus, constants.SuccessMessage, w)
 }
 
 func unmarshalPod(r *http.Request) (*v1.Pod, error) {
-	data, err := ioutil.ReadAll(r.Body)
+	rv, err := unmarshalReview(r)
+	if err != nil {
+		return nil, err
+	}
+	if rv.pod == nil {
+		return nil, fmt.Errorf("%s admission request has no pod", rv.operation)
+	}
+	return rv.pod, nil
+}
+
+// maxReviewSize is the largest AdmissionReview decoded, well above the
+// largest object the API server accepts, twice over for updates
+const maxReviewSize = 8 << 20
+
+// dryRunRequest holds AdmissionRequest.DryRun, which the vendored
+// AdmissionRequest predates
+type dryRunRequest struct {
+	Request *struct {
+		DryRun *bool `json:"dryRun,omitempty"`
+	} `json:"request"`
+}
+
+// unmarshalReview returns the pod to admit, whether the request is a dry run
+// and, if the request updates an existing object, the images it already had.
+// Reviews larger than maxReviewSize are rejected.
+func unmarshalReview(r *http.Request) (*review, error) {
+	if r.Body == nil {
+		return nil, fmt.Errorf("admission review has no body")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReviewSize+1))
 	if err != nil {
 		return nil, err
 	}
+	if len(data) > maxReviewSize {
+		return nil, fmt.Errorf("admission review exceeds %d bytes", maxReviewSize)
+	}
 	ar := v1beta1.AdmissionReview{}
 	if err := json.Unmarshal(data, &ar); err != nil {
 		return nil, err
 	}
-	pod := v1.Pod{}
-	if err := json.Unmarshal(ar.Request.Object.Raw, &pod); err != nil {
+	if ar.Request == nil {
+		return nil, fmt.Errorf("admission review has no request")
+	}
+	dr := dryRunRequest{}
+	if err := json.Unmarshal(data, &dr); err != nil {
+		return nil, err
+	}
+	dryRun := dr.Request != nil && dr.Request.DryRun != nil && *dr.Request.DryRun
+	if !validatedOperation(ar.Request.Operation) {
+		return &review{operation: ar.Request.Operation, dryRun: dryRun, requester: ar.Request.UserInfo.Username}, nil
+	}
+	if len(ar.Request.Object.Raw) == 0 {
+		return nil, fmt.Errorf("admission request has no object")
+	}
+	pod, err := decodePod(ar.Request.Kind, ar.Request.Object.Raw)
+	if err != nil {
 		return nil, err
 	}
-	return &pod, nil
+	rv := &review{
+		pod:       pod,
+		operation: ar.Request.Operation,
+		dryRun:    dryRun,
+		requester: ar.Request.UserInfo.Username,
+		uid:       ar.Request.UID,
+	}
+	if rv.uid != "" {
+		rv.key = responseKey(rv.uid, data)
+	}
+	if ar.Request.Operation != v1beta1.Update || len(ar.Request.OldObject.Raw) == 0 {
+		return rv, nil
+	}
+	old, err := decodePod(ar.Request.Kind, ar.Request.OldObject.Raw)
+	if err != nil {
+		return nil, err
+	}
+	rv.oldImages = pods.Images(*old)
+	return rv, nil
+}
+
+// decodePod decodes raw as a Pod or, for workloads, as the pod they would
+// create from their template. Custom resources with CustomResourceImages are
+// decoded as a pod running the images selected in them.
+func decodePod(kind metav1.GroupVersionKind, raw []byte) (*v1.Pod, error) {
+	if paths, ok := customResourceImagePaths(kind); ok {
+		return pods.FromCustomResource(raw, paths)
+	}
+	if kind.Kind == "" || kind.Kind == "Pod" {
+		pod := v1.Pod{}
+		if err := json.Unmarshal(raw, &pod); err != nil {
+			return nil, err
+		}
+		return &pod, nil
+	}
+	gvk := schema.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw, &gvk, nil)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("no %s in admission request", kind.Kind)
+	}
+	return pods.FromTemplate(obj)
+}
+
+// customResourceImages is Options.CustomResourceImages, which decoding
+// reviews can't read from admissionConfig since it refers to the decoder.
+// It is guarded by optionsMu.
+var customResourceImages []CustomResourceImages
+
+// customResourceImagePaths returns the paths selecting the images of custom
+// resources of kind, if CustomResourceImages has any
+func customResourceImagePaths(kind metav1.GroupVersionKind) ([]string, bool) {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	for _, c := range customResourceImages {
+		if c.Group == kind.Group && c.Kind == kind.Kind {
+			return c.Paths, true
+		}
+	}
+	return nil, false
 }
 
 func checkBreakglass(pod *v1.Pod) bool {
@@ -150,27 +1138,158 @@ func checkBreakglass(pod *v1.Pod) bool {
 	return ok
 }
 
-// TODO: update this once we have more metadata clients
+// namespaceExempt returns true if pods in namespace are admitted without validation
+func namespaceExempt(namespace string) bool {
+	exempt := currentOptions().ExemptNamespaces
+	if exempt == nil {
+		exempt = kritisconstants.DefaultExemptNamespaces
+	}
+	for _, ns := range exempt {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// exemptPodSelectors are the parsed Options.ExemptPodSelectors. They are
+// guarded by optionsMu.
+var exemptPodSelectors []exemptPodSelector
+
+// matchingExemptPodSelector returns the first of the ExemptPodSelectors of the
+// namespace of pod matching its labels, or nil if none does
+func matchingExemptPodSelector(pod *v1.Pod) *ExemptPodSelector {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	for _, e := range exemptPodSelectors {
+		if e.Namespace == pod.Namespace && e.selector.Matches(labels.Set(pod.Labels)) {
+			return &e.ExemptPodSelector
+		}
+	}
+	return nil
+}
+
+// imageSecurityPolicies returns the ImageSecurityPolicies in namespace, or the
+// cluster default ones if it has none. If a policy bundle is configured, they
+// are the ones of the bundle applying to namespace instead. If a policy
+// signing key is configured, it returns an *untrustedPolicyError if any of
+// the policies in the cluster isn't signed by it.
+func imageSecurityPolicies(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+	o := currentOptions()
+	if o.PolicyBundle != "" {
+		isps, err := admissionConfig.fetchPolicyBundle(o.PolicyBundle, o.PolicyBundlePublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return securitypolicy.BundlePoliciesInNamespace(isps, namespace), nil
+	}
+	isps, err := admissionConfig.fetchImageSecurityPolicies(namespace)
+	if err != nil {
+		return nil, err
+	}
+	defaultNamespace := o.DefaultPolicyNamespace
+	if len(isps) == 0 && defaultNamespace != "" && defaultNamespace != namespace {
+		logrus.Debugf("no image security policies in namespace %s, using the defaults in %s", namespace, defaultNamespace)
+		if isps, err = admissionConfig.fetchImageSecurityPolicies(defaultNamespace); err != nil {
+			return nil, err
+		}
+	}
+	if o.PolicySigningPublicKey != "" {
+		for _, isp := range isps {
+			if err := securitypolicy.VerifyPolicySignature(isp, o.PolicySigningPublicKey); err != nil {
+				return nil, &untrustedPolicyError{err: err}
+			}
+		}
+	}
+	return isps, nil
+}
+
+// untrustedPolicyError means an ImageSecurityPolicy doesn't carry a valid
+// signature by the policy signing key, so it can't be enforced
+type untrustedPolicyError struct {
+	err error
+}
+
+func (e *untrustedPolicyError) Error() string {
+	return fmt.Sprintf("refusing to enforce untrusted policy: %v", e.err)
+}
+
+// isMirrorPod returns true if pod mirrors a static pod run by a kubelet
+func isMirrorPod(pod *v1.Pod) bool {
+	_, ok := pod.GetAnnotations()[kritisconstants.MirrorPodAnnotation]
+	return ok
+}
+
+// metadataClient returns a client of the default metadata backend, Container
+// Analysis. Other backends are registered with RegisterMetadataBackend or
+// Options.MetadataBackends.
 func metadataClient() (metadata.MetadataFetcher, error) {
 	return containeranalysis.NewContainerAnalysisClient()
 }
 
-func returnStatus(status constants.Status, message string, w http.ResponseWriter) {
+func returnStatus(status constants.Status, reason constants.Reason, message string, w http.ResponseWriter) {
+	returnStatusWithWarnings(status, reason, message, nil, w)
+}
+
+// returnStatusWithWarnings responds like returnStatus, with warnings the API
+// server shows the client
+func returnStatusWithWarnings(status constants.Status, reason constants.Reason, message string, warnings []string, w http.ResponseWriter) {
 	response := &v1beta1.AdmissionResponse{
 		Allowed: (status == constants.SuccessStatus),
 		Result: &metav1.Status{
 			Status:  string(status),
 			Message: message,
+			Reason:  metav1.StatusReason(reason),
 		},
 	}
-	if err := writeHttpResponse(response, w); err != nil {
+	if err := writeHttpResponse(response, warnings, w); err != nil {
 		logrus.Error("error writing response:", err)
 	}
 }
 
-func writeHttpResponse(response *v1beta1.AdmissionResponse, w http.ResponseWriter) error {
-	ar := v1beta1.AdmissionReview{
-		Response: response,
+// returnTemporaryFailure denies a pod which couldn't be validated because of
+// the temporary err, marking the denial as temporary and asking the client to
+// retry after a while
+func returnTemporaryFailure(err error, w http.ResponseWriter) {
+	response := &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  string(constants.FailureStatus),
+			Message: fmt.Sprintf("temporarily unavailable, retry in %v: %v", retryAfter, err),
+			Reason:  metav1.StatusReason(constants.ReasonTemporarilyUnavailable),
+			Code:    http.StatusServiceUnavailable,
+			Details: &metav1.StatusDetails{
+				RetryAfterSeconds: retryAfterSeconds(),
+			},
+		},
+	}
+	setRetryAfter(err, w)
+	if err := writeHttpResponse(response, nil, w); err != nil {
+		logrus.Error("error writing response:", err)
+	}
+}
+
+// returnError writes the response code for err without an admission response.
+// Temporary errors also get a Retry-After header.
+func returnError(err error, w http.ResponseWriter) {
+	logrus.Error(err)
+	setRetryAfter(err, w)
+	w.WriteHeader(httpStatus(err))
+}
+
+// warnedAdmissionResponse is an AdmissionResponse with warnings, which API
+// servers since Kubernetes 1.19 show the client, e.g. kubectl, but which the
+// vendored admission API predates. Older API servers ignore them.
+type warnedAdmissionResponse struct {
+	*v1beta1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func writeHttpResponse(response *v1beta1.AdmissionResponse, warnings []string, w http.ResponseWriter) error {
+	ar := struct {
+		Response *warnedAdmissionResponse `json:"response"`
+	}{
+		Response: &warnedAdmissionResponse{AdmissionResponse: response, Warnings: warnings},
 	}
 	data, err := json.Marshal(ar)
 	if err != nil {
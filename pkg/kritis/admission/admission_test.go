@@ -17,25 +17,45 @@ limitations under the License.
 package admission
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
 	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	kritisconstants "github.com/grafeas/kritis/pkg/kritis/constants"
 	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/pods"
 	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/grafeas/kritis/pkg/kritis/violation"
+	"github.com/pkg/errors"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 type testConfig struct {
 	mockConfig config
+	body       string
 	httpStatus int
 	allowed    bool
 	status     constants.Status
+	reason     constants.Reason
 	message    string
+	warnings   []string
 }
 
 func Test_BreakglassAnnotation(t *testing.T) {
@@ -86,6 +106,7 @@ func Test_UnqualifiedImage(t *testing.T) {
 		httpStatus: http.StatusOK,
 		allowed:    false,
 		status:     constants.FailureStatus,
+		reason:     constants.ReasonUnqualifiedImage,
 		message:    "image:tag is not a fully qualified image",
 	})
 }
@@ -148,10 +169,2054 @@ func Test_InvalidISP(t *testing.T) {
 		httpStatus: http.StatusOK,
 		allowed:    false,
 		status:     constants.FailureStatus,
+		reason:     constants.ReasonVulnerabilityThreshold,
 		message:    fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
 	})
 }
 
+func Test_CronJobVulnerableImage(t *testing.T) {
+	cronJob := batchv1beta1.CronJob{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1beta1", Kind: "CronJob"},
+		Spec: batchv1beta1.CronJobSpec{
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+						},
+					},
+				},
+			},
+		},
+	}
+	raw, err := json.Marshal(cronJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Kind:   metav1.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "LOW",
+				},
+			},
+		}}, nil
+	}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockMetadataClient{
+			vulnz: []metadata.Vulnerability{{Severity: "HIGH"}},
+		}, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 unmarshalPod,
+			fetchMetadataClient:         mockMetadata,
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		},
+		body:       string(body),
+		httpStatus: http.StatusOK,
+		allowed:    false,
+		status:     constants.FailureStatus,
+		reason:     constants.ReasonVulnerabilityThreshold,
+		message:    fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
+	})
+}
+
+func Test_OtherOperations(t *testing.T) {
+	pod, err := json.Marshal(v1.Pod{
+		Spec: v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name      string
+		operation v1beta1.Operation
+		deny      bool
+		allowed   bool
+		status    constants.Status
+		reason    constants.Reason
+		message   string
+	}{
+		{
+			name:      "delete",
+			operation: v1beta1.Delete,
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+		},
+		{
+			name:      "connect",
+			operation: v1beta1.Connect,
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+		},
+		{
+			name:      "denied delete",
+			operation: v1beta1.Delete,
+			deny:      true,
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonUnsupportedOperation,
+			message:   unsupportedOperationMessage(v1beta1.Delete),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Deleted pods are only in OldObject, and CONNECT requests have
+			// no object at all
+			request := &v1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Operation: test.operation,
+			}
+			if test.operation == v1beta1.Delete {
+				request.OldObject = runtime.RawExtension{Raw: pod}
+			}
+			body, err := json.Marshal(v1beta1.AdmissionReview{Request: request})
+			if err != nil {
+				t.Fatal(err)
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:         unmarshalPod,
+					retrieveReview:      unmarshalReview,
+					fetchMetadataClient: mockMetadata(),
+					fetchImageSecurityPolicies: func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+						t.Errorf("%s request was validated", test.operation)
+						return nil, nil
+					},
+					options: Options{DenyOtherOperations: test.deny},
+				},
+				body:       string(body),
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_PodUpdateValidatesNewImages(t *testing.T) {
+	oldImage := testutil.QualifiedImage
+	newImage := "gcr.io/image/new@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	podWith := func(images ...string) []byte {
+		pod := v1.Pod{}
+		for _, image := range images {
+			pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Image: image})
+		}
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	body, err := json.Marshal(v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: v1beta1.Update,
+			Object:    runtime.RawExtension{Raw: podWith(oldImage, newImage)},
+			OldObject: runtime.RawExtension{Raw: podWith(oldImage)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	validated := []string{}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated = append(validated, image)
+		return []securitypolicy.SecurityPolicyViolation{{
+			Vulnerability: metadata.Vulnerability{Severity: "HIGH"},
+			Violation:     securitypolicy.ExceedsMaxSeverityViolation,
+			Reason:        securitypolicy.ExceedsMaxSeverityViolationReason(image, metadata.Vulnerability{Severity: "HIGH"}, kritisv1beta1.ImageSecurityPolicy{}),
+		}}, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 unmarshalPod,
+			retrieveReview:              unmarshalReview,
+			fetchMetadataClient:         mockMetadata(),
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: mockValidate,
+		},
+		body:       string(body),
+		httpStatus: http.StatusOK,
+		allowed:    false,
+		status:     constants.FailureStatus,
+		reason:     constants.ReasonVulnerabilityThreshold,
+		message:    fmt.Sprintf("found violations in %s", newImage),
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{newImage}, validated)
+}
+
+func Test_DryRun(t *testing.T) {
+	reviewBody := func(dryRun bool) string {
+		raw, err := json.Marshal(v1.Pod{
+			Spec: v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal(v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				Kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Object: runtime.RawExtension{Raw: raw},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The vendored AdmissionRequest has no DryRun field
+		ar := map[string]map[string]interface{}{}
+		if err := json.Unmarshal(body, &ar); err != nil {
+			t.Fatal(err)
+		}
+		ar["request"]["dryRun"] = dryRun
+		if body, err = json.Marshal(ar); err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	allow := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	deny := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return []securitypolicy.SecurityPolicyViolation{{
+			Violation: securitypolicy.ExceedsMaxSeverityViolation,
+		}}, nil
+	}
+	var tests = []struct {
+		name         string
+		dryRun       bool
+		validate     func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error)
+		allowed      bool
+		attestations int
+		handled      int
+	}{
+		{
+			name:         "admitted",
+			validate:     allow,
+			allowed:      true,
+			attestations: 1,
+		},
+		{
+			name:     "admitted in a dry run",
+			dryRun:   true,
+			validate: allow,
+			allowed:  true,
+		},
+		{
+			name:     "denied",
+			validate: deny,
+			handled:  1,
+		},
+		{
+			name:     "denied in a dry run",
+			dryRun:   true,
+			validate: deny,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy := slowStrategy{release: make(chan struct{}), handled: make(chan string, 1)}
+			close(strategy.release)
+			original := defaultViolationStrategy
+			defer func() {
+				defaultViolationStrategy = original
+			}()
+			defaultViolationStrategy = strategy
+			attestations := 0
+			mockAttest := func(namespace string, image string, client metadata.MetadataFetcher) error {
+				attestations++
+				return nil
+			}
+			tc := testConfig{
+				mockConfig: config{
+					retrieveReview:              unmarshalReview,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: test.validate,
+					createAttestations:          mockAttest,
+				},
+				body:       reviewBody(test.dryRun),
+				httpStatus: http.StatusOK,
+				allowed:    true,
+				status:     constants.SuccessStatus,
+				message:    constants.SuccessMessage,
+			}
+			if !test.allowed {
+				tc.allowed = false
+				tc.status = constants.FailureStatus
+				tc.reason = constants.ReasonVulnerabilityThreshold
+				tc.message = fmt.Sprintf("found violations in %s", testutil.QualifiedImage)
+			}
+			before := scrapeMetrics()
+			RunTest(t, tc)
+			if attestations != test.attestations {
+				t.Errorf("expected %d attestations, got %d", test.attestations, attestations)
+			}
+			if after := scrapeMetrics(); test.dryRun && after != before {
+				t.Errorf("expected a dry run not to change the metrics, got:\n%s", after)
+			}
+			if len(strategy.handled) != test.handled {
+				t.Errorf("expected %d handled violations, got %d", test.handled, len(strategy.handled))
+			}
+		})
+	}
+}
+
+func Test_NewImages(t *testing.T) {
+	var tests = []struct {
+		name      string
+		images    []string
+		oldImages []string
+		expected  []string
+	}{
+		{"create", []string{"a", "b"}, nil, []string{"a", "b"}},
+		{"image added", []string{"a", "b"}, []string{"a"}, []string{"b"}},
+		{"image unchanged", []string{"a"}, []string{"a"}, []string{}},
+		{"image replaced", []string{"b"}, []string{"a"}, []string{"b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, newImages(test.images, test.oldImages))
+		})
+	}
+}
+
+func Test_RootImageRunAsNonRoot(t *testing.T) {
+	nonRoot := true
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return []securitypolicy.SecurityPolicyViolation{{
+			Violation: securitypolicy.RootImageViolation,
+			Reason:    securitypolicy.RootImageViolationReason(image, ""),
+		}}, nil
+	}
+	var tests = []struct {
+		name            string
+		securityContext *v1.SecurityContext
+		allowed         bool
+		status          constants.Status
+		reason          constants.Reason
+		message         string
+	}{
+		{
+			name:    "root image run as root",
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonRootImage,
+			message: fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
+		},
+		{
+			name:            "root image run as non-root",
+			securityContext: &v1.SecurityContext{RunAsNonRoot: &nonRoot},
+			allowed:         true,
+			status:          constants.SuccessStatus,
+			message:         constants.SuccessMessage,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: testutil.QualifiedImage, SecurityContext: test.securityContext}},
+					},
+				}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_RootImageRunAsNonRootIsntCached(t *testing.T) {
+	nonRoot := true
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return []securitypolicy.SecurityPolicyViolation{{
+			Violation: securitypolicy.RootImageViolation,
+			Reason:    securitypolicy.RootImageViolationReason(image, ""),
+		}}, nil
+	}
+	attested := []string{}
+	mockAttest := func(namespace string, image string, client metadata.MetadataFetcher) error {
+		attested = append(attested, image)
+		return nil
+	}
+	cache := newAllowCache(defaultCacheTTL)
+	run := func(securityContext *v1.SecurityContext, allowed bool) {
+		tc := testConfig{
+			mockConfig: config{
+				retrievePod: func(r *http.Request) (*v1.Pod, error) {
+					return &v1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{Image: testutil.QualifiedImage, SecurityContext: securityContext}},
+						},
+					}, nil
+				},
+				fetchMetadataClient:         mockMetadata(),
+				fetchImageSecurityPolicies:  mockISP,
+				validateImageSecurityPolicy: mockValidate,
+				createAttestations:          mockAttest,
+				cache:                       cache,
+			},
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		}
+		if !allowed {
+			tc.allowed = false
+			tc.status = constants.FailureStatus
+			tc.reason = constants.ReasonRootImage
+			tc.message = fmt.Sprintf("found violations in %s", testutil.QualifiedImage)
+		}
+		RunTest(t, tc)
+	}
+	// A pod running the root image as non-root is admitted, but another
+	// running it as root still isn't
+	run(&v1.SecurityContext{RunAsNonRoot: &nonRoot}, true)
+	run(nil, false)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{}, attested)
+}
+
+// slowStrategy handles violations once release is closed
+type slowStrategy struct {
+	release chan struct{}
+	handled chan string
+}
+
+func (s slowStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	<-s.release
+	s.handled <- image
+	return nil
+}
+
+func Test_ViolationHandledAsynchronously(t *testing.T) {
+	slow := slowStrategy{release: make(chan struct{}), handled: make(chan string, 1)}
+	original := defaultViolationStrategy
+	defer func() {
+		defaultViolationStrategy = original
+	}()
+	defaultViolationStrategy = violation.NewQueueStrategy(slow, 1, 1)
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "LOW",
+				},
+			},
+		}}, nil
+	}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockMetadataClient{
+			vulnz: []metadata.Vulnerability{{Severity: "HIGH"}},
+		}, nil
+	}
+	// The response is returned while the strategy is still blocked
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockValidPod(),
+			fetchMetadataClient:         mockMetadata,
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    false,
+		status:     constants.FailureStatus,
+		reason:     constants.ReasonVulnerabilityThreshold,
+		message:    fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
+	})
+	close(slow.release)
+	select {
+	case image := <-slow.handled:
+		testutil.CheckErrorAndDeepEqual(t, false, nil, testutil.QualifiedImage, image)
+	case <-time.After(5 * time.Second):
+		t.Fatal("violation was never handled")
+	}
+}
+
+func Test_NoPolicies(t *testing.T) {
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name          string
+		requirePolicy bool
+		allowed       bool
+		status        constants.Status
+		reason        constants.Reason
+		message       string
+	}{
+		{
+			name:          "admitted by default",
+			requirePolicy: false,
+			allowed:       true,
+			status:        constants.SuccessStatus,
+			message:       constants.SuccessMessage,
+		},
+		{
+			name:          "denied when a policy is required",
+			requirePolicy: true,
+			allowed:       false,
+			status:        constants.FailureStatus,
+			reason:        constants.ReasonNoPolicy,
+			message:       "no ImageSecurityPolicy found in namespace default",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+					options:                     Options{RequirePolicy: test.requirePolicy},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_DefaultPolicyNamespace(t *testing.T) {
+	policies := map[string][]kritisv1beta1.ImageSecurityPolicy{
+		"team":   {{ObjectMeta: metav1.ObjectMeta{Name: "team-isp", Namespace: "team"}}},
+		"kritis": {{ObjectMeta: metav1.ObjectMeta{Name: "default-isp", Namespace: "kritis"}}},
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return policies[namespace], nil
+	}
+	var tests = []struct {
+		name             string
+		namespace        string
+		defaultNamespace string
+		expected         []string
+	}{
+		{
+			name:             "namespace policies override the default",
+			namespace:        "team",
+			defaultNamespace: "kritis",
+			expected:         []string{"team-isp"},
+		},
+		{
+			name:             "default applies to namespaces without policies",
+			namespace:        "other",
+			defaultNamespace: "kritis",
+			expected:         []string{"default-isp"},
+		},
+		{
+			name:      "no default",
+			namespace: "other",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: test.namespace},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+					},
+				}, nil
+			}
+			var validated []string
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, isp.Name)
+				return nil, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					options:                     Options{DefaultPolicyNamespace: test.defaultNamespace},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    true,
+				status:     constants.SuccessStatus,
+				message:    constants.SuccessMessage,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, validated)
+		})
+	}
+}
+
+func Test_PolicyBundle(t *testing.T) {
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{ObjectMeta: metav1.ObjectMeta{Name: "cluster-isp", Namespace: namespace}}}, nil
+	}
+	mockBundle := func(ref string, publicKey string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		if ref != "gcr.io/kritis-project/policies:v1" || publicKey != "key" {
+			return nil, fmt.Errorf("unexpected bundle %s", ref)
+		}
+		return []kritisv1beta1.ImageSecurityPolicy{
+			{ObjectMeta: metav1.ObjectMeta{Name: "everywhere"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "prod-only", Namespace: "prod"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "dev-only", Namespace: "dev"}},
+		}, nil
+	}
+	var validated []string
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated = append(validated, isp.Name)
+		return nil, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockPod,
+			fetchMetadataClient:         mockMetadata(),
+			fetchImageSecurityPolicies:  mockISP,
+			fetchPolicyBundle:           mockBundle,
+			validateImageSecurityPolicy: mockValidate,
+			options:                     Options{PolicyBundle: "gcr.io/kritis-project/policies:v1", PolicyBundlePublicKey: "key"},
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"everywhere", "prod-only"}, validated)
+}
+
+func Test_InitContainerRegistry(t *testing.T) {
+	untrusted := "docker.io/someone/init@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				AllowedInitContainerRegistries: []string{"gcr.io/image"},
+			},
+		}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name       string
+		containers []v1.Container
+		init       []v1.Container
+		cached     bool
+		allowed    bool
+		status     constants.Status
+		reason     constants.Reason
+		message    string
+	}{
+		{
+			name:    "init container from an allowed registry",
+			init:    []v1.Container{{Image: testutil.QualifiedImage}},
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:       "container from another registry",
+			containers: []v1.Container{{Image: untrusted}},
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		},
+		{
+			name:       "init container from a disallowed registry",
+			init:       []v1.Container{{Image: untrusted}},
+			containers: []v1.Container{{Image: testutil.QualifiedImage}},
+			allowed:    false,
+			status:     constants.FailureStatus,
+			reason:     constants.ReasonDisallowedRegistry,
+			message:    string(securitypolicy.InitContainerRegistryViolationReason(untrusted, []string{"gcr.io/image"})),
+		},
+		{
+			name:    "previously admitted image in an init container from a disallowed registry",
+			init:    []v1.Container{{Image: untrusted}},
+			cached:  true,
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonDisallowedRegistry,
+			message: string(securitypolicy.InitContainerRegistryViolationReason(untrusted, []string{"gcr.io/image"})),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+					Spec: v1.PodSpec{
+						InitContainers: test.init,
+						Containers:     test.containers,
+					},
+				}, nil
+			}
+			cache := newAllowCache(defaultCacheTTL)
+			if test.cached {
+				cache.add("default", untrusted)
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					cache:                       cache,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_TenantRegistry(t *testing.T) {
+	own := "gcr.io/platform/team-a/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	other := "gcr.io/platform/team-b/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				TenantRegistryPrefix: "gcr.io/platform/{namespace}",
+			},
+		}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name    string
+		images  []string
+		cached  bool
+		allowed bool
+		status  constants.Status
+		reason  constants.Reason
+		message string
+	}{
+		{
+			name:    "same tenant image",
+			images:  []string{own},
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:    "cross tenant image",
+			images:  []string{own, other},
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonDisallowedRegistry,
+			message: string(securitypolicy.CrossTenantImageViolationReason(other, "team-a", "gcr.io/platform/team-a")),
+		},
+		{
+			name:    "previously admitted cross tenant image",
+			images:  []string{other},
+			cached:  true,
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonDisallowedRegistry,
+			message: string(securitypolicy.CrossTenantImageViolationReason(other, "team-a", "gcr.io/platform/team-a")),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containers := []v1.Container{}
+			for _, image := range test.images {
+				containers = append(containers, v1.Container{Image: image})
+			}
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"},
+					Spec:       v1.PodSpec{Containers: containers},
+				}, nil
+			}
+			cache := newAllowCache(defaultCacheTTL)
+			if test.cached {
+				cache.add("team-a", other)
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					cache:                       cache,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_ServiceAccountBinding(t *testing.T) {
+	sensitive := "gcr.io/my-project/payments/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				ServiceAccountBindings: []kritisv1beta1.ServiceAccountBinding{{
+					Images:          []string{"gcr.io/my-project/payments/*"},
+					ServiceAccounts: []string{"payments"},
+				}},
+			},
+		}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name           string
+		serviceAccount string
+		allowed        bool
+		status         constants.Status
+		reason         constants.Reason
+		message        string
+	}{
+		{
+			name:           "approved service account",
+			serviceAccount: "payments",
+			allowed:        true,
+			status:         constants.SuccessStatus,
+			message:        constants.SuccessMessage,
+		},
+		{
+			name:           "disapproved service account",
+			serviceAccount: "web",
+			allowed:        false,
+			status:         constants.FailureStatus,
+			reason:         constants.ReasonDisallowedServiceAccount,
+			message:        string(securitypolicy.ServiceAccountViolationReason(sensitive, "web", []string{"payments"})),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					Spec: v1.PodSpec{
+						ServiceAccountName: test.serviceAccount,
+						Containers:         []v1.Container{{Image: sensitive}},
+					},
+				}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					cache:                       newAllowCache(defaultCacheTTL),
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_DefaultAction(t *testing.T) {
+	matched := "gcr.io/my-project/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	unmatched := "gcr.io/other/image@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name          string
+		defaultAction string
+		allowed       bool
+		status        constants.Status
+		reason        constants.Reason
+		message       string
+	}{
+		{
+			name:          "unmatched image under allow default",
+			defaultAction: kritisv1beta1.DefaultActionAllow,
+			allowed:       true,
+			status:        constants.SuccessStatus,
+			message:       constants.SuccessMessage,
+		},
+		{
+			name:          "unmatched image under deny default",
+			defaultAction: kritisv1beta1.DefaultActionDeny,
+			allowed:       false,
+			status:        constants.FailureStatus,
+			reason:        constants.ReasonUnmatchedImage,
+			message:       string(securitypolicy.UnmatchedImageViolationReason(unmatched)),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{{
+					Spec: kritisv1beta1.ImageSecurityPolicySpec{
+						Images:        []string{"gcr.io/my-project/*"},
+						DefaultAction: test.defaultAction,
+					},
+				}}, nil
+			}
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: matched}, {Image: unmatched}},
+					},
+				}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					cache:                       newAllowCache(defaultCacheTTL),
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_PolicySignature(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	isp := kritisv1beta1.ImageSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "strict", Namespace: "prod"},
+		Spec: kritisv1beta1.ImageSecurityPolicySpec{
+			PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+				MaximumSeverity: "LOW",
+			},
+		},
+	}
+	signature, err := securitypolicy.SignImageSecurityPolicy(isp, publicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isp.Annotations = map[string]string{kritisconstants.PolicySignatureAnnotation: signature}
+	tampered := *isp.DeepCopy()
+	tampered.Spec.PackageVulernerabilityRequirements.MaximumSeverity = "CRITICAL"
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name    string
+		isp     kritisv1beta1.ImageSecurityPolicy
+		allowed bool
+		status  constants.Status
+		reason  constants.Reason
+		message string
+	}{
+		{
+			name:    "validly signed policy",
+			isp:     isp,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:    "tampered policy",
+			isp:     tampered,
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonUntrustedPolicy,
+			message: "refusing to enforce untrusted policy: image security policy prod/strict was modified since it was signed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{test.isp}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockValidPod(),
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					cache:                       newAllowCache(defaultCacheTTL),
+					options:                     Options{PolicySigningPublicKey: publicKey},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_NamespaceVulnerabilityBudget(t *testing.T) {
+	running := "gcr.io/image/running@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	securitypolicy.SetNamespaceVulnerabilities("budgeted", map[string]int{running: 3})
+	client := mockMetadataClient{vulnz: []metadata.Vulnerability{{CVE: "cve1"}, {CVE: "cve2"}}}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name    string
+		max     int
+		allowed bool
+		status  constants.Status
+		reason  constants.Reason
+		message string
+	}{
+		{
+			name:    "at budget",
+			max:     5,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:    "over budget",
+			max:     4,
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonVulnerabilityBudget,
+			message: string(securitypolicy.NamespaceBudgetViolationReason("budgeted", 5, 4)),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "budgeted"},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: running}, {Image: testutil.QualifiedImage}},
+					},
+				}, nil
+			}
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{{
+					Spec: kritisv1beta1.ImageSecurityPolicySpec{
+						MaxNamespaceVulnerabilities: test.max,
+					},
+				}}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod: mockPod,
+					fetchMetadataClient: func() (metadata.MetadataFetcher, error) {
+						return client, nil
+					},
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+// mockBuildsClient returns the builds of each image
+type mockBuildsClient struct {
+	mockMetadataClient
+	builds map[string][]metadata.Build
+}
+
+func (m mockBuildsClient) GetBuildDetails(containerImage string) ([]metadata.Build, error) {
+	return m.builds[containerImage], nil
+}
+
+func Test_InconsistentProvenance(t *testing.T) {
+	sidecar := "docker.io/someone/sidecar@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	builders := map[string][]string{
+		testutil.QualifiedImage: {"ci@project"},
+		sidecar:                 {"someone"},
+	}
+	client := mockBuildsClient{builds: map[string][]metadata.Build{
+		testutil.QualifiedImage: {{Creator: "ci@project"}},
+		sidecar:                 {{Creator: "someone"}},
+	}}
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: testutil.QualifiedImage}, {Image: sidecar}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				RequireConsistentProvenance: true,
+			},
+		}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod: mockPod,
+			fetchMetadataClient: func() (metadata.MetadataFetcher, error) {
+				return client, nil
+			},
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: mockValidate,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    false,
+		status:     constants.FailureStatus,
+		reason:     constants.ReasonProvenance,
+		message:    string(securitypolicy.InconsistentProvenanceViolationReason(builders)),
+	})
+}
+
+func Test_SourceRepository(t *testing.T) {
+	client := mockBuildsClient{builds: map[string][]metadata.Build{
+		testutil.QualifiedImage: {{Creator: "ci@project", SourceURI: "https://github.com/org/app"}},
+	}}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				RequireSourceRepository: true,
+			},
+		}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name    string
+		source  string
+		allowed bool
+		reason  constants.Reason
+		message string
+	}{
+		{
+			name:    "matching source repository",
+			source:  "https://github.com/org/app",
+			allowed: true,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:    "mismatched source repository",
+			source:  "https://github.com/org/other",
+			reason:  constants.ReasonProvenance,
+			message: string(securitypolicy.SourceRepositoryMismatchViolationReason(testutil.QualifiedImage, "https://github.com/org/other", []string{"https://github.com/org/app"})),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The workload declares the source repository, which its pod inherits
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{kritisconstants.SourceRepositoryAnnotation: test.source},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+					},
+				},
+			}
+			status := constants.FailureStatus
+			if test.allowed {
+				status = constants.SuccessStatus
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod: func(r *http.Request) (*v1.Pod, error) {
+						return pods.FromTemplate(deployment)
+					},
+					fetchMetadataClient: func() (metadata.MetadataFetcher, error) {
+						return client, nil
+					},
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_ImageStreamResolved(t *testing.T) {
+	internal := "image-registry.openshift-image-registry.svc:5000/shop/frontend@sha256:abcd"
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: internal}},
+			},
+		}, nil
+	}
+	mockResolve := func(image string) (string, error) {
+		if image == internal {
+			return testutil.QualifiedImage, nil
+		}
+		return image, nil
+	}
+	validated := []string{}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated = append(validated, image)
+		return nil, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockPod,
+			fetchMetadataClient:         mockMetadata(),
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: mockValidate,
+			resolveImage:                mockResolve,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{testutil.QualifiedImage}, validated)
+}
+
+func Test_MirrorPod(t *testing.T) {
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "etcd-node-1",
+				Namespace:   "kube-system",
+				Annotations: map[string]string{"kubernetes.io/config.mirror": "4d4f5e"},
+			},
+			Spec: v1.PodSpec{
+				NodeName:   "node-1",
+				Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "LOW",
+				},
+			},
+		}}, nil
+	}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockMetadataClient{
+			vulnz: []metadata.Vulnerability{{Severity: "HIGH"}},
+		}, nil
+	}
+	var tests = []struct {
+		name    string
+		exempt  bool
+		allowed bool
+		status  constants.Status
+		reason  constants.Reason
+		message string
+	}{
+		{
+			name:    "validated by default",
+			exempt:  false,
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonVulnerabilityThreshold,
+			message: fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
+		},
+		{
+			name:    "exempt",
+			exempt:  true,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata,
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+					options:                     Options{ExemptMirrorPods: test.exempt, ExemptNamespaces: []string{}},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_ResolveTags(t *testing.T) {
+	taggedImage := "gcr.io/image/digest:1.0"
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: taggedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	var tests = []struct {
+		name        string
+		resolveTags bool
+		allowed     bool
+		status      constants.Status
+		reason      constants.Reason
+		message     string
+	}{
+		{
+			name:        "tag resolved to digest",
+			resolveTags: true,
+			allowed:     true,
+			status:      constants.SuccessStatus,
+			message:     constants.SuccessMessage,
+		},
+		{
+			name:    "tag left unresolved",
+			allowed: false,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonUnqualifiedImage,
+			message: fmt.Sprintf("%s is not a fully qualified image", taggedImage),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := testutil.NewFakeDigestResolver(map[string]string{
+				taggedImage: testutil.QualifiedImage,
+			})
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         func() (metadata.MetadataFetcher, error) { return mockMetadataClient{}, nil },
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+					digestResolver:              resolver,
+					options:                     Options{ResolveTags: test.resolveTags},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_RequireDigestReferenceWithResolvedTag(t *testing.T) {
+	taggedImage := "gcr.io/image/digest:1.0"
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: taggedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				RequireDigestReference: true,
+			},
+		}}, nil
+	}
+	resolver := testutil.NewFakeDigestResolver(map[string]string{
+		taggedImage: testutil.QualifiedImage,
+	})
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockPod,
+			fetchMetadataClient:         func() (metadata.MetadataFetcher, error) { return mockMetadataClient{}, nil },
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+			digestResolver:              resolver,
+			options:                     Options{ResolveTags: true},
+		},
+		httpStatus: http.StatusOK,
+		allowed:    false,
+		status:     constants.FailureStatus,
+		reason:     constants.ReasonUnqualifiedImage,
+		message:    fmt.Sprintf("%s is not referenced by digest", taggedImage),
+	})
+}
+
+func Test_PauseImageSkipped(t *testing.T) {
+	pause := "registry.k8s.io/pause@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: pause}, {Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	validated := []string{}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated = append(validated, image)
+		return nil, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockPod,
+			fetchMetadataClient:         mockMetadata(),
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: mockValidate,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{testutil.QualifiedImage}, validated)
+}
+
+func Test_StrictForPrivileged(t *testing.T) {
+	yes := true
+	privileged := v1.PodSpec{
+		Containers: []v1.Container{{
+			Name:            "agent",
+			Image:           testutil.QualifiedImage,
+			SecurityContext: &v1.SecurityContext{Privileged: &yes},
+		}},
+	}
+	unprivileged := v1.PodSpec{
+		Containers: []v1.Container{{Name: "app", Image: testutil.QualifiedImage}},
+	}
+	var tests = []struct {
+		name      string
+		spec      v1.PodSpec
+		strict    bool
+		governs   []string
+		attested  bool
+		allowed   bool
+		status    constants.Status
+		reason    constants.Reason
+		message   string
+		validated []string
+	}{
+		{
+			name:      "privileged pod without attestations",
+			spec:      privileged,
+			strict:    true,
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonNoAttestation,
+			message:   string(securitypolicy.UnattestedPrivilegedViolationReason(testutil.QualifiedImage, []string{"privileged container agent"})),
+			validated: []string{},
+		},
+		{
+			name:      "privileged pod with attestations",
+			spec:      privileged,
+			strict:    true,
+			attested:  true,
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+			validated: []string{},
+		},
+		{
+			name:      "unprivileged pod without attestations",
+			spec:      unprivileged,
+			strict:    true,
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+			validated: []string{testutil.QualifiedImage},
+		},
+		{
+			name:      "privileged pod without a strict policy",
+			spec:      privileged,
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+			validated: []string{testutil.QualifiedImage},
+		},
+		{
+			name:      "privileged pod with images the strict policy doesn't govern",
+			spec:      privileged,
+			strict:    true,
+			governs:   []string{"gcr.io/other/*"},
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+			validated: []string{testutil.QualifiedImage},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{Spec: test.spec}, nil
+			}
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{{
+					Spec: kritisv1beta1.ImageSecurityPolicySpec{Images: test.governs, StrictForPrivileged: test.strict},
+				}}, nil
+			}
+			mockVerify := func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+				return test.attested, nil
+			}
+			validated := []string{}
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, image)
+				return nil, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					verifyAttestations:          mockVerify,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+		})
+	}
+}
+
+func Test_NeverPullPolicy(t *testing.T) {
+	local := "local/app:dev"
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: local, ImagePullPolicy: v1.PullNever}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	var tests = []struct {
+		name       string
+		policy     string
+		httpStatus int
+		allowed    bool
+		status     constants.Status
+		reason     constants.Reason
+		message    string
+		validated  []string
+	}{
+		{
+			name:       "validated like other images",
+			httpStatus: http.StatusServiceUnavailable,
+			validated:  []string{},
+		},
+		{
+			name:       "deny",
+			policy:     NeverPullDeny,
+			httpStatus: http.StatusOK,
+			allowed:    false,
+			status:     constants.FailureStatus,
+			reason:     constants.ReasonNeverPulled,
+			message:    neverPulledMessage(local),
+			validated:  []string{},
+		},
+		{
+			name:       "allow",
+			policy:     NeverPullAllow,
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+			validated:  []string{},
+		},
+		{
+			name:       "best-effort validate",
+			policy:     NeverPullValidate,
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+			validated:  []string{local},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validated := []string{}
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, image)
+				return nil, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					digestResolver:              testutil.NewFakeDigestResolver(nil),
+					options:                     Options{ResolveTags: true, NeverPullPolicy: test.policy},
+				},
+				httpStatus: test.httpStatus,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+		})
+	}
+}
+
+func Test_UnresolvedImagePolicy(t *testing.T) {
+	placeholder := "$(APP_IMAGE)"
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: placeholder}, {Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	var tests = []struct {
+		name      string
+		policy    string
+		allowed   bool
+		status    constants.Status
+		reason    constants.Reason
+		message   string
+		validated []string
+	}{
+		{
+			name:      "denied by default",
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonUnresolvedImage,
+			message:   unresolvedImageMessage(placeholder),
+			validated: []string{},
+		},
+		{
+			name:      "deny",
+			policy:    UnresolvedImageDeny,
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonUnresolvedImage,
+			message:   unresolvedImageMessage(placeholder),
+			validated: []string{},
+		},
+		{
+			name:      "allow",
+			policy:    UnresolvedImageAllow,
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+			validated: []string{testutil.QualifiedImage},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validated := []string{}
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, image)
+				return nil, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					options:                     Options{UnresolvedImagePolicy: test.policy},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+		})
+	}
+}
+
+func Test_RequirePullableImages(t *testing.T) {
+	existing := "gcr.io/kritis-project/app:v1"
+	missing := "gcr.io/kritis-project/app:v2"
+	notFound := errors.New("unsupported status code 404; body: ")
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	mockSecret := func(namespace string, name string) (*v1.Secret, error) {
+		if namespace != "team" || name != "regcred" {
+			return nil, fmt.Errorf("secret %s/%s not found", namespace, name)
+		}
+		return &v1.Secret{
+			Type: v1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				v1.DockerConfigJsonKey: []byte(`{"auths": {"gcr.io": {"username": "_json_key", "password": "key"}}}`),
+			},
+		}, nil
+	}
+	var tests = []struct {
+		name       string
+		require    bool
+		image      string
+		pullPolicy v1.PullPolicy
+		allowed    bool
+		status     constants.Status
+		reason     constants.Reason
+		message    string
+		checked    []string
+	}{
+		{
+			name:    "existing image",
+			require: true,
+			image:   existing,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+			checked: []string{existing},
+		},
+		{
+			name:    "nonexistent image",
+			require: true,
+			image:   missing,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonImageNotPullable,
+			message: unpullableMessage(missing, notFound),
+			checked: []string{missing},
+		},
+		{
+			name:       "never pulled image",
+			require:    true,
+			image:      missing,
+			pullPolicy: v1.PullNever,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+			checked:    []string{},
+		},
+		{
+			name:    "not required",
+			image:   missing,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+			checked: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "team"},
+					Spec: v1.PodSpec{
+						Containers:       []v1.Container{{Image: test.image, ImagePullPolicy: test.pullPolicy}},
+						ImagePullSecrets: []v1.LocalObjectReference{{Name: "regcred"}},
+					},
+				}, nil
+			}
+			checked := []string{}
+			mockPullable := func(image string, creds map[string]util.RegistryCredentials) error {
+				checked = append(checked, image)
+				if creds["gcr.io"].Password != "key" {
+					t.Errorf("expected the credentials of the pod's imagePullSecrets, got %v", creds)
+				}
+				if image == missing {
+					return notFound
+				}
+				return nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					fetchSecret:                 mockSecret,
+					checkPullable:               mockPullable,
+					options:                     Options{RequirePullableImages: test.require},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.checked, checked)
+		})
+	}
+}
+
+func Test_SuggestImageUpgrades(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/team/app/tags/list":
+			fmt.Fprint(w, `{"name": "team/app", "tags": ["1.2.3", "1.2.4", "1.3.0"]}`)
+		case "/v2/team/sidecar/tags/list":
+			fmt.Fprint(w, `{"name": "team/sidecar", "tags": ["2.0.0", "1.9.9"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": [{"code": "NAME_UNKNOWN", "message": "repository name not known to registry"}]}`)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	outdated := host + "/team/app:1.2.3"
+	current := host + "/team/sidecar:2.0.0"
+	unlisted := host + "/team/unknown:1.0.0"
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	var tests = []struct {
+		name     string
+		suggest  bool
+		images   []string
+		warnings []string
+	}{
+		{
+			name:     "newer patch release",
+			suggest:  true,
+			images:   []string{outdated, current},
+			warnings: []string{upgradeWarning(outdated, "1.2.4")},
+		},
+		{
+			name:    "tags which can't be listed",
+			suggest: true,
+			images:  []string{current, unlisted},
+		},
+		{
+			name:   "upgrades not suggested",
+			images: []string{outdated},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				pod := &v1.Pod{}
+				for _, image := range test.images {
+					pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Image: image})
+				}
+				return pod, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					newerPatchTag:               util.NewerPatchTag,
+					options:                     Options{SuggestImageUpgrades: test.suggest},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    true,
+				status:     constants.SuccessStatus,
+				message:    constants.SuccessMessage,
+				warnings:   test.warnings,
+			})
+		})
+	}
+}
+
+func Test_ResolveFailureCached(t *testing.T) {
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: "gcr.io/unreachable/image:tag"}},
+			},
+		}, nil
+	}
+	resolver := testutil.NewFakeDigestResolver(nil)
+	now := time.Now()
+	failures := newNegativeCache(defaultNegativeCacheTTL)
+	failures.now = func() time.Time { return now }
+	tc := testConfig{
+		mockConfig: config{
+			retrievePod:     mockPod,
+			digestResolver:  resolver,
+			resolveFailures: failures,
+			options:         Options{ResolveTags: true},
+		},
+		httpStatus: http.StatusServiceUnavailable,
+	}
+	RunTest(t, tc)
+	now = now.Add(defaultNegativeCacheTTL)
+	RunTest(t, tc)
+	if calls := resolver.Calls(); calls != 1 {
+		t.Fatalf("expected resolver to be called once within the negative cache window, got %d calls", calls)
+	}
+	now = now.Add(time.Second)
+	RunTest(t, tc)
+	if calls := resolver.Calls(); calls != 2 {
+		t.Errorf("expected resolver to be called again after the negative cache expired, got %d calls", calls)
+	}
+}
+
+func Test_RetryServedFromResponseCache(t *testing.T) {
+	reviewBody := func(uid types.UID, name string) string {
+		raw, err := json.Marshal(v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal(v1beta1.AdmissionReview{
+			Request: &v1beta1.AdmissionRequest{
+				UID:    uid,
+				Kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Object: runtime.RawExtension{Raw: raw},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	validated := []string{}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated = append(validated, image)
+		return []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}}, nil
+	}
+	now := time.Now()
+	responses := newResponseCache(defaultResponseCacheTTL)
+	responses.now = func() time.Time { return now }
+	tc := func(body string) testConfig {
+		return testConfig{
+			mockConfig: config{
+				retrieveReview:              unmarshalReview,
+				fetchMetadataClient:         mockMetadata(),
+				fetchImageSecurityPolicies:  mockISP,
+				validateImageSecurityPolicy: mockValidate,
+				responses:                   responses,
+			},
+			body:       body,
+			httpStatus: http.StatusOK,
+			status:     constants.FailureStatus,
+			reason:     constants.ReasonVulnerabilityThreshold,
+			message:    fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
+		}
+	}
+	request := reviewBody("705ab4f5-6393-11e8-b7cc-42010a800002", "app")
+	RunTest(t, tc(request))
+	// The retry of the request is served the same response from the cache
+	RunTest(t, tc(request))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{testutil.QualifiedImage}, validated)
+	// A request reusing the UID with other content is validated
+	RunTest(t, tc(reviewBody("705ab4f5-6393-11e8-b7cc-42010a800002", "other-app")))
+	// So are requests without a UID
+	RunTest(t, tc(reviewBody("", "app")))
+	RunTest(t, tc(reviewBody("", "app")))
+	if len(validated) != 4 {
+		t.Fatalf("expected requests with other content or without a UID to be validated, got %d validations", len(validated))
+	}
+	// Once the cached response expires, the pod is validated again
+	now = now.Add(defaultResponseCacheTTL + time.Second)
+	RunTest(t, tc(request))
+	if len(validated) != 5 {
+		t.Errorf("expected the pod to be validated again after the cached response expired, got %d validations", len(validated))
+	}
+}
+
+func Test_PolicyEvaluationOrder(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{
+			{ObjectMeta: metav1.ObjectMeta{Name: "b-isp"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "a-isp"}},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "z-isp"},
+				Spec:       kritisv1beta1.ImageSecurityPolicySpec{Priority: 1},
+			},
+		}, nil
+	}
+	evaluated := []string{}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		evaluated = append(evaluated, isp.Name)
+		return nil, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockValidPod(),
+			fetchMetadataClient:         mockMetadata(),
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: mockValidate,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"z-isp", "a-isp", "b-isp"}, evaluated)
+}
+
 func Test_GlobalWhitelist(t *testing.T) {
 	mockPod := func(r *http.Request) (*v1.Pod, error) {
 		return &v1.Pod{
@@ -176,6 +2241,681 @@ func Test_GlobalWhitelist(t *testing.T) {
 	})
 }
 
+func Test_GlobalWhitelistSkipsMetadata(t *testing.T) {
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{Image: "gcr.io/kritis-project/kritis-server@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+					{Image: "gcr.io/kritis-int-test/kritis-server:latest"},
+				},
+			},
+		}, nil
+	}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		t.Error("metadata client was created for whitelisted images")
+		return nil, fmt.Errorf("unexpected metadata call")
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		t.Error("policies were fetched for whitelisted images")
+		return nil, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                mockPod,
+			fetchMetadataClient:        mockMetadata,
+			fetchImageSecurityPolicies: mockISP,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+}
+
+func Test_PolicyChangeInvalidatesCache(t *testing.T) {
+	validations := 0
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validations++
+		return nil, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockConfig := config{
+		retrievePod:                 mockValidPod(),
+		fetchMetadataClient:         mockMetadata(),
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: mockValidate,
+		cache:                       newAllowCache(defaultCacheTTL),
+	}
+	tc := testConfig{
+		mockConfig: mockConfig,
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	}
+	// The second admission should be served from the cache
+	RunTest(t, tc)
+	RunTest(t, tc)
+	if validations != 1 {
+		t.Fatalf("expected 1 validation before policy change, got %d", validations)
+	}
+	// Simulate an operator updating an ISP
+	w := watch.NewFake()
+	done := make(chan struct{})
+	go func() {
+		invalidateCacheOnPolicyChange(context.Background(), w, mockConfig.cache)
+		close(done)
+	}()
+	w.Modify(&kritisv1beta1.ImageSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-isp"},
+	})
+	w.Stop()
+	<-done
+	// The previously cached allow must be re-evaluated
+	RunTest(t, tc)
+	if validations != 2 {
+		t.Errorf("expected image to be re-validated after policy change, got %d validations", validations)
+	}
+}
+
+func Test_AsyncAttestation(t *testing.T) {
+	release := make(chan struct{})
+	created := make(chan string, 1)
+	mockAttest := func(namespace string, image string, client metadata.MetadataFetcher) error {
+		<-release
+		created <- image
+		return nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockMetadataClient{}, nil
+	}
+	mockConfig := config{
+		retrievePod:                 mockValidPod(),
+		fetchMetadataClient:         mockMetadata,
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		createAttestations:          mockAttest,
+		attestationQueue:            newAttestationQueue(mockAttest),
+		options:                     Options{AsyncAttestation: true},
+	}
+	// The attestation is blocked, so the handler must return without waiting on it
+	RunTest(t, testConfig{
+		mockConfig: mockConfig,
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	select {
+	case image := <-created:
+		t.Fatalf("attestation for %s created before admission returned", image)
+	default:
+	}
+	close(release)
+	select {
+	case image := <-created:
+		if image != testutil.QualifiedImage {
+			t.Errorf("expected attestation for %s, got %s", testutil.QualifiedImage, image)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("attestation was never created")
+	}
+}
+
+func Test_ErrorPaths(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	validConfig := config{
+		retrievePod:                 mockValidPod(),
+		fetchMetadataClient:         mockMetadata(),
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+	}
+	var tests = []struct {
+		name       string
+		mutate     func(c *config)
+		body       string
+		kind       error
+		httpStatus int
+	}{
+		{
+			name: "malformed request",
+			mutate: func(c *config) {
+				c.retrievePod = unmarshalPod
+			},
+			body:       "{not json",
+			kind:       ErrMalformedRequest,
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name: "policies can't be listed",
+			mutate: func(c *config) {
+				c.fetchImageSecurityPolicies = func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+					return nil, fmt.Errorf("forbidden")
+				}
+			},
+			kind:       ErrPolicyLoad,
+			httpStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "metadata client can't be created",
+			mutate: func(c *config) {
+				c.fetchMetadataClient = func() (metadata.MetadataFetcher, error) {
+					return nil, fmt.Errorf("no credentials")
+				}
+			},
+			kind:       ErrMetadataUnavailable,
+			httpStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "vulnerabilities can't be fetched",
+			mutate: func(c *config) {
+				c.validateImageSecurityPolicy = func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+					return nil, fmt.Errorf("deadline exceeded")
+				}
+			},
+			kind:       ErrMetadataUnavailable,
+			httpStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "registry can't be reached",
+			mutate: func(c *config) {
+				c.options = Options{RequirePullableImages: true}
+				c.checkPullable = func(image string, creds map[string]util.RegistryCredentials) error {
+					return fmt.Errorf("connection refused")
+				}
+			},
+			kind:       ErrMetadataUnavailable,
+			httpStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := validConfig
+			test.mutate(&c)
+			RunTest(t, testConfig{
+				mockConfig: c,
+				body:       test.body,
+				httpStatus: test.httpStatus,
+			})
+			// Callers of ValidatePod can branch on the kind of error
+			if test.kind == ErrMalformedRequest {
+				return
+			}
+			original := admissionConfig
+			defer func() {
+				admissionConfig = original
+			}()
+			admissionConfig = c
+			pod, _ := c.retrievePod(nil)
+			_, _, _, err := ValidatePod(pod)
+			if _, ok := err.(*Error); !ok {
+				t.Fatalf("expected *Error, got %T: %v", err, err)
+			}
+			if errors.Cause(err) != test.kind {
+				t.Errorf("expected error of kind %q, got %v", test.kind, err)
+			}
+		})
+	}
+}
+
+func Test_FailurePolicy(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return nil, fmt.Errorf("forbidden")
+	}
+	var tests = []struct {
+		name       string
+		policy     string
+		httpStatus int
+		allowed    bool
+		status     constants.Status
+		reason     constants.Reason
+		message    string
+	}{
+		{
+			name:       "webhook failure policy",
+			policy:     "",
+			httpStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "fail",
+			policy:     FailurePolicyFail,
+			httpStatus: http.StatusOK,
+			allowed:    false,
+			status:     constants.FailureStatus,
+			reason:     constants.ReasonValidationError,
+			message:    "error loading image security policies: forbidden",
+		},
+		{
+			name:       "ignore",
+			policy:     FailurePolicyIgnore,
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                mockValidPod(),
+					fetchImageSecurityPolicies: mockISP,
+					options:                    Options{FailurePolicy: test.policy},
+				},
+				httpStatus: test.httpStatus,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_TemporaryFailure(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	timeout := func() (metadata.MetadataFetcher, error) {
+		return nil, context.DeadlineExceeded
+	}
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	var tests = []struct {
+		name       string
+		policy     string
+		httpStatus int
+		reason     constants.Reason
+	}{
+		{
+			name:       "webhook failure policy",
+			httpStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "fail",
+			policy:     FailurePolicyFail,
+			httpStatus: http.StatusOK,
+			reason:     constants.ReasonTemporarilyUnavailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			admissionConfig = config{
+				retrievePod:                mockValidPod(),
+				fetchImageSecurityPolicies: mockISP,
+				fetchMetadataClient:        timeout,
+				options:                    Options{FailurePolicy: test.policy},
+			}
+			req := httptest.NewRequest("POST", "/", nil)
+			rr := httptest.NewRecorder()
+			AdmissionReviewHandler(rr, req)
+			if rr.Code != test.httpStatus {
+				t.Fatalf("expected status code %d, got %d", test.httpStatus, rr.Code)
+			}
+			if retry := rr.Header().Get("Retry-After"); retry != "10" {
+				t.Errorf("expected Retry-After 10, got %q", retry)
+			}
+			if test.reason == "" {
+				return
+			}
+			var ar v1beta1.AdmissionReview
+			if err := json.Unmarshal(rr.Body.Bytes(), &ar); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			result := ar.Response.Result
+			if ar.Response.Allowed {
+				t.Error("expected pod to be denied")
+			}
+			if constants.Reason(result.Reason) != test.reason {
+				t.Errorf("expected reason %s, got %s", test.reason, result.Reason)
+			}
+			if result.Code != http.StatusServiceUnavailable {
+				t.Errorf("expected code %d, got %d", http.StatusServiceUnavailable, result.Code)
+			}
+			if result.Details == nil || result.Details.RetryAfterSeconds != 10 {
+				t.Errorf("expected to be asked to retry after 10 seconds, got %+v", result.Details)
+			}
+			if !strings.HasPrefix(result.Message, "temporarily unavailable") {
+				t.Errorf("expected message to mark the failure temporary, got %q", result.Message)
+			}
+		})
+	}
+}
+
+func Test_DisableEnforcement(t *testing.T) {
+	vulnerableISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "LOW",
+				},
+			},
+		}}, nil
+	}
+	brokenISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return nil, fmt.Errorf("forbidden")
+	}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockMetadataClient{
+			vulnz: []metadata.Vulnerability{{Severity: "HIGH"}},
+		}, nil
+	}
+	var tests = []struct {
+		name       string
+		disable    bool
+		isp        func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error)
+		httpStatus int
+		allowed    bool
+		status     constants.Status
+		reason     constants.Reason
+		message    string
+	}{
+		{
+			name:       "violation enforced",
+			isp:        vulnerableISP,
+			httpStatus: http.StatusOK,
+			allowed:    false,
+			status:     constants.FailureStatus,
+			reason:     constants.ReasonVulnerabilityThreshold,
+			message:    fmt.Sprintf("found violations in %s", testutil.QualifiedImage),
+		},
+		{
+			name:       "violation not enforced",
+			disable:    true,
+			isp:        vulnerableISP,
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		},
+		{
+			name:       "validation error enforced",
+			isp:        brokenISP,
+			httpStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "validation error not enforced",
+			disable:    true,
+			isp:        brokenISP,
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockValidPod(),
+					fetchMetadataClient:         mockMetadata,
+					fetchImageSecurityPolicies:  test.isp,
+					validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+					options:                     Options{DisableEnforcement: test.disable},
+				},
+				httpStatus: test.httpStatus,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_ExemptNamespace(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}}, nil
+	}
+	denied := violationsMessage(testutil.QualifiedImage, []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}})
+	var tests = []struct {
+		name      string
+		namespace string
+		exempt    []string
+		allowed   bool
+		status    constants.Status
+		reason    constants.Reason
+		message   string
+	}{
+		{
+			name:      "kube-system is exempt by default",
+			namespace: "kube-system",
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+		},
+		{
+			name:      "kritis is exempt by default",
+			namespace: "kritis",
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+		},
+		{
+			name:      "other namespaces are validated by default",
+			namespace: "default",
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonVulnerabilityThreshold,
+			message:   denied,
+		},
+		{
+			name:      "configured namespaces replace the defaults",
+			namespace: "kube-system",
+			exempt:    []string{"monitoring"},
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonVulnerabilityThreshold,
+			message:   denied,
+		},
+		{
+			name:      "configured namespace is exempt",
+			namespace: "monitoring",
+			exempt:    []string{"monitoring"},
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+		},
+		{
+			name:      "empty list enforces policies in kube-system",
+			namespace: "kube-system",
+			exempt:    []string{},
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonVulnerabilityThreshold,
+			message:   denied,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: test.namespace},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+					},
+				}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					options:                     Options{ExemptNamespaces: test.exempt},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+func Test_ExemptPodSelectors(t *testing.T) {
+	selectors := []ExemptPodSelector{
+		{Namespace: "kube-system", Selector: "app.kubernetes.io/component in (csi-driver,csi-provisioner)"},
+		{Namespace: "kube-system", Selector: "exempt=true,tier=system"},
+	}
+	defer SetOptions(currentOptions())
+	SetOptions(Options{ExemptPodSelectors: selectors, ExemptNamespaces: []string{}})
+	var tests = []struct {
+		name      string
+		namespace string
+		labels    map[string]string
+		allowed   bool
+		status    constants.Status
+		reason    constants.Reason
+		message   string
+		validated []string
+	}{
+		{
+			name:      "CSI driver pod is exempt",
+			namespace: "kube-system",
+			labels:    map[string]string{"app.kubernetes.io/component": "csi-driver"},
+			allowed:   true,
+			status:    constants.SuccessStatus,
+			message:   constants.SuccessMessage,
+			validated: []string{},
+		},
+		{
+			name:      "labelled pod in another namespace is validated",
+			namespace: "team",
+			labels:    map[string]string{"app.kubernetes.io/component": "csi-driver"},
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonVulnerabilityThreshold,
+			message:   violationsMessage(testutil.QualifiedImage, []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}}),
+			validated: []string{testutil.QualifiedImage},
+		},
+		{
+			name:      "every requirement of a selector must match",
+			namespace: "kube-system",
+			labels:    map[string]string{"exempt": "true"},
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonVulnerabilityThreshold,
+			message:   violationsMessage(testutil.QualifiedImage, []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}}),
+			validated: []string{testutil.QualifiedImage},
+		},
+		{
+			name:      "normal pod is validated",
+			namespace: "kube-system",
+			labels:    map[string]string{"app": "web"},
+			status:    constants.FailureStatus,
+			reason:    constants.ReasonVulnerabilityThreshold,
+			message:   violationsMessage(testutil.QualifiedImage, []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}}),
+			validated: []string{testutil.QualifiedImage},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: test.namespace, Labels: test.labels},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+					},
+				}, nil
+			}
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+			}
+			validated := []string{}
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, image)
+				return []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.ExceedsMaxSeverityViolation, Reason: "found CVE"}}, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					options:                     currentOptions(),
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+		})
+	}
+}
+
+// mockBatchClient only serves vulnerabilities in batches
+type mockBatchClient struct {
+	mockMetadataClient
+	t       *testing.T
+	batches *[][]string
+}
+
+func (m mockBatchClient) GetVulnerabilities(containerImage string) ([]metadata.Vulnerability, error) {
+	m.t.Errorf("unexpected per-image query for %s", containerImage)
+	return nil, nil
+}
+
+func (m mockBatchClient) FetchVulnerabilitiesBatch(images []string) (map[string][]metadata.Vulnerability, error) {
+	*m.batches = append(*m.batches, images)
+	vulnz := map[string][]metadata.Vulnerability{}
+	for _, image := range images {
+		vulnz[image] = []metadata.Vulnerability{{Severity: "LOW"}}
+	}
+	return vulnz, nil
+}
+
+func Test_BatchVulnerabilities(t *testing.T) {
+	images := []string{
+		testutil.QualifiedImage,
+		"gcr.io/kritis-project/sidecar@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	}
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: images[0]}, {Image: images[1]}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "MEDIUM",
+				},
+			},
+		}}, nil
+	}
+	batches := [][]string{}
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockBatchClient{t: t, batches: &batches}, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockPod,
+			fetchMetadataClient:         mockMetadata,
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, [][]string{images}, batches)
+}
+
 type mockMetadataClient struct {
 	vulnz []metadata.Vulnerability
 }
@@ -184,6 +2924,34 @@ func (m mockMetadataClient) GetVulnerabilities(containerImage string) ([]metadat
 	return m.vulnz, nil
 }
 
+func (m mockMetadataClient) HasMetadata(containerImage string) (bool, error) {
+	return true, nil
+}
+
+func (m mockMetadataClient) GetBuildDetails(containerImage string) ([]metadata.Build, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetAttestations(containerImage string) ([]metadata.PGPAttestation, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetSecretFindings(containerImage string) ([]metadata.SecretFinding, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetMalwareFindings(containerImage string) ([]metadata.MalwareFinding, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetOperatingSystems(containerImage string) ([]string, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) CreateAttestationOccurence(noteName string, image string, signature string, keyID string) error {
+	return nil
+}
+
 func mockMetadata() func() (metadata.MetadataFetcher, error) {
 	return func() (metadata.MetadataFetcher, error) {
 		return nil, nil
@@ -206,7 +2974,7 @@ func mockValidPod() func(r *http.Request) (*v1.Pod, error) {
 
 func RunTest(t *testing.T, tc testConfig) {
 	// Create a request to pass to our handler.
-	req, err := http.NewRequest("GET", "/", nil)
+	req, err := http.NewRequest("GET", "/", strings.NewReader(tc.body))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -226,10 +2994,138 @@ func RunTest(t *testing.T, tc testConfig) {
 			status, tc.httpStatus)
 	}
 	// Check the response body is what we expect.
-	expected := `{"response":{"uid":"","allowed":%t,"status":{"metadata":{},"status":"%s","message":"%s"}}}`
-	expected = fmt.Sprintf(expected, tc.allowed, tc.status, tc.message)
+	// Errors are returned without an admission response.
+	expected := ""
+	if tc.httpStatus == http.StatusOK {
+		reason := ""
+		if tc.reason != "" {
+			reason = fmt.Sprintf(`,"reason":"%s"`, tc.reason)
+		}
+		warnings := ""
+		if len(tc.warnings) != 0 {
+			data, err := json.Marshal(tc.warnings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			warnings = fmt.Sprintf(`,"warnings":%s`, data)
+		}
+		expected = `{"response":{"uid":"","allowed":%t,"status":{"metadata":{},"status":"%s","message":"%s"%s}%s}}`
+		expected = fmt.Sprintf(expected, tc.allowed, tc.status, tc.message, reason, warnings)
+	}
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
 }
+
+func Test_UnmarshalPodMalformed(t *testing.T) {
+	var tests = []struct {
+		name string
+		body string
+	}{
+		{"empty body", ""},
+		{"not json", "{"},
+		{"no request", `{}`},
+		{"null request", `{"request":null}`},
+		{"no object", `{"request":{"uid":"1"}}`},
+		{"null object", `{"request":{"object":null}}`},
+		{"object of the wrong type", `{"request":{"object":[]}}`},
+		{"workload with an invalid object", `{"request":{"kind":{"group":"apps","version":"v1","kind":"Deployment"},"object":{"spec":1}}}`},
+		{"unknown kind", `{"request":{"kind":{"group":"example.com","version":"v1","kind":"Widget"},"object":{}}}`},
+		{"update with an invalid old object", `{"request":{"operation":"UPDATE","object":{},"oldObject":[]}}`},
+		{"too large", `{"request":{"object":{"metadata":{"name":"` + strings.Repeat("a", maxReviewSize) + `"}}}}`},
+		{"deeply nested", strings.Repeat("[", 100000) + strings.Repeat("]", 100000)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod, err := unmarshalPod(httptest.NewRequest("POST", "/", strings.NewReader(test.body)))
+			if err == nil {
+				t.Errorf("expected an error, got pod %v", pod)
+			}
+		})
+	}
+}
+
+func Test_UnmarshalCustomResource(t *testing.T) {
+	workflow := `{"metadata":{"name":"build","namespace":"ci"},"spec":{"templates":[{"container":{"image":"%s"}},{"steps":[]}]}}`
+	defer SetOptions(currentOptions())
+	SetOptions(Options{
+		CustomResourceImages: []CustomResourceImages{
+			{Group: "argoproj.io", Kind: "Workflow", Paths: []string{"{.spec.templates[*].container.image}"}},
+		},
+	})
+	var tests = []struct {
+		name      string
+		kind      metav1.GroupVersionKind
+		shouldErr bool
+		expected  []string
+	}{
+		{
+			name:     "configured kind",
+			kind:     metav1.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Workflow"},
+			expected: []string{testutil.QualifiedImage},
+		},
+		{
+			name:      "kind of another group",
+			kind:      metav1.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Workflow"},
+			shouldErr: true,
+		},
+		{
+			name:      "kind without images",
+			kind:      metav1.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "WorkflowTemplate"},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := json.Marshal(v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+				Kind:      test.kind,
+				Operation: v1beta1.Update,
+				Object:    runtime.RawExtension{Raw: []byte(fmt.Sprintf(workflow, testutil.QualifiedImage))},
+				OldObject: runtime.RawExtension{Raw: []byte(fmt.Sprintf(workflow, "gcr.io/project/old@sha256:123"))},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rv, err := unmarshalReview(httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+			if test.shouldErr {
+				testutil.CheckError(t, true, err)
+				return
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, pods.Images(*rv.pod))
+			testutil.CheckErrorAndDeepEqual(t, false, nil, "ci", rv.pod.Namespace)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"gcr.io/project/old@sha256:123"}, rv.oldImages)
+		})
+	}
+}
+
+func FuzzUnmarshalPod(f *testing.F) {
+	pod, err := json.Marshal(v1.Pod{
+		Spec: v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, request := range []v1beta1.AdmissionRequest{
+		{Object: runtime.RawExtension{Raw: pod}},
+		{Operation: v1beta1.Update, Object: runtime.RawExtension{Raw: pod}, OldObject: runtime.RawExtension{Raw: pod}},
+		{Kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, Object: runtime.RawExtension{Raw: []byte(`{"spec":{"template":{"spec":{}}}}`)}},
+		{Kind: metav1.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}, Object: runtime.RawExtension{Raw: []byte(`{"spec":{"jobTemplate":{}}}`)}},
+	} {
+		request := request
+		seed, err := json.Marshal(v1beta1.AdmissionReview{Request: &request})
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(seed)
+	}
+	f.Add([]byte(`{"request":{"dryRun":true,"object":{}}}`))
+	f.Add([]byte(`{"request":null}`))
+	f.Add([]byte(`null`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pod, err := unmarshalPod(httptest.NewRequest("POST", "/", bytes.NewReader(data)))
+		if (pod == nil) == (err == nil) {
+			t.Fatalf("expected either a pod or an error, got pod %v and error %v", pod, err)
+		}
+	})
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	"github.com/grafeas/kritis/pkg/kritis/crd/authority"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/metrics"
+	"github.com/grafeas/kritis/pkg/kritis/secrets"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	attestationQueueSize      = 100
+	attestationWorkers        = 4
+	attestationRetries        = 3
+	attestationInitialBackoff = time.Second
+)
+
+// createAttestations signs image with every AttestationAuthority in namespace
+// and stores each signature as an Attestation Occurrence.
+func createAttestations(namespace string, image string, client metadata.MetadataFetcher) error {
+	auths, err := authority.Authorities(namespace)
+	if err != nil {
+		return err
+	}
+	for _, a := range auths {
+		key, err := attestation.NewPgpKey("", a.Spec.PublicKeyData)
+		if err != nil {
+			return err
+		}
+		sign := func() (string, error) {
+			secret, err := secrets.Fetch(namespace, a.Spec.PrivateKeySecretName)
+			if err != nil {
+				return "", fmt.Errorf("error fetching signing secret for %s: %v", a.Name, err)
+			}
+			sig, err := util.NewAtomicContainerSig(image, nil)
+			if err != nil {
+				return "", err
+			}
+			message, err := sig.Json()
+			if err != nil {
+				return "", err
+			}
+			return attestation.CreateMessageAttestation(a.Spec.PublicKeyData, secret.PrivateKey, message)
+		}
+		if err := createAttestationOnce(client, a.Spec.NoteReference, image, key.PublicKey().KeyIdString(), sign); err != nil {
+			return fmt.Errorf("error creating attestation for %s by %s: %v", image, a.Name, err)
+		}
+	}
+	return nil
+}
+
+// attestationLocks serializes creating the attestation of an image digest
+// under a note by a key within this process, so that concurrent admissions
+// of the same image don't create duplicate occurrences. Replicas don't share
+// it, so createAttestationOnce also deletes duplicates they race to create.
+var attestationLocks = newKeyedMutex()
+
+// createAttestationOnce creates the attestation of image under noteName by
+// the key with keyID, signed by sign, unless it already exists
+func createAttestationOnce(client metadata.MetadataFetcher, noteName string, image string, keyID string, sign func() (string, error)) error {
+	unlock := attestationLocks.lock(strings.Join([]string{imageDigestOrName(image), noteName, keyID}, "|"))
+	defer unlock()
+	return createAttestationDeduplicated(client, noteName, image, keyID, sign)
+}
+
+// createAttestationDeduplicated creates the attestation of image under
+// noteName by the key with keyID unless it already exists. Other replicas may
+// create it concurrently, and metadata backends don't reject duplicate
+// occurrences, so after creating it the attestations are listed again and
+// every duplicate but the one with the lowest occurrence name is deleted.
+// Replicas which race agree on which one to keep, so one always remains.
+func createAttestationDeduplicated(client metadata.MetadataFetcher, noteName string, image string, keyID string, sign func() (string, error)) error {
+	if existing, err := matchingAttestations(client, noteName, image, keyID); err != nil {
+		return err
+	} else if len(existing) != 0 {
+		logrus.Debugf("%s is already attested under %s by key %s", image, noteName, keyID)
+		return nil
+	}
+	signature, err := sign()
+	if err != nil {
+		return err
+	}
+	if err := client.CreateAttestationOccurence(noteName, image, signature, keyID); err != nil {
+		return err
+	}
+	deleter, ok := client.(metadata.OccurrenceDeleter)
+	if !ok {
+		return nil
+	}
+	created, err := matchingAttestations(client, noteName, image, keyID)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, att := range created {
+		if att.OccurrenceName != "" {
+			names = append(names, att.OccurrenceName)
+		}
+	}
+	sort.Strings(names)
+	for i := 1; i < len(names); i++ {
+		logrus.Infof("deleting duplicate attestation %s of %s under %s by key %s", names[i], image, noteName, keyID)
+		if err := deleter.DeleteOccurrence(names[i]); err != nil {
+			return fmt.Errorf("error deleting duplicate attestation %s: %v", names[i], err)
+		}
+	}
+	return nil
+}
+
+// matchingAttestations returns the attestations of image under noteName by
+// the key with keyID
+func matchingAttestations(client metadata.MetadataFetcher, noteName string, image string, keyID string) ([]metadata.PGPAttestation, error) {
+	atts, err := client.GetAttestations(image)
+	if err != nil {
+		return nil, err
+	}
+	matching := []metadata.PGPAttestation{}
+	for _, att := range atts {
+		if att.NoteName == noteName && att.KeyID == keyID {
+			matching = append(matching, att)
+		}
+	}
+	return matching, nil
+}
+
+// imageDigestOrName returns the digest of image, or image if it has none
+func imageDigestOrName(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	return image
+}
+
+// keyedMutex is a set of mutexes, created on demand and dropped once unlocked
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// refs is the number of callers holding or waiting for the lock
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// lock locks the mutex for key, returning the func unlocking it
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+type attestationJob struct {
+	namespace string
+	image     string
+	client    metadata.MetadataFetcher
+	// attempts is how many times creating the attestations already failed
+	attempts int
+}
+
+// attestWithRetries creates the attestations of job with attest, retrying
+// with exponential backoff as configured by the options, after the attempts
+// job already made. If every attempt fails, the job is dead-lettered.
+func attestWithRetries(attest func(string, string, metadata.MetadataFetcher) error, job attestationJob) error {
+	retries, backoff := currentOptions().attestationRetryPolicy()
+	for i := 1; i < job.attempts; i++ {
+		backoff *= 2
+	}
+	for attempt := job.attempts + 1; ; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err := attest(job.namespace, job.image, job.client)
+		if err == nil {
+			logrus.Infof("created attestations for %s", job.image)
+			return nil
+		}
+		if attempt > retries {
+			deadLetter(job, attempt, err)
+			return err
+		}
+		logrus.Warnf("error creating attestations for %s, retrying in %s: %v", job.image, backoff, err)
+	}
+}
+
+// attestInline creates the attestations of job with attest once, handing
+// them to queue to be retried in the background if that fails, so that
+// retries don't delay the admission response
+func attestInline(attest func(string, string, metadata.MetadataFetcher) error, queue *attestationQueue, job attestationJob) {
+	err := attest(job.namespace, job.image, job.client)
+	if err == nil {
+		logrus.Infof("created attestations for %s", job.image)
+		return
+	}
+	job.attempts = 1
+	if retries, _ := currentOptions().attestationRetryPolicy(); retries == 0 || queue == nil {
+		deadLetter(job, job.attempts, err)
+		return
+	}
+	logrus.Warnf("error creating attestations for %s, retrying in the background: %v", job.image, err)
+	queue.add(job)
+}
+
+// deadLetter records that creating the attestations of job failed for good,
+// so operators can see which images are left unattested and will be
+// validated again on their next admission. It is a variable for testing.
+var deadLetter = func(job attestationJob, attempts int, err error) {
+	metrics.AddAttestationFailures(1)
+	logrus.WithFields(logrus.Fields{
+		"namespace": job.namespace,
+		"image":     job.image,
+		"attempts":  attempts,
+	}).Errorf("dead letter: giving up creating attestations for %s: %v", job.image, err)
+}
+
+// attestationQueue creates attestations in background workers, so that
+// admission responses don't wait on signing and occurrence creation.
+type attestationQueue struct {
+	jobs    chan attestationJob
+	attest  func(namespace string, image string, client metadata.MetadataFetcher) error
+	workers int
+	once    sync.Once
+}
+
+func newAttestationQueue(attest func(string, string, metadata.MetadataFetcher) error) *attestationQueue {
+	return &attestationQueue{
+		jobs:    make(chan attestationJob, attestationQueueSize),
+		attest:  attest,
+		workers: attestationWorkers,
+	}
+}
+
+// enqueue schedules an attestation for image, starting the workers on first use.
+// It never blocks; if the queue is full the attestation is dropped and will be
+// retried on the image's next admission.
+func (q *attestationQueue) enqueue(namespace string, image string, client metadata.MetadataFetcher) {
+	q.add(attestationJob{namespace: namespace, image: image, client: client})
+}
+
+// add schedules job like enqueue
+func (q *attestationQueue) add(job attestationJob) {
+	q.once.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			go q.work()
+		}
+	})
+	select {
+	case q.jobs <- job:
+	default:
+		logrus.Errorf("attestation queue is full, dropping attestation for %s", job.image)
+	}
+}
+
+func (q *attestationQueue) work() {
+	for job := range q.jobs {
+		q.process(job)
+	}
+}
+
+// process attempts an attestation, retrying with exponential backoff
+func (q *attestationQueue) process(job attestationJob) {
+	attestWithRetries(q.attest, job)
+}
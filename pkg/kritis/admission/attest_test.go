@@ -0,0 +1,319 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAttestationQueueRetries(t *testing.T) {
+	var tests = []struct {
+		name                string
+		retries             *int
+		failures            int
+		expectedCalls       int
+		expectedDeadLetters int
+	}{
+		{
+			name:          "succeeds first time",
+			failures:      0,
+			expectedCalls: 1,
+		},
+		{
+			name:          "fails then succeeds",
+			failures:      2,
+			expectedCalls: 3,
+		},
+		{
+			name:                "always fails",
+			failures:            10,
+			expectedCalls:       attestationRetries + 1,
+			expectedDeadLetters: 1,
+		},
+		{
+			name:                "always fails with configured retries",
+			retries:             intPtr(5),
+			failures:            10,
+			expectedCalls:       6,
+			expectedDeadLetters: 1,
+		},
+		{
+			name:                "fails without retries",
+			retries:             intPtr(0),
+			failures:            10,
+			expectedCalls:       1,
+			expectedDeadLetters: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original, originalDeadLetter := admissionConfig, deadLetter
+			defer func() {
+				admissionConfig, deadLetter = original, originalDeadLetter
+			}()
+			admissionConfig.options = Options{
+				AttestationRetries: test.retries,
+				AttestationBackoff: metav1.Duration{Duration: time.Millisecond},
+			}
+			deadLetters := []string{}
+			deadLetter = func(job attestationJob, attempts int, err error) {
+				deadLetters = append(deadLetters, job.image)
+			}
+			calls := 0
+			attest := func(namespace string, image string, client metadata.MetadataFetcher) error {
+				calls++
+				if calls <= test.failures {
+					return fmt.Errorf("transient error")
+				}
+				return nil
+			}
+			q := newAttestationQueue(attest)
+			q.process(attestationJob{image: testutil.QualifiedImage})
+			if calls != test.expectedCalls {
+				t.Errorf("expected %d attempts, got %d", test.expectedCalls, calls)
+			}
+			if len(deadLetters) != test.expectedDeadLetters {
+				t.Errorf("expected %d dead letters, got %v", test.expectedDeadLetters, deadLetters)
+			}
+		})
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestAttestInlineRetriesInBackground(t *testing.T) {
+	var tests = []struct {
+		name        string
+		retries     *int
+		queued      int
+		deadLetters int
+	}{
+		{
+			name:   "retried on the queue",
+			queued: 1,
+		},
+		{
+			name:        "not retried",
+			retries:     intPtr(0),
+			deadLetters: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original, originalDeadLetter := admissionConfig, deadLetter
+			defer func() {
+				admissionConfig, deadLetter = original, originalDeadLetter
+			}()
+			admissionConfig.options = Options{AttestationRetries: test.retries}
+			deadLetters := 0
+			deadLetter = func(job attestationJob, attempts int, err error) {
+				deadLetters++
+			}
+			attest := func(namespace string, image string, client metadata.MetadataFetcher) error {
+				return fmt.Errorf("transient error")
+			}
+			// A queue without workers, to inspect what is queued
+			q := newAttestationQueue(attest)
+			q.once.Do(func() {})
+			attestInline(attest, q, attestationJob{image: testutil.QualifiedImage})
+			if len(q.jobs) != test.queued {
+				t.Errorf("expected %d queued retries, got %d", test.queued, len(q.jobs))
+			}
+			if test.queued != 0 {
+				testutil.CheckErrorAndDeepEqual(t, false, nil, 1, (<-q.jobs).attempts)
+			}
+			if deadLetters != test.deadLetters {
+				t.Errorf("expected %d dead letters, got %d", test.deadLetters, deadLetters)
+			}
+		})
+	}
+}
+
+// attestingClient stores the attestations created with it
+type attestingClient struct {
+	mockMetadataClient
+	mu      sync.Mutex
+	atts    []metadata.PGPAttestation
+	created int
+}
+
+func (c *attestingClient) GetAttestations(containerImage string) ([]metadata.PGPAttestation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]metadata.PGPAttestation{}, c.atts...), nil
+}
+
+func (c *attestingClient) CreateAttestationOccurence(noteName string, image string, signature string, keyID string) error {
+	// Widen the window for concurrent creations to race in
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created++
+	c.atts = append(c.atts, metadata.PGPAttestation{
+		Signature:      signature,
+		KeyID:          keyID,
+		NoteName:       noteName,
+		OccurrenceName: fmt.Sprintf("projects/kritis/occurrences/%d", c.created),
+	})
+	return nil
+}
+
+func (c *attestingClient) DeleteOccurrence(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, att := range c.atts {
+		if att.OccurrenceName == name {
+			c.atts = append(c.atts[:i], c.atts[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// replicasClient is shared by replicas which don't share attestationLocks.
+// The first lookup of each replica waits for the others', so that every
+// replica finds no attestation and creates one.
+type replicasClient struct {
+	*attestingClient
+	replicas sync.WaitGroup
+	lookups  int
+}
+
+func (c *replicasClient) GetAttestations(containerImage string) ([]metadata.PGPAttestation, error) {
+	atts, err := c.attestingClient.GetAttestations(containerImage)
+	c.mu.Lock()
+	c.lookups++
+	first := c.lookups <= 2
+	c.mu.Unlock()
+	if first {
+		c.replicas.Done()
+		c.replicas.Wait()
+	}
+	return atts, err
+}
+
+func TestCreateAttestationOnceConcurrently(t *testing.T) {
+	client := &attestingClient{}
+	note := "projects/kritis/notes/qa"
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- createAttestationOnce(client, note, testutil.QualifiedImage, "key", func() (string, error) {
+				return "signature", nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		testutil.CheckError(t, false, err)
+	}
+	expected := []metadata.PGPAttestation{{Signature: "signature", KeyID: "key", NoteName: note, OccurrenceName: "projects/kritis/occurrences/1"}}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, client.atts)
+	if len(attestationLocks.locks) != 0 {
+		t.Errorf("expected locks to be dropped once unlocked, got %v", attestationLocks.locks)
+	}
+}
+
+func TestCreateAttestationAcrossReplicas(t *testing.T) {
+	client := &replicasClient{attestingClient: &attestingClient{}}
+	client.replicas.Add(2)
+	note := "projects/kritis/notes/qa"
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- createAttestationDeduplicated(client, note, testutil.QualifiedImage, "key", func() (string, error) {
+				return fmt.Sprintf("signature %d", i), nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		testutil.CheckError(t, false, err)
+	}
+	if client.created != 2 {
+		t.Fatalf("expected both replicas to create an attestation, got %d", client.created)
+	}
+	if len(client.atts) != 1 || client.atts[0].OccurrenceName != "projects/kritis/occurrences/1" {
+		t.Errorf("expected only the first attestation to remain, got %v", client.atts)
+	}
+}
+
+func TestCreateAttestationOnce(t *testing.T) {
+	note := "projects/kritis/notes/qa"
+	var tests = []struct {
+		name     string
+		existing []metadata.PGPAttestation
+		created  bool
+	}{
+		{
+			name:    "no attestations",
+			created: true,
+		},
+		{
+			name:     "already attested",
+			existing: []metadata.PGPAttestation{{KeyID: "key", NoteName: note}},
+		},
+		{
+			name:     "attested by another key",
+			existing: []metadata.PGPAttestation{{KeyID: "other", NoteName: note}},
+			created:  true,
+		},
+		{
+			name:     "attested under another note",
+			existing: []metadata.PGPAttestation{{KeyID: "key", NoteName: "projects/kritis/notes/prod"}},
+			created:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &attestingClient{atts: test.existing}
+			signed := false
+			err := createAttestationOnce(client, note, testutil.QualifiedImage, "key", func() (string, error) {
+				signed = true
+				return "signature", nil
+			})
+			testutil.CheckError(t, false, err)
+			if signed != test.created || len(client.atts) != len(test.existing)+btoi(test.created) {
+				t.Errorf("expected an attestation to be created: %t, got signed: %t, attestations: %v", test.created, signed, client.atts)
+			}
+		})
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	decisionQueueSize = 1000
+)
+
+// DecisionRecord is an audit record of an admission decision
+type DecisionRecord struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	// Pod is the name of the pod, or its generateName if it has no name yet
+	Pod string `json:"pod"`
+	// Requester is the user who made the admission request, if known
+	Requester string   `json:"requester,omitempty"`
+	Images    []string `json:"images"`
+	// Policies are the ImageSecurityPolicies the pod was validated against,
+	// as namespace/name
+	Policies []string `json:"policies,omitempty"`
+	Allowed  bool     `json:"allowed"`
+	Reason   string   `json:"reason,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	// Violations are the reasons of the violations the pod was denied for
+	Violations []string `json:"violations,omitempty"`
+	// Error is why the pod couldn't be validated, if it couldn't
+	Error string `json:"error,omitempty"`
+}
+
+// DecisionSink persists DecisionRecords
+type DecisionSink interface {
+	Record(DecisionRecord) error
+}
+
+// WriterSink appends DecisionRecords to a writer, one JSON object per line
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a WriterSink appending to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewFileSink returns a WriterSink appending to the file at path, which is
+// created if it doesn't exist
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink(f), nil
+}
+
+// Record appends r to the writer
+func (s *WriterSink) Record(r DecisionRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// decisionQueue records decisions with a sink in a background worker, so
+// that a slow sink doesn't delay admission responses. A nil *decisionQueue
+// is valid and records nothing.
+type decisionQueue struct {
+	sink    DecisionSink
+	records chan DecisionRecord
+	once    sync.Once
+}
+
+func newDecisionQueue(sink DecisionSink) *decisionQueue {
+	return &decisionQueue{
+		sink:    sink,
+		records: make(chan DecisionRecord, decisionQueueSize),
+	}
+}
+
+// SetDecisionSink records every admission decision with sink, or none if
+// sink is nil
+func SetDecisionSink(sink DecisionSink) {
+	if sink == nil {
+		admissionConfig.decisions = nil
+		return
+	}
+	admissionConfig.decisions = newDecisionQueue(sink)
+}
+
+// record queues r, starting the worker on first use. If the queue is full,
+// r is dropped.
+func (q *decisionQueue) record(r DecisionRecord) {
+	if q == nil {
+		return
+	}
+	q.once.Do(func() {
+		go q.work()
+	})
+	select {
+	case q.records <- r:
+	default:
+		logrus.Errorf("decision record queue is full, dropping decision for pod %s in namespace %s", r.Pod, r.Namespace)
+	}
+}
+
+func (q *decisionQueue) work() {
+	for r := range q.records {
+		if err := q.sink.Record(r); err != nil {
+			logrus.Errorf("error recording decision for pod %s in namespace %s: %v", r.Pod, r.Namespace, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// channelSink sends the decisions it records on a channel
+type channelSink chan DecisionRecord
+
+func (s channelSink) Record(r DecisionRecord) error {
+	s <- r
+	return nil
+}
+
+func Test_DecisionRecords(t *testing.T) {
+	violation := securitypolicy.SecurityPolicyViolation{
+		Violation: securitypolicy.ExceedsMaxSeverityViolation,
+		Reason:    "vulnerable",
+	}
+	var tests = []struct {
+		name       string
+		violations []securitypolicy.SecurityPolicyViolation
+		expected   DecisionRecord
+	}{
+		{
+			name: "allow",
+			expected: DecisionRecord{
+				Namespace: "default",
+				Pod:       "web",
+				Images:    []string{testutil.QualifiedImage},
+				Policies:  []string{"default/policy"},
+				Allowed:   true,
+				Message:   constants.SuccessMessage,
+			},
+		},
+		{
+			name:       "deny",
+			violations: []securitypolicy.SecurityPolicyViolation{violation},
+			expected: DecisionRecord{
+				Namespace:  "default",
+				Pod:        "web",
+				Images:     []string{testutil.QualifiedImage},
+				Policies:   []string{"default/policy"},
+				Allowed:    false,
+				Reason:     string(constants.ReasonVulnerabilityThreshold),
+				Message:    violationsMessage(testutil.QualifiedImage, []securitypolicy.SecurityPolicyViolation{violation}),
+				Violations: []string{"vulnerable"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+					},
+				}, nil
+			}
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"},
+				}}, nil
+			}
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				return test.violations, nil
+			}
+			sink := make(channelSink, 1)
+			status := constants.SuccessStatus
+			if !test.expected.Allowed {
+				status = constants.FailureStatus
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					decisions:                   newDecisionQueue(sink),
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.expected.Allowed,
+				status:     status,
+				reason:     constants.Reason(test.expected.Reason),
+				message:    test.expected.Message,
+			})
+			select {
+			case r := <-sink:
+				if r.Time.IsZero() {
+					t.Error("expected the decision to be timestamped")
+				}
+				r.Time = time.Time{}
+				testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, r)
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected a decision to be recorded")
+			}
+		})
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	for _, allowed := range []bool{true, false} {
+		if err := sink.Record(DecisionRecord{Namespace: "default", Pod: "web", Allowed: allowed}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected a line per decision, got %q", buf.String())
+	}
+	var r DecisionRecord
+	if err := json.Unmarshal(lines[1], &r); err != nil {
+		t.Fatalf("error decoding decision: %v", err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, DecisionRecord{Namespace: "default", Pod: "web"}, r)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"sort"
+
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/pods"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// AttestAllSummary is what AttestAll did with each running image, as
+// namespace/image
+type AttestAllSummary struct {
+	// Attested are the images attested, or which would be in a dry run
+	Attested []string
+	// AlreadyAttested are the images which already had an attestation
+	AlreadyAttested []string
+	// Violating are the images violating an ImageSecurityPolicy
+	Violating []string
+	// Failed are the images which couldn't be validated or attested
+	Failed []string
+}
+
+// AttestAll attests the images running in namespace, or in every namespace
+// if it is "", which pass validation against their namespace's
+// ImageSecurityPolicies, so that admitting them again doesn't validate them.
+// It suits enabling attestations in a cluster already running clean images.
+// Images violating any policy aren't attested, even if a pod running them
+// overrides the violation, since attestations apply to every pod. Nor are
+// images which policies require attestations of, or which a pod running
+// them couldn't be admitted with, such as a privileged pod. In a dry run, no
+// attestation is created.
+func AttestAll(namespace string, dryRun bool) (AttestAllSummary, error) {
+	summary := AttestAllSummary{}
+	running, err := admissionConfig.listPods(namespace)
+	if err != nil {
+		return summary, fmt.Errorf("error listing pods: %v", err)
+	}
+	byNamespace := map[string][]string{}
+	// The pods running each image, by namespace/image
+	podsOf := map[string][]v1.Pod{}
+	for _, p := range running {
+		if namespaceExempt(p.Namespace) {
+			continue
+		}
+		for _, image := range qualifiedImages(withoutPauseImages(pods.Images(p))) {
+			key := fmt.Sprintf("%s/%s", p.Namespace, image)
+			if util.CheckGlobalWhitelist([]string{image}) {
+				continue
+			}
+			if _, seen := podsOf[key]; !seen {
+				byNamespace[p.Namespace] = append(byNamespace[p.Namespace], image)
+			}
+			podsOf[key] = append(podsOf[key], p)
+		}
+	}
+	namespaces := []string{}
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	client, err := admissionConfig.fetchMetadataClient()
+	if err != nil {
+		return summary, newError(ErrMetadataUnavailable, err)
+	}
+	for _, ns := range namespaces {
+		if err := attestNamespace(ns, byNamespace[ns], podsOf, client, dryRun, &summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// attestNamespace attests those of images, the images running in namespace
+// in the pods podsOf them by namespace/image, which pass validation, adding
+// what it did to summary
+func attestNamespace(namespace string, images []string, podsOf map[string][]v1.Pod, client metadata.MetadataFetcher, dryRun bool, summary *AttestAllSummary) error {
+	isps, err := imageSecurityPolicies(namespace)
+	if err != nil {
+		return newError(ErrPolicyLoad, err)
+	}
+	if len(isps) == 0 {
+		logrus.Debugf("no image security policies in namespace %s, not attesting its images", namespace)
+		return nil
+	}
+	clients, err := policyMetadataClients(isps, client)
+	if err != nil {
+		return newError(ErrMetadataUnavailable, err)
+	}
+	unattested := map[string]bool{}
+	for _, image := range unattestedImages(namespace, images, isps, client) {
+		unattested[image] = true
+	}
+images:
+	for _, image := range images {
+		key := fmt.Sprintf("%s/%s", namespace, image)
+		if !unattested[image] {
+			summary.AlreadyAttested = append(summary.AlreadyAttested, key)
+			continue
+		}
+		violations, err := runningImageViolations(namespace, image, podsOf[key], isps, client)
+		if err != nil {
+			logrus.Errorf("error validating %s in namespace %s: %v", image, namespace, err)
+			summary.Failed = append(summary.Failed, key)
+			continue
+		}
+		if len(violations) != 0 {
+			logrus.Infof("not attesting %s: %s", image, violations[0].Reason)
+			summary.Violating = append(summary.Violating, key)
+			continue
+		}
+		for i, isp := range isps {
+			violations, err := admissionConfig.validateImageSecurityPolicy(isp, image, clients[i], securitypolicy.RequestRescans())
+			if err != nil {
+				logrus.Errorf("error validating %s against %s/%s: %v", image, isp.Namespace, isp.Name, err)
+				summary.Failed = append(summary.Failed, key)
+				continue images
+			}
+			if len(violations) != 0 {
+				logrus.Infof("not attesting %s: %s", image, violations[0].Reason)
+				summary.Violating = append(summary.Violating, key)
+				continue images
+			}
+		}
+		if dryRun {
+			logrus.Infof("would attest %s in namespace %s", image, namespace)
+		} else if err := admissionConfig.createAttestations(namespace, image, client); err != nil {
+			logrus.Errorf("error attesting %s in namespace %s: %v", image, namespace, err)
+			summary.Failed = append(summary.Failed, key)
+			continue
+		}
+		summary.Attested = append(summary.Attested, key)
+	}
+	return nil
+}
+
+// runningImageViolations returns the violations of isps by image, which is
+// unattested and run by the pods running in namespace, which validatePod
+// checks before validating an image's metadata: whether it requires an
+// attestation, and the tenant, service account and privileged pod
+// requirements
+func runningImageViolations(namespace string, image string, running []v1.Pod, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) ([]securitypolicy.SecurityPolicyViolation, error) {
+	images := []string{image}
+	violations, err := requiredAttestationViolations(namespace, isps, images)
+	if err != nil || len(violations) != 0 {
+		return violations, err
+	}
+	if violations := tenantViolations(namespace, images, isps); len(violations) != 0 {
+		return violations, nil
+	}
+	for i := range running {
+		if violations := serviceAccountViolations(&running[i], images, isps); len(violations) != 0 {
+			return violations, nil
+		}
+		if violations := privilegedViolations(&running[i], images, isps, client); len(violations) != 0 {
+			return violations, nil
+		}
+	}
+	return nil, nil
+}
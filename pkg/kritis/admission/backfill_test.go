@@ -0,0 +1,168 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"testing"
+
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	cleanImage           = "gcr.io/project/clean@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	violatingImage       = "gcr.io/project/violating@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	alreadyAttestedImage = "gcr.io/project/attested@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	unsignedImage        = "gcr.io/project/unsigned@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	privilegedImage      = "gcr.io/project/privileged@sha256:5555555555555555555555555555555555555555555555555555555555555555"
+	paymentsImage        = "gcr.io/project/payments@sha256:6666666666666666666666666666666666666666666666666666666666666666"
+	otherTenantImage     = "gcr.io/other/app@sha256:7777777777777777777777777777777777777777777777777777777777777777"
+)
+
+// backfillConfig returns a config attesting the fixture pods, recording the
+// attestations it creates in signed. Signing unsignedImage fails.
+func backfillConfig(signed *[]string) config {
+	pod := func(namespace string, images ...string) v1.Pod {
+		p := v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}}
+		for _, image := range images {
+			p.Spec.Containers = append(p.Spec.Containers, v1.Container{Image: image})
+		}
+		return p
+	}
+	privileged := pod("prod", privilegedImage)
+	privileged.Spec.Containers[0].SecurityContext = &v1.SecurityContext{Privileged: &[]bool{true}[0]}
+	payments := pod("prod", paymentsImage)
+	payments.Spec.ServiceAccountName = "web"
+	running := []v1.Pod{
+		pod("prod", cleanImage, violatingImage),
+		pod("prod", cleanImage, alreadyAttestedImage, "gcr.io/project/tagged:latest"),
+		pod("prod", unsignedImage, "gcr.io/kritis-project/kritis-server@sha256:4444444444444444444444444444444444444444444444444444444444444444"),
+		privileged,
+		payments,
+		pod("prod", otherTenantImage),
+		pod("strict", cleanImage),
+		pod("unpoliced", cleanImage),
+	}
+	return config{
+		listPods: func(namespace string) ([]v1.Pod, error) {
+			return running, nil
+		},
+		fetchMetadataClient: mockMetadata(),
+		fetchImageSecurityPolicies: func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+			switch namespace {
+			case "prod":
+				return []kritisv1beta1.ImageSecurityPolicy{{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "policy"},
+					Spec: kritisv1beta1.ImageSecurityPolicySpec{
+						StrictForPrivileged:  true,
+						TenantRegistryPrefix: "gcr.io/project",
+						ServiceAccountBindings: []kritisv1beta1.ServiceAccountBinding{{
+							Images:          []string{"gcr.io/project/payments*"},
+							ServiceAccounts: []string{"payments"},
+						}},
+					},
+				}}, nil
+			case "strict":
+				return []kritisv1beta1.ImageSecurityPolicy{{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "policy"},
+					Spec:       kritisv1beta1.ImageSecurityPolicySpec{RequireAttestation: true},
+				}}, nil
+			}
+			return nil, nil
+		},
+		verifyAttestations: func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+			return image == alreadyAttestedImage, nil
+		},
+		validateImageSecurityPolicy: func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+			if image == violatingImage {
+				return []securitypolicy.SecurityPolicyViolation{{
+					Violation: securitypolicy.ExceedsMaxSeverityViolation,
+					Reason:    "found CVE cve1",
+				}}, nil
+			}
+			return nil, nil
+		},
+		createAttestations: func(namespace string, image string, client metadata.MetadataFetcher) error {
+			if image == unsignedImage {
+				return fmt.Errorf("no signing key")
+			}
+			*signed = append(*signed, fmt.Sprintf("%s/%s", namespace, image))
+			return nil
+		},
+	}
+}
+
+func Test_AttestAll(t *testing.T) {
+	var tests = []struct {
+		name     string
+		dryRun   bool
+		expected AttestAllSummary
+		signed   []string
+	}{
+		{
+			name: "attest",
+			expected: AttestAllSummary{
+				Attested:        []string{"prod/" + cleanImage},
+				AlreadyAttested: []string{"prod/" + alreadyAttestedImage},
+				Violating:       []string{"prod/" + violatingImage, "prod/" + privilegedImage, "prod/" + paymentsImage, "prod/" + otherTenantImage, "strict/" + cleanImage},
+				Failed:          []string{"prod/" + unsignedImage},
+			},
+			signed: []string{"prod/" + cleanImage},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			expected: AttestAllSummary{
+				Attested:        []string{"prod/" + cleanImage, "prod/" + unsignedImage},
+				AlreadyAttested: []string{"prod/" + alreadyAttestedImage},
+				Violating:       []string{"prod/" + violatingImage, "prod/" + privilegedImage, "prod/" + paymentsImage, "prod/" + otherTenantImage, "strict/" + cleanImage},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var signed []string
+			original := admissionConfig
+			defer func() {
+				admissionConfig = original
+			}()
+			admissionConfig = backfillConfig(&signed)
+			summary, err := AttestAll("", test.dryRun)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, summary)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.signed, signed)
+		})
+	}
+}
+
+func Test_AttestAllPodListError(t *testing.T) {
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	var signed []string
+	admissionConfig = backfillConfig(&signed)
+	admissionConfig.listPods = func(namespace string) ([]v1.Pod, error) {
+		return nil, fmt.Errorf("forbidden")
+	}
+	_, err := AttestAll("prod", false)
+	testutil.CheckError(t, true, err)
+}
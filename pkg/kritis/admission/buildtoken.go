@@ -0,0 +1,123 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	kritisconstants "github.com/grafeas/kritis/pkg/kritis/constants"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// BuildToken records that CI already validated images of a build with the
+// same policies. It is signed by CI and set as the pod's
+// kritisconstants.BuildTokenAnnotation, so that admission doesn't validate
+// the images again.
+type BuildToken struct {
+	// BuildID identifies the CI build, e.g. its commit or build number
+	BuildID string `json:"buildId"`
+	// Digests are the digests of the images the build validated
+	Digests []string `json:"digests"`
+	// IssuedAt is when CI validated the images
+	IssuedAt time.Time `json:"issuedAt"`
+	// ExpiresAt is when the token stops holding, as the vulnerabilities
+	// known for the images may change after the build. It is required.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SignBuildToken returns token signed with the given base64 encoded, armored
+// PGP keys, to be set as the pod's kritisconstants.BuildTokenAnnotation
+func SignBuildToken(token BuildToken, publicKey string, privateKey string) (string, error) {
+	message, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return attestation.CreateMessageAttestation(publicKey, privateKey, string(message))
+}
+
+// verifyBuildToken checks signed is signed by publicKey and hasn't expired,
+// and returns the token it holds
+func verifyBuildToken(signed string, publicKey string) (*BuildToken, error) {
+	message, err := attestation.GetPlainMessage(publicKey, signed)
+	if err != nil {
+		return nil, err
+	}
+	token := BuildToken{}
+	if err := json.Unmarshal(message, &token); err != nil {
+		return nil, fmt.Errorf("build token is invalid: %v", err)
+	}
+	now := time.Now()
+	if token.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("build token has no expiry")
+	}
+	if now.After(token.ExpiresAt) {
+		return nil, fmt.Errorf("build token expired at %s", token.ExpiresAt.Format(time.RFC3339))
+	}
+	if token.IssuedAt.After(now.Add(maxBuildTimeSkew)) {
+		return nil, fmt.Errorf("build token is issued in the future at %s", token.IssuedAt.Format(time.RFC3339))
+	}
+	return &token, nil
+}
+
+// covers returns true if image is one of the digests t validated. Like
+// attestations, the token is bound to the digest rather than the repository,
+// so that it still holds after the image is promoted to another repository.
+func (t *BuildToken) covers(image string) bool {
+	critical, err := util.NewCritical(image)
+	if err != nil {
+		return false
+	}
+	for _, d := range t.Digests {
+		if d == critical.Image.DockerDigest {
+			return true
+		}
+	}
+	return false
+}
+
+// unvalidatedImages returns the images CI didn't validate according to the
+// build token of pod. If no build token public key is configured, or the
+// token isn't signed by it or expired, every image is returned and validated
+// as usual. The token only vouches for the vulnerabilities of the images, so
+// it is applied after attestations are required, and the images it covers
+// aren't cached, as other pods in the namespace may not hold the token.
+func unvalidatedImages(pod *v1.Pod, images []string) []string {
+	publicKey := currentOptions().BuildTokenPublicKey
+	signed, ok := pod.GetAnnotations()[kritisconstants.BuildTokenAnnotation]
+	if publicKey == "" || !ok {
+		return images
+	}
+	token, err := verifyBuildToken(signed, publicKey)
+	if err != nil {
+		logrus.Warnf("rejecting build token of %s: %v", pod.Name, err)
+		return images
+	}
+	unvalidated := []string{}
+	for _, image := range images {
+		if !token.covers(image) {
+			unvalidated = append(unvalidated, image)
+			continue
+		}
+		logrus.Infof("%s was validated by build %s, skipping validation", image, token.BuildID)
+	}
+	return unvalidated
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	kritisconstants "github.com/grafeas/kritis/pkg/kritis/constants"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildToken(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	otherPublicKey, otherPrivateKey := createBase64KeyPair(t)
+	token := validBuildToken()
+	signed := signBuildToken(t, token, publicKey, privateKey)
+	expired := validBuildToken()
+	expired.IssuedAt = expired.IssuedAt.Add(-2 * time.Hour)
+	expired.ExpiresAt = expired.ExpiresAt.Add(-2 * time.Hour)
+	noExpiry := validBuildToken()
+	noExpiry.ExpiresAt = time.Time{}
+	future := validBuildToken()
+	future.IssuedAt = future.IssuedAt.Add(time.Hour)
+	var tests = []struct {
+		name      string
+		signed    string
+		image     string
+		shouldErr bool
+		covered   bool
+	}{
+		{
+			name:    "valid token",
+			signed:  signed,
+			image:   attestedImage,
+			covered: true,
+		},
+		{
+			name:    "valid token for the digest in another repository",
+			signed:  signed,
+			image:   promotedImage,
+			covered: true,
+		},
+		{
+			name:   "mismatched digest",
+			signed: signed,
+			image:  racedImage,
+		},
+		{
+			name:      "tampered token",
+			signed:    tamper(t, signed),
+			image:     attestedImage,
+			shouldErr: true,
+		},
+		{
+			name:      "token signed by another key",
+			signed:    signBuildToken(t, token, otherPublicKey, otherPrivateKey),
+			image:     attestedImage,
+			shouldErr: true,
+		},
+		{
+			name:      "expired token",
+			signed:    signBuildToken(t, expired, publicKey, privateKey),
+			image:     attestedImage,
+			shouldErr: true,
+		},
+		{
+			name:      "token without expiry",
+			signed:    signBuildToken(t, noExpiry, publicKey, privateKey),
+			image:     attestedImage,
+			shouldErr: true,
+		},
+		{
+			name:      "token issued in the future",
+			signed:    signBuildToken(t, future, publicKey, privateKey),
+			image:     attestedImage,
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := verifyBuildToken(test.signed, publicKey)
+			testutil.CheckError(t, test.shouldErr, err)
+			if test.shouldErr {
+				return
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, token, *verified)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.covered, verified.covers(test.image))
+		})
+	}
+}
+
+func Test_BuildTokenSkipsValidation(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	signed := signBuildToken(t, validBuildToken(), publicKey, privateKey)
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	var tests = []struct {
+		name      string
+		token     string
+		publicKey string
+		validated []string
+	}{
+		{
+			name:      "valid token",
+			token:     signed,
+			publicKey: publicKey,
+			validated: []string{racedImage},
+		},
+		{
+			name:      "tampered token",
+			token:     tamper(t, signed),
+			publicKey: publicKey,
+			validated: []string{attestedImage, racedImage},
+		},
+		{
+			name:      "no build token key",
+			token:     signed,
+			validated: []string{attestedImage, racedImage},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockPod := func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{kritisconstants.BuildTokenAnnotation: test.token},
+					},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{{Image: attestedImage}, {Image: racedImage}},
+					},
+				}, nil
+			}
+			cache := newAllowCache(defaultCacheTTL)
+			validated := []string{}
+			mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, image)
+				return nil, nil
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod:                 mockPod,
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					cache:                       cache,
+					options:                     Options{BuildTokenPublicKey: test.publicKey},
+				},
+				httpStatus: http.StatusOK,
+				allowed:    true,
+				status:     constants.SuccessStatus,
+				message:    constants.SuccessMessage,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+			// Other pods in the namespace may not hold the token
+			if len(test.validated) == 1 && cache.allowed("", attestedImage) {
+				t.Errorf("%s was cached as admitted by the build token", attestedImage)
+			}
+		})
+	}
+}
+
+func Test_BuildTokenRequiresAttestation(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	signed := signBuildToken(t, validBuildToken(), publicKey, privateKey)
+	validated := []string{}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod: func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{kritisconstants.BuildTokenAnnotation: signed},
+					},
+					Spec: v1.PodSpec{Containers: []v1.Container{{Image: attestedImage}}},
+				}, nil
+			},
+			fetchMetadataClient: mockMetadata(),
+			fetchImageSecurityPolicies: func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				isp := kritisv1beta1.ImageSecurityPolicy{}
+				isp.Spec.RequireAttestation = true
+				return []kritisv1beta1.ImageSecurityPolicy{isp}, nil
+			},
+			verifyAttestations: func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+				return false, nil
+			},
+			validateImageSecurityPolicy: func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+				validated = append(validated, image)
+				return nil, nil
+			},
+			options: Options{BuildTokenPublicKey: publicKey},
+		},
+		httpStatus: http.StatusOK,
+		allowed:    false,
+		status:     constants.FailureStatus,
+		reason:     constants.ReasonNoAttestation,
+		message:    string(securitypolicy.MissingAttestationViolationReason(attestedImage)),
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{}, validated)
+}
+
+// validBuildToken returns a token for attestedImage which expires in an hour
+func validBuildToken() BuildToken {
+	now := time.Now().UTC().Truncate(time.Second)
+	return BuildToken{
+		BuildID:   "0123abc",
+		Digests:   []string{"sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+}
+
+func signBuildToken(t *testing.T, token BuildToken, publicKey string, privateKey string) string {
+	signed, err := SignBuildToken(token, publicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// tamper returns signed with a character of its armored body changed
+func tamper(t *testing.T, signed string) string {
+	armored, err := base64.StdEncoding.DecodeString(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(armored), "\n")
+	// The body starts after the armor header and a blank line
+	body := []byte(lines[3])
+	if body[10] == 'A' {
+		body[10] = 'B'
+	} else {
+		body[10] = 'A'
+	}
+	lines[3] = string(body)
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\n")))
+}
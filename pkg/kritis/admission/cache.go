@@ -0,0 +1,333 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// defaultCacheTTL is how long an allow decision for an image is reused
+	defaultCacheTTL = 5 * time.Minute
+	// defaultNegativeCacheTTL is how long a failure to resolve an image is reused
+	defaultNegativeCacheTTL = 30 * time.Second
+	// defaultResponseCacheTTL is how long the response to an admission
+	// request is reused for identical retries of it
+	defaultResponseCacheTTL = 10 * time.Second
+	// watchRetryInterval is how long to wait before re-establishing a closed watch
+	watchRetryInterval = 10 * time.Second
+)
+
+// allowCache remembers images which recently passed validation in a namespace,
+// so repeated admissions of the same image don't re-fetch metadata.
+// A nil *allowCache is valid and caches nothing.
+type allowCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]time.Time
+}
+
+func newAllowCache(ttl time.Duration) *allowCache {
+	return &allowCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]time.Time{},
+	}
+}
+
+func cacheKey(namespace, image string) string {
+	if normalized, err := util.NormalizeImage(image); err == nil {
+		image = normalized
+	}
+	return fmt.Sprintf("%s/%s", namespace, image)
+}
+
+// allowed returns true if image was admitted in namespace within the cache TTL
+func (c *allowCache) allowed(namespace, image string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(namespace, image)
+	expiry, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if c.now().After(expiry) {
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+// setTTL changes how long decisions added from now on are cached
+func (c *allowCache) setTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// add records that image was admitted in namespace
+func (c *allowCache) add(namespace, image string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(namespace, image)] = c.now().Add(c.ttl)
+}
+
+// flush drops every cached decision
+func (c *allowCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]time.Time{}
+}
+
+type failure struct {
+	err    error
+	expiry time.Time
+}
+
+// negativeCache remembers images which recently failed to resolve, so that
+// admissions referencing an unreachable registry fail fast instead of each
+// waiting on it. A nil *negativeCache is valid and caches nothing.
+type negativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]failure
+}
+
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]failure{},
+	}
+}
+
+// failed returns the error image failed to resolve with within the cache TTL,
+// or nil if it didn't
+func (c *negativeCache) failed(image string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.entries[image]
+	if !ok {
+		return nil
+	}
+	if c.now().After(f.expiry) {
+		delete(c.entries, image)
+		return nil
+	}
+	return f.err
+}
+
+// setTTL changes how long failures added from now on are cached
+func (c *negativeCache) setTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// add records that image failed to resolve with err
+func (c *negativeCache) add(image string, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[image] = failure{err: err, expiry: c.now().Add(c.ttl)}
+}
+
+// cachedResponse is the admission response to a request
+type cachedResponse struct {
+	status   constants.Status
+	reason   constants.Reason
+	message  string
+	warnings []string
+	expiry   time.Time
+}
+
+// responseCache remembers the responses to recent admission requests, so
+// that the API server retrying a request, with the same UID and content,
+// gets the same response without the pod being validated again. A nil
+// *responseCache is valid and caches nothing.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]cachedResponse
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]cachedResponse{},
+	}
+}
+
+// responseKey identifies the request with uid and body, so that a request
+// reusing a UID with other content isn't served another pod's response
+func responseKey(uid types.UID, body []byte) string {
+	return fmt.Sprintf("%s/%x", uid, sha256.Sum256(body))
+}
+
+// get returns the response to the request with key within the cache TTL
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	if c == nil || key == "" {
+		return cachedResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.entries[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	if c.now().After(r.expiry) {
+		delete(c.entries, key)
+		return cachedResponse{}, false
+	}
+	return r, true
+}
+
+// setTTL changes how long responses added from now on are cached
+func (c *responseCache) setTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// add records r as the response to the request with key. Expired responses
+// are dropped, as each request is only retried briefly.
+func (c *responseCache) add(key string, r cachedResponse) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if now.After(e.expiry) {
+			delete(c.entries, k)
+		}
+	}
+	r.expiry = now.Add(c.ttl)
+	c.entries[key] = r
+}
+
+// flush drops every cached response
+func (c *responseCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]cachedResponse{}
+}
+
+// flusher is a cache of admission decisions which may not hold once policies
+// change
+type flusher interface {
+	flush()
+}
+
+// CacheImageSecurityPolicies serves the ImageSecurityPolicies admissions are
+// validated against from a cache which is kept up to date by a watch until
+// ctx is done, instead of listing them on every admission. It must be called
+// before admissions are handled.
+func CacheImageSecurityPolicies(ctx context.Context) error {
+	c, err := securitypolicy.NewInClusterPolicyCache()
+	if err != nil {
+		return err
+	}
+	go c.Run(ctx.Done())
+	admissionConfig.fetchImageSecurityPolicies = c.ImageSecurityPolicies
+	return nil
+}
+
+// WatchImageSecurityPolicies flushes cached admission decisions whenever an
+// ImageSecurityPolicy is added, modified or deleted, so a tightened policy
+// takes effect immediately. The watch is re-established until ctx is done.
+func WatchImageSecurityPolicies(ctx context.Context) {
+	for {
+		w, err := admissionConfig.watchImageSecurityPolicies()
+		if err != nil {
+			logrus.Errorf("error watching image security policies: %v", err)
+		} else {
+			invalidateCacheOnPolicyChange(ctx, w, admissionConfig.cache, admissionConfig.responses)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// invalidateCacheOnPolicyChange flushes caches on every event from w.
+// It returns when w is closed or ctx is done.
+func invalidateCacheOnPolicyChange(ctx context.Context, w watch.Interface, caches ...flusher) {
+	defer w.Stop()
+	// Events may have been missed while no watch was running
+	for _, c := range caches {
+		c.flush()
+	}
+	for {
+		select {
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			logrus.Debugf("image security policy %s, flushing admission cache", e.Type)
+			for _, c := range caches {
+				c.flush()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
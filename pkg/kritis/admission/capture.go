@@ -0,0 +1,263 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxCaptureSize bounds how many AdmissionReviews can be captured
+	maxCaptureSize = 1000
+	// redacted replaces secrets in captured AdmissionReviews
+	redacted = "REDACTED"
+	// lastAppliedAnnotation holds the object as last applied by kubectl,
+	// including any secrets in it
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// CapturedReview is an AdmissionReview as AdmissionReviewHandler received
+// it, with secrets redacted
+type CapturedReview struct {
+	// ID identifies the review for ReplayHandler
+	ID     int             `json:"id"`
+	Time   time.Time       `json:"time"`
+	Review json.RawMessage `json:"review"`
+}
+
+// captureBuffer keeps the most recent AdmissionReviews, for debugging.
+// A nil *captureBuffer, or one of size 0, captures nothing.
+type captureBuffer struct {
+	mu      sync.Mutex
+	size    int
+	nextID  int
+	reviews []CapturedReview
+}
+
+func newCaptureBuffer() *captureBuffer {
+	return &captureBuffer{nextID: 1}
+}
+
+// setSize changes how many reviews are kept, dropping the oldest ones if
+// there are more
+func (c *captureBuffer) setSize(size int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.trim()
+}
+
+func (c *captureBuffer) enabled() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size > 0
+}
+
+// add keeps the redacted review, dropping the oldest one if c is full
+func (c *captureBuffer) add(review []byte) {
+	if c == nil {
+		return
+	}
+	data, err := redact(review)
+	if err != nil {
+		logrus.Debugf("not capturing undecodable admission review: %v", err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size == 0 {
+		return
+	}
+	c.reviews = append(c.reviews, CapturedReview{ID: c.nextID, Time: time.Now(), Review: data})
+	c.nextID++
+	c.trim()
+}
+
+func (c *captureBuffer) trim() {
+	if extra := len(c.reviews) - c.size; extra > 0 {
+		c.reviews = append([]CapturedReview{}, c.reviews[extra:]...)
+	}
+}
+
+// list returns the kept reviews, oldest first
+func (c *captureBuffer) list() []CapturedReview {
+	if c == nil {
+		return []CapturedReview{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CapturedReview{}, c.reviews...)
+}
+
+// get returns the kept review with id
+func (c *captureBuffer) get(id int) (CapturedReview, bool) {
+	for _, r := range c.list() {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return CapturedReview{}, false
+}
+
+// captureRequest keeps the body of r, and returns r with a body which can
+// still be read
+func captureRequest(r *http.Request) *http.Request {
+	if r.Body == nil || !admissionConfig.captures.enabled() {
+		return r
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReviewSize+1))
+	if err != nil {
+		logrus.Debugf("not capturing admission review: %v", err)
+	} else if len(data) <= maxReviewSize {
+		admissionConfig.captures.add(data)
+	}
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+	return r
+}
+
+// redact returns review with the values of containers' environment
+// variables, and the object as last applied by kubectl, replaced
+func redact(review []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(review, &v); err != nil {
+		return nil, err
+	}
+	redactValue(v)
+	return json.Marshal(v)
+}
+
+func redactValue(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			switch key {
+			case "env":
+				if vars, ok := value.([]interface{}); ok {
+					for _, e := range vars {
+						if e, ok := e.(map[string]interface{}); ok && e["value"] != nil {
+							e["value"] = redacted
+						}
+					}
+				}
+			case "annotations":
+				if annotations, ok := value.(map[string]interface{}); ok && annotations[lastAppliedAnnotation] != nil {
+					annotations[lastAppliedAnnotation] = redacted
+				}
+			}
+			redactValue(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			redactValue(value)
+		}
+	}
+}
+
+// CapturesHandler lists the captured AdmissionReviews. If token isn't
+// empty, requests must have it as their bearer token.
+func CapturesHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !bearerTokenMatches(r, token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, err := json.Marshal(admissionConfig.captures.list())
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			logrus.Error("error writing response:", err)
+		}
+	}
+}
+
+// ReplayHandler replays the captured AdmissionReview with the id parameter
+// against AdmissionReviewHandler and returns its response. The review is
+// replayed as a dry run, so that it has no side effects. If token isn't
+// empty, requests must have it as their bearer token.
+func ReplayHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !bearerTokenMatches(r, token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			returnError(newError(ErrMalformedRequest, fmt.Errorf("invalid id parameter: %v", err)), w)
+			return
+		}
+		captured, ok := admissionConfig.captures.get(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		review, err := asDryRun(captured.Review)
+		if err != nil {
+			returnError(newError(ErrMalformedRequest, err), w)
+			return
+		}
+		logrus.Infof("replaying captured admission review %d", id)
+		replay, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader(review))
+		if err != nil {
+			returnError(err, w)
+			return
+		}
+		handleAdmissionReview(w, replay)
+	}
+}
+
+// asDryRun returns review with its request marked as a dry run
+func asDryRun(review []byte) ([]byte, error) {
+	ar := map[string]interface{}{}
+	if err := json.Unmarshal(review, &ar); err != nil {
+		return nil, err
+	}
+	request, ok := ar["request"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("admission review has no request")
+	}
+	request["dryRun"] = true
+	return json.Marshal(ar)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/metrics"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/grafeas/kritis/pkg/kritis/violation"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCaptureBuffer(t *testing.T) {
+	c := newCaptureBuffer()
+	c.add([]byte(`{}`))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 0, len(c.list()))
+	c.setSize(2)
+	for i := 0; i < 3; i++ {
+		c.add([]byte(`{}`))
+	}
+	ids := func() []int {
+		ids := []int{}
+		for _, r := range c.list() {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []int{2, 3}, ids())
+	c.setSize(1)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []int{3}, ids())
+	_, ok := c.get(2)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, ok)
+	_, ok = c.get(3)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, true, ok)
+}
+
+func TestRedact(t *testing.T) {
+	review := `{"request":{"object":{"metadata":{"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"password\":\"hunter2\"}","team":"payments"}},` +
+		`"spec":{"containers":[{"image":"app","env":[{"name":"PASSWORD","value":"hunter2"},{"name":"TOKEN","valueFrom":{"secretKeyRef":{"name":"token","key":"token"}}}]}]}}}}`
+	expected := `{"request":{"object":{"metadata":{"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"REDACTED","team":"payments"}},` +
+		`"spec":{"containers":[{"env":[{"name":"PASSWORD","value":"REDACTED"},{"name":"TOKEN","valueFrom":{"secretKeyRef":{"key":"token","name":"token"}}}],"image":"app"}]}}}}`
+	data, err := redact([]byte(review))
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, string(data))
+}
+
+func Test_CaptureAndReplay(t *testing.T) {
+	raw, err := json.Marshal(v1.Pod{
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Image: testutil.QualifiedImage,
+			Env:   []v1.EnvVar{{Name: "PASSWORD", Value: "hunter2"}},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return []securitypolicy.SecurityPolicyViolation{{
+			Violation: securitypolicy.UnknownImageViolation,
+			Reason:    securitypolicy.UnknownImageViolationReason(image),
+		}}, nil
+	}
+	strategy := &violation.MemoryStrategy{Violations: map[string]bool{}}
+	originalStrategy := defaultViolationStrategy
+	original := admissionConfig
+	defer func() {
+		defaultViolationStrategy = originalStrategy
+		admissionConfig = original
+	}()
+	defaultViolationStrategy = strategy
+	admissionConfig = config{
+		retrievePod:                 unmarshalPod,
+		retrieveReview:              unmarshalReview,
+		fetchMetadataClient:         mockMetadata(),
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: mockValidate,
+		captures:                    newCaptureBuffer(),
+	}
+	admissionConfig.captures.setSize(5)
+
+	admitted := httptest.NewRecorder()
+	AdmissionReviewHandler(admitted, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body))))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, true, strategy.Violations[testutil.QualifiedImage])
+
+	// The review is captured with secrets redacted
+	listed := httptest.NewRecorder()
+	CapturesHandler("token")(listed, authorized(httptest.NewRequest(http.MethodGet, "/debug/admissions", nil)))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, http.StatusOK, listed.Code)
+	captured := []CapturedReview{}
+	if err := json.Unmarshal(listed.Body.Bytes(), &captured); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, len(captured))
+	if strings.Contains(string(captured[0].Review), "hunter2") {
+		t.Errorf("captured review has a secret: %s", captured[0].Review)
+	}
+
+	// Replaying it gives the same decision, without side effects
+	strategy.Violations = map[string]bool{}
+	replayed := httptest.NewRecorder()
+	ReplayHandler("token")(replayed, authorized(httptest.NewRequest(http.MethodPost, "/debug/replay?id=1", nil)))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, admitted.Code, replayed.Code)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, admitted.Body.String(), replayed.Body.String())
+	testutil.CheckErrorAndDeepEqual(t, false, nil, map[string]bool{}, strategy.Violations)
+	// and isn't captured again
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, len(admissionConfig.captures.list()))
+
+	var tests = []struct {
+		name     string
+		request  *http.Request
+		expected int
+	}{
+		{"unauthorized list", httptest.NewRequest(http.MethodGet, "/debug/admissions", nil), http.StatusUnauthorized},
+		{"unauthorized replay", httptest.NewRequest(http.MethodPost, "/debug/replay?id=1", nil), http.StatusUnauthorized},
+		{"unknown review", authorized(httptest.NewRequest(http.MethodPost, "/debug/replay?id=2", nil)), http.StatusNotFound},
+		{"invalid id", authorized(httptest.NewRequest(http.MethodPost, "/debug/replay?id=first", nil)), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			if strings.HasPrefix(test.request.URL.Path, "/debug/replay") {
+				ReplayHandler("token")(rr, test.request)
+			} else {
+				CapturesHandler("token")(rr, test.request)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, rr.Code)
+		})
+	}
+}
+
+func Test_ReplayHasNoSideEffects(t *testing.T) {
+	raw, err := json.Marshal(v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:    "request",
+			Kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	var violations []securitypolicy.SecurityPolicyViolation
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return violations, nil
+	}
+	sink := make(channelSink, 10)
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig = config{
+		retrievePod:                 unmarshalPod,
+		retrieveReview:              unmarshalReview,
+		fetchMetadataClient:         mockMetadata(),
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: mockValidate,
+		createAttestations:          func(string, string, metadata.MetadataFetcher) error { return nil },
+		decisions:                   newDecisionQueue(sink),
+		cache:                       newAllowCache(time.Hour),
+		responses:                   newResponseCache(time.Hour),
+		captures:                    newCaptureBuffer(),
+	}
+	admissionConfig.captures.setSize(5)
+
+	admitted := httptest.NewRecorder()
+	AdmissionReviewHandler(admitted, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body))))
+	select {
+	case <-sink:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the admission to be recorded")
+	}
+	admissionConfig.cache.flush()
+	admissionConfig.responses.flush()
+	before := scrapeMetrics()
+
+	replay := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ReplayHandler("token")(rr, authorized(httptest.NewRequest(http.MethodPost, "/debug/replay?id=1", nil)))
+		return rr
+	}
+	allowed := replay()
+	testutil.CheckErrorAndDeepEqual(t, false, nil, admitted.Body.String(), allowed.Body.String())
+	if admissionConfig.cache.allowed("default", testutil.QualifiedImage) {
+		t.Error("expected a replay not to cache the image as admitted")
+	}
+	// A second replay is validated again, rather than served from the
+	// response cache
+	violations = []securitypolicy.SecurityPolicyViolation{{Violation: securitypolicy.UnknownImageViolation, Reason: "unknown"}}
+	denied := replay()
+	if denied.Body.String() == allowed.Body.String() {
+		t.Errorf("expected the second replay to be validated again, got %s", denied.Body.String())
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, before, scrapeMetrics())
+	select {
+	case r := <-sink:
+		t.Errorf("expected replays not to be recorded, got %+v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// scrapeMetrics returns the metrics as served to Prometheus
+func scrapeMetrics() string {
+	rr := httptest.NewRecorder()
+	metrics.Handler(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rr.Body.String()
+}
+
+func authorized(r *http.Request) *http.Request {
+	r.Header.Set("Authorization", "Bearer token")
+	return r
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The clientset of the cluster the webhook runs in, created on first use
+// and shared by every admission, rather than created for each API call.
+// If creating it fails, it is created again on next use.
+var (
+	clientsetMu sync.Mutex
+	clientset   kubernetes.Interface
+)
+
+// inClusterClientset returns the shared clientset of the cluster
+func inClusterClientset() (kubernetes.Interface, error) {
+	clientsetMu.Lock()
+	defer clientsetMu.Unlock()
+	if clientset != nil {
+		return clientset, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error building config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building clientset: %v", err)
+	}
+	clientset = client
+	return client, nil
+}
@@ -30,6 +30,102 @@ const (
 	SuccessMessage = "Successfully admitted."
 )
 
+// Reason is a stable, machine readable code for why a pod was denied, set
+// as metav1.Status.Reason in addition to the message
+type Reason string
+
+const (
+	// ReasonUnqualifiedImage means an image isn't referenced by digest
+	ReasonUnqualifiedImage Reason = "KRITIS_UNQUALIFIED_IMAGE"
+	// ReasonVulnerabilityThreshold means an image's CVEs exceed a policy's
+	// maximum severity or counts, have fixes available or match its
+	// vulnerability filter
+	ReasonVulnerabilityThreshold Reason = "KRITIS_VULN_THRESHOLD"
+	// ReasonKnownExploitedVulnerability means an image has a CVE on the
+	// Known Exploited Vulnerabilities list
+	ReasonKnownExploitedVulnerability Reason = "KRITIS_KNOWN_EXPLOITED_VULN"
+	// ReasonUnpatchedDisclosedVulnerability means an image has a CVE with a
+	// fix which wasn't patched within the policy's maximum disclosure age
+	ReasonUnpatchedDisclosedVulnerability Reason = "KRITIS_UNPATCHED_DISCLOSED_VULN"
+	// ReasonNoAttestation means an image has neither an attestation nor a
+	// valid signature required by a policy, or a pod with elevated
+	// privileges runs an image without an attestation
+	ReasonNoAttestation Reason = "KRITIS_NO_ATTESTATION"
+	// ReasonStaleImage means the signed build timestamp of an image is older
+	// than a policy's maximum image age
+	ReasonStaleImage Reason = "KRITIS_STALE_IMAGE"
+	// ReasonNoMetadata means there is no metadata for an image
+	ReasonNoMetadata Reason = "KRITIS_NO_METADATA"
+	// ReasonProvenance means an image has no build provenance, was built
+	// below a policy's minimum SLSA level, or wasn't built by the same
+	// builder as the other images of its pod
+	ReasonProvenance Reason = "KRITIS_PROVENANCE"
+	// ReasonEmbeddedSecret means a secret was detected in an image
+	ReasonEmbeddedSecret Reason = "KRITIS_EMBEDDED_SECRET"
+	// ReasonMalware means malware was detected in an image
+	ReasonMalware Reason = "KRITIS_MALWARE"
+	// ReasonImageTooLarge means an image exceeds a policy's maximum size or
+	// number of layers
+	ReasonImageTooLarge Reason = "KRITIS_IMAGE_TOO_LARGE"
+	// ReasonDisallowedOperatingSystem means an image is based on an
+	// operating system a policy disallows
+	ReasonDisallowedOperatingSystem Reason = "KRITIS_DISALLOWED_OS"
+	// ReasonMissingNoteKind means an image has no occurrence of a kind of
+	// note a policy requires
+	ReasonMissingNoteKind Reason = "KRITIS_MISSING_NOTE_KIND"
+	// ReasonDeniedNoteKind means an image has an occurrence of a kind of
+	// note a policy denies
+	ReasonDeniedNoteKind Reason = "KRITIS_DENIED_NOTE_KIND"
+	// ReasonDisallowedRegistry means an init container image isn't from a
+	// registry a policy allows, or an image isn't from its namespace's tenant
+	ReasonDisallowedRegistry Reason = "KRITIS_DISALLOWED_REGISTRY"
+	// ReasonUnmatchedImage means no policy validates or whitelists an image,
+	// and a policy denies unmatched images by default
+	ReasonUnmatchedImage Reason = "KRITIS_UNMATCHED_IMAGE"
+	// ReasonDisallowedServiceAccount means an image runs under a service
+	// account a policy doesn't allow to run it
+	ReasonDisallowedServiceAccount Reason = "KRITIS_DISALLOWED_SERVICE_ACCOUNT"
+	// ReasonOPAPolicy means the OPA decision a policy delegates to denies an
+	// image
+	ReasonOPAPolicy Reason = "KRITIS_OPA_POLICY"
+	// ReasonVulnerabilityBudget means a pod would exceed the vulnerability
+	// budget of its namespace
+	ReasonVulnerabilityBudget Reason = "KRITIS_VULN_BUDGET"
+	// ReasonRootImage means an image runs as root
+	ReasonRootImage Reason = "KRITIS_ROOT_IMAGE"
+	// ReasonMissingImageLabels means an image's config doesn't set required labels
+	ReasonMissingImageLabels Reason = "KRITIS_MISSING_IMAGE_LABELS"
+	// ReasonExposedPort means an image's config exposes a disallowed port, or
+	// too many ports
+	ReasonExposedPort Reason = "KRITIS_EXPOSED_PORT"
+	// ReasonNeverPulled means an image is never pulled, so it can't be
+	// validated, and was denied by the Deny never pull policy
+	ReasonNeverPulled Reason = "KRITIS_NEVER_PULLED"
+	// ReasonUnresolvedImage means an image isn't a literal image reference,
+	// such as an unsubstituted placeholder, and was denied by the Deny
+	// unresolved image policy
+	ReasonUnresolvedImage Reason = "KRITIS_UNRESOLVED_IMAGE"
+	// ReasonImageNotPullable means an image doesn't exist, or can't be pulled
+	// with the pod's imagePullSecrets
+	ReasonImageNotPullable Reason = "KRITIS_IMAGE_NOT_PULLABLE"
+	// ReasonNoPolicy means the namespace has no ImageSecurityPolicy
+	ReasonNoPolicy Reason = "KRITIS_NO_POLICY"
+	// ReasonUntrustedPolicy means an ImageSecurityPolicy of the namespace
+	// isn't signed by a trusted policy author, or was modified since
+	ReasonUntrustedPolicy Reason = "KRITIS_UNTRUSTED_POLICY"
+	// ReasonValidationError means a pod couldn't be validated, and was
+	// denied by the Fail failure policy
+	ReasonValidationError Reason = "KRITIS_VALIDATION_ERROR"
+	// ReasonTemporarilyUnavailable means a pod couldn't be validated because
+	// of a transient error, such as a metadata backend outage, and was denied
+	// by the Fail failure policy. Retrying later may admit it.
+	ReasonTemporarilyUnavailable Reason = "KRITIS_TEMPORARILY_UNAVAILABLE"
+	// ReasonUnsupportedOperation means the request is for an operation other
+	// than CREATE or UPDATE, which kritis doesn't validate, and was denied
+	// because DenyOtherOperations is set
+	ReasonUnsupportedOperation Reason = "KRITIS_UNSUPPORTED_OPERATION"
+)
+
 const (
 	RSABits = 4096
 )
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+)
+
+// EffectiveConfig is the configuration kritis currently admits pods with
+type EffectiveConfig struct {
+	// Options are the current Options, with the paths and queries of
+	// violation route webhooks, which may carry tokens, redacted
+	Options Options `json:"options"`
+	// ImageWhitelist are the images which are always admitted, which are
+	// the defaults if Options.ImageWhitelist isn't set
+	ImageWhitelist []string `json:"imageWhitelist"`
+	// Policies are the loaded ImageSecurityPolicies, as namespace/name
+	Policies []string `json:"policies"`
+}
+
+// ConfigHandler returns a handler serving GET /config with the
+// EffectiveConfig. If token isn't empty, requests must present it as a
+// bearer token.
+func ConfigHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !bearerTokenMatches(r, token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		c, err := Config()
+		if err != nil {
+			returnError(err, w)
+			return
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			logrus.Error("error writing response:", err)
+		}
+	}
+}
+
+// Config returns the EffectiveConfig
+func Config() (*EffectiveConfig, error) {
+	o := currentOptions()
+	var isps []kritisv1beta1.ImageSecurityPolicy
+	var err error
+	if o.PolicyBundle != "" {
+		isps, err = admissionConfig.fetchPolicyBundle(o.PolicyBundle, o.PolicyBundlePublicKey)
+	} else {
+		isps, err = admissionConfig.fetchImageSecurityPolicies("")
+	}
+	if err != nil {
+		return nil, newError(ErrPolicyLoad, err)
+	}
+	securitypolicy.Sort(isps)
+	c := &EffectiveConfig{
+		Options:        redactOptions(o),
+		ImageWhitelist: util.GlobalWhitelist(),
+		Policies:       []string{},
+	}
+	for _, isp := range isps {
+		c.Policies = append(c.Policies, fmt.Sprintf("%s/%s", isp.Namespace, isp.Name))
+	}
+	return c, nil
+}
+
+// redactOptions returns o with the secrets it may hold redacted
+func redactOptions(o Options) Options {
+	routes := []ViolationRoute{}
+	for _, r := range o.ViolationRoutes {
+		r.Webhook = redactURL(r.Webhook)
+		routes = append(routes, r)
+	}
+	o.ViolationRoutes = routes
+	return o
+}
+
+// redactURL returns the scheme and host of rawurl, followed by a redacted
+// path if it has a path or query, since webhooks are often authorized by
+// tokens in them
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return redacted
+	}
+	redactedURL := url.URL{Scheme: u.Scheme, Host: u.Host}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Opaque != "" {
+		redactedURL.Path = "/" + redacted
+	}
+	return redactedURL.String()
+}
+
+func bearerTokenMatches(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of errors returned by ValidatePod.
+// Use errors.Cause(err) to find the kind of an *Error.
+var (
+	// ErrMalformedRequest means the AdmissionReview didn't contain a pod or workload kritis could decode
+	ErrMalformedRequest = errors.New("malformed admission request")
+	// ErrPolicyLoad means the ImageSecurityPolicies for the namespace couldn't be listed
+	ErrPolicyLoad = errors.New("error loading image security policies")
+	// ErrMetadataUnavailable means vulnerability metadata for an image couldn't be fetched
+	ErrMetadataUnavailable = errors.New("metadata unavailable")
+)
+
+// retryAfter is how long clients are asked to wait before retrying an
+// admission which failed on a temporary error
+const retryAfter = 10 * time.Second
+
+// Error is an error of a known Kind encountered while admitting a pod
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func newError(kind error, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+// Cause returns the kind of e, so errors.Cause can be used to branch on it
+func (e *Error) Cause() error {
+	return e.Kind
+}
+
+// Temporary returns true if e may not recur when the admission is retried,
+// such as when the metadata backend is briefly unavailable
+func (e *Error) Temporary() bool {
+	return e.Kind == ErrMetadataUnavailable
+}
+
+// temporary returns true if err is a temporary *Error
+func temporary(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Temporary()
+}
+
+// retryAfterSeconds returns the Retry-After hint for temporary errors
+func retryAfterSeconds() int32 {
+	return int32(retryAfter / time.Second)
+}
+
+// setRetryAfter sets the Retry-After header of w if err is temporary
+func setRetryAfter(err error, w http.ResponseWriter) {
+	if temporary(err) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfterSeconds())))
+	}
+}
+
+// httpStatus returns the response code AdmissionReviewHandler returns for err
+func httpStatus(err error) int {
+	switch errors.Cause(err) {
+	case ErrMalformedRequest:
+		return http.StatusBadRequest
+	case ErrMetadataUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// imageFinding is the outcome of validating an image against an
+// ImageSecurityPolicy
+type imageFinding struct {
+	isp   kritisv1beta1.ImageSecurityPolicy
+	image string
+	// violations deny the pod
+	violations []securitypolicy.SecurityPolicyViolation
+	// overridden are soft findings: violations which the pod's spec
+	// overrides, so they are only logged
+	overridden []securitypolicy.SecurityPolicyViolation
+	err        error
+}
+
+// evaluateImages validates each of images against each of isps in the
+// background, streaming a finding per policy and image on the returned
+// channel in that order, so the caller can respond as soon as one denies the
+// pod. Each policy's metadata is fetched with the client at the same index
+// of clients. containerImages maps images to how the pod's containers reference
+// them. The channel is closed once every image was validated, or after the
+// first error, since the other images would likely fail the same way. Images
+// are only validated until they violate a policy, as that decides the pod.
+func evaluateImages(pod *v1.Pod, isps []kritisv1beta1.ImageSecurityPolicy, clients []metadata.MetadataFetcher, images []string, containerImages map[string]string) <-chan imageFinding {
+	// Buffer every finding, so evaluation completes even if the caller
+	// stops receiving
+	findings := make(chan imageFinding, len(isps)*len(images))
+	validate := admissionConfig.validateImageSecurityPolicy
+	go func() {
+		defer close(findings)
+		for i, isp := range isps {
+			for _, image := range images {
+				f := imageFinding{isp: isp, image: image}
+				violations, err := validate(referencedPolicy(isp, containerImages[image]), image, clients[i], securitypolicy.StopAtFirstViolation(), securitypolicy.RequestRescans())
+				if err != nil {
+					f.err = err
+					findings <- f
+					return
+				}
+				if requested := containerImages[image]; requested != image {
+					violations = append(securitypolicy.ValidateImageReference(isp, requested), violations...)
+				}
+				f.violations, f.overridden = splitOverriddenViolations(pod, containerImages[image], violations)
+				findings <- f
+			}
+		}
+	}()
+	return findings
+}
+
+// referencedPolicy returns isp as it applies to an image the pod references
+// as requested. Its UnknownDigestAction only applies to images the pod pins
+// to a digest, not to tags kritis resolved to one.
+func referencedPolicy(isp kritisv1beta1.ImageSecurityPolicy, requested string) kritisv1beta1.ImageSecurityPolicy {
+	if requested != "" && !util.IsDigestReference(requested) {
+		isp.Spec.UnknownDigestAction = ""
+	}
+	return isp
+}
+
+// logSoftFindings logs the overridden violations of f
+func logSoftFindings(pod *v1.Pod, f imageFinding) {
+	for _, v := range f.overridden {
+		logrus.WithFields(logrus.Fields{
+			"pod":    pod.Name,
+			"image":  f.image,
+			"policy": f.isp.Name,
+		}).Infof("soft finding: %s", v.Reason)
+	}
+}
+
+// collectSoftFindings logs the remaining findings once pod was denied, so
+// the violations of the images validated after the decisive one are still
+// recorded. It returns when evaluation completes.
+func collectSoftFindings(pod *v1.Pod, findings <-chan imageFinding) {
+	for f := range findings {
+		fields := logrus.Fields{
+			"pod":    pod.Name,
+			"image":  f.image,
+			"policy": f.isp.Name,
+		}
+		if f.err != nil {
+			logrus.WithFields(fields).Warnf("error validating image after the pod was denied: %v", f.err)
+			continue
+		}
+		for _, v := range f.violations {
+			logrus.WithFields(fields).Infof("soft finding after the pod was denied: %s", v.Reason)
+		}
+		logSoftFindings(pod, f)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+const slowImage = "gcr.io/kritis-project/slow@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+func severityViolation(image string) securitypolicy.SecurityPolicyViolation {
+	vulnz := metadata.Vulnerability{CVE: "CVE-1", Severity: "HIGH"}
+	return securitypolicy.SecurityPolicyViolation{
+		Vulnerability: vulnz,
+		Violation:     securitypolicy.ExceedsMaxSeverityViolation,
+		Reason:        securitypolicy.ExceedsMaxSeverityViolationReason(image, vulnz, kritisv1beta1.ImageSecurityPolicy{}),
+	}
+}
+
+func Test_EarlyExitOnViolation(t *testing.T) {
+	release := make(chan struct{})
+	validatedSlow := make(chan struct{})
+	c := config{
+		fetchMetadataClient: mockMetadata(),
+		fetchImageSecurityPolicies: func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+			return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+		},
+		validateImageSecurityPolicy: func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+			if image == slowImage {
+				<-release
+				close(validatedSlow)
+			}
+			return []securitypolicy.SecurityPolicyViolation{severityViolation(image)}, nil
+		},
+	}
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig = c
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}, {Image: slowImage}}},
+	}
+	type result struct {
+		status constants.Status
+		reason constants.Reason
+	}
+	results := make(chan result)
+	go func() {
+		status, reason, _, _ := validatePod(&review{pod: pod, dryRun: true}, nil)
+		results <- result{status, reason}
+	}()
+	// The pod is denied while the slow image is still being validated
+	select {
+	case r := <-results:
+		testutil.CheckErrorAndDeepEqual(t, false, nil, result{constants.FailureStatus, constants.ReasonVulnerabilityThreshold}, r)
+	case <-time.After(10 * time.Second):
+		t.Fatal("validation didn't return on the first violation")
+	}
+	close(release)
+	select {
+	case <-validatedSlow:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the slow image wasn't validated in the background")
+	}
+}
+
+func Test_CollectSoftFindings(t *testing.T) {
+	hook := &captureHook{}
+	originalHooks := logrus.StandardLogger().Hooks
+	logrus.StandardLogger().Hooks = logrus.LevelHooks{}
+	logrus.AddHook(hook)
+	defer func() {
+		logrus.StandardLogger().Hooks = originalHooks
+	}()
+	rootReason := securitypolicy.RootImageViolationReason(testutil.QualifiedImage, "")
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig.validateImageSecurityPolicy = func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		if image == slowImage {
+			return []securitypolicy.SecurityPolicyViolation{severityViolation(image)}, nil
+		}
+		return []securitypolicy.SecurityPolicyViolation{{
+			Violation: securitypolicy.RootImageViolation,
+			Reason:    rootReason,
+		}}, nil
+	}
+	nonRoot := true
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			SecurityContext: &v1.PodSecurityContext{RunAsNonRoot: &nonRoot},
+			Containers:      []v1.Container{{Image: testutil.QualifiedImage}, {Image: slowImage}},
+		},
+	}
+	pod.Name = "pod"
+	images := []string{testutil.QualifiedImage, slowImage}
+	containerImages := map[string]string{testutil.QualifiedImage: testutil.QualifiedImage, slowImage: slowImage}
+	isps := []kritisv1beta1.ImageSecurityPolicy{{}}
+	isps[0].Name = "isp"
+	findings := evaluateImages(pod, isps, []metadata.MetadataFetcher{nil}, images, containerImages)
+	// The root image is overridden by the pod's security context
+	first := <-findings
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 0, len(first.violations))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, len(first.overridden))
+	logSoftFindings(pod, first)
+	collectSoftFindings(pod, findings)
+	expected := []string{
+		fmt.Sprintf("soft finding: %s", rootReason),
+		fmt.Sprintf("soft finding after the pod was denied: %s", severityViolation(slowImage).Reason),
+	}
+	logged := []string{}
+	for _, e := range hook.entries {
+		if e.Level != logrus.DebugLevel {
+			logged = append(logged, e.Message)
+			if e.Data["pod"] != "pod" || e.Data["policy"] != "isp" {
+				t.Errorf("expected the pod and policy to be logged, got %v", e.Data)
+			}
+		}
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, logged)
+}
+
+func Test_EvaluateImagesStopsOnError(t *testing.T) {
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	validated := 0
+	admissionConfig.validateImageSecurityPolicy = func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated++
+		return nil, fmt.Errorf("deadline exceeded")
+	}
+	images := []string{testutil.QualifiedImage, slowImage}
+	containerImages := map[string]string{testutil.QualifiedImage: testutil.QualifiedImage, slowImage: slowImage}
+	findings := []imageFinding{}
+	for f := range evaluateImages(&v1.Pod{}, []kritisv1beta1.ImageSecurityPolicy{{}}, []metadata.MetadataFetcher{nil}, images, containerImages) {
+		findings = append(findings, f)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, len(findings))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, validated)
+	if findings[0].err == nil {
+		t.Error("expected the error to be streamed")
+	}
+}
+
+func Test_ReferencedPolicy(t *testing.T) {
+	var tests = []struct {
+		name      string
+		requested string
+		expected  string
+	}{
+		{
+			name:      "pinned digest",
+			requested: testutil.QualifiedImage,
+			expected:  kritisv1beta1.UnknownDigestDeny,
+		},
+		{
+			name:      "resolved tag",
+			requested: "gcr.io/kritis-project/app:latest",
+		},
+		{
+			name:     "unknown reference",
+			expected: kritisv1beta1.UnknownDigestDeny,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := kritisv1beta1.ImageSecurityPolicy{
+				Spec: kritisv1beta1.ImageSecurityPolicySpec{UnknownDigestAction: kritisv1beta1.UnknownDigestDeny},
+			}
+			actual := referencedPolicy(isp, test.requested)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, actual.Spec.UnknownDigestAction)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, kritisv1beta1.UnknownDigestDeny, isp.Spec.UnknownDigestAction)
+		})
+	}
+}
+
+func Test_EvaluateImagesStopsAtFirstViolation(t *testing.T) {
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig.validateImageSecurityPolicy = func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		if len(opts) != 2 {
+			t.Errorf("expected images to be validated until they violate the policy, requesting rescans, got options %v", opts)
+		}
+		return nil, nil
+	}
+	images := []string{testutil.QualifiedImage}
+	containerImages := map[string]string{testutil.QualifiedImage: testutil.QualifiedImage}
+	for range evaluateImages(&v1.Pod{}, []kritisv1beta1.ImageSecurityPolicy{{}}, []metadata.MetadataFetcher{nil}, images, containerImages) {
+	}
+}
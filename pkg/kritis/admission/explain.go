@@ -0,0 +1,232 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Explanation is the admission decision kritis would currently make for an
+// image in a namespace, along with every violation found
+type Explanation struct {
+	Image      string               `json:"image"`
+	Namespace  string               `json:"namespace"`
+	Allowed    bool                 `json:"allowed"`
+	Message    string               `json:"message"`
+	Violations []ExplainedViolation `json:"violations,omitempty"`
+	// TotalViolations is the number of violations found, including any
+	// omitted from Violations by Options.MaxExplainedViolations
+	TotalViolations int `json:"totalViolations,omitempty"`
+	// Omitted summarizes the violations omitted from Violations, e.g. "+N more"
+	Omitted string `json:"omitted,omitempty"`
+}
+
+// ExplainedViolation is a violation of the named ImageSecurityPolicy
+type ExplainedViolation struct {
+	Policy   string `json:"policy"`
+	Reason   string `json:"reason"`
+	CVE      string `json:"cve,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// ExplainHandler returns a handler serving GET /explain?image=X&namespace=Y
+// with the Explanation for image X under the ImageSecurityPolicies of
+// namespace Y. If token isn't empty, requests must present it as a bearer
+// token.
+func ExplainHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !bearerTokenMatches(r, token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		image := r.URL.Query().Get("image")
+		if image == "" {
+			returnError(newError(ErrMalformedRequest, fmt.Errorf("missing image parameter")), w)
+			return
+		}
+		e, err := Explain(r.URL.Query().Get("namespace"), image)
+		if err != nil {
+			metadataClientFailed(err)
+			returnError(err, w)
+			return
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			logrus.Error("error writing response:", err)
+		}
+	}
+}
+
+// Explain evaluates image against every ImageSecurityPolicy in namespace the
+// way ValidatePod would, but without using the cache, handling violations,
+// requesting rescans, remembering images which failed to resolve or creating
+// attestations.
+func Explain(namespace string, image string) (*Explanation, error) {
+	e := &Explanation{
+		Image:     image,
+		Namespace: namespace,
+		Allowed:   true,
+		Message:   constants.SuccessMessage,
+	}
+	images, err := resolveImages([]string{image}, nil, false)
+	if err != nil {
+		return nil, newError(ErrMetadataUnavailable, err)
+	}
+	image = images[0]
+	if namespaceExempt(namespace) || util.CheckGlobalWhitelist(images) {
+		return e, nil
+	}
+	isps, err := imageSecurityPolicies(namespace)
+	if err != nil {
+		return nil, newError(ErrPolicyLoad, err)
+	}
+	securitypolicy.Sort(isps)
+	if len(isps) == 0 && currentOptions().RequirePolicy {
+		e.Allowed = false
+		e.Message = noPolicyMessage(namespace)
+		return e, nil
+	}
+	metadataClient, err := admissionConfig.fetchMetadataClient()
+	if err != nil {
+		return nil, newError(ErrMetadataUnavailable, err)
+	}
+	clients, err := policyMetadataClients(isps, metadataClient)
+	if err != nil {
+		return nil, newError(ErrMetadataUnavailable, err)
+	}
+	for i, isp := range isps {
+		violations, err := admissionConfig.validateImageSecurityPolicy(isp, image, clients[i])
+		if err != nil {
+			return nil, newError(ErrMetadataUnavailable, err)
+		}
+		if len(violations) == 0 {
+			continue
+		}
+		// Report the first policy denying the image, as ValidatePod does
+		if e.Allowed {
+			e.Allowed = false
+			e.Message = violationsMessage(image, violations)
+		}
+		for _, v := range violations {
+			e.TotalViolations++
+			if max := currentOptions().MaxExplainedViolations; max > 0 && len(e.Violations) >= max {
+				continue
+			}
+			e.Violations = append(e.Violations, ExplainedViolation{
+				Policy:   isp.Name,
+				Reason:   string(v.Reason),
+				CVE:      v.Vulnerability.CVE,
+				Severity: v.Vulnerability.Severity,
+			})
+		}
+	}
+	if omitted := e.TotalViolations - len(e.Violations); omitted > 0 {
+		e.Omitted = fmt.Sprintf("+%d more", omitted)
+	}
+	return e, nil
+}
+
+// violationsMessage returns the message of the admission response denying image
+func violationsMessage(image string, violations []securitypolicy.SecurityPolicyViolation) string {
+	for _, v := range violations {
+		switch v.Violation {
+		case securitypolicy.UnqualifiedImageViolation:
+			return fmt.Sprintf("%s is not a fully qualified image", image)
+		case securitypolicy.TagReferenceViolation:
+			// The reason names the image as the pod references it
+			return string(v.Reason)
+		}
+	}
+	return fmt.Sprintf("found violations in %s", image)
+}
+
+// violationReasons are the reasons of the admission response denying an image
+// for each kind of violation
+var violationReasons = map[int]constants.Reason{
+	securitypolicy.UnqualifiedImageViolation:          constants.ReasonUnqualifiedImage,
+	securitypolicy.TagReferenceViolation:              constants.ReasonUnqualifiedImage,
+	securitypolicy.FixesNotAvailableViolation:         constants.ReasonVulnerabilityThreshold,
+	securitypolicy.ExceedsMaxSeverityViolation:        constants.ReasonVulnerabilityThreshold,
+	securitypolicy.ExceedsMaxCountViolation:           constants.ReasonVulnerabilityThreshold,
+	securitypolicy.FilterViolation:                    constants.ReasonVulnerabilityThreshold,
+	securitypolicy.KnownExploitedViolation:            constants.ReasonKnownExploitedVulnerability,
+	securitypolicy.DisclosureAgeViolation:             constants.ReasonUnpatchedDisclosedVulnerability,
+	securitypolicy.UnknownImageViolation:              constants.ReasonNoMetadata,
+	securitypolicy.UnknownDigestViolation:             constants.ReasonNoMetadata,
+	securitypolicy.MissingProvenanceViolation:         constants.ReasonProvenance,
+	securitypolicy.InsufficientSLSALevelViolation:     constants.ReasonProvenance,
+	securitypolicy.EmbeddedSecretViolation:            constants.ReasonEmbeddedSecret,
+	securitypolicy.MissingSignatureViolation:          constants.ReasonNoAttestation,
+	securitypolicy.ExceedsMaxImageSizeViolation:       constants.ReasonImageTooLarge,
+	securitypolicy.ExceedsMaxLayersViolation:          constants.ReasonImageTooLarge,
+	securitypolicy.RootImageViolation:                 constants.ReasonRootImage,
+	securitypolicy.DisallowedOperatingSystemViolation: constants.ReasonDisallowedOperatingSystem,
+	securitypolicy.MissingNoteKindViolation:           constants.ReasonMissingNoteKind,
+	securitypolicy.DeniedNoteKindViolation:            constants.ReasonDeniedNoteKind,
+	securitypolicy.InitContainerRegistryViolation:     constants.ReasonDisallowedRegistry,
+	securitypolicy.CrossTenantImageViolation:          constants.ReasonDisallowedRegistry,
+	securitypolicy.UnmatchedImageViolation:            constants.ReasonUnmatchedImage,
+	securitypolicy.ServiceAccountViolation:            constants.ReasonDisallowedServiceAccount,
+	securitypolicy.OPAViolation:                       constants.ReasonOPAPolicy,
+	securitypolicy.NamespaceBudgetViolation:           constants.ReasonVulnerabilityBudget,
+	securitypolicy.InconsistentProvenanceViolation:    constants.ReasonProvenance,
+	securitypolicy.MalwareViolation:                   constants.ReasonMalware,
+	securitypolicy.MissingImageLabelsViolation:        constants.ReasonMissingImageLabels,
+	securitypolicy.ExposedPortViolation:               constants.ReasonExposedPort,
+	securitypolicy.UnattestedPrivilegedViolation:      constants.ReasonNoAttestation,
+	securitypolicy.MissingAttestationViolation:        constants.ReasonNoAttestation,
+	securitypolicy.UnsignedBuildTimestampViolation:    constants.ReasonNoAttestation,
+	securitypolicy.StaleImageViolation:                constants.ReasonStaleImage,
+	securitypolicy.IncompleteScanViolation:            constants.ReasonNoMetadata,
+	securitypolicy.SourceRepositoryMismatchViolation:  constants.ReasonProvenance,
+}
+
+// violationsReason returns the reason of the admission response denying an
+// image with violations, which is that of the first violation
+func violationsReason(violations []securitypolicy.SecurityPolicyViolation) constants.Reason {
+	for _, v := range violations {
+		if v.Violation == securitypolicy.UnqualifiedImageViolation || v.Violation == securitypolicy.TagReferenceViolation {
+			return constants.ReasonUnqualifiedImage
+		}
+	}
+	if len(violations) == 0 {
+		return ""
+	}
+	return violationReasons[violations[0].Violation]
+}
+
+func noPolicyMessage(namespace string) string {
+	return fmt.Sprintf("no ImageSecurityPolicy found in namespace %s", namespace)
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExplainHandler(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-isp", Namespace: namespace},
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "LOW",
+				},
+			},
+		}}, nil
+	}
+	vulnerable := "gcr.io/kritis-project/vulnerable@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mockMetadata := func() (metadata.MetadataFetcher, error) {
+		return mockExplainClient{
+			vulnz: map[string][]metadata.Vulnerability{
+				vulnerable: {{CVE: "CVE-2018-1", Severity: "HIGH", HasFixAvailable: true}},
+			},
+		}, nil
+	}
+	var tests = []struct {
+		name     string
+		image    string
+		expected Explanation
+	}{
+		{
+			name:  "clean image",
+			image: testutil.QualifiedImage,
+			expected: Explanation{
+				Image:     testutil.QualifiedImage,
+				Namespace: "default",
+				Allowed:   true,
+				Message:   constants.SuccessMessage,
+			},
+		},
+		{
+			name:  "violating image",
+			image: vulnerable,
+			expected: Explanation{
+				Image:     vulnerable,
+				Namespace: "default",
+				Allowed:   false,
+				Message:   fmt.Sprintf("found violations in %s", vulnerable),
+				Violations: []ExplainedViolation{
+					{
+						Policy:   "my-isp",
+						Reason:   fmt.Sprintf("found CVE CVE-2018-1 in %s, which has severity HIGH exceeding max severity LOW", vulnerable),
+						CVE:      "CVE-2018-1",
+						Severity: "HIGH",
+					},
+				},
+				TotalViolations: 1,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := admissionConfig
+			defer func() {
+				admissionConfig = original
+			}()
+			attested := false
+			admissionConfig = config{
+				fetchMetadataClient:         mockMetadata,
+				fetchImageSecurityPolicies:  mockISP,
+				validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+				createAttestations: func(namespace string, image string, client metadata.MetadataFetcher) error {
+					attested = true
+					return nil
+				},
+				cache: newAllowCache(defaultCacheTTL),
+			}
+			q := url.Values{"image": {test.image}, "namespace": {"default"}}
+			req, err := http.NewRequest("GET", "/explain?"+q.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := scrapeMetrics()
+			rr := httptest.NewRecorder()
+			ExplainHandler("").ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			actual := Explanation{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, actual)
+			// Explaining must not have side effects
+			if attested {
+				t.Error("explain created attestations")
+			}
+			if admissionConfig.cache.allowed("default", test.image) {
+				t.Error("explain cached the decision")
+			}
+			if scrapeMetrics() != before {
+				t.Error("explain recorded metrics")
+			}
+		})
+	}
+}
+
+func TestExplainTruncatesViolations(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-isp", Namespace: namespace},
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: kritisv1beta1.PackageVulernerabilityRequirements{
+					MaximumSeverity: "LOW",
+				},
+			},
+		}}, nil
+	}
+	vulnz := []metadata.Vulnerability{}
+	for i := 0; i < 250; i++ {
+		vulnz = append(vulnz, metadata.Vulnerability{CVE: fmt.Sprintf("CVE-2018-%d", i), Severity: "HIGH", HasFixAvailable: true})
+	}
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig = config{
+		fetchMetadataClient: func() (metadata.MetadataFetcher, error) {
+			return mockExplainClient{vulnz: map[string][]metadata.Vulnerability{testutil.QualifiedImage: vulnz}}, nil
+		},
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		options:                     Options{MaxExplainedViolations: 100},
+	}
+	e, err := Explain("default", testutil.QualifiedImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Violations) != 100 {
+		t.Errorf("expected 100 violations to be listed, got %d", len(e.Violations))
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 250, e.TotalViolations)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, "+150 more", e.Omitted)
+}
+
+func TestExplainDoesntRescanOrCacheFailures(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-isp", Namespace: namespace},
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				DenyUnknownImages: true,
+				RescanAfter:       &metav1.Duration{Duration: time.Hour},
+			},
+		}}, nil
+	}
+	var rescanned []string
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig = config{
+		fetchMetadataClient: func() (metadata.MetadataFetcher, error) {
+			return unknownImagesClient{rescanned: &rescanned}, nil
+		},
+		fetchImageSecurityPolicies:  mockISP,
+		validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		digestResolver:              testutil.NewFakeDigestResolver(nil),
+		resolveFailures:             newNegativeCache(defaultNegativeCacheTTL),
+		options:                     Options{ResolveTags: true},
+	}
+	e, err := Explain("default", testutil.QualifiedImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Allowed {
+		t.Errorf("expected the unknown image to be denied")
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string(nil), rescanned)
+
+	unresolvable := "gcr.io/unreachable/image:tag"
+	if _, err := Explain("default", unresolvable); err == nil {
+		t.Fatalf("expected %s not to resolve", unresolvable)
+	}
+	if err := admissionConfig.resolveFailures.failed(unresolvable); err != nil {
+		t.Errorf("explain cached the resolve failure: %v", err)
+	}
+}
+
+func TestExplainHandlerMissingImage(t *testing.T) {
+	req, err := http.NewRequest("GET", "/explain?namespace=default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	ExplainHandler("").ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExplainHandlerToken(t *testing.T) {
+	var tests = []struct {
+		name   string
+		header string
+	}{
+		{"wrong token", "Bearer guess"},
+		{"missing token", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/explain?image="+url.QueryEscape(testutil.QualifiedImage), nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			rr := httptest.NewRecorder()
+			ExplainHandler("secret").ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// mockExplainClient returns the vulnerabilities of each image
+type mockExplainClient struct {
+	mockMetadataClient
+	vulnz map[string][]metadata.Vulnerability
+}
+
+func (m mockExplainClient) GetVulnerabilities(containerImage string) ([]metadata.Vulnerability, error) {
+	return m.vulnz[containerImage], nil
+}
+
+// unknownImagesClient has no metadata for any image, and records the images
+// it is asked to rescan
+type unknownImagesClient struct {
+	mockMetadataClient
+	rescanned *[]string
+}
+
+func (m unknownImagesClient) HasMetadata(containerImage string) (bool, error) {
+	return false, nil
+}
+
+func (m unknownImagesClient) RequestRescan(containerImage string) error {
+	*m.rescanned = append(*m.rescanned, containerImage)
+	return nil
+}
+
+func TestViolationsReason(t *testing.T) {
+	var tests = []struct {
+		violations []int
+		expected   constants.Reason
+	}{
+		{[]int{securitypolicy.UnqualifiedImageViolation}, constants.ReasonUnqualifiedImage},
+		{[]int{securitypolicy.TagReferenceViolation}, constants.ReasonUnqualifiedImage},
+		{[]int{securitypolicy.FixesNotAvailableViolation}, constants.ReasonVulnerabilityThreshold},
+		{[]int{securitypolicy.ExceedsMaxSeverityViolation}, constants.ReasonVulnerabilityThreshold},
+		{[]int{securitypolicy.ExceedsMaxCountViolation}, constants.ReasonVulnerabilityThreshold},
+		{[]int{securitypolicy.FilterViolation}, constants.ReasonVulnerabilityThreshold},
+		{[]int{securitypolicy.KnownExploitedViolation}, constants.ReasonKnownExploitedVulnerability},
+		{[]int{securitypolicy.DisclosureAgeViolation}, constants.ReasonUnpatchedDisclosedVulnerability},
+		{[]int{securitypolicy.UnknownImageViolation}, constants.ReasonNoMetadata},
+		{[]int{securitypolicy.UnknownDigestViolation}, constants.ReasonNoMetadata},
+		{[]int{securitypolicy.MissingProvenanceViolation}, constants.ReasonProvenance},
+		{[]int{securitypolicy.InsufficientSLSALevelViolation}, constants.ReasonProvenance},
+		{[]int{securitypolicy.EmbeddedSecretViolation}, constants.ReasonEmbeddedSecret},
+		{[]int{securitypolicy.MissingSignatureViolation}, constants.ReasonNoAttestation},
+		{[]int{securitypolicy.ExceedsMaxImageSizeViolation}, constants.ReasonImageTooLarge},
+		{[]int{securitypolicy.ExceedsMaxLayersViolation}, constants.ReasonImageTooLarge},
+		{[]int{securitypolicy.RootImageViolation}, constants.ReasonRootImage},
+		{[]int{securitypolicy.DisallowedOperatingSystemViolation}, constants.ReasonDisallowedOperatingSystem},
+		{[]int{securitypolicy.MissingNoteKindViolation}, constants.ReasonMissingNoteKind},
+		{[]int{securitypolicy.DeniedNoteKindViolation}, constants.ReasonDeniedNoteKind},
+		{[]int{securitypolicy.InitContainerRegistryViolation}, constants.ReasonDisallowedRegistry},
+		{[]int{securitypolicy.CrossTenantImageViolation}, constants.ReasonDisallowedRegistry},
+		{[]int{securitypolicy.UnmatchedImageViolation}, constants.ReasonUnmatchedImage},
+		{[]int{securitypolicy.ServiceAccountViolation}, constants.ReasonDisallowedServiceAccount},
+		{[]int{securitypolicy.OPAViolation}, constants.ReasonOPAPolicy},
+		{[]int{securitypolicy.NamespaceBudgetViolation}, constants.ReasonVulnerabilityBudget},
+		{[]int{securitypolicy.InconsistentProvenanceViolation}, constants.ReasonProvenance},
+		{[]int{securitypolicy.MalwareViolation}, constants.ReasonMalware},
+		{[]int{securitypolicy.MissingImageLabelsViolation}, constants.ReasonMissingImageLabels},
+		{[]int{securitypolicy.ExposedPortViolation}, constants.ReasonExposedPort},
+		{[]int{securitypolicy.UnattestedPrivilegedViolation}, constants.ReasonNoAttestation},
+		{[]int{securitypolicy.MissingAttestationViolation}, constants.ReasonNoAttestation},
+		{[]int{securitypolicy.UnsignedBuildTimestampViolation}, constants.ReasonNoAttestation},
+		{[]int{securitypolicy.StaleImageViolation}, constants.ReasonStaleImage},
+		{[]int{securitypolicy.IncompleteScanViolation}, constants.ReasonNoMetadata},
+		{[]int{securitypolicy.SourceRepositoryMismatchViolation}, constants.ReasonProvenance},
+		// The first violation decides, unless the image is unqualified
+		{[]int{securitypolicy.RootImageViolation, securitypolicy.ExceedsMaxSeverityViolation}, constants.ReasonRootImage},
+		{[]int{securitypolicy.RootImageViolation, securitypolicy.UnqualifiedImageViolation}, constants.ReasonUnqualifiedImage},
+	}
+	for _, test := range tests {
+		t.Run(string(test.expected), func(t *testing.T) {
+			violations := []securitypolicy.SecurityPolicyViolation{}
+			for _, v := range test.violations {
+				violations = append(violations, securitypolicy.SecurityPolicyViolation{Violation: v})
+			}
+			if reason := violationsReason(violations); reason != test.expected {
+				t.Errorf("expected reason %s, got %s", test.expected, reason)
+			}
+		})
+	}
+}
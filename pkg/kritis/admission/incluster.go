@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// splitPolicyName splits a namespace/name ImageSecurityPolicy reference
+func splitPolicyName(policy string) (string, string, error) {
+	parts := strings.Split(policy, "/")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%q must be namespace/name", policy)
+	}
+	if errs := validation.IsDNS1123Label(parts[0]); len(errs) != 0 {
+		return "", "", fmt.Errorf("namespace of %q is invalid: %v", policy, errs)
+	}
+	if errs := validation.IsDNS1123Subdomain(parts[1]); len(errs) != 0 {
+		return "", "", fmt.Errorf("name of %q is invalid: %v", policy, errs)
+	}
+	return parts[0], parts[1], nil
+}
+
+// splitInClusterImages splits images into those from the in-cluster
+// registries and the others
+func splitInClusterImages(images []string) ([]string, []string) {
+	hosts := map[string]bool{}
+	for _, host := range currentOptions().InClusterRegistries {
+		hosts[host] = true
+	}
+	var inCluster, others []string
+	for _, image := range images {
+		if ref, err := name.ParseReference(image, name.WeakValidation); err == nil && hosts[ref.Context().RegistryStr()] {
+			inCluster = append(inCluster, image)
+			continue
+		}
+		others = append(others, image)
+	}
+	return inCluster, others
+}
+
+// inClusterRegistryPolicy returns the ImageSecurityPolicy images from the
+// in-cluster registries are validated against
+func inClusterRegistryPolicy() (kritisv1beta1.ImageSecurityPolicy, error) {
+	policy := currentOptions().InClusterRegistryPolicy
+	namespace, policyName, err := splitPolicyName(policy)
+	if err != nil {
+		return kritisv1beta1.ImageSecurityPolicy{}, err
+	}
+	isps, err := imageSecurityPolicies(namespace)
+	if err != nil {
+		return kritisv1beta1.ImageSecurityPolicy{}, err
+	}
+	for _, isp := range isps {
+		if isp.Name == policyName {
+			return isp, nil
+		}
+	}
+	return kritisv1beta1.ImageSecurityPolicy{}, fmt.Errorf("in-cluster registry policy %s not found", policy)
+}
+
+// inClusterViolations validates images, which are from the in-cluster
+// registries, against the in-cluster registry policy, for the pod of rv.
+// Images which pass it are cached as admitted, unless rv is a dry run.
+func inClusterViolations(rv *review, images []string, client metadata.MetadataFetcher) ([]securitypolicy.SecurityPolicyViolation, error) {
+	pod := rv.pod
+	isp, err := inClusterRegistryPolicy()
+	if err != nil {
+		return nil, newError(ErrPolicyLoad, err)
+	}
+	clients, err := policyMetadataClients([]kritisv1beta1.ImageSecurityPolicy{isp}, client)
+	if err != nil {
+		return nil, newError(ErrMetadataUnavailable, err)
+	}
+	var violations []securitypolicy.SecurityPolicyViolation
+	for _, image := range images {
+		if util.CheckGlobalWhitelist([]string{image}) || admissionConfig.cache.allowed(pod.Namespace, image) {
+			continue
+		}
+		if len(unattestedImages(pod.Namespace, []string{image}, []kritisv1beta1.ImageSecurityPolicy{isp}, client)) == 0 {
+			continue
+		}
+		v, err := requiredAttestationViolations(pod.Namespace, []kritisv1beta1.ImageSecurityPolicy{isp}, []string{image})
+		if err != nil {
+			return nil, newError(ErrPolicyLoad, err)
+		}
+		if len(v) != 0 {
+			violations = append(violations, v...)
+			continue
+		}
+		logrus.Infof("validating in-cluster image %s against %s/%s", image, isp.Namespace, isp.Name)
+		v, err = admissionConfig.validateImageSecurityPolicy(isp, image, clients[0], securitypolicy.RequestRescans())
+		if err != nil {
+			return nil, newError(ErrMetadataUnavailable, err)
+		}
+		if len(v) == 0 && !rv.dryRun {
+			admissionConfig.cache.add(pod.Namespace, image)
+		}
+		violations = append(violations, v...)
+	}
+	return violations, nil
+}
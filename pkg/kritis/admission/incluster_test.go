@@ -0,0 +1,166 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+)
+
+const inClusterImage = "registry.kube-system.svc:5000/app@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+func Test_InClusterRegistryPolicy(t *testing.T) {
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		isp := kritisv1beta1.ImageSecurityPolicy{}
+		isp.Namespace = namespace
+		if namespace == "kritis" {
+			isp.Name = "in-cluster"
+			isp.Spec.RequireAttestation = true
+			isp.Spec.AllowedBuilders = []string{"pipeline"}
+		} else {
+			isp.Name = "default"
+		}
+		return []kritisv1beta1.ImageSecurityPolicy{isp}, nil
+	}
+	options := Options{
+		InClusterRegistries:     []string{"registry.kube-system.svc:5000"},
+		InClusterRegistryPolicy: "kritis/in-cluster",
+	}
+	var tests = []struct {
+		name     string
+		image    string
+		attested bool
+		options  Options
+		allowed  bool
+		reason   constants.Reason
+		message  string
+		// policies are the ImageSecurityPolicies attestations are verified
+		// against and the image is validated against
+		verified  []string
+		validated []string
+	}{
+		{
+			name:     "attested in-cluster image",
+			image:    inClusterImage,
+			attested: true,
+			options:  options,
+			allowed:  true,
+			message:  constants.SuccessMessage,
+			verified: []string{"in-cluster"},
+		},
+		{
+			name:     "unattested in-cluster image",
+			image:    inClusterImage,
+			options:  options,
+			reason:   constants.ReasonNoAttestation,
+			message:  string(securitypolicy.MissingAttestationViolationReason(inClusterImage)),
+			verified: []string{"in-cluster"},
+		},
+		{
+			name:      "other image",
+			image:     testutil.QualifiedImage,
+			options:   options,
+			allowed:   true,
+			message:   constants.SuccessMessage,
+			verified:  []string{"default"},
+			validated: []string{"default"},
+		},
+		{
+			name:      "in-cluster registry not configured",
+			image:     inClusterImage,
+			allowed:   true,
+			message:   constants.SuccessMessage,
+			verified:  []string{"default"},
+			validated: []string{"default"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var verified, validated []string
+			status := constants.FailureStatus
+			if test.allowed {
+				status = constants.SuccessStatus
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod: func(r *http.Request) (*v1.Pod, error) {
+						return &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Image: test.image}}}}, nil
+					},
+					fetchMetadataClient:        mockMetadata(),
+					fetchImageSecurityPolicies: mockISP,
+					verifyAttestations: func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+						for _, isp := range isps {
+							verified = append(verified, isp.Name)
+						}
+						return test.attested, nil
+					},
+					validateImageSecurityPolicy: func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+						validated = append(validated, isp.Name)
+						return nil, nil
+					},
+					options: test.options,
+				},
+				httpStatus: http.StatusOK,
+				allowed:    test.allowed,
+				status:     status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.verified, verified)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+		})
+	}
+}
+
+func Test_InClusterRegistryPolicyNotFound(t *testing.T) {
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod: func(r *http.Request) (*v1.Pod, error) {
+				return &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Image: inClusterImage}}}}, nil
+			},
+			fetchMetadataClient: mockMetadata(),
+			fetchImageSecurityPolicies: func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+			},
+			options: Options{
+				InClusterRegistries:     []string{"registry.kube-system.svc:5000"},
+				InClusterRegistryPolicy: "kritis/in-cluster",
+			},
+		},
+		httpStatus: http.StatusInternalServerError,
+	})
+}
+
+func Test_SplitInClusterImages(t *testing.T) {
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig.options = Options{InClusterRegistries: []string{"registry.kube-system.svc:5000"}}
+	other := fmt.Sprintf("registry.kube-system.svc/app@sha256:%064d", 1)
+	inCluster, others := splitInClusterImages([]string{inClusterImage, testutil.QualifiedImage, other})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{inClusterImage}, inCluster)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{testutil.QualifiedImage, other}, others)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/metadata/containeranalysis"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sharedMetadataClient creates a metadata client on first use and reuses it
+// for every admission, rather than opening a connection per admission.
+// If creating it fails, or it loses its connection, it is created again on
+// next use.
+type sharedMetadataClient struct {
+	mu     sync.Mutex
+	create func() (metadata.MetadataFetcher, error)
+	client metadata.MetadataFetcher
+}
+
+func newSharedMetadataClient(create func() (metadata.MetadataFetcher, error)) *sharedMetadataClient {
+	return &sharedMetadataClient{create: create}
+}
+
+// get returns the shared client, creating it if there is none
+func (s *sharedMetadataClient) get() (metadata.MetadataFetcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	client, err := s.create()
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+// failed drops the shared client if err means it lost its connection, so
+// that the next admission reconnects
+func (s *sharedMetadataClient) failed(err error) {
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+	}
+	if status.Code(err) != codes.Unavailable {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return
+	}
+	logrus.Warnf("metadata client is unavailable, reconnecting on next use: %v", err)
+	s.closeLocked()
+}
+
+// close closes the shared client, if it has been created
+func (s *sharedMetadataClient) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+func (s *sharedMetadataClient) closeLocked() {
+	if s.client == nil {
+		return
+	}
+	if c, ok := s.client.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			logrus.Warnf("error closing metadata client: %v", err)
+		}
+	}
+	s.client = nil
+}
+
+// DefaultMetadataBackend is the name of the metadata backend queried for
+// ImageSecurityPolicies which don't select one
+const DefaultMetadataBackend = "containeranalysis"
+
+var (
+	metadataBackendsMu sync.RWMutex
+	// metadataBackends are the metadata backends ImageSecurityPolicies can
+	// select with metadataBackend, by name
+	metadataBackends = map[string]*sharedMetadataClient{
+		DefaultMetadataBackend: metadataClients,
+	}
+	// metadataBackendEndpoints are the endpoints of the backends set by
+	// Options.MetadataBackends, by name
+	metadataBackendEndpoints = map[string]string{}
+)
+
+// For testing
+var newGrafeasClient = func(endpoint string) (metadata.MetadataFetcher, error) {
+	return containeranalysis.NewContainerAnalysisClient(option.WithEndpoint(endpoint))
+}
+
+// RegisterMetadataBackend makes the metadata backend whose client create
+// creates selectable as name by ImageSecurityPolicies, e.g. to query Clair
+// for the images of some namespaces. The client is shared by all admissions.
+//
+// Backends serving the Grafeas API are registered without code by
+// Options.MetadataBackends. Others, such as Clair, implement
+// metadata.MetadataFetcher and are registered before SetOptions is called.
+func RegisterMetadataBackend(name string, create func() (metadata.MetadataFetcher, error)) {
+	metadataBackendsMu.Lock()
+	defer metadataBackendsMu.Unlock()
+	if backend, ok := metadataBackends[name]; ok {
+		backend.close()
+	}
+	metadataBackends[name] = newSharedMetadataClient(create)
+	delete(metadataBackendEndpoints, name)
+	securitypolicy.SetMetadataBackends(metadataBackendNames())
+}
+
+// setMetadataBackends registers a backend for each name and Grafeas API
+// endpoint of backends. Backends whose endpoint changed are reconnected,
+// and those no longer set are removed.
+func setMetadataBackends(backends map[string]string) {
+	metadataBackendsMu.Lock()
+	defer metadataBackendsMu.Unlock()
+	for name, endpoint := range metadataBackendEndpoints {
+		if e, ok := backends[name]; ok && e == endpoint {
+			continue
+		}
+		metadataBackends[name].close()
+		delete(metadataBackends, name)
+		delete(metadataBackendEndpoints, name)
+	}
+	for name, endpoint := range backends {
+		if _, ok := metadataBackendEndpoints[name]; ok {
+			continue
+		}
+		endpoint := endpoint
+		metadataBackends[name] = newSharedMetadataClient(func() (metadata.MetadataFetcher, error) {
+			return newGrafeasClient(endpoint)
+		})
+		metadataBackendEndpoints[name] = endpoint
+	}
+	securitypolicy.SetMetadataBackends(metadataBackendNames())
+}
+
+// metadataBackendNames returns the names of the registered backends. The
+// caller must hold metadataBackendsMu.
+func metadataBackendNames() []string {
+	names := []string{}
+	for name := range metadataBackends {
+		names = append(names, name)
+	}
+	return names
+}
+
+// metadataBackend returns the client of the metadata backend name
+func metadataBackend(name string) (metadata.MetadataFetcher, error) {
+	metadataBackendsMu.RLock()
+	backend, ok := metadataBackends[name]
+	metadataBackendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown metadata backend %q", name)
+	}
+	return backend.get()
+}
+
+// metadataClientFailed drops the clients of every metadata backend if err
+// means one lost its connection, since err doesn't tell which one did
+func metadataClientFailed(err error) {
+	metadataBackendsMu.RLock()
+	defer metadataBackendsMu.RUnlock()
+	for _, backend := range metadataBackends {
+		backend.failed(err)
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/api/core/v1"
+)
+
+// closableClient records whether it was closed
+type closableClient struct {
+	mockMetadataClient
+	closed *bool
+}
+
+func (c closableClient) Close() error {
+	*c.closed = true
+	return nil
+}
+
+// countingConstructor returns a metadata client constructor counting its calls
+func countingConstructor() (func() (metadata.MetadataFetcher, error), *int) {
+	var mu sync.Mutex
+	calls := 0
+	return func() (metadata.MetadataFetcher, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return mockMetadataClient{}, nil
+	}, &calls
+}
+
+func TestSharedMetadataClient(t *testing.T) {
+	create, calls := countingConstructor()
+	s := newSharedMetadataClient(create)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, *calls)
+}
+
+func TestSharedMetadataClientRetriesCreation(t *testing.T) {
+	calls := 0
+	s := newSharedMetadataClient(func() (metadata.MetadataFetcher, error) {
+		calls++
+		if calls == 1 {
+			return nil, fmt.Errorf("no credentials yet")
+		}
+		return mockMetadataClient{}, nil
+	})
+	_, err := s.get()
+	testutil.CheckError(t, true, err)
+	_, err = s.get()
+	testutil.CheckError(t, false, err)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 2, calls)
+}
+
+func TestSharedMetadataClientReconnects(t *testing.T) {
+	var tests = []struct {
+		name       string
+		err        error
+		reconnects bool
+	}{
+		{
+			name:       "connection lost",
+			err:        newError(ErrMetadataUnavailable, status.Error(codes.Unavailable, "connection refused")),
+			reconnects: true,
+		},
+		{
+			name: "other error",
+			err:  newError(ErrMetadataUnavailable, status.Error(codes.NotFound, "no such project")),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			closed := false
+			s := newSharedMetadataClient(func() (metadata.MetadataFetcher, error) {
+				calls++
+				return closableClient{closed: &closed}, nil
+			})
+			if _, err := s.get(); err != nil {
+				t.Fatal(err)
+			}
+			s.failed(test.err)
+			if _, err := s.get(); err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.reconnects, closed)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.reconnects, calls == 2)
+		})
+	}
+}
+
+func Test_MetadataClientReusedAcrossAdmissions(t *testing.T) {
+	create, calls := countingConstructor()
+	shared := newSharedMetadataClient(create)
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	for i := 0; i < 10; i++ {
+		RunTest(t, testConfig{
+			mockConfig: config{
+				retrievePod:                 mockPod,
+				fetchMetadataClient:         shared.get,
+				fetchImageSecurityPolicies:  mockISP,
+				validateImageSecurityPolicy: mockValidate,
+			},
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		})
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, *calls)
+}
+
+// backendClient is the client of a named fake metadata backend
+type backendClient struct {
+	mockMetadataClient
+	name string
+}
+
+func Test_MetadataBackendPerPolicy(t *testing.T) {
+	mockPod := func(r *http.Request) (*v1.Pod, error) {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Image: testutil.QualifiedImage}},
+			},
+		}, nil
+	}
+	policy := func(name string, backend string) kritisv1beta1.ImageSecurityPolicy {
+		isp := kritisv1beta1.ImageSecurityPolicy{}
+		isp.Name = name
+		isp.Spec.MetadataBackend = backend
+		return isp
+	}
+	var tests = []struct {
+		name       string
+		isps       []kritisv1beta1.ImageSecurityPolicy
+		httpStatus int
+		// queried maps policies to the backend they were validated with
+		queried map[string]string
+	}{
+		{
+			name:       "policies routed to their backends",
+			isps:       []kritisv1beta1.ImageSecurityPolicy{policy("default", ""), policy("team", "clair")},
+			httpStatus: http.StatusOK,
+			queried:    map[string]string{"default": DefaultMetadataBackend, "team": "clair"},
+		},
+		{
+			name:       "unknown backend",
+			isps:       []kritisv1beta1.ImageSecurityPolicy{policy("default", ""), policy("team", "unknown")},
+			httpStatus: http.StatusServiceUnavailable,
+			queried:    map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queried := map[string]string{}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod: mockPod,
+					fetchMetadataClient: func() (metadata.MetadataFetcher, error) {
+						return backendClient{name: DefaultMetadataBackend}, nil
+					},
+					fetchMetadataBackend: func(name string) (metadata.MetadataFetcher, error) {
+						if name != "clair" {
+							return nil, fmt.Errorf("unknown metadata backend %q", name)
+						}
+						return backendClient{name: name}, nil
+					},
+					fetchImageSecurityPolicies: func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+						return test.isps, nil
+					},
+					validateImageSecurityPolicy: func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+						queried[isp.Name] = client.(backendClient).name
+						return nil, nil
+					},
+				},
+				httpStatus: test.httpStatus,
+				allowed:    true,
+				status:     constants.SuccessStatus,
+				message:    constants.SuccessMessage,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.queried, queried)
+		})
+	}
+}
+
+func TestRegisterMetadataBackend(t *testing.T) {
+	defer func() {
+		metadataBackendsMu.Lock()
+		delete(metadataBackends, "clair")
+		metadataBackendsMu.Unlock()
+	}()
+	create, calls := countingConstructor()
+	RegisterMetadataBackend("clair", create)
+	for i := 0; i < 3; i++ {
+		if _, err := metadataBackend("clair"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, *calls)
+	if _, err := metadataBackend("unknown"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
+
+func TestSetMetadataBackends(t *testing.T) {
+	original := newGrafeasClient
+	defer func() {
+		newGrafeasClient = original
+		setMetadataBackends(nil)
+		securitypolicy.SetMetadataBackends(nil)
+	}()
+	endpoints := []string{}
+	newGrafeasClient = func(endpoint string) (metadata.MetadataFetcher, error) {
+		endpoints = append(endpoints, endpoint)
+		return mockMetadataClient{}, nil
+	}
+
+	setMetadataBackends(map[string]string{"grafeas": "grafeas:8080"})
+	if _, err := metadataBackend("grafeas"); err != nil {
+		t.Fatal(err)
+	}
+	// Setting the same endpoint again keeps the connected client
+	setMetadataBackends(map[string]string{"grafeas": "grafeas:8080"})
+	if _, err := metadataBackend("grafeas"); err != nil {
+		t.Fatal(err)
+	}
+	// A new endpoint reconnects
+	setMetadataBackends(map[string]string{"grafeas": "grafeas:9090"})
+	if _, err := metadataBackend("grafeas"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"grafeas:8080", "grafeas:9090"}, endpoints)
+
+	setMetadataBackends(nil)
+	if _, err := metadataBackend("grafeas"); err == nil {
+		t.Error("expected an error for a removed backend")
+	}
+	metadataBackendsMu.RLock()
+	defer metadataBackendsMu.RUnlock()
+	if _, ok := metadataBackends[DefaultMetadataBackend]; !ok {
+		t.Error("default backend was removed")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// attestationRequired returns true if isp requires attestations of images
+// of pods in namespace. The namespace is only fetched if isp restricts the
+// requirement to some namespaces.
+func attestationRequired(isp kritisv1beta1.ImageSecurityPolicy, namespace string) (bool, error) {
+	if !isp.Spec.RequireAttestation {
+		return false, nil
+	}
+	if isp.Spec.RequireAttestationNamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(isp.Spec.RequireAttestationNamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("image security policy %s/%s has an invalid namespace selector: %v", isp.Namespace, isp.Name, err)
+	}
+	ns, err := admissionConfig.fetchNamespace(namespace)
+	if err != nil {
+		return false, fmt.Errorf("error getting namespace %s: %v", namespace, err)
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+func fetchNamespace(name string) (*v1.Namespace, error) {
+	client, err := inClusterClientset()
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_AttestationRequiredByNamespace(t *testing.T) {
+	namespaces := map[string]map[string]string{
+		"prod": {"env": "prod"},
+		"dev":  {"env": "dev"},
+	}
+	var tests = []struct {
+		name       string
+		namespace  string
+		governs    []string
+		attested   bool
+		httpStatus int
+		allowed    bool
+		reason     constants.Reason
+		message    string
+		validated  bool
+	}{
+		{
+			name:       "unattested image in prod",
+			namespace:  "prod",
+			httpStatus: http.StatusOK,
+			reason:     constants.ReasonNoAttestation,
+			message:    string(securitypolicy.MissingAttestationViolationReason(testutil.QualifiedImage)),
+		},
+		{
+			name:       "attested image in prod",
+			namespace:  "prod",
+			attested:   true,
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			message:    constants.SuccessMessage,
+		},
+		{
+			name:       "unattested image in prod the policy doesn't govern",
+			namespace:  "prod",
+			governs:    []string{"gcr.io/other/*"},
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			message:    constants.SuccessMessage,
+			validated:  true,
+		},
+		{
+			name:       "unattested image in dev",
+			namespace:  "dev",
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			message:    constants.SuccessMessage,
+			validated:  true,
+		},
+		{
+			name:       "unknown namespace",
+			namespace:  "missing",
+			httpStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The same policy applies to every namespace, as a default policy does
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				isp := kritisv1beta1.ImageSecurityPolicy{}
+				isp.Namespace = "policies"
+				isp.Name = "shared"
+				isp.Spec.Images = test.governs
+				isp.Spec.RequireAttestation = true
+				isp.Spec.RequireAttestationNamespaceSelector = &metav1.LabelSelector{
+					MatchLabels: map[string]string{"env": "prod"},
+				}
+				return []kritisv1beta1.ImageSecurityPolicy{isp}, nil
+			}
+			validated := false
+			status := constants.FailureStatus
+			if test.allowed {
+				status = constants.SuccessStatus
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod: func(r *http.Request) (*v1.Pod, error) {
+						return &v1.Pod{
+							ObjectMeta: metav1.ObjectMeta{Namespace: test.namespace},
+							Spec:       v1.PodSpec{Containers: []v1.Container{{Image: testutil.QualifiedImage}}},
+						}, nil
+					},
+					fetchMetadataClient:        mockMetadata(),
+					fetchImageSecurityPolicies: mockISP,
+					fetchNamespace: func(name string) (*v1.Namespace, error) {
+						labels, ok := namespaces[name]
+						if !ok {
+							return nil, fmt.Errorf("namespace %s not found", name)
+						}
+						return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}, nil
+					},
+					verifyAttestations: func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+						return test.attested, nil
+					},
+					validateImageSecurityPolicy: func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+						validated = true
+						return nil, nil
+					},
+				},
+				httpStatus: test.httpStatus,
+				allowed:    test.allowed,
+				status:     status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.validated, validated)
+		})
+	}
+}
+
+func Test_AttestationRequiredWithoutSelector(t *testing.T) {
+	isp := kritisv1beta1.ImageSecurityPolicy{}
+	isp.Spec.RequireAttestation = true
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig.fetchNamespace = func(name string) (*v1.Namespace, error) {
+		t.Errorf("namespace %s was fetched for a policy without a namespace selector", name)
+		return nil, nil
+	}
+	required, err := attestationRequired(isp, "dev")
+	testutil.CheckErrorAndDeepEqual(t, false, err, true, required)
+}
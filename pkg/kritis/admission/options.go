@@ -0,0 +1,581 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata/containeranalysis"
+	"github.com/grafeas/kritis/pkg/kritis/metrics"
+	"github.com/grafeas/kritis/pkg/kritis/pods"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/grafeas/kritis/pkg/kritis/violation"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// ConfigMapKey is the key of the ConfigMap holding Options as YAML
+	ConfigMapKey = "config.yaml"
+
+	// FailurePolicyFail denies pods which couldn't be validated
+	FailurePolicyFail = "Fail"
+	// FailurePolicyIgnore admits pods which couldn't be validated
+	FailurePolicyIgnore = "Ignore"
+
+	// NeverPullDeny denies pods with images their containers never pull
+	NeverPullDeny = "Deny"
+	// NeverPullAllow admits images pods never pull without validating them
+	NeverPullAllow = "Allow"
+	// NeverPullValidate validates images pods never pull as they are
+	// referenced if they can't be resolved, instead of failing validation
+	NeverPullValidate = "Validate"
+
+	// UnresolvedImageDeny denies pods with images which aren't literal image
+	// references, such as unsubstituted $(IMAGE) placeholders
+	UnresolvedImageDeny = "Deny"
+	// UnresolvedImageAllow admits images which aren't literal image
+	// references without validating them
+	UnresolvedImageAllow = "Allow"
+)
+
+// Options configures the behavior of AdmissionReviewHandler.
+// They are set from flags, and can be overridden by a ConfigMap.
+type Options struct {
+	// AsyncAttestation creates attestations in the background after the
+	// admission response is returned, instead of before it
+	AsyncAttestation bool `json:"asyncAttestation"`
+	// AttestationRetries is how many times creating the attestations of an
+	// image is retried after failing, before giving up on it and logging it
+	// as a dead letter. If it isn't set they are retried 3 times, and 0
+	// doesn't retry them. Attestations which fail inline are retried in the
+	// background, so retries never delay the admission response.
+	AttestationRetries *int `json:"attestationRetries"`
+	// AttestationBackoff is how long the first retry of creating
+	// attestations waits, each retry waiting twice as long as the previous.
+	// 0 waits 1s.
+	AttestationBackoff metav1.Duration `json:"attestationBackoff"`
+	// RequirePolicy denies pods in namespaces without any ImageSecurityPolicy,
+	// instead of admitting them unchecked
+	RequirePolicy bool `json:"requirePolicy"`
+	// ExemptMirrorPods admits mirror pods of static pods without validation.
+	// The kubelet runs static pods whether or not their mirror pod is
+	// admitted, so denying one only hides a running pod from the API server.
+	ExemptMirrorPods bool `json:"exemptMirrorPods"`
+	// ResolveTags validates images referenced by tag as the digest the tag
+	// currently points to, instead of denying them as not fully qualified
+	ResolveTags bool `json:"resolveTags"`
+	// DenyOtherOperations denies requests for operations other than CREATE
+	// and UPDATE, such as DELETE or CONNECT if the webhook is registered for
+	// them by mistake, instead of admitting them without validation
+	DenyOtherOperations bool `json:"denyOtherOperations"`
+	// FailurePolicy is FailurePolicyFail or FailurePolicyIgnore to deny or
+	// admit pods which couldn't be validated. If empty, an HTTP error is
+	// returned and the webhook's own failurePolicy applies.
+	FailurePolicy string `json:"failurePolicy"`
+	// NeverPullPolicy is NeverPullDeny, NeverPullAllow or NeverPullValidate
+	// to handle images of containers with imagePullPolicy Never, which must
+	// already be on the node and may not be in any registry. If empty, they
+	// are validated like any other image.
+	NeverPullPolicy string `json:"neverPullPolicy"`
+	// UnresolvedImagePolicy is UnresolvedImageDeny or UnresolvedImageAllow
+	// to handle images which aren't literal image references, such as
+	// $(IMAGE) or {{ .Values.image }} placeholders a controller failed to
+	// substitute. If empty, they are denied.
+	UnresolvedImagePolicy string `json:"unresolvedImagePolicy"`
+	// RequirePullableImages denies pods with images whose manifests can't be
+	// fetched from their registries with the pods' imagePullSecrets, such as
+	// images which don't exist, instead of admitting pods which can't start
+	RequirePullableImages bool `json:"requirePullableImages"`
+	// SuggestImageUpgrades warns, on the responses admitting pods, of images
+	// tagged with a version whose repository has a newer patch release of
+	// it, e.g. 1.2.5 for 1.2.3. It lists the tags of the pods' repositories
+	// on every admission, but never denies a pod.
+	SuggestImageUpgrades bool `json:"suggestImageUpgrades"`
+	// ExemptNamespaces are namespaces whose pods are admitted without
+	// validation. If nil, constants.DefaultExemptNamespaces are. An empty
+	// list exempts none, enforcing policies in those too.
+	ExemptNamespaces []string `json:"exemptNamespaces"`
+	// ExemptPodSelectors select pods of system workloads running vendor
+	// images, which are admitted without validation. Unlike those of
+	// ExemptNamespaces, their admissions are logged.
+	ExemptPodSelectors []ExemptPodSelector `json:"exemptPodSelectors"`
+	// ImageWhitelist are images which are always admitted, see util.SetGlobalWhitelist
+	ImageWhitelist []string `json:"imageWhitelist"`
+	// PauseImages are pod sandbox images which are never validated, see
+	// util.SetPauseImages
+	PauseImages []string `json:"pauseImages"`
+	// CacheTTL is how long an image admitted in a namespace isn't re-validated
+	CacheTTL metav1.Duration `json:"cacheTTL"`
+	// NegativeCacheTTL is how long an image which failed to resolve isn't retried
+	NegativeCacheTTL metav1.Duration `json:"negativeCacheTTL"`
+	// ResponseCacheTTL is how long the response to an admission request is
+	// reused for retries of the same request, with the same UID and content
+	ResponseCacheTTL metav1.Duration `json:"responseCacheTTL"`
+	// DisableEnforcement admits every pod, only logging the decision that
+	// would have been made. It is a kill switch for when kritis is wrongly
+	// blocking deploys, and is meant to be set in the watched ConfigMap.
+	DisableEnforcement bool `json:"disableEnforcement"`
+	// MetadataProjects maps image repository prefixes to the Container
+	// Analysis project holding metadata of the images in them, for images
+	// whose project can't be derived from their GCR or Artifact Registry path
+	MetadataProjects map[string]string `json:"metadataProjects"`
+	// MetadataBackends maps the names of metadata backends, which
+	// ImageSecurityPolicies select with metadataBackend, to the endpoint of
+	// the Grafeas API they serve, e.g. grafeas.security.svc:8080
+	MetadataBackends map[string]string `json:"metadataBackends"`
+	// KnownExploitedCVEs is the Known Exploited Vulnerabilities list denied
+	// by policies with denyKnownExploitedCVEs, as CVE IDs
+	KnownExploitedCVEs []string `json:"knownExploitedCVEs"`
+	// SeverityOverrides re-score CVEs for the cluster's environment, mapping
+	// CVE IDs to the severity, e.g. CRITICAL, or CVSS score, e.g. 9.8, which
+	// policies evaluate instead of the one the metadata backend reports
+	SeverityOverrides map[string]string `json:"severityOverrides"`
+	// DefaultPolicyNamespace holds the cluster default ImageSecurityPolicies,
+	// which apply to namespaces without any ImageSecurityPolicy of their own
+	DefaultPolicyNamespace string `json:"defaultPolicyNamespace"`
+	// MaxExplainedViolations caps the violations listed in an Explanation,
+	// the rest being summarized by their number. 0 means no cap.
+	MaxExplainedViolations int `json:"maxExplainedViolations"`
+	// PolicyBundle is the OCI reference of a policy bundle, whose
+	// ImageSecurityPolicies are used instead of those in the cluster.
+	// See securitypolicy.ParsePolicyBundle.
+	PolicyBundle string `json:"policyBundle"`
+	// PolicyBundlePublicKey is the base64 encoded, armored PGP public key
+	// the policy bundle must be signed by
+	PolicyBundlePublicKey string `json:"policyBundlePublicKey"`
+	// PolicySigningPublicKey is the base64 encoded, armored PGP public key
+	// of the policy authors. If set, ImageSecurityPolicies in the cluster
+	// must carry a valid signature by it, see
+	// securitypolicy.VerifyPolicySignature, and pods in namespaces with an
+	// unsigned or modified policy are denied rather than validated against
+	// it. Policies of a policy bundle are verified with the bundle instead.
+	PolicySigningPublicKey string `json:"policySigningPublicKey"`
+	// BuildTokenPublicKey is the base64 encoded, armored PGP public key CI
+	// signs build tokens with. See BuildToken. If empty, build tokens are
+	// ignored.
+	BuildTokenPublicKey string `json:"buildTokenPublicKey"`
+	// CaptureSize is how many of the most recent AdmissionReviews are
+	// captured, with secrets redacted, for CapturesHandler and ReplayHandler.
+	// 0 captures none.
+	CaptureSize int `json:"captureSize"`
+	// InClusterRegistries are the hosts of registries running in the cluster,
+	// such as registry.kube-system.svc:5000, whose images the metadata
+	// backend may not be able to scan. Their images are validated against
+	// InClusterRegistryPolicy instead of the pod's ImageSecurityPolicies.
+	InClusterRegistries []string `json:"inClusterRegistries"`
+	// InClusterRegistryPolicy is the namespace/name of the
+	// ImageSecurityPolicy images from InClusterRegistries are validated
+	// against, which typically has requireAttestation set
+	InClusterRegistryPolicy string `json:"inClusterRegistryPolicy"`
+	// ViolationRoutes hand violations to webhooks depending on the maximum
+	// severity of their vulnerabilities, instead of logging them
+	ViolationRoutes []ViolationRoute `json:"violationRoutes"`
+	// ViolationDedupeWindow is how long identical violations of an image
+	// are handled once, suppressing repeats in other pods. 0 suppresses none.
+	ViolationDedupeWindow metav1.Duration `json:"violationDedupeWindow"`
+	// MetricsNamespaces are the namespaces, or patterns matched against them
+	// as in path.Match, whose metrics are labeled with their namespace. If
+	// empty, the first MetricsNamespaceLimit namespaces are. The metrics of
+	// other namespaces are labeled metrics.OtherNamespaces.
+	MetricsNamespaces []string `json:"metricsNamespaces"`
+	// MetricsNamespaceLimit is how many namespaces metrics are labeled with
+	// without MetricsNamespaces, or metrics.DefaultNamespaceLimit if 0
+	MetricsNamespaceLimit int `json:"metricsNamespaceLimit"`
+	// OPAPolicy is the Rego module whose decisions policies with an
+	// opaDecision delegate to. It is evaluated in the webhook.
+	OPAPolicy string `json:"opaPolicy"`
+	// CustomResourceImages select the images embedded in kinds of custom
+	// resources, such as Argo Workflows, so they're validated like pods
+	CustomResourceImages []CustomResourceImages `json:"customResourceImages"`
+}
+
+// CustomResourceImages selects the images in custom resources of a kind with
+// JSONPath templates, such as {.spec.templates[*].container.image} for Argo
+// Workflows. Resources of the kind are validated as a pod running the images,
+// with the resource's name, namespace and annotations. The webhook must also
+// be registered for the resource.
+type CustomResourceImages struct {
+	// Group is the API group of the kind, e.g. argoproj.io
+	Group string `json:"group"`
+	// Kind is the kind of the resources, e.g. Workflow
+	Kind string `json:"kind"`
+	// Paths are JSONPath templates selecting images in the resources
+	Paths []string `json:"paths"`
+}
+
+// ExemptPodSelector selects the pods in Namespace whose labels match the
+// label Selector, such as app.kubernetes.io/component=csi-driver. Since
+// labels are set by whoever creates a pod, a selector only applies in its
+// namespace, which only trusted operators should be able to deploy to.
+type ExemptPodSelector struct {
+	Namespace string `json:"namespace"`
+	Selector  string `json:"selector"`
+}
+
+// exemptPodSelector is an ExemptPodSelector with its selector parsed
+type exemptPodSelector struct {
+	ExemptPodSelector
+	selector labels.Selector
+}
+
+// parseExemptPodSelectors parses the selectors of exempt, which must be valid
+func parseExemptPodSelectors(exempt []ExemptPodSelector) []exemptPodSelector {
+	parsed := []exemptPodSelector{}
+	for _, e := range exempt {
+		selector, err := labels.Parse(e.Selector)
+		if err != nil {
+			logrus.Errorf("invalid exempt pod selector %q: %v", e.Selector, err)
+			continue
+		}
+		parsed = append(parsed, exemptPodSelector{ExemptPodSelector: e, selector: selector})
+	}
+	return parsed
+}
+
+// ViolationRoute posts violations whose maximum vulnerability severity is at
+// least MinSeverity to Webhook, as a violation.Notification. Violations
+// reaching the MinSeverity of several routes only go to the highest one.
+type ViolationRoute struct {
+	MinSeverity string `json:"minSeverity"`
+	Webhook     string `json:"webhook"`
+}
+
+// setViolationRoutes routes violations to the webhooks of routes, which
+// must be valid
+func setViolationRoutes(routes []ViolationRoute) {
+	strategies := []violation.Route{}
+	for _, r := range routes {
+		strategies = append(strategies, violation.Route{
+			MinSeverity: r.MinSeverity,
+			Strategy:    violation.NewWebhookStrategy(r.Webhook),
+		})
+	}
+	if err := severityStrategy.SetRoutes(strategies); err != nil {
+		logrus.Errorf("error setting violation routes: %v", err)
+	}
+}
+
+var optionsMu sync.RWMutex
+
+// SetOptions configures the behavior of AdmissionReviewHandler
+func SetOptions(o Options) {
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+	admissionConfig.options = o
+	customResourceImages = o.CustomResourceImages
+	exemptPodSelectors = parseExemptPodSelectors(o.ExemptPodSelectors)
+	if o.ImageWhitelist != nil {
+		util.SetGlobalWhitelist(o.ImageWhitelist)
+	}
+	if o.PauseImages != nil {
+		util.SetPauseImages(o.PauseImages)
+	}
+	containeranalysis.SetProjects(o.MetadataProjects)
+	setMetadataBackends(o.MetadataBackends)
+	securitypolicy.SetKnownExploitedCVEs(o.KnownExploitedCVEs)
+	securitypolicy.SetSeverityOverrides(o.SeverityOverrides)
+	securitypolicy.SetOPAPolicy(o.OPAPolicy)
+	metrics.SetNamespaceLabels(o.MetricsNamespaces, o.MetricsNamespaceLimit)
+	setViolationRoutes(o.ViolationRoutes)
+	dedupeStrategy.SetWindow(o.ViolationDedupeWindow.Duration)
+	admissionConfig.captures.setSize(o.CaptureSize)
+	if o.CacheTTL.Duration > 0 {
+		admissionConfig.cache.setTTL(o.CacheTTL.Duration)
+	}
+	if o.NegativeCacheTTL.Duration > 0 {
+		admissionConfig.resolveFailures.setTTL(o.NegativeCacheTTL.Duration)
+	}
+	if o.ResponseCacheTTL.Duration > 0 {
+		admissionConfig.responses.setTTL(o.ResponseCacheTTL.Duration)
+	}
+	// Cached decisions may not hold under the new options
+	admissionConfig.cache.flush()
+	admissionConfig.responses.flush()
+}
+
+// attestationRetryPolicy returns how many times creating attestations is
+// retried, and the backoff before the first retry
+func (o Options) attestationRetryPolicy() (int, time.Duration) {
+	retries, backoff := attestationRetries, attestationInitialBackoff
+	if o.AttestationRetries != nil {
+		retries = *o.AttestationRetries
+	}
+	if o.AttestationBackoff.Duration > 0 {
+		backoff = o.AttestationBackoff.Duration
+	}
+	return retries, backoff
+}
+
+func currentOptions() Options {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	return admissionConfig.options
+}
+
+// ParseOptions parses YAML options over defaults, so that options which
+// aren't set keep their default, and validates the result.
+func ParseOptions(data string, defaults Options) (Options, error) {
+	j, err := yaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		return Options{}, err
+	}
+	// Decode over a copy of defaults, so that decoding doesn't write to
+	// slices and maps shared with them
+	base, err := json.Marshal(defaults)
+	if err != nil {
+		return Options{}, err
+	}
+	o := Options{}
+	if err := json.Unmarshal(base, &o); err != nil {
+		return Options{}, err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&o); err != nil {
+		return Options{}, err
+	}
+	if err := o.Validate(); err != nil {
+		return Options{}, err
+	}
+	return o, nil
+}
+
+// Validate returns an error if any of o is invalid
+func (o Options) Validate() error {
+	switch o.FailurePolicy {
+	case "", FailurePolicyFail, FailurePolicyIgnore:
+	default:
+		return fmt.Errorf("failurePolicy must be %q or %q, got %q", FailurePolicyFail, FailurePolicyIgnore, o.FailurePolicy)
+	}
+	switch o.NeverPullPolicy {
+	case "", NeverPullDeny, NeverPullAllow, NeverPullValidate:
+	default:
+		return fmt.Errorf("neverPullPolicy must be %q, %q or %q, got %q", NeverPullDeny, NeverPullAllow, NeverPullValidate, o.NeverPullPolicy)
+	}
+	switch o.UnresolvedImagePolicy {
+	case "", UnresolvedImageDeny, UnresolvedImageAllow:
+	default:
+		return fmt.Errorf("unresolvedImagePolicy must be %q or %q, got %q", UnresolvedImageDeny, UnresolvedImageAllow, o.UnresolvedImagePolicy)
+	}
+	if o.AttestationRetries != nil && *o.AttestationRetries < 0 {
+		return fmt.Errorf("attestationRetries must not be negative, got %d", *o.AttestationRetries)
+	}
+	for _, ns := range o.ExemptNamespaces {
+		if errs := validation.IsDNS1123Label(ns); len(errs) != 0 {
+			return fmt.Errorf("exempt namespace %q is invalid: %v", ns, errs)
+		}
+	}
+	for _, e := range o.ExemptPodSelectors {
+		if errs := validation.IsDNS1123Label(e.Namespace); len(errs) != 0 {
+			return fmt.Errorf("namespace %q of exempt pod selector %q is invalid: %v", e.Namespace, e.Selector, errs)
+		}
+		if _, err := labels.Parse(e.Selector); err != nil {
+			return fmt.Errorf("exempt pod selector %q is invalid: %v", e.Selector, err)
+		}
+	}
+	if o.DefaultPolicyNamespace != "" {
+		if errs := validation.IsDNS1123Label(o.DefaultPolicyNamespace); len(errs) != 0 {
+			return fmt.Errorf("default policy namespace %q is invalid: %v", o.DefaultPolicyNamespace, errs)
+		}
+	}
+	for _, image := range o.ImageWhitelist {
+		if _, err := util.NormalizeImage(image); err != nil {
+			return fmt.Errorf("whitelisted image %q is invalid: %v", image, err)
+		}
+	}
+	for _, image := range o.PauseImages {
+		if _, err := util.NormalizeImage(image); err != nil {
+			return fmt.Errorf("pause image %q is invalid: %v", image, err)
+		}
+	}
+	for prefix, project := range o.MetadataProjects {
+		if prefix == "" || project == "" {
+			return fmt.Errorf("metadata project mapping %q: %q must have a repository and a project", prefix, project)
+		}
+	}
+	for name, endpoint := range o.MetadataBackends {
+		if name == "" || endpoint == "" {
+			return fmt.Errorf("metadata backend %q: %q must have a name and an endpoint", name, endpoint)
+		}
+		if name == DefaultMetadataBackend {
+			return fmt.Errorf("metadata backend %q is the default backend and can't be replaced", name)
+		}
+	}
+	for _, cve := range o.KnownExploitedCVEs {
+		if cve == "" {
+			return fmt.Errorf("known exploited CVEs must not be empty")
+		}
+	}
+	for cve, severity := range o.SeverityOverrides {
+		if cve == "" {
+			return fmt.Errorf("severity override %q must have a CVE", severity)
+		}
+		if _, err := securitypolicy.ParseSeverity(severity); err != nil {
+			return fmt.Errorf("severity override of %s is invalid: %v", cve, err)
+		}
+	}
+	if o.PolicyBundle != "" {
+		if _, err := name.ParseReference(o.PolicyBundle, name.WeakValidation); err != nil {
+			return fmt.Errorf("policy bundle %q is invalid: %v", o.PolicyBundle, err)
+		}
+		if o.PolicyBundlePublicKey == "" {
+			return fmt.Errorf("policy bundle %q needs a public key to verify it with", o.PolicyBundle)
+		}
+	}
+	for _, host := range o.InClusterRegistries {
+		if _, err := name.NewRegistry(host, name.WeakValidation); err != nil || host == "" {
+			return fmt.Errorf("in-cluster registry %q is invalid", host)
+		}
+	}
+	if len(o.InClusterRegistries) != 0 || o.InClusterRegistryPolicy != "" {
+		if _, _, err := splitPolicyName(o.InClusterRegistryPolicy); err != nil {
+			return fmt.Errorf("in-cluster registry policy: %v", err)
+		}
+	}
+	for _, c := range o.CustomResourceImages {
+		if c.Kind == "" {
+			return fmt.Errorf("custom resource images of group %q must have a kind", c.Group)
+		}
+		if len(c.Paths) == 0 {
+			return fmt.Errorf("custom resource images of %s.%s must have paths", c.Kind, c.Group)
+		}
+		for _, path := range c.Paths {
+			if _, err := pods.ParseImagePath(path); err != nil {
+				return fmt.Errorf("custom resource images of %s.%s: %v", c.Kind, c.Group, err)
+			}
+		}
+	}
+	for _, r := range o.ViolationRoutes {
+		if _, err := violation.ParseSeverity(r.MinSeverity); err != nil {
+			return fmt.Errorf("violation route to %q: %v", r.Webhook, err)
+		}
+		if u, err := url.Parse(r.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("violation route webhook %q must be an http or https URL", r.Webhook)
+		}
+	}
+	if o.MetricsNamespaceLimit < 0 {
+		return fmt.Errorf("metricsNamespaceLimit must not be negative, got %d", o.MetricsNamespaceLimit)
+	}
+	if o.OPAPolicy != "" {
+		if _, err := securitypolicy.CompileOPAPolicy(o.OPAPolicy); err != nil {
+			return fmt.Errorf("opaPolicy is invalid: %v", err)
+		}
+	}
+	if o.CaptureSize < 0 || o.CaptureSize > maxCaptureSize {
+		return fmt.Errorf("captureSize must be between 0 and %d, got %d", maxCaptureSize, o.CaptureSize)
+	}
+	if o.MaxExplainedViolations < 0 {
+		return fmt.Errorf("maxExplainedViolations must not be negative, got %d", o.MaxExplainedViolations)
+	}
+	if o.ViolationDedupeWindow.Duration < 0 {
+		return fmt.Errorf("violationDedupeWindow must not be negative, got %s", o.ViolationDedupeWindow.Duration)
+	}
+	if o.CacheTTL.Duration < 0 {
+		return fmt.Errorf("cacheTTL must not be negative, got %s", o.CacheTTL.Duration)
+	}
+	if o.NegativeCacheTTL.Duration < 0 {
+		return fmt.Errorf("negativeCacheTTL must not be negative, got %s", o.NegativeCacheTTL.Duration)
+	}
+	if o.ResponseCacheTTL.Duration < 0 {
+		return fmt.Errorf("responseCacheTTL must not be negative, got %s", o.ResponseCacheTTL.Duration)
+	}
+	return nil
+}
+
+// WatchConfigMap sets the Options in the ConfigMap namespace/name whenever it
+// changes, reverting to the Options in use when it was called if the
+// ConfigMap is deleted. Invalid options are logged and ignored.
+// The watch is re-established until ctx is done.
+func WatchConfigMap(ctx context.Context, namespace string, name string) {
+	defaults := currentOptions()
+	for {
+		w, err := admissionConfig.watchConfigMap(namespace, name)
+		if err != nil {
+			logrus.Errorf("error watching config map %s/%s: %v", namespace, name, err)
+		} else {
+			applyConfigMapChanges(ctx, w, defaults)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// applyConfigMapChanges sets the Options of every ConfigMap from w.
+// It returns when w is closed or ctx is done.
+func applyConfigMapChanges(ctx context.Context, w watch.Interface, defaults Options) {
+	defer w.Stop()
+	for {
+		select {
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			cm, ok := e.Object.(*v1.ConfigMap)
+			if !ok {
+				continue
+			}
+			if e.Type == watch.Deleted {
+				logrus.Infof("config map %s/%s deleted, reverting to default options", cm.Namespace, cm.Name)
+				SetOptions(defaults)
+				continue
+			}
+			o, err := ParseOptions(cm.Data[ConfigMapKey], defaults)
+			if err != nil {
+				logrus.Errorf("ignoring invalid config map %s/%s: %v", cm.Namespace, cm.Name, err)
+				continue
+			}
+			logrus.Infof("loaded options from config map %s/%s", cm.Namespace, cm.Name)
+			SetOptions(o)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func watchConfigMap(namespace string, name string) (watch.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error building config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building clientset: %v", err)
+	}
+	return client.CoreV1().ConfigMaps(namespace).Watch(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+}
@@ -0,0 +1,358 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestParseOptions(t *testing.T) {
+	defaults := Options{
+		RequirePolicy:  true,
+		ImageWhitelist: []string{"gcr.io/kritis-project/kritis-server"},
+	}
+	var tests = []struct {
+		name      string
+		data      string
+		shouldErr bool
+		expected  Options
+	}{
+		{
+			name:     "empty keeps defaults",
+			data:     "",
+			expected: defaults,
+		},
+		{
+			name: "valid",
+			data: `
+asyncAttestation: true
+failurePolicy: Ignore
+exemptNamespaces: [kube-system, monitoring]
+cacheTTL: 1m
+`,
+			expected: Options{
+				AsyncAttestation: true,
+				RequirePolicy:    true,
+				FailurePolicy:    FailurePolicyIgnore,
+				ExemptNamespaces: []string{"kube-system", "monitoring"},
+				ImageWhitelist:   []string{"gcr.io/kritis-project/kritis-server"},
+				CacheTTL:         metav1.Duration{Duration: time.Minute},
+			},
+		},
+		{
+			name: "overrides defaults",
+			data: `
+requirePolicy: false
+imageWhitelist: []
+`,
+			expected: Options{
+				ImageWhitelist: []string{},
+			},
+		},
+		{
+			name:      "invalid failure policy",
+			data:      "failurePolicy: Maybe",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid never pull policy",
+			data:      "neverPullPolicy: Sometimes",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid namespace",
+			data:      "exemptNamespaces: [Kube_System]",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid default policy namespace",
+			data:      "defaultPolicyNamespace: Kritis_System",
+			shouldErr: true,
+		},
+		{
+			name: "no attestation retries",
+			data: "attestationRetries: 0",
+			expected: Options{
+				RequirePolicy:      true,
+				ImageWhitelist:     []string{"gcr.io/kritis-project/kritis-server"},
+				AttestationRetries: intPtr(0),
+			},
+		},
+		{
+			name:      "negative attestation retries",
+			data:      "attestationRetries: -1",
+			shouldErr: true,
+		},
+		{
+			name:      "negative max explained violations",
+			data:      "maxExplainedViolations: -1",
+			shouldErr: true,
+		},
+		{
+			name:      "policy bundle without a public key",
+			data:      "policyBundle: gcr.io/kritis-project/policies:v1",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid image",
+			data:      "imageWhitelist: ['gcr.io/UPPER/case:tag']",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid pause image",
+			data:      "pauseImages: ['registry.k8s.io/PAUSE']",
+			shouldErr: true,
+		},
+		{
+			name: "metadata projects",
+			data: `
+metadataProjects:
+  registry.example.com/team: team-project
+`,
+			expected: Options{
+				RequirePolicy:    true,
+				ImageWhitelist:   []string{"gcr.io/kritis-project/kritis-server"},
+				MetadataProjects: map[string]string{"registry.example.com/team": "team-project"},
+			},
+		},
+		{
+			name:      "metadata project missing",
+			data:      "metadataProjects: {registry.example.com/team: ''}",
+			shouldErr: true,
+		},
+		{
+			name: "metadata backends",
+			data: "metadataBackends: {grafeas: 'grafeas.security.svc:8080'}",
+			expected: Options{
+				RequirePolicy:    true,
+				ImageWhitelist:   []string{"gcr.io/kritis-project/kritis-server"},
+				MetadataBackends: map[string]string{"grafeas": "grafeas.security.svc:8080"},
+			},
+		},
+		{
+			name:      "metadata backend missing endpoint",
+			data:      "metadataBackends: {grafeas: ''}",
+			shouldErr: true,
+		},
+		{
+			name:      "metadata backend replacing the default",
+			data:      "metadataBackends: {containeranalysis: 'grafeas.security.svc:8080'}",
+			shouldErr: true,
+		},
+		{
+			name: "known exploited cves",
+			data: "knownExploitedCVEs: [CVE-2021-44228]",
+			expected: Options{
+				RequirePolicy:      true,
+				ImageWhitelist:     []string{"gcr.io/kritis-project/kritis-server"},
+				KnownExploitedCVEs: []string{"CVE-2021-44228"},
+			},
+		},
+		{
+			name: "severity overrides",
+			data: "severityOverrides: {CVE-2021-44228: CRITICAL, CVE-2022-22965: '9.8'}",
+			expected: Options{
+				RequirePolicy:     true,
+				ImageWhitelist:    []string{"gcr.io/kritis-project/kritis-server"},
+				SeverityOverrides: map[string]string{"CVE-2021-44228": "CRITICAL", "CVE-2022-22965": "9.8"},
+			},
+		},
+		{
+			name:      "invalid severity override",
+			data:      "severityOverrides: {CVE-2021-44228: SEVERE}",
+			shouldErr: true,
+		},
+		{
+			name: "violation routes",
+			data: `
+violationRoutes:
+- minSeverity: CRITICAL
+  webhook: https://pager.example.com/kritis
+`,
+			expected: Options{
+				RequirePolicy:   true,
+				ImageWhitelist:  []string{"gcr.io/kritis-project/kritis-server"},
+				ViolationRoutes: []ViolationRoute{{MinSeverity: "CRITICAL", Webhook: "https://pager.example.com/kritis"}},
+			},
+		},
+		{
+			name:      "capture size too large",
+			data:      "captureSize: 100000",
+			shouldErr: true,
+		},
+		{
+			name:      "violation route with an unknown severity",
+			data:      "violationRoutes: [{minSeverity: URGENT, webhook: 'https://pager.example.com/kritis'}]",
+			shouldErr: true,
+		},
+		{
+			name:      "violation route without a webhook",
+			data:      "violationRoutes: [{minSeverity: CRITICAL}]",
+			shouldErr: true,
+		},
+		{
+			name: "in-cluster registry",
+			data: `
+inClusterRegistries: ["registry.kube-system.svc:5000"]
+inClusterRegistryPolicy: kritis/in-cluster
+`,
+			expected: Options{
+				RequirePolicy:           true,
+				ImageWhitelist:          []string{"gcr.io/kritis-project/kritis-server"},
+				InClusterRegistries:     []string{"registry.kube-system.svc:5000"},
+				InClusterRegistryPolicy: "kritis/in-cluster",
+			},
+		},
+		{
+			name:      "in-cluster registry without a policy",
+			data:      `inClusterRegistries: ["registry.kube-system.svc:5000"]`,
+			shouldErr: true,
+		},
+		{
+			name:      "in-cluster registry policy without a namespace",
+			data:      "inClusterRegistryPolicy: in-cluster",
+			shouldErr: true,
+		},
+		{
+			name: "custom resource images",
+			data: `
+customResourceImages:
+- {group: argoproj.io, kind: Workflow, paths: ["{.spec.templates[*].container.image}"]}
+`,
+			expected: Options{
+				RequirePolicy:  true,
+				ImageWhitelist: []string{"gcr.io/kritis-project/kritis-server"},
+				CustomResourceImages: []CustomResourceImages{
+					{Group: "argoproj.io", Kind: "Workflow", Paths: []string{"{.spec.templates[*].container.image}"}},
+				},
+			},
+		},
+		{
+			name:      "custom resource images without paths",
+			data:      "customResourceImages: [{group: argoproj.io, kind: Workflow}]",
+			shouldErr: true,
+		},
+		{
+			name:      "custom resource images with an invalid path",
+			data:      "customResourceImages: [{group: argoproj.io, kind: Workflow, paths: ['{.spec.templates[*']}]",
+			shouldErr: true,
+		},
+		{
+			name:      "empty known exploited cve",
+			data:      "knownExploitedCVEs: ['']",
+			shouldErr: true,
+		},
+		{
+			name:      "negative violation dedupe window",
+			data:      "violationDedupeWindow: -1m",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid unresolved image policy",
+			data:      "unresolvedImagePolicy: Validate",
+			shouldErr: true,
+		},
+		{
+			name:      "negative metrics namespace limit",
+			data:      "metricsNamespaceLimit: -1",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid exempt pod selector",
+			data:      "exemptPodSelectors: [{namespace: kube-system, selector: \"app in (csi\"}]",
+			shouldErr: true,
+		},
+		{
+			name:      "exempt pod selector without a namespace",
+			data:      "exemptPodSelectors: [{selector: app=csi}]",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid opa policy",
+			data:      "opaPolicy: \"deny[msg] { msg := 1 }\"",
+			shouldErr: true,
+		},
+		{
+			name:      "negative ttl",
+			data:      "negativeCacheTTL: -1s",
+			shouldErr: true,
+		},
+		{
+			name:      "negative response cache ttl",
+			data:      "responseCacheTTL: -10s",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid duration",
+			data:      "cacheTTL: forever",
+			shouldErr: true,
+		},
+		{
+			name:      "unknown option",
+			data:      "requirePolicies: true",
+			shouldErr: true,
+		},
+		{
+			name:      "wrong type",
+			data:      "requirePolicy: [true]",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, err := ParseOptions(test.data, defaults)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, o)
+		})
+	}
+}
+
+func TestApplyConfigMapChanges(t *testing.T) {
+	original := admissionConfig
+	defer func() {
+		admissionConfig = original
+	}()
+	admissionConfig = config{}
+	defaults := Options{RequirePolicy: true}
+	SetOptions(defaults)
+	cm := func(data string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kritis", Name: "kritis-config"},
+			Data:       map[string]string{ConfigMapKey: data},
+		}
+	}
+	w := watch.NewFakeWithChanSize(4, false)
+	w.Add(cm("exemptNamespaces: [kube-system]"))
+	w.Modify(cm("failurePolicy: Maybe"))
+	w.Stop()
+	applyConfigMapChanges(context.Background(), w, defaults)
+	// The invalid change is ignored
+	expected := Options{RequirePolicy: true, ExemptNamespaces: []string{"kube-system"}}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, currentOptions())
+
+	w = watch.NewFakeWithChanSize(1, false)
+	w.Delete(cm(""))
+	w.Stop()
+	applyConfigMapChanges(context.Background(), w, defaults)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, defaults, currentOptions())
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+
+	"github.com/grafeas/kritis/pkg/kritis/pods"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// unpullableImage returns the first of images of pod which can't be pulled
+// with creds, the credentials of the pod's imagePullSecrets, and why, or ""
+// if all of them can. Images the pod never pulls aren't checked. Only images
+// the registry doesn't have are unpullable; other errors, such as the
+// registry being unreachable, are returned with "".
+func unpullableImage(pod *v1.Pod, images []string, creds map[string]util.RegistryCredentials) (string, error) {
+	for _, image := range images {
+		if pods.NeverPulled(*pod, image) {
+			continue
+		}
+		if err := admissionConfig.checkPullable(image, creds); err != nil {
+			if !util.IsImageNotFound(err) {
+				return "", fmt.Errorf("error checking %s can be pulled: %v", image, err)
+			}
+			return image, err
+		}
+	}
+	return "", nil
+}
+
+// pullCredentials returns the registry credentials of the imagePullSecrets
+// of pod, by registry host. Those of the pod's service account are already
+// in its spec, added by the ServiceAccount admission plugin.
+func pullCredentials(pod *v1.Pod) (map[string]util.RegistryCredentials, error) {
+	creds := map[string]util.RegistryCredentials{}
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		secret, err := admissionConfig.fetchSecret(pod.Namespace, ref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting image pull secret %s/%s: %v", pod.Namespace, ref.Name, err)
+		}
+		data, ok := secret.Data[v1.DockerConfigJsonKey]
+		if !ok {
+			data, ok = secret.Data[v1.DockerConfigKey]
+		}
+		if !ok {
+			continue
+		}
+		c, err := util.ParseDockerConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("image pull secret %s/%s is invalid: %v", pod.Namespace, ref.Name, err)
+		}
+		for host, cred := range c {
+			if _, ok := creds[host]; !ok {
+				creds[host] = cred
+			}
+		}
+	}
+	return creds, nil
+}
+
+// unpullableMessage returns the message of pods denied because image can't
+// be pulled
+func unpullableMessage(image string, err error) string {
+	return fmt.Sprintf("%s can't be pulled, check it exists and the pod's imagePullSecrets grant access to it: %v", image, err)
+}
+
+func fetchSecret(namespace string, name string) (*v1.Secret, error) {
+	client, err := inClusterClientset()
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+}
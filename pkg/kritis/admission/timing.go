@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Phases of an admission review, in the order they run
+const (
+	phaseDecode         = "decode"
+	phaseBreakglass     = "breakglass"
+	phaseExemptions     = "exemptions"
+	phaseResolve        = "resolve"
+	phaseWhitelist      = "whitelist"
+	phasePolicies       = "policies"
+	phaseMetadataClient = "metadata_client"
+	phaseAttestations   = "attestations"
+	phaseValidate       = "validate"
+	phaseAttest         = "attest"
+)
+
+// phaseTimer measures how long each phase of an admission review takes.
+// Each observation is attributed the time since the previous one.
+// A nil *phaseTimer is valid and measures nothing.
+type phaseTimer struct {
+	now          func() time.Time
+	start        time.Time
+	last         time.Time
+	durations    map[string]time.Duration
+	slowestImage string
+	slowest      time.Duration
+}
+
+func newPhaseTimer() *phaseTimer {
+	t := &phaseTimer{
+		now:       time.Now,
+		durations: map[string]time.Duration{},
+	}
+	t.start = t.now()
+	t.last = t.start
+	return t
+}
+
+// observe attributes the time since the last observation to phase
+func (t *phaseTimer) observe(phase string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	now := t.now()
+	d := now.Sub(t.last)
+	t.durations[phase] += d
+	t.last = now
+	return d
+}
+
+// observeImage attributes the time since the last observation to
+// validating image
+func (t *phaseTimer) observeImage(image string) {
+	if t == nil {
+		return
+	}
+	if d := t.observe(phaseValidate); d >= t.slowest {
+		t.slowest = d
+		t.slowestImage = image
+	}
+}
+
+// fields returns the duration of each observed phase and of the whole review
+// as log fields
+func (t *phaseTimer) fields() logrus.Fields {
+	if t == nil {
+		return logrus.Fields{}
+	}
+	fields := logrus.Fields{
+		"duration_total": t.now().Sub(t.start),
+	}
+	for phase, d := range t.durations {
+		fields["duration_"+phase] = d
+	}
+	if t.slowestImage != "" {
+		fields["slowest_image"] = t.slowestImage
+		fields["duration_slowest_image"] = t.slowest
+	}
+	return fields
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+// captureHook records log entries
+type captureHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *captureHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *captureHook) Fire(e *logrus.Entry) error {
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func Test_TimingFields(t *testing.T) {
+	hook := &captureHook{}
+	original := logrus.StandardLogger().Hooks
+	logrus.StandardLogger().Hooks = logrus.LevelHooks{}
+	logrus.AddHook(hook)
+	defer func() {
+		logrus.StandardLogger().Hooks = original
+	}()
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	RunTest(t, testConfig{
+		mockConfig: config{
+			retrievePod:                 mockValidPod(),
+			fetchMetadataClient:         func() (metadata.MetadataFetcher, error) { return mockMetadataClient{}, nil },
+			fetchImageSecurityPolicies:  mockISP,
+			validateImageSecurityPolicy: securitypolicy.ValidateImageSecurityPolicy,
+		},
+		httpStatus: http.StatusOK,
+		allowed:    true,
+		status:     constants.SuccessStatus,
+		message:    constants.SuccessMessage,
+	})
+	var fields logrus.Fields
+	for _, e := range hook.entries {
+		if e.Message == "finished admission review" {
+			fields = e.Data
+		}
+	}
+	if fields == nil {
+		t.Fatal("no timings were logged")
+	}
+	for _, phase := range []string{phaseDecode, phaseBreakglass, phaseExemptions, phaseResolve, phaseWhitelist, phasePolicies, phaseMetadataClient, phaseAttestations, phaseValidate, phaseAttest, "total", "slowest_image"} {
+		if _, ok := fields["duration_"+phase]; !ok {
+			t.Errorf("missing duration of %s in %v", phase, fields)
+		}
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, testutil.QualifiedImage, fields["slowest_image"])
+}
+
+func Test_PhaseTimer(t *testing.T) {
+	now := time.Now()
+	timer := newPhaseTimer()
+	timer.now = func() time.Time { return now }
+	timer.start = now
+	timer.last = now
+	now = now.Add(time.Second)
+	timer.observe(phasePolicies)
+	now = now.Add(2 * time.Second)
+	timer.observeImage("a")
+	now = now.Add(time.Second)
+	timer.observeImage("b")
+	expected := logrus.Fields{
+		"duration_total":         4 * time.Second,
+		"duration_policies":      time.Second,
+		"duration_validate":      3 * time.Second,
+		"slowest_image":          "a",
+		"duration_slowest_image": 2 * time.Second,
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, timer.fields())
+	// A nil timer measures nothing
+	var nilTimer *phaseTimer
+	nilTimer.observe(phasePolicies)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, logrus.Fields{}, nilTimer.fields())
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsVersions are the TLS versions a minimum can be set to
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSOptions configures the TLS of the admission webhook server
+type TLSOptions struct {
+	// ClientCAFile is the file with the PEM encoded CAs which callers' client
+	// certificates must be signed by, e.g. the CA of the certificate the
+	// API server is configured to authenticate to webhooks with. If empty,
+	// any caller is served.
+	ClientCAFile string
+	// MinVersion is the minimum TLS version, e.g. 1.2. If empty, the crypto/tls
+	// default applies.
+	MinVersion string
+	// CipherSuites are the names of the TLS 1.0 to 1.2 cipher suites which
+	// may be negotiated, as in crypto/tls, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. If empty, the crypto/tls
+	// defaults apply. TLS 1.3 cipher suites aren't configurable.
+	CipherSuites []string
+}
+
+// TLSConfig returns the TLS configuration of the admission webhook server
+func TLSConfig(o TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{ClientAuth: tls.NoClientCert}
+	if o.MinVersion != "" {
+		version, ok := tlsVersions[o.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown minimum TLS version %q, must be 1.0, 1.1, 1.2 or 1.3", o.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	for _, name := range o.CipherSuites {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	if o.ClientCAFile == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(o.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in client CA file %s", o.ClientCAFile)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = pool
+	return config, nil
+}
+
+// cipherSuite returns the ID of the cipher suite called name. Cipher suites
+// with known security issues aren't allowed.
+func cipherSuite(name string) (uint16, error) {
+	for _, c := range tls.CipherSuites() {
+		if c.Name == name {
+			return c.ID, nil
+		}
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		if c.Name == name {
+			return 0, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %s", name)
+}
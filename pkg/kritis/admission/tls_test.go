@@ -0,0 +1,244 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+// testCA is a certificate authority issuing client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// clientCert issues a client certificate for name
+func (ca *testCA) clientCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSConfigVerifiesClientCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kritis-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trusted := newTestCA(t, "trusted")
+	untrusted := newTestCA(t, "untrusted")
+	caFile := filepath.Join(dir, "client-ca")
+	if err := ioutil.WriteFile(caFile, trusted.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := TLSConfig(TLSOptions{ClientCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = config
+	// Rejected handshakes are expected
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	var tests = []struct {
+		name    string
+		certs   []tls.Certificate
+		allowed bool
+	}{
+		{
+			name:    "signed by the client CA",
+			certs:   []tls.Certificate{trusted.clientCert(t, "kube-apiserver")},
+			allowed: true,
+		},
+		{
+			name:  "signed by another CA",
+			certs: []tls.Certificate{untrusted.clientCert(t, "kube-apiserver")},
+		},
+		{
+			name: "no client certificate",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := server.Client()
+			transport := client.Transport.(*http.Transport)
+			transport.TLSClientConfig.Certificates = test.certs
+			defer transport.CloseIdleConnections()
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			testutil.CheckError(t, !test.allowed, err)
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	config, err := TLSConfig(TLSOptions{})
+	testutil.CheckErrorAndDeepEqual(t, false, err, tls.NoClientCert, config.ClientAuth)
+
+	dir, err := ioutil.TempDir("", "kritis-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid")
+	if err := ioutil.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = TLSConfig(TLSOptions{ClientCAFile: invalid})
+	testutil.CheckError(t, true, err)
+	_, err = TLSConfig(TLSOptions{ClientCAFile: filepath.Join(dir, "missing")})
+	testutil.CheckError(t, true, err)
+	_, err = TLSConfig(TLSOptions{MinVersion: "1.4"})
+	testutil.CheckError(t, true, err)
+	_, err = TLSConfig(TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}})
+	testutil.CheckError(t, true, err)
+	_, err = TLSConfig(TLSOptions{CipherSuites: []string{"TLS_NOT_A_CIPHER"}})
+	testutil.CheckError(t, true, err)
+}
+
+func TestTLSConfigRestrictsProtocol(t *testing.T) {
+	var tests = []struct {
+		name    string
+		server  TLSOptions
+		client  *tls.Config
+		allowed bool
+	}{
+		{
+			name:    "TLS 1.0 client with a minimum of TLS 1.2",
+			server:  TLSOptions{MinVersion: "1.2"},
+			client:  &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10},
+			allowed: false,
+		},
+		{
+			name:    "TLS 1.2 client with a minimum of TLS 1.2",
+			server:  TLSOptions{MinVersion: "1.2"},
+			client:  &tls.Config{MaxVersion: tls.VersionTLS12},
+			allowed: true,
+		},
+		{
+			name:    "TLS 1.2 client with a minimum of TLS 1.3",
+			server:  TLSOptions{MinVersion: "1.3"},
+			client:  &tls.Config{MaxVersion: tls.VersionTLS12},
+			allowed: false,
+		},
+		{
+			name:    "client offering an allowed cipher suite",
+			server:  TLSOptions{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			client:  &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}},
+			allowed: true,
+		},
+		{
+			name:    "client offering only disallowed cipher suites",
+			server:  TLSOptions{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			client:  &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}},
+			allowed: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := TLSConfig(test.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			server.TLS = config
+			// Rejected handshakes are expected
+			server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			server.StartTLS()
+			defer server.Close()
+
+			client := server.Client()
+			transport := client.Transport.(*http.Transport)
+			test.client.RootCAs = transport.TLSClientConfig.RootCAs
+			transport.TLSClientConfig = test.client
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			testutil.CheckError(t, !test.allowed, err)
+		})
+	}
+}
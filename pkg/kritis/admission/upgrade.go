@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"fmt"
+
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// upgradeWarnings returns a warning for every one of images, as pod
+// references them, with a newer patch release in its repository, if
+// SuggestImageUpgrades is set. Tags are listed with creds, or the
+// credentials of the pod's imagePullSecrets if creds is nil. Tags which
+// can't be listed are only logged, as the warnings never deny a pod.
+func upgradeWarnings(pod *v1.Pod, images []string, creds map[string]util.RegistryCredentials) []string {
+	if !currentOptions().SuggestImageUpgrades || admissionConfig.newerPatchTag == nil {
+		return nil
+	}
+	if creds == nil {
+		var err error
+		if creds, err = pullCredentials(pod); err != nil {
+			logrus.Debugf("error getting the image pull secrets of the pod, listing tags anonymously: %v", err)
+		}
+	}
+	var warnings []string
+	for _, image := range images {
+		tag, err := admissionConfig.newerPatchTag(image, creds)
+		if err != nil {
+			logrus.Debugf("error listing the tags of %s: %v", image, err)
+			continue
+		}
+		if tag != "" {
+			warnings = append(warnings, upgradeWarning(image, tag))
+		}
+	}
+	return warnings
+}
+
+func upgradeWarning(image string, tag string) string {
+	return fmt.Sprintf("%s has a newer patch release %s, consider upgrading to it", image, tag)
+}
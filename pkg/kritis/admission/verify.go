@@ -0,0 +1,198 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	"github.com/grafeas/kritis/pkg/kritis/crd/authority"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+)
+
+// verifyAttestations returns true if image has a valid attestation by any
+// AttestationAuthority in namespace, from a builder allowed by isps, in which
+// case it was already admitted and its vulnerabilities don't need to be
+// checked again.
+func verifyAttestations(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+	auths, err := authority.Authorities(namespace)
+	if err != nil {
+		return false, err
+	}
+	if len(auths) == 0 {
+		return false, nil
+	}
+	atts, err := client.GetAttestations(image)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range auths {
+		for _, att := range atts {
+			sig, err := verifyAttestation(a, image, att)
+			if err == nil {
+				err = checkBuilder(isps, sig)
+			}
+			if err != nil {
+				logrus.Warnf("rejecting attestation of %s by %s: %v", image, a.Name, err)
+				continue
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// latestBuildTime returns the latest build timestamp signed by a valid
+// attestation of image by any AttestationAuthority in namespace, from a
+// builder allowed by isps, or the zero time if none signs one
+func latestBuildTime(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (time.Time, error) {
+	var latest time.Time
+	auths, err := authority.Authorities(namespace)
+	if err != nil || len(auths) == 0 {
+		return latest, err
+	}
+	atts, err := client.GetAttestations(image)
+	if err != nil {
+		return latest, err
+	}
+	for _, a := range auths {
+		for _, att := range atts {
+			sig, err := verifyAttestation(a, image, att)
+			if err == nil {
+				err = checkBuilder(isps, sig)
+			}
+			var built time.Time
+			if err == nil {
+				built, err = signedBuildTime(sig)
+			}
+			if err != nil {
+				logrus.Debugf("not using attestation of %s by %s for its build timestamp: %v", image, a.Name, err)
+				continue
+			}
+			if built.After(latest) {
+				latest = built
+			}
+		}
+	}
+	return latest, nil
+}
+
+// maxBuildTimeSkew is how far in the future a signed build timestamp may be,
+// to allow for clock skew between the builder and the webhook
+const maxBuildTimeSkew = 5 * time.Minute
+
+// signedBuildTime returns the build timestamp sig signs. Timestamps in the
+// future are invalid, as they would never be older than a maximum age.
+func signedBuildTime(sig *util.AtomicContainerSig) (time.Time, error) {
+	timestamp, ok := sig.Optional[util.BuildTimestampKey]
+	if !ok {
+		return time.Time{}, fmt.Errorf("attestation has no build timestamp")
+	}
+	built, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("attestation has an invalid build timestamp: %v", err)
+	}
+	if built.After(time.Now().Add(maxBuildTimeSkew)) {
+		return time.Time{}, fmt.Errorf("attestation has a build timestamp in the future, %s", timestamp)
+	}
+	return built, nil
+}
+
+// verifyAttestation checks att is signed by a, and that it attests the exact
+// digest the pod pulls, and returns the signed payload. Without binding the
+// attestation to the digest, an attestation of one digest could admit another
+// pushed under the same tag. The repository isn't checked, so an attestation
+// still holds after the image is copied to another repository, e.g. when
+// promoting it from staging to production.
+func verifyAttestation(a kritisv1beta1.AttestationAuthority, image string, att metadata.PGPAttestation) (*util.AtomicContainerSig, error) {
+	publicKey, err := trustedKey(a, att.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	message, err := attestation.GetPlainMessage(publicKey, att.Signature)
+	if err != nil {
+		return nil, err
+	}
+	sig := util.AtomicContainerSig{}
+	if err := json.Unmarshal(message, &sig); err != nil {
+		return nil, fmt.Errorf("attestation is not an atomic container signature: %v", err)
+	}
+	if sig.Critical == nil || sig.Critical.Image == nil || sig.Critical.Identity == nil {
+		return nil, fmt.Errorf("attestation is missing critical fields")
+	}
+	expected, err := util.NewCritical(image)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Critical.Image.DockerDigest != expected.Image.DockerDigest {
+		return nil, fmt.Errorf("attestation is for digest %s, but the pod pulls %s", sig.Critical.Image.DockerDigest, expected.Image.DockerDigest)
+	}
+	if sig.Critical.Identity.DockerRef != expected.Identity.DockerRef {
+		logrus.Debugf("accepting attestation of %s for %s, which has the same digest", sig.Critical.Identity.DockerRef, expected.Identity.DockerRef)
+	}
+	return &sig, nil
+}
+
+// trustedKey returns the public key of a with keyID, or an error if a doesn't
+// trust that key. Keys which can't be parsed are skipped, so that one bad key
+// doesn't reject attestations by the others.
+func trustedKey(a kritisv1beta1.AttestationAuthority, keyID string) (string, error) {
+	for _, publicKey := range authority.PublicKeys(a) {
+		key, err := attestation.NewPgpKey("", publicKey)
+		if err != nil {
+			logrus.Warnf("skipping invalid public key of %s: %v", a.Name, err)
+			continue
+		}
+		if key.PublicKey() == nil {
+			continue
+		}
+		if key.PublicKey().KeyIdString() == keyID {
+			return publicKey, nil
+		}
+	}
+	return "", fmt.Errorf("attestation is signed by key %s, which %s doesn't trust", keyID, a.Name)
+}
+
+// checkBuilder returns an error unless sig is from a builder which every
+// ImageSecurityPolicy with AllowedBuilders allows
+func checkBuilder(isps []kritisv1beta1.ImageSecurityPolicy, sig *util.AtomicContainerSig) error {
+	builder := sig.Optional[util.BuilderKey]
+	for _, isp := range isps {
+		allowed := isp.Spec.AllowedBuilders
+		if len(allowed) == 0 {
+			continue
+		}
+		if !builderInList(builder, allowed) {
+			return fmt.Errorf("attestation is from builder %q, which %s doesn't allow", builder, isp.Name)
+		}
+	}
+	return nil
+}
+
+func builderInList(builder string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == builder {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,422 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
+	kritisv1beta1 "github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	attestedImage = "gcr.io/kritis-project/app@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	promotedImage = "gcr.io/kritis-project/prod/app@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	racedImage    = "gcr.io/kritis-project/app@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func TestVerifyAttestation(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	auth := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{PublicKeyData: publicKey},
+	}
+	otherPublicKey, _ := createBase64KeyPair(t)
+	other := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{PublicKeyData: otherPublicKey},
+	}
+	newPublicKey, _ := createBase64KeyPair(t)
+	rotated := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{
+			PublicKeyData: newPublicKey,
+			PublicKeys:    []string{otherPublicKey, publicKey},
+		},
+	}
+	badKeyFirst := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{
+			PublicKeyData: base64.StdEncoding.EncodeToString([]byte("not a key")),
+			PublicKeys:    []string{publicKey},
+		},
+	}
+	revoked := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{
+			PublicKeyData: newPublicKey,
+			PublicKeys:    []string{otherPublicKey},
+		},
+	}
+	att := attest(t, publicKey, privateKey, attestedImage, nil)
+	var tests = []struct {
+		name      string
+		authority kritisv1beta1.AttestationAuthority
+		image     string
+		shouldErr bool
+	}{
+		{
+			name:      "attestation for the pulled digest",
+			authority: auth,
+			image:     attestedImage,
+		},
+		{
+			name:      "attestation for the digest in another repository",
+			authority: auth,
+			image:     promotedImage,
+		},
+		{
+			name:      "attestation for a different digest",
+			authority: auth,
+			image:     racedImage,
+			shouldErr: true,
+		},
+		{
+			name:      "attestation by another authority",
+			authority: other,
+			image:     attestedImage,
+			shouldErr: true,
+		},
+		{
+			name:      "attestation by a key rotated out but still trusted",
+			authority: rotated,
+			image:     attestedImage,
+		},
+		{
+			name:      "attestation by a key trusted after an invalid key",
+			authority: badKeyFirst,
+			image:     attestedImage,
+		},
+		{
+			name:      "attestation by a revoked key",
+			authority: revoked,
+			image:     attestedImage,
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := verifyAttestation(test.authority, test.image, att)
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
+
+func TestVerifyAttestationByExpiredKey(t *testing.T) {
+	// The key expired an hour ago, before the attestation is signed
+	publicKey, privateKey := createExpiredBase64KeyPair(t, time.Now().Add(-2*time.Hour), time.Hour)
+	auth := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{PublicKeyData: publicKey},
+	}
+	_, err := verifyAttestation(auth, attestedImage, attest(t, publicKey, privateKey, attestedImage, nil))
+	testutil.CheckError(t, true, err)
+}
+
+func TestCheckBuilder(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	auth := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{PublicKeyData: publicKey},
+	}
+	isps := []kritisv1beta1.ImageSecurityPolicy{
+		{},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ci-only"},
+			Spec: kritisv1beta1.ImageSecurityPolicySpec{
+				AllowedBuilders: []string{"ci@my-project.iam.gserviceaccount.com"},
+			},
+		},
+	}
+	var tests = []struct {
+		name      string
+		builder   map[string]string
+		isps      []kritisv1beta1.ImageSecurityPolicy
+		shouldErr bool
+	}{
+		{
+			name:    "allowed builder",
+			builder: map[string]string{util.BuilderKey: "ci@my-project.iam.gserviceaccount.com"},
+			isps:    isps,
+		},
+		{
+			name:      "disallowed builder",
+			builder:   map[string]string{util.BuilderKey: "laptop@my-project.iam.gserviceaccount.com"},
+			isps:      isps,
+			shouldErr: true,
+		},
+		{
+			name:      "no builder claim",
+			isps:      isps,
+			shouldErr: true,
+		},
+		{
+			name: "no builder required",
+			isps: isps[:1],
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sig, err := verifyAttestation(auth, attestedImage, attest(t, publicKey, privateKey, attestedImage, test.builder))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckError(t, test.shouldErr, checkBuilder(test.isps, sig))
+		})
+	}
+}
+
+func Test_AttestedImageSkipsValidation(t *testing.T) {
+	validated := []string{}
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		validated = append(validated, image)
+		return nil, nil
+	}
+	mockVerify := func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (bool, error) {
+		return image == attestedImage, nil
+	}
+	mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+		return []kritisv1beta1.ImageSecurityPolicy{{}}, nil
+	}
+	for _, image := range []string{attestedImage, racedImage} {
+		podImage := image
+		RunTest(t, testConfig{
+			mockConfig: config{
+				retrievePod: func(r *http.Request) (*v1.Pod, error) {
+					return &v1.Pod{
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{Image: podImage}},
+						},
+					}, nil
+				},
+				fetchMetadataClient:         mockMetadata(),
+				fetchImageSecurityPolicies:  mockISP,
+				validateImageSecurityPolicy: mockValidate,
+				verifyAttestations:          mockVerify,
+			},
+			httpStatus: http.StatusOK,
+			allowed:    true,
+			status:     constants.SuccessStatus,
+			message:    constants.SuccessMessage,
+		})
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{racedImage}, validated)
+}
+
+func TestSignedBuildTime(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	auth := kritisv1beta1.AttestationAuthority{
+		Spec: kritisv1beta1.AttestationAuthoritySpec{PublicKeyData: publicKey},
+	}
+	built := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name      string
+		optional  map[string]string
+		expected  time.Time
+		shouldErr bool
+	}{
+		{
+			name:     "signed build timestamp",
+			optional: map[string]string{util.BuildTimestampKey: "2018-06-01T12:00:00Z"},
+			expected: built,
+		},
+		{
+			name:     "signed build timestamp with an offset",
+			optional: map[string]string{util.BuildTimestampKey: "2018-06-01T14:00:00+02:00"},
+			expected: built,
+		},
+		{
+			name:      "no build timestamp",
+			optional:  map[string]string{util.BuilderKey: "ci@my-project.iam.gserviceaccount.com"},
+			shouldErr: true,
+		},
+		{
+			name:      "invalid build timestamp",
+			optional:  map[string]string{util.BuildTimestampKey: "yesterday"},
+			shouldErr: true,
+		},
+		{
+			name:      "build timestamp in the future",
+			optional:  map[string]string{util.BuildTimestampKey: time.Now().Add(24 * time.Hour).Format(time.RFC3339)},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sig, err := verifyAttestation(auth, attestedImage, attest(t, publicKey, privateKey, attestedImage, test.optional))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := signedBuildTime(sig)
+			testutil.CheckError(t, test.shouldErr, err)
+			if !actual.Equal(test.expected) {
+				t.Errorf("expected build time %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_MaxImageAge(t *testing.T) {
+	mockValidate := func(isp kritisv1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...securitypolicy.ValidateOption) ([]securitypolicy.SecurityPolicyViolation, error) {
+		return nil, nil
+	}
+	recent := time.Now().Add(-24 * time.Hour)
+	stale := time.Now().Add(-10 * 24 * time.Hour)
+	var tests = []struct {
+		name       string
+		governs    []string
+		built      time.Time
+		err        error
+		httpStatus int
+		allowed    bool
+		status     constants.Status
+		reason     constants.Reason
+		message    string
+	}{
+		{
+			name:    "recently built",
+			built:   recent,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:    "built before the smallest maximum age",
+			built:   stale,
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonStaleImage,
+			message: string(securitypolicy.StaleImageViolationReason(attestedImage, stale, 7*24*time.Hour)),
+		},
+		{
+			name:    "built before the maximum age of a policy not governing it",
+			governs: []string{"gcr.io/other/*"},
+			built:   stale,
+			allowed: true,
+			status:  constants.SuccessStatus,
+			message: constants.SuccessMessage,
+		},
+		{
+			name:    "no signed build timestamp",
+			status:  constants.FailureStatus,
+			reason:  constants.ReasonNoAttestation,
+			message: string(securitypolicy.UnsignedBuildTimestampViolationReason(attestedImage)),
+		},
+		{
+			name:       "build timestamp can't be fetched",
+			err:        fmt.Errorf("deadline exceeded"),
+			httpStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockISP := func(namespace string) ([]kritisv1beta1.ImageSecurityPolicy, error) {
+				return []kritisv1beta1.ImageSecurityPolicy{
+					{Spec: kritisv1beta1.ImageSecurityPolicySpec{Images: test.governs, MaxImageAge: &metav1.Duration{Duration: 7 * 24 * time.Hour}}},
+					{Spec: kritisv1beta1.ImageSecurityPolicySpec{MaxImageAge: &metav1.Duration{Duration: 30 * 24 * time.Hour}}},
+				}, nil
+			}
+			mockBuildTime := func(namespace string, image string, isps []kritisv1beta1.ImageSecurityPolicy, client metadata.MetadataFetcher) (time.Time, error) {
+				return test.built, test.err
+			}
+			if test.httpStatus == 0 {
+				test.httpStatus = http.StatusOK
+			}
+			RunTest(t, testConfig{
+				mockConfig: config{
+					retrievePod: func(r *http.Request) (*v1.Pod, error) {
+						return &v1.Pod{
+							Spec: v1.PodSpec{
+								Containers: []v1.Container{{Image: attestedImage}},
+							},
+						}, nil
+					},
+					fetchMetadataClient:         mockMetadata(),
+					fetchImageSecurityPolicies:  mockISP,
+					validateImageSecurityPolicy: mockValidate,
+					fetchBuildTime:              mockBuildTime,
+				},
+				httpStatus: test.httpStatus,
+				allowed:    test.allowed,
+				status:     test.status,
+				reason:     test.reason,
+				message:    test.message,
+			})
+		})
+	}
+}
+
+// attest returns an attestation of image signed with the given keys
+func attest(t *testing.T, publicKey string, privateKey string, image string, optional map[string]string) metadata.PGPAttestation {
+	sig, err := util.NewAtomicContainerSig(image, optional)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := sig.Json()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := attestation.CreateMessageAttestation(publicKey, privateKey, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := attestation.NewPgpKey("", publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.PGPAttestation{Signature: signature, KeyID: key.PublicKey().KeyIdString()}
+}
+
+func createBase64KeyPair(t *testing.T) (string, string) {
+	key, err := openpgp.NewEntity("kritis", "test", "kritis@grafeas.com", nil)
+	testutil.CheckError(t, false, err)
+	return getBase64EncodedKey(key, openpgp.PublicKeyType, t), getBase64EncodedKey(key, openpgp.PrivateKeyType, t)
+}
+
+// createExpiredBase64KeyPair returns a key pair created at created, which
+// expires after lifetime
+func createExpiredBase64KeyPair(t *testing.T, created time.Time, lifetime time.Duration) (string, string) {
+	config := &packet.Config{Time: func() time.Time { return created }}
+	key, err := openpgp.NewEntity("kritis", "test", "kritis@grafeas.com", config)
+	testutil.CheckError(t, false, err)
+	secs := uint32(lifetime.Seconds())
+	for _, ident := range key.Identities {
+		ident.SelfSignature.KeyLifetimeSecs = &secs
+		testutil.CheckError(t, false, ident.SelfSignature.SignUserId(ident.UserId.Id, key.PrimaryKey, key.PrivateKey, config))
+	}
+	return getBase64EncodedKey(key, openpgp.PublicKeyType, t), getBase64EncodedKey(key, openpgp.PrivateKeyType, t)
+}
+
+func getBase64EncodedKey(key *openpgp.Entity, keyType string, t *testing.T) string {
+	buf := bytes.NewBuffer(nil)
+	wr, err := armor.Encode(buf, keyType, nil)
+	testutil.CheckError(t, false, err)
+	if keyType == openpgp.PrivateKeyType {
+		testutil.CheckError(t, false, key.SerializePrivate(wr, nil))
+	} else {
+		testutil.CheckError(t, false, key.Serialize(wr))
+	}
+	wr.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
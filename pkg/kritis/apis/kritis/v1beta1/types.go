@@ -37,12 +37,234 @@ type PackageVulernerabilityRequirements struct {
 	MaximumSeverity       string   `json:"maximumSeverity"`
 	OnlyFixesNotAvailable bool     `json:"onlyFixesNotAvailable"`
 	WhitelistCVEs         []string `json:"whitelistCVEs"`
+	// ScopedWhitelistCVEs whitelist CVEs only in some images
+	ScopedWhitelistCVEs []ScopedCVE `json:"scopedWhitelistCVEs,omitempty"`
+	// MaximumCounts caps the number of non-whitelisted CVEs allowed per
+	// severity, e.g. {"MEDIUM": 50}. Severities without a cap are unlimited.
+	// A cap replaces MaximumSeverity for its severity.
+	MaximumCounts map[string]int `json:"maximumCounts,omitempty"`
+	// CVEGracePeriod is how long after its occurrence is created a CVE only
+	// produces a warning, giving teams time to remediate before it blocks.
+	CVEGracePeriod *metav1.Duration `json:"cveGracePeriod,omitempty"`
+	// MaximumDisclosureAge is how long after its public disclosure a CVE
+	// with a fix available may remain unpatched in an image, whatever its
+	// severity. Unlike CVEGracePeriod, it's keyed to when the CVE was
+	// disclosed rather than to when its occurrence was created.
+	MaximumDisclosureAge *metav1.Duration `json:"maximumDisclosureAge,omitempty"`
+	// VulnerabilityFilter is an expression over vulnerability fields, such
+	// as "severity >= HIGH AND fixAvailable == true", which non-whitelisted
+	// CVEs matching it violate. See securitypolicy.CompileVulnerabilityFilter.
+	VulnerabilityFilter string `json:"vulnerabilityFilter,omitempty"`
+}
+
+// ScopedCVE is a CVE whitelisted only in images matching Images
+type ScopedCVE struct {
+	CVE string `json:"cve"`
+	// Images are image references, or patterns matched against them as in
+	// path.Match, e.g. gcr.io/my-project/app@* for every digest of an image
+	Images []string `json:"images"`
+	// Expires is when the CVE stops being whitelisted. Never if unset.
+	Expires *metav1.Time `json:"expires,omitempty"`
 }
 
 // ImageSecurityPolicy is the spec for a ImageSecurityPolicy resource
 type ImageSecurityPolicySpec struct {
-	ImageWhitelist                     []string                           `json:"imageWhitelist"`
+	ImageWhitelist []string `json:"imageWhitelist"`
+	// Images are image references, or patterns matched against them as in
+	// ScopedCVE.Images, of the images the policy validates. It validates
+	// every image if empty. Requirements on pods as a whole, such as
+	// TenantRegistryPrefix, apply to all of their images regardless.
+	Images                             []string                           `json:"images,omitempty"`
 	PackageVulernerabilityRequirements PackageVulernerabilityRequirements `json:"packageVulnerabilityRequirements"`
+	// DefaultAction is how to handle images which no policy in the
+	// namespace validates or whitelists, because they all restrict Images:
+	// Allow admits them, which is the default, and Deny denies them, so
+	// only images matched by some policy can run
+	DefaultAction string `json:"defaultAction,omitempty"`
+	// DenyUnknownImages denies images which have no metadata of any kind,
+	// e.g. because they were never scanned
+	DenyUnknownImages bool `json:"denyUnknownImages,omitempty"`
+	// UnknownDigestAction is how to handle images which pods pin to a digest
+	// without any metadata, e.g. because they were built locally and never
+	// pushed to a scanned registry: Deny, Allow without validating their
+	// metadata, or RequireAttestation. It takes precedence over
+	// DenyUnknownImages for them. If it is empty, they are validated like
+	// any other image.
+	UnknownDigestAction string `json:"unknownDigestAction,omitempty"`
+	// AllowedBuilders are the build pipeline identities whose attestations
+	// are trusted. If set, an attestation only skips validation if its
+	// builder claim is one of them.
+	AllowedBuilders []string `json:"allowedBuilders,omitempty"`
+	// StrictForPrivileged requires every image of pods with elevated
+	// privileges, such as privileged containers, host namespaces or hostPath
+	// volumes, to have a valid attestation, in addition to passing the
+	// other requirements of the policy
+	StrictForPrivileged bool `json:"strictForPrivileged,omitempty"`
+	// RequireAttestation denies images without a valid attestation, instead
+	// of validating them against the other requirements of the policy. It
+	// suits images which can't be scanned, such as those of an in-cluster
+	// registry, together with AllowedBuilders to only trust the attestations
+	// of their build pipeline.
+	RequireAttestation bool `json:"requireAttestation,omitempty"`
+	// RequireAttestationNamespaceSelector restricts RequireAttestation to
+	// pods in namespaces whose labels it matches, e.g. env=prod, so that
+	// policies shared by several namespaces, such as those of the default
+	// policy namespace or of a policy bundle, only require attestations in
+	// some of them. The other requirements apply in every namespace.
+	RequireAttestationNamespaceSelector *metav1.LabelSelector `json:"requireAttestationNamespaceSelector,omitempty"`
+	// MetadataBackend is the name of the metadata backend queried for the
+	// vulnerabilities and other metadata of images governed by the policy,
+	// as set by the metadataBackends option or registered with
+	// admission.RegisterMetadataBackend. Container Analysis is queried if it
+	// is empty, and policies selecting an unknown backend are rejected.
+	// Attestations are always verified with Container Analysis.
+	MetadataBackend string `json:"metadataBackend,omitempty"`
+	// Priority orders the evaluation of ImageSecurityPolicies in a namespace.
+	// Policies with a higher priority are evaluated first, and policies with
+	// the same priority are evaluated in order of name.
+	Priority int `json:"priority,omitempty"`
+	// MinSLSALevel is the minimum SLSA build level images must have been
+	// built at, according to their build provenance. Images without build
+	// provenance violate any minimum.
+	MinSLSALevel int `json:"minSlsaLevel,omitempty"`
+	// RequireConsistentProvenance denies pods whose images weren't all built
+	// by the same builder, according to their build provenance, so a
+	// trusted image can't be run alongside one from another source
+	RequireConsistentProvenance bool `json:"requireConsistentProvenance,omitempty"`
+	// RequireSourceRepository denies pods whose images weren't built from
+	// the source repository, e.g. https://github.com/org/app, which the pod
+	// declares with the kritis.grafeas.io/source-repository annotation,
+	// according to their build provenance. Pods which don't declare it are
+	// denied. Workloads must set it on their pod template, since annotations
+	// of the workload itself don't reach the pods it creates.
+	RequireSourceRepository bool `json:"requireSourceRepository,omitempty"`
+	// DenyEmbeddedSecrets denies images in which the scanner detected
+	// embedded secrets, such as credentials or private keys
+	DenyEmbeddedSecrets bool `json:"denyEmbeddedSecrets,omitempty"`
+	// DenyMalware denies images in which the scanner detected malware,
+	// even if they are whitelisted
+	DenyMalware bool `json:"denyMalware,omitempty"`
+	// RequireNotarySignature requires images to be signed with Docker Content
+	// Trust, as an alternative to an attestation by an AttestationAuthority.
+	// Images with a valid attestation are admitted without a signature.
+	RequireNotarySignature *NotaryTrust `json:"requireNotarySignature,omitempty"`
+	// MaxImageSizeBytes is the maximum total size of an image's config and
+	// compressed layers, according to its manifest. 0 means unlimited.
+	MaxImageSizeBytes int64 `json:"maxImageSizeBytes,omitempty"`
+	// MaxLayers is the maximum number of layers in an image's manifest.
+	// 0 means unlimited.
+	MaxLayers int `json:"maxLayers,omitempty"`
+	// RequireNonRootImage denies images whose config runs them as root,
+	// unless the pod's securityContext runs their containers as non-root
+	RequireNonRootImage bool `json:"requireNonRootImage,omitempty"`
+	// RequiredImageLabels are labels, such as org.opencontainers.image.source,
+	// which images' configs must set to a non-empty value
+	RequiredImageLabels []string `json:"requiredImageLabels,omitempty"`
+	// DisallowedExposedPorts denies images whose config exposes any of these
+	// ports, e.g. 23 for telnet over any protocol, or 69/udp
+	DisallowedExposedPorts []string `json:"disallowedExposedPorts,omitempty"`
+	// MaxExposedPorts is the maximum number of ports an image's config may
+	// expose. 0 means unlimited.
+	MaxExposedPorts int `json:"maxExposedPorts,omitempty"`
+	// EvaluateSBOM cross-references the components of the SBOM attached to
+	// images against the SBOM vulnerability database, and validates the
+	// vulnerabilities found like those the scanner reported. Images without
+	// an SBOM are validated with the scanner's vulnerabilities only.
+	EvaluateSBOM bool `json:"evaluateSBOM,omitempty"`
+	// DenyKnownExploitedCVEs denies images with any CVE on the configured
+	// Known Exploited Vulnerabilities list, regardless of its severity, of
+	// whitelisted CVEs and of CVEGracePeriod
+	DenyKnownExploitedCVEs bool `json:"denyKnownExploitedCVEs,omitempty"`
+	// RequireDigestReference denies images which pods reference by tag
+	// instead of by digest, even if kritis could resolve the tag
+	RequireDigestReference bool `json:"requireDigestReference,omitempty"`
+	// DisallowedOperatingSystems denies images whose packages were installed
+	// from any of these operating systems, e.g. end of life distributions.
+	// Each is a CPE URI prefix such as cpe:/o:centos:centos:6, or a product
+	// and version such as centos:6, which also matches versions 6.x.
+	DisallowedOperatingSystems []string `json:"disallowedOperatingSystems,omitempty"`
+	// RequiredNoteKinds are kinds of notes, such as BUILD_DETAILS, images
+	// must have an occurrence of
+	RequiredNoteKinds []string `json:"requiredNoteKinds,omitempty"`
+	// DeniedNoteKinds are kinds of notes, such as UPGRADE for a pending
+	// upgrade, images must not have an occurrence of
+	DeniedNoteKinds []string `json:"deniedNoteKinds,omitempty"`
+	// RescanAfter is how old the latest scan of an image, or without a
+	// metadata backend recording scans its newest vulnerability occurrence,
+	// may be before a rescan of it is requested. A rescan is also requested
+	// for images without any metadata. Requesting a rescan needs a metadata
+	// backend supporting it, and doesn't change whether images are admitted.
+	RescanAfter *metav1.Duration `json:"rescanAfter,omitempty"`
+	// RequireCompleteScan denies images whose latest scan, out of the scans
+	// the metadata backend recorded over time, didn't finish successfully,
+	// as their vulnerabilities may be incomplete. It needs a metadata backend
+	// recording scans.
+	RequireCompleteScan bool `json:"requireCompleteScan,omitempty"`
+	// AllowedInitContainerRegistries are the registries, such as gcr.io, or
+	// repository prefixes, such as gcr.io/my-project, which init container
+	// images must be from. Init containers run before, and can prepare
+	// volumes shared with, the other containers of a pod.
+	AllowedInitContainerRegistries []string `json:"allowedInitContainerRegistries,omitempty"`
+	// TenantRegistryPrefix is the registry or repository prefix, such as
+	// gcr.io/platform/{namespace}, which the images of pods must be from,
+	// with {namespace} replaced by the name of their namespace, so that each
+	// tenant namespace only runs its own images. Whitelisted images are
+	// exempt, e.g. those of sidecars shared by every tenant.
+	TenantRegistryPrefix string `json:"tenantRegistryPrefix,omitempty"`
+	// MaxNamespaceVulnerabilities caps the total number of vulnerabilities
+	// of the images running in the namespace, counting each image once.
+	// Pods whose new images would exceed it are denied. 0 means unlimited.
+	MaxNamespaceVulnerabilities int `json:"maxNamespaceVulnerabilities,omitempty"`
+	// ServiceAccountBindings restrict sensitive images to run under approved
+	// service accounts. Pods running an image matching a binding under
+	// another service account are denied.
+	ServiceAccountBindings []ServiceAccountBinding `json:"serviceAccountBindings,omitempty"`
+	// OPADecision is the path of a rule of the configured OPA policy, such
+	// as kritis/deny, which decides on images instead of the package
+	// vulnerability requirements of the policy. It is evaluated with the
+	// image's metadata as input, see securitypolicy.OPAInput, and must be a
+	// set of messages, each of which is a violation.
+	OPADecision string `json:"opaDecision,omitempty"`
+	// MaxImageAge denies images without a valid attestation, by an
+	// AttestationAuthority in the namespace, signing a build timestamp
+	// within MaxImageAge of now, so that pods only run images the build
+	// pipeline built and signed recently. It's checked on every admission,
+	// even of images admitted before, as they age.
+	MaxImageAge *metav1.Duration `json:"maxImageAge,omitempty"`
+}
+
+// ServiceAccountBinding binds images to the service accounts allowed to run them
+type ServiceAccountBinding struct {
+	// Images are image references, or patterns matched against them as in
+	// ScopedCVE.Images
+	Images []string `json:"images"`
+	// ServiceAccounts are the names of the service accounts, in the pod's
+	// namespace, which may run the images. Pods without a service account
+	// run under the default one.
+	ServiceAccounts []string `json:"serviceAccounts"`
+}
+
+// Values of ImageSecurityPolicySpec.UnknownDigestAction
+const (
+	UnknownDigestDeny               = "Deny"
+	UnknownDigestAllow              = "Allow"
+	UnknownDigestRequireAttestation = "RequireAttestation"
+)
+
+// Values of ImageSecurityPolicySpec.DefaultAction
+const (
+	DefaultActionAllow = "Allow"
+	DefaultActionDeny  = "Deny"
+)
+
+// NotaryTrust is a Notary v1 server and the key trusted to sign images in it
+type NotaryTrust struct {
+	// Server is the URL of the Notary server, e.g. https://notary.docker.io
+	Server string `json:"server"`
+	// PublicKeyData is the PEM encoded ECDSA public key or certificate of the
+	// targets role which signs images. Signatures are rejected outside the
+	// validity period of a certificate.
+	PublicKeyData string `json:"publicKeyData"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -59,13 +281,30 @@ type ImageSecurityPolicyList struct {
 // +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
+// AttestationAuthority is a specification for an AttestationAuthority resource
 type AttestationAuthority struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	NoteReference        string `json:"noteReference"`
+	Spec AttestationAuthoritySpec `json:"spec"`
+}
+
+// AttestationAuthoritySpec is the spec for an AttestationAuthority resource
+type AttestationAuthoritySpec struct {
+	// NoteReference is the name of the attestation note, of the form
+	// projects/<project>/notes/<note>
+	NoteReference string `json:"noteReference"`
+	// PrivateKeySecretName is the name of the secret holding the PGP signing key
 	PrivateKeySecretName string `json:"privateKeySecretName"`
-	PublicKeyData        string `json:"publicKeyData"`
+	// PublicKeyData is the base64 encoded, armored PGP public key
+	// of the signing key
+	PublicKeyData string `json:"publicKeyData"`
+	// PublicKeys are base64 encoded, armored PGP public keys whose
+	// attestations are trusted besides those of the signing key, such as keys
+	// being rotated out. Removing a key revokes its attestations.
+	// Attestations signed before a key was created or after it expired are
+	// rejected.
+	PublicKeys []string `json:"publicKeys,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
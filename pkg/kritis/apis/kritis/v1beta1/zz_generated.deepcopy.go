@@ -21,6 +21,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -29,6 +30,7 @@ func (in *AttestationAuthority) DeepCopyInto(out *AttestationAuthority) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
 	return
 }
 
@@ -83,6 +85,27 @@ func (in *AttestationAuthorityList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttestationAuthoritySpec) DeepCopyInto(out *AttestationAuthoritySpec) {
+	*out = *in
+	if in.PublicKeys != nil {
+		in, out := &in.PublicKeys, &out.PublicKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationAuthoritySpec.
+func (in *AttestationAuthoritySpec) DeepCopy() *AttestationAuthoritySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationAuthoritySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageSecurityPolicy) DeepCopyInto(out *ImageSecurityPolicy) {
 	*out = *in
@@ -151,7 +174,74 @@ func (in *ImageSecurityPolicySpec) DeepCopyInto(out *ImageSecurityPolicySpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.PackageVulernerabilityRequirements.DeepCopyInto(&out.PackageVulernerabilityRequirements)
+	if in.AllowedBuilders != nil {
+		in, out := &in.AllowedBuilders, &out.AllowedBuilders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequireAttestationNamespaceSelector != nil {
+		in, out := &in.RequireAttestationNamespaceSelector, &out.RequireAttestationNamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RequireNotarySignature != nil {
+		in, out := &in.RequireNotarySignature, &out.RequireNotarySignature
+		*out = new(NotaryTrust)
+		**out = **in
+	}
+	if in.RequiredImageLabels != nil {
+		in, out := &in.RequiredImageLabels, &out.RequiredImageLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DisallowedExposedPorts != nil {
+		in, out := &in.DisallowedExposedPorts, &out.DisallowedExposedPorts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DisallowedOperatingSystems != nil {
+		in, out := &in.DisallowedOperatingSystems, &out.DisallowedOperatingSystems
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredNoteKinds != nil {
+		in, out := &in.RequiredNoteKinds, &out.RequiredNoteKinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedNoteKinds != nil {
+		in, out := &in.DeniedNoteKinds, &out.DeniedNoteKinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RescanAfter != nil {
+		in, out := &in.RescanAfter, &out.RescanAfter
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.AllowedInitContainerRegistries != nil {
+		in, out := &in.AllowedInitContainerRegistries, &out.AllowedInitContainerRegistries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceAccountBindings != nil {
+		in, out := &in.ServiceAccountBindings, &out.ServiceAccountBindings
+		*out = make([]ServiceAccountBinding, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MaxImageAge != nil {
+		in, out := &in.MaxImageAge, &out.MaxImageAge
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -165,6 +255,22 @@ func (in *ImageSecurityPolicySpec) DeepCopy() *ImageSecurityPolicySpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotaryTrust) DeepCopyInto(out *NotaryTrust) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotaryTrust.
+func (in *NotaryTrust) DeepCopy() *NotaryTrust {
+	if in == nil {
+		return nil
+	}
+	out := new(NotaryTrust)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PackageVulernerabilityRequirements) DeepCopyInto(out *PackageVulernerabilityRequirements) {
 	*out = *in
@@ -173,6 +279,30 @@ func (in *PackageVulernerabilityRequirements) DeepCopyInto(out *PackageVulernera
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ScopedWhitelistCVEs != nil {
+		in, out := &in.ScopedWhitelistCVEs, &out.ScopedWhitelistCVEs
+		*out = make([]ScopedCVE, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MaximumCounts != nil {
+		in, out := &in.MaximumCounts, &out.MaximumCounts
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CVEGracePeriod != nil {
+		in, out := &in.CVEGracePeriod, &out.CVEGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaximumDisclosureAge != nil {
+		in, out := &in.MaximumDisclosureAge, &out.MaximumDisclosureAge
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -185,3 +315,54 @@ func (in *PackageVulernerabilityRequirements) DeepCopy() *PackageVulernerability
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScopedCVE) DeepCopyInto(out *ScopedCVE) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Expires != nil {
+		in, out := &in.Expires, &out.Expires
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScopedCVE.
+func (in *ScopedCVE) DeepCopy() *ScopedCVE {
+	if in == nil {
+		return nil
+	}
+	out := new(ScopedCVE)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountBinding) DeepCopyInto(out *ServiceAccountBinding) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountBinding.
+func (in *ServiceAccountBinding) DeepCopy() *ServiceAccountBinding {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountBinding)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/grafeas/kritis/pkg/kritis/admission/constants"
 	"github.com/pkg/errors"
@@ -49,31 +50,85 @@ var pgpConfig = packet.Config{
 // VerifyMessageAttestation verifies if the image is attested using the Base64
 // encoded public key.
 func VerifyMessageAttestation(pubKeyEnc string, attestationHash string, message string) error {
-	pemPublicKey, err := base64.StdEncoding.DecodeString(pubKeyEnc)
+	plaintext, err := GetPlainMessage(pubKeyEnc, attestationHash)
 	if err != nil {
 		return err
 	}
+	if string(plaintext) != message {
+		return fmt.Errorf("Signature could not be verified. Got: %q, Want: %q", plaintext, message)
+	}
+	return nil
+}
+
+// GetPlainMessage verifies the attestation is signed by the Base64 encoded
+// public key and returns the message it attests.
+func GetPlainMessage(pubKeyEnc string, attestationHash string) ([]byte, error) {
+	pemPublicKey, err := base64.StdEncoding.DecodeString(pubKeyEnc)
+	if err != nil {
+		return nil, err
+	}
 	attestation, err := base64.StdEncoding.DecodeString(attestationHash)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(string(pemPublicKey)))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	buf := bytes.NewBuffer([]byte(attestation))
 	armorBlock, err := armor.Decode(buf)
+	if err != nil {
+		return nil, err
+	}
 	md, err := openpgp.ReadMessage(armorBlock.Body, keyring, nil, &pgpConfig)
+	if err != nil {
+		return nil, err
+	}
+	if md.SignedBy == nil {
+		return nil, fmt.Errorf("Attestation is not signed by the public key")
+	}
 
 	// Verify Signature using the Public Key
+	// The signature is only checked once the whole body has been read
 	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if md.SignatureError != nil {
+		return nil, md.SignatureError
 	}
+	if err := checkKeyValidity(md); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
 
-	if string(plaintext) != message {
-		return fmt.Errorf("Signature could not be verified. Got: %q, Want: %q", plaintext, message)
+// checkKeyValidity returns an error if the key which signed md wasn't valid
+// when md was signed, i.e. it was signed before the key was created or after
+// the key expired. Signatures made while the key was valid still hold after
+// it expires.
+func checkKeyValidity(md *openpgp.MessageDetails) error {
+	var signed time.Time
+	switch {
+	case md.Signature != nil:
+		signed = md.Signature.CreationTime
+	case md.SignatureV3 != nil:
+		signed = md.SignatureV3.CreationTime
+	default:
+		return fmt.Errorf("Attestation has no signature")
+	}
+	key := md.SignedBy.PublicKey
+	if signed.Before(key.CreationTime) {
+		return fmt.Errorf("Attestation was signed at %s, before key %s was created at %s", signed.Format(time.RFC3339), key.KeyIdString(), key.CreationTime.Format(time.RFC3339))
+	}
+	self := md.SignedBy.SelfSignature
+	if self == nil || self.KeyLifetimeSecs == nil || *self.KeyLifetimeSecs == 0 {
+		return nil
+	}
+	expiry := key.CreationTime.Add(time.Duration(*self.KeyLifetimeSecs) * time.Second)
+	if signed.After(expiry) {
+		return fmt.Errorf("Attestation was signed at %s, after key %s expired at %s", signed.Format(time.RFC3339), key.KeyIdString(), expiry.Format(time.RFC3339))
 	}
 	return nil
 }
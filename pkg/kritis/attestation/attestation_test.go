@@ -20,10 +20,12 @@ import (
 	"bytes"
 	"encoding/base64"
 	"testing"
+	"time"
 
 	"github.com/grafeas/kritis/pkg/kritis/testutil"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 var tcAttestations = []struct {
@@ -54,6 +56,50 @@ func TestAttestations(t *testing.T) {
 	}
 }
 
+func TestAttestationKeyValidity(t *testing.T) {
+	created := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	publicKey, privateKey := createExpiringBase64KeyPair(t, created, time.Hour)
+	var tests = []struct {
+		name      string
+		signed    time.Time
+		shouldErr bool
+	}{
+		{"signed while the key was valid", created.Add(30 * time.Minute), false},
+		{"signed after the key expired", created.Add(90 * time.Minute), true},
+		{"signed before the key was created", created.Add(-time.Hour), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				pgpConfig.Time = nil
+			}()
+			pgpConfig.Time = func() time.Time {
+				return test.signed
+			}
+			sig, err := CreateMessageAttestation(publicKey, privateKey, "test")
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			err = VerifyMessageAttestation(publicKey, sig, "test")
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
+
+// createExpiringBase64KeyPair returns a key pair created at created, which
+// expires after lifetime
+func createExpiringBase64KeyPair(t *testing.T, created time.Time, lifetime time.Duration) (string, string) {
+	config := &packet.Config{Time: func() time.Time { return created }}
+	key, err := openpgp.NewEntity("kritis", "test", "kritis@grafeas.com", config)
+	testutil.CheckError(t, false, err)
+	secs := uint32(lifetime.Seconds())
+	for _, ident := range key.Identities {
+		ident.SelfSignature.KeyLifetimeSecs = &secs
+		testutil.CheckError(t, false, ident.SelfSignature.SignUserId(ident.UserId.Id, key.PrimaryKey, key.PrivateKey, config))
+	}
+	return getBase64EncodedKey(key, openpgp.PublicKeyType, t), getBase64EncodedKey(key, openpgp.PrivateKeyType, t)
+}
+
 func createBase64KeyPair(t *testing.T) (string, string) {
 	// Create a new pair of key
 	var key *openpgp.Entity
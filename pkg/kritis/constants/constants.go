@@ -27,6 +27,23 @@ const (
 	// Breakglass is the key for the breakglass annotation
 	Breakglass = "kritis.grafeas.io/breakglass"
 
+	// BuildTokenAnnotation is the key for the annotation holding a signed
+	// admission.BuildToken, with which CI proves it validated the pod's images
+	BuildTokenAnnotation = "kritis.grafeas.io/build-token"
+
+	// SourceRepositoryAnnotation is the key for the annotation declaring the
+	// source repository a pod's images must have been built from. Workloads
+	// set it on their pod template, so that their pods are annotated too.
+	SourceRepositoryAnnotation = "kritis.grafeas.io/source-repository"
+
+	// PolicySignatureAnnotation is the key for the annotation holding the
+	// signature of an ImageSecurityPolicy by a trusted policy author
+	PolicySignatureAnnotation = "kritis.grafeas.io/policy-signature"
+
+	// MirrorPodAnnotation is set by the kubelet on the mirror pods it creates
+	// in the API server for the static pods it runs
+	MirrorPodAnnotation = "kubernetes.io/config.mirror"
+
 	// A list of label values
 	PreviouslyAttestedAnnotation = "Previously attested."
 	NoAttestationsAnnotation     = "No valid attestations present. This pod will not be able to restart in future"
@@ -37,6 +54,30 @@ const (
 
 var (
 	// GlobalImageWhitelist is a list of images that are globally whitelisted
-	// They should always pass the webhook check
-	GlobalImageWhitelist = []string{"gcr.io/kritis-project/kritis-server"}
+	// They should always pass the webhook check, before any policy is checked,
+	// so that kritis never blocks its own pods from running.
+	// Deployments using their own builds of kritis can override it.
+	GlobalImageWhitelist = []string{
+		// The admission webhook, which also runs the background cron job
+		// and creates attestations
+		"gcr.io/kritis-project/kritis-server",
+		// The webhook as deployed by the integration tests
+		"gcr.io/kritis-int-test/kritis-server",
+	}
+
+	// DefaultExemptNamespaces are the namespaces whose pods are admitted
+	// without validation unless exempt namespaces are configured, so that
+	// kritis can't break the cluster's system pods or its own. kritis is
+	// conventionally installed in the kritis namespace.
+	DefaultExemptNamespaces = []string{"kube-system", "kritis"}
+
+	// PauseImages are the pause images kubelets run as pod sandboxes, which
+	// are never validated since they are infrastructure rather than workload.
+	// Clusters using another sandbox image can override them.
+	PauseImages = []string{
+		"registry.k8s.io/pause",
+		"k8s.gcr.io/pause",
+		"gcr.io/google_containers/pause",
+		"gcr.io/google-containers/pause",
+	}
 )
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/sirupsen/logrus"
+)
+
+// metadataBackends is the set of metadata backends policies may select, or
+// nil if it hasn't been set and any may be selected
+var (
+	metadataBackendsMu sync.RWMutex
+	metadataBackends   map[string]bool
+)
+
+// SetMetadataBackends sets the names of the metadata backends policies may
+// select with metadataBackend. Policies selecting any other are ignored
+// and logged when they are loaded. If names is nil, policies may select any.
+func SetMetadataBackends(names []string) {
+	var set map[string]bool
+	if names != nil {
+		set = map[string]bool{}
+		for _, name := range names {
+			set[name] = true
+		}
+	}
+	metadataBackendsMu.Lock()
+	defer metadataBackendsMu.Unlock()
+	metadataBackends = set
+}
+
+// validatePolicy returns an error if isp can't be evaluated, because its
+// vulnerability filter is invalid or it selects an unknown metadata backend
+func validatePolicy(isp v1beta1.ImageSecurityPolicy) error {
+	if _, err := vulnerabilityFilter(isp); err != nil {
+		return err
+	}
+	if isp.Spec.MetadataBackend == "" {
+		return nil
+	}
+	metadataBackendsMu.RLock()
+	defer metadataBackendsMu.RUnlock()
+	if metadataBackends != nil && !metadataBackends[isp.Spec.MetadataBackend] {
+		return fmt.Errorf("ImageSecurityPolicy %s/%s selects unknown metadata backend %q", isp.Namespace, isp.Name, isp.Spec.MetadataBackend)
+	}
+	return nil
+}
+
+// validPolicies returns isps without those validatePolicy rejects, logging
+// them, so that an invalid policy doesn't fail admissions in its namespace
+func validPolicies(isps []v1beta1.ImageSecurityPolicy) []v1beta1.ImageSecurityPolicy {
+	valid := make([]v1beta1.ImageSecurityPolicy, 0, len(isps))
+	for _, isp := range isps {
+		if err := validatePolicy(isp); err != nil {
+			logrus.Errorf("ignoring image security policy %s/%s: %v", isp.Namespace, isp.Name, err)
+			continue
+		}
+		valid = append(valid, isp)
+	}
+	return valid
+}
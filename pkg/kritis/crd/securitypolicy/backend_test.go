@@ -0,0 +1,70 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestValidatePolicyMetadataBackend(t *testing.T) {
+	var tests = []struct {
+		name      string
+		backends  []string
+		backend   string
+		shouldErr bool
+	}{
+		{
+			name:     "default backend",
+			backends: []string{"containeranalysis"},
+		},
+		{
+			name:     "known backend",
+			backends: []string{"containeranalysis", "clair"},
+			backend:  "clair",
+		},
+		{
+			name:      "unknown backend",
+			backends:  []string{"containeranalysis"},
+			backend:   "clair",
+			shouldErr: true,
+		},
+		{
+			name:    "backends not set",
+			backend: "clair",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetMetadataBackends(test.backends)
+			defer SetMetadataBackends(nil)
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{MetadataBackend: test.backend},
+			}
+			testutil.CheckError(t, test.shouldErr, validatePolicy(isp))
+		})
+	}
+}
+
+func TestValidPolicies(t *testing.T) {
+	invalid := testPolicy("default", "invalid")
+	invalid.Spec.PackageVulernerabilityRequirements.VulnerabilityFilter = "severity >="
+	isps := []v1beta1.ImageSecurityPolicy{testPolicy("default", "a"), invalid, testPolicy("default", "b")}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"default/a", "default/b"}, policyNames(validPolicies(isps)))
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+)
+
+// namespaceVulnerabilities are the number of vulnerabilities of each image
+// running in a namespace, by namespace, as last counted by the cron job
+var (
+	namespaceVulnerabilitiesMu sync.RWMutex
+	namespaceVulnerabilities   = map[string]map[string]int{}
+)
+
+// SetNamespaceVulnerabilities records the number of vulnerabilities of each
+// image running in namespace, which the vulnerability budget of namespace
+// is checked against
+func SetNamespaceVulnerabilities(namespace string, byImage map[string]int) {
+	normalized := map[string]int{}
+	for image, n := range byImage {
+		normalized[budgetKey(image)] = n
+	}
+	namespaceVulnerabilitiesMu.Lock()
+	defer namespaceVulnerabilitiesMu.Unlock()
+	namespaceVulnerabilities[namespace] = normalized
+}
+
+// NamespaceVulnerabilities returns the number of vulnerabilities of each
+// image running in namespace, if they were counted
+func NamespaceVulnerabilities(namespace string) (map[string]int, bool) {
+	namespaceVulnerabilitiesMu.RLock()
+	defer namespaceVulnerabilitiesMu.RUnlock()
+	byImage, ok := namespaceVulnerabilities[namespace]
+	return byImage, ok
+}
+
+func budgetKey(image string) string {
+	if normalized, err := util.NormalizeImage(image); err == nil {
+		return normalized
+	}
+	return image
+}
+
+// ValidateNamespaceBudget checks if running images in namespace keeps the
+// vulnerabilities of the images running in it within the
+// MaxNamespaceVulnerabilities of isp. Only images isp governs are counted,
+// and images already running in namespace aren't counted again, so pods which
+// add no vulnerabilities, e.g. rescheduled replicas, are allowed even if the
+// namespace is over budget. Until the images running in namespace are
+// counted, any images are allowed.
+func ValidateNamespaceBudget(isp v1beta1.ImageSecurityPolicy, namespace string, images []string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	max := isp.Spec.MaxNamespaceVulnerabilities
+	if max <= 0 {
+		return nil, nil
+	}
+	running, ok := NamespaceVulnerabilities(namespace)
+	if !ok {
+		logrus.Debugf("vulnerabilities in namespace %s haven't been counted yet, not checking its budget", namespace)
+		return nil, nil
+	}
+	total := 0
+	for image, n := range running {
+		if Governs(isp, image) {
+			total += n
+		}
+	}
+	// added is the number of vulnerabilities of the images not running yet
+	added := 0
+	counted := map[string]bool{}
+	for _, image := range images {
+		if !Governs(isp, image) {
+			continue
+		}
+		key := budgetKey(image)
+		if _, ok := running[key]; ok || counted[key] {
+			continue
+		}
+		counted[key] = true
+		vulnz, err := client.GetVulnerabilities(image)
+		if err != nil {
+			return nil, err
+		}
+		added += len(vulnz)
+	}
+	total += added
+	if added == 0 || total <= max {
+		return nil, nil
+	}
+	return []SecurityPolicyViolation{{
+		Violation: NamespaceBudgetViolation,
+		Reason:    NamespaceBudgetViolationReason(namespace, total, max),
+	}}, nil
+}
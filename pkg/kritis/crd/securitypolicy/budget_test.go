@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestValidateNamespaceBudget(t *testing.T) {
+	running := "gcr.io/image/running@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	// mockMetadataClient returns 2 vulnerabilities for every image
+	var tests = []struct {
+		name     string
+		max      int
+		governs  []string
+		running  map[string]int
+		counted  bool
+		images   []string
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:    "within budget",
+			max:     10,
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{testutil.QualifiedImage},
+		},
+		{
+			name:    "exactly at budget",
+			max:     9,
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{testutil.QualifiedImage},
+		},
+		{
+			name:    "over budget",
+			max:     8,
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{testutil.QualifiedImage},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: NamespaceBudgetViolation,
+					Reason:    NamespaceBudgetViolationReason("budget", 9, 8),
+				},
+			},
+		},
+		{
+			name:    "only running images in a namespace over budget",
+			max:     5,
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{running},
+		},
+		{
+			name:    "new images in a namespace over budget",
+			max:     5,
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{running, testutil.QualifiedImage},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: NamespaceBudgetViolation,
+					Reason:    NamespaceBudgetViolationReason("budget", 9, 5),
+				},
+			},
+		},
+		{
+			name:    "running images count once",
+			max:     9,
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{running, testutil.QualifiedImage, testutil.QualifiedImage},
+		},
+		{
+			name:    "ungoverned running images don't count",
+			max:     8,
+			governs: []string{testutil.QualifiedImage},
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{testutil.QualifiedImage},
+		},
+		{
+			name:    "ungoverned images don't count",
+			max:     8,
+			governs: []string{"gcr.io/image/running*"},
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{testutil.QualifiedImage},
+		},
+		{
+			name:   "not counted yet",
+			max:    1,
+			images: []string{testutil.QualifiedImage},
+		},
+		{
+			name:    "unlimited",
+			running: map[string]int{running: 7},
+			counted: true,
+			images:  []string{testutil.QualifiedImage},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespaceVulnerabilitiesMu.Lock()
+			namespaceVulnerabilities = map[string]map[string]int{}
+			namespaceVulnerabilitiesMu.Unlock()
+			if test.counted {
+				SetNamespaceVulnerabilities("budget", test.running)
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					Images:                      test.governs,
+					MaxNamespaceVulnerabilities: test.max,
+				},
+			}
+			violations, err := ValidateNamespaceBudget(isp, "budget", test.images, mockMetadataClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// bundleRefreshInterval is how long a pulled policy bundle is used
+	// before it's pulled again, to pick up a tag pointing to a new version
+	bundleRefreshInterval = time.Minute
+	// bundleRetryInterval is how long after a failed refresh a policy
+	// bundle is pulled again
+	bundleRetryInterval = 10 * time.Second
+	// bundlePullTimeout bounds each request pulling a policy bundle
+	bundlePullTimeout = 10 * time.Second
+	// maxBundleSize is the size of the largest policy bundle pulled
+	maxBundleSize = 4 << 20
+)
+
+// pulledBundle is the last verified version of a policy bundle, if any, and
+// its pull in flight
+type pulledBundle struct {
+	isps     []v1beta1.ImageSecurityPolicy
+	verified bool
+	expiry   time.Time
+	pull     *bundlePull
+}
+
+// bundlePull is a pull of a policy bundle, whose result is set once done is
+// closed
+type bundlePull struct {
+	done chan struct{}
+	isps []v1beta1.ImageSecurityPolicy
+	err  error
+}
+
+var (
+	bundlesMu sync.Mutex
+	bundles   = map[string]*pulledBundle{}
+)
+
+// PolicyBundle returns the ImageSecurityPolicies in the policy bundle at the
+// OCI reference ref, which must be signed by the base64 encoded, armored PGP
+// public key publicKey. See ParsePolicyBundle for its format.
+// Bundles are pulled again in the background after bundleRefreshInterval,
+// serving the last verified version while they are, or if that fails.
+func PolicyBundle(ref string, publicKey string) ([]v1beta1.ImageSecurityPolicy, error) {
+	key := ref + "|" + publicKey
+	bundlesMu.Lock()
+	b, ok := bundles[key]
+	if !ok {
+		b = &pulledBundle{}
+		bundles[key] = b
+	}
+	p := b.pull
+	if p == nil && (!b.verified || !now().Before(b.expiry)) {
+		p = &bundlePull{done: make(chan struct{})}
+		b.pull = p
+		go refreshPolicyBundle(b, p, ref, publicKey)
+	}
+	isps, verified := b.isps, b.verified
+	bundlesMu.Unlock()
+	if verified {
+		return isps, nil
+	}
+	// Wait for the first pull, which concurrent callers share
+	<-p.done
+	return p.isps, p.err
+}
+
+// refreshPolicyBundle pulls the bundle b at ref for p, keeping the last
+// verified version of b if that fails
+func refreshPolicyBundle(b *pulledBundle, p *bundlePull, ref string, publicKey string) {
+	defer close(p.done)
+	p.isps, p.err = pullVerifiedPolicyBundle(ref, publicKey)
+	bundlesMu.Lock()
+	defer bundlesMu.Unlock()
+	b.pull = nil
+	switch {
+	case p.err == nil:
+		b.isps, b.verified, b.expiry = p.isps, true, now().Add(bundleRefreshInterval)
+	case b.verified:
+		logrus.Errorf("serving the last verified policy bundle %s: %v", ref, p.err)
+		b.expiry = now().Add(bundleRetryInterval)
+	}
+}
+
+// pullVerifiedPolicyBundle pulls the bundle at ref and verifies it was
+// signed by publicKey
+func pullVerifiedPolicyBundle(ref string, publicKey string) ([]v1beta1.ImageSecurityPolicy, error) {
+	data, err := pullPolicyBundle(ref)
+	if err != nil {
+		return nil, fmt.Errorf("error pulling policy bundle %s: %v", ref, err)
+	}
+	isps, err := ParsePolicyBundle(data, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading policy bundle %s: %v", ref, err)
+	}
+	return isps, nil
+}
+
+// pullPolicyBundle returns the content of the single layer of the artifact at ref
+func pullPolicyBundle(ref string) ([]byte, error) {
+	r, err := name.ParseReference(ref, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	transport := util.NewTimeoutTransport(util.RegistryTransport(), bundlePullTimeout)
+	img, err := remote.Image(r, remote.WithTransport(transport))
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("expected a single layer, got %d", len(layers))
+	}
+	// The layer is stored as is, not as a compressed filesystem
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("policy bundle is larger than %d bytes", maxBundleSize)
+	}
+	return data, nil
+}
+
+// ParsePolicyBundle returns the ImageSecurityPolicies in a policy bundle.
+// A bundle is a YAML ImageSecurityPolicyList, PGP signed by publicKey as by
+// attestation.CreateMessageAttestation. Policies without a namespace apply
+// to every namespace.
+func ParsePolicyBundle(data []byte, publicKey string) ([]v1beta1.ImageSecurityPolicy, error) {
+	message, err := attestation.GetPlainMessage(publicKey, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error verifying signature: %v", err)
+	}
+	list := v1beta1.ImageSecurityPolicyList{}
+	if err := yaml.Unmarshal(message, &list); err != nil {
+		return nil, err
+	}
+	return validPolicies(list.Items), nil
+}
+
+// BundlePoliciesInNamespace returns the isps which apply to namespace
+func BundlePoliciesInNamespace(isps []v1beta1.ImageSecurityPolicy, namespace string) []v1beta1.ImageSecurityPolicy {
+	inNamespace := []v1beta1.ImageSecurityPolicy{}
+	for _, isp := range isps {
+		if isp.Namespace == "" || isp.Namespace == namespace {
+			inNamespace = append(inNamespace, isp)
+		}
+	}
+	return inNamespace
+}
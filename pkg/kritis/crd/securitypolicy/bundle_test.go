@@ -0,0 +1,273 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const bundleYAML = `
+apiVersion: kritis.grafeas.io/v1beta1
+kind: ImageSecurityPolicyList
+items:
+- metadata:
+    name: everywhere
+  spec:
+    packageVulnerabilityRequirements:
+      maximumSeverity: MEDIUM
+- metadata:
+    name: prod-only
+    namespace: prod
+  spec:
+    packageVulnerabilityRequirements:
+      maximumSeverity: LOW
+`
+
+// fakeRegistry serves the artifact repo:tag with a single layer holding layer,
+// and counts the manifests it serves
+type fakeRegistry struct {
+	*httptest.Server
+	pulls int
+}
+
+func newFakeRegistry(t *testing.T, repo string, tag string, layer []byte) *fakeRegistry {
+	config := []byte("{}")
+	blobs := map[string][]byte{digest(config): config, digest(layer): layer}
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.kritis.policy.config.v1+json",
+			"size":      len(config),
+			"digest":    digest(config),
+		},
+		"layers": []map[string]interface{}{{
+			"mediaType": "application/vnd.kritis.policy.bundle.v1",
+			"size":      len(layer),
+			"digest":    digest(layer),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRegistry{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch p := req.URL.Path; {
+		case p == "/v2/":
+		case p == fmt.Sprintf("/v2/%s/manifests/%s", repo, tag):
+			r.pulls++
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Write(manifest)
+		case strings.HasPrefix(p, fmt.Sprintf("/v2/%s/blobs/", repo)):
+			blob, ok := blobs[strings.TrimPrefix(p, fmt.Sprintf("/v2/%s/blobs/", repo))]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(blob)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	return r
+}
+
+func digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func TestPolicyBundle(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	otherPublicKey, otherPrivateKey := createBase64KeyPair(t)
+	signed, err := attestation.CreateMessageAttestation(publicKey, privateKey, bundleYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := attestation.CreateMessageAttestation(otherPublicKey, otherPrivateKey, bundleYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []v1beta1.ImageSecurityPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "everywhere"},
+			Spec: v1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{MaximumSeverity: "MEDIUM"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod-only", Namespace: "prod"},
+			Spec: v1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{MaximumSeverity: "LOW"},
+			},
+		},
+	}
+	var tests = []struct {
+		name      string
+		layer     string
+		shouldErr bool
+		expected  []v1beta1.ImageSecurityPolicy
+	}{
+		{
+			name:     "signed bundle",
+			layer:    signed,
+			expected: expected,
+		},
+		{
+			name:      "bundle signed by another key",
+			layer:     forged,
+			shouldErr: true,
+		},
+		{
+			name:      "unsigned bundle",
+			layer:     base64.StdEncoding.EncodeToString([]byte(bundleYAML)),
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newFakeRegistry(t, "policies/bundle", "v1", []byte(test.layer))
+			defer registry.Close()
+			ref := strings.TrimPrefix(registry.URL, "http://") + "/policies/bundle:v1"
+			isps, err := PolicyBundle(ref, publicKey)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, isps)
+		})
+	}
+}
+
+func TestPolicyBundleRefresh(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	signed, err := attestation.CreateMessageAttestation(publicKey, privateKey, bundleYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newFakeRegistry(t, "policies/bundle", "latest", []byte(signed))
+	defer registry.Close()
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/policies/bundle:latest"
+
+	original := now
+	defer func() {
+		now = original
+	}()
+	pulled := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{pulled, pulled.Add(bundleRefreshInterval / 2), pulled.Add(2 * bundleRefreshInterval)} {
+		now = func() time.Time { return at }
+		if _, err := PolicyBundle(ref, publicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForBundlePull(ref, publicKey)
+	if registry.pulls != 2 {
+		t.Errorf("expected the bundle to be pulled again only once it's stale, got %d pulls", registry.pulls)
+	}
+}
+
+func TestPolicyBundleRefreshFailure(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	signed, err := attestation.CreateMessageAttestation(publicKey, privateKey, bundleYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newFakeRegistry(t, "policies/bundle", "latest", []byte(signed))
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/policies/bundle:latest"
+
+	original := now
+	defer func() {
+		now = original
+	}()
+	pulled := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return pulled }
+	expected, err := PolicyBundle(ref, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The registry goes away, so refreshing the bundle fails
+	registry.Close()
+	for _, at := range []time.Time{pulled.Add(2 * bundleRefreshInterval), pulled.Add(2*bundleRefreshInterval + bundleRetryInterval)} {
+		now = func() time.Time { return at }
+		isps, err := PolicyBundle(ref, publicKey)
+		testutil.CheckErrorAndDeepEqual(t, false, err, expected, isps)
+		waitForBundlePull(ref, publicKey)
+	}
+	isps, err := PolicyBundle(ref, publicKey)
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, isps)
+}
+
+func TestPolicyBundleTooLarge(t *testing.T) {
+	publicKey, _ := createBase64KeyPair(t)
+	registry := newFakeRegistry(t, "policies/bundle", "v1", bytes.Repeat([]byte("a"), maxBundleSize+1))
+	defer registry.Close()
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/policies/bundle:v1"
+	_, err := PolicyBundle(ref, publicKey)
+	testutil.CheckError(t, true, err)
+}
+
+// waitForBundlePull waits for the pull in flight of the bundle at ref, if any
+func waitForBundlePull(ref string, publicKey string) {
+	bundlesMu.Lock()
+	var pull *bundlePull
+	if b, ok := bundles[ref+"|"+publicKey]; ok {
+		pull = b.pull
+	}
+	bundlesMu.Unlock()
+	if pull != nil {
+		<-pull.done
+	}
+}
+
+func TestBundlePoliciesInNamespace(t *testing.T) {
+	isps := []v1beta1.ImageSecurityPolicy{
+		{ObjectMeta: metav1.ObjectMeta{Name: "everywhere"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "prod-only", Namespace: "prod"}},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, isps, BundlePoliciesInNamespace(isps, "prod"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, isps[:1], BundlePoliciesInNamespace(isps, "dev"))
+}
+
+func createBase64KeyPair(t *testing.T) (string, string) {
+	key, err := openpgp.NewEntity("kritis", "test", "kritis@grafeas.com", nil)
+	testutil.CheckError(t, false, err)
+	return encodeKey(t, key, openpgp.PublicKeyType), encodeKey(t, key, openpgp.PrivateKeyType)
+}
+
+func encodeKey(t *testing.T, key *openpgp.Entity, keyType string) string {
+	buf := bytes.NewBuffer(nil)
+	wr, err := armor.Encode(buf, keyType, nil)
+	testutil.CheckError(t, false, err)
+	if keyType == openpgp.PrivateKeyType {
+		testutil.CheckError(t, false, key.SerializePrivate(wr, nil))
+	} else {
+		testutil.CheckError(t, false, key.Serialize(wr))
+	}
+	wr.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	ca "google.golang.org/genproto/googleapis/devtools/containeranalysis/v1alpha1"
+)
+
+// VulnerabilityFilter returns true if a vulnerability matches a filter expression
+type VulnerabilityFilter func(v metadata.Vulnerability) bool
+
+// CompileVulnerabilityFilter compiles a filter expression over vulnerability
+// fields, e.g.
+//
+//	severity >= HIGH AND fixAvailable == true
+//	cve == "CVE-2021-44228" OR NOT (severity < MEDIUM)
+//
+// The fields are severity, compared in order of severity with any of ==, !=,
+// <, <=, > and >=, fixAvailable, compared to true or false with == and !=,
+// and cve, compared to a CVE ID or note name with == and !=.
+// Comparisons are combined with AND, OR, NOT and parentheses.
+func CompileVulnerabilityFilter(expr string) (VulnerabilityFilter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return f, nil
+}
+
+// tokenize splits expr into words, quoted strings, operators and parentheses
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, expr[i:i+end+2])
+			i += end + 2
+		case strings.ContainsRune("=!<>", c):
+			j := i + 1
+			if j < len(expr) && expr[j] == '=' {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(expr) && !unicode.IsSpace(rune(expr[j])) && !strings.ContainsRune("()\"=!<>", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() (string, error) {
+	if p.done() {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+// keyword returns true and consumes the next token if it is the keyword k,
+// in any case
+func (p *filterParser) keyword(k string) bool {
+	if strings.EqualFold(p.peek(), k) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (VulnerabilityFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(v metadata.Vulnerability) bool { return l(v) || right(v) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (VulnerabilityFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(v metadata.Vulnerability) bool { return l(v) && right(v) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (VulnerabilityFilter, error) {
+	if p.keyword("NOT") {
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v metadata.Vulnerability) bool { return !f(v) }, nil
+	}
+	if p.peek() == "(" {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, err := p.next(); err != nil || t != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return f, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (VulnerabilityFilter, error) {
+	field, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch field {
+	case "severity":
+		return severityComparison(op, value)
+	case "fixAvailable":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("fixAvailable must be compared to true or false, got %q", value)
+		}
+		return equality(op, func(v metadata.Vulnerability) bool { return v.HasFixAvailable == b })
+	case "cve":
+		cve := strings.Trim(value, `"`)
+		return equality(op, func(v metadata.Vulnerability) bool {
+			return v.CVE == cve || strings.HasSuffix(v.CVE, "/"+cve)
+		})
+	default:
+		return nil, fmt.Errorf("unknown field %q, must be severity, fixAvailable or cve", field)
+	}
+}
+
+// equality returns f for ==, or its negation for !=
+func equality(op string, f VulnerabilityFilter) (VulnerabilityFilter, error) {
+	switch op {
+	case "==":
+		return f, nil
+	case "!=":
+		return func(v metadata.Vulnerability) bool { return !f(v) }, nil
+	default:
+		return nil, fmt.Errorf("operator %q can't be used here, must be == or !=", op)
+	}
+}
+
+func severityComparison(op string, value string) (VulnerabilityFilter, error) {
+	s, ok := ca.VulnerabilityType_Severity_value[strings.ToUpper(value)]
+	if !ok {
+		return nil, fmt.Errorf("unknown severity %q", value)
+	}
+	var compare func(severity int32) bool
+	switch op {
+	case "==":
+		compare = func(severity int32) bool { return severity == s }
+	case "!=":
+		compare = func(severity int32) bool { return severity != s }
+	case "<":
+		compare = func(severity int32) bool { return severity < s }
+	case "<=":
+		compare = func(severity int32) bool { return severity <= s }
+	case ">":
+		compare = func(severity int32) bool { return severity > s }
+	case ">=":
+		compare = func(severity int32) bool { return severity >= s }
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+	return func(v metadata.Vulnerability) bool {
+		return compare(ca.VulnerabilityType_Severity_value[v.Severity])
+	}, nil
+}
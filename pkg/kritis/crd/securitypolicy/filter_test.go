@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+var filterFixtures = []metadata.Vulnerability{
+	{CVE: "providers/goog-vulnz/notes/CVE-1", Severity: "LOW", HasFixAvailable: true},
+	{CVE: "providers/goog-vulnz/notes/CVE-2", Severity: "MEDIUM", HasFixAvailable: false},
+	{CVE: "providers/goog-vulnz/notes/CVE-3", Severity: "HIGH", HasFixAvailable: true},
+	{CVE: "providers/goog-vulnz/notes/CVE-4", Severity: "CRITICAL", HasFixAvailable: false},
+}
+
+func TestCompileVulnerabilityFilter(t *testing.T) {
+	var tests = []struct {
+		expr     string
+		expected []string
+	}{
+		{"severity >= HIGH", []string{"CVE-3", "CVE-4"}},
+		{"severity >= HIGH AND fixAvailable == true", []string{"CVE-3"}},
+		{"severity < medium OR fixAvailable == false", []string{"CVE-1", "CVE-2", "CVE-4"}},
+		{"severity == MEDIUM", []string{"CVE-2"}},
+		{"severity != MEDIUM AND severity <= HIGH", []string{"CVE-1", "CVE-3"}},
+		{"NOT fixAvailable == true", []string{"CVE-2", "CVE-4"}},
+		{`cve == "CVE-4" OR cve == providers/goog-vulnz/notes/CVE-1`, []string{"CVE-1", "CVE-4"}},
+		{"cve != CVE-1 and (severity > HIGH or fixAvailable == true)", []string{"CVE-3", "CVE-4"}},
+		{"NOT (severity > LOW AND severity < CRITICAL)", []string{"CVE-1", "CVE-4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			f, err := CompileVulnerabilityFilter(test.expr)
+			if err != nil {
+				t.Fatalf("error compiling %q: %v", test.expr, err)
+			}
+			matched := []string{}
+			for _, v := range filterFixtures {
+				if f(v) {
+					matched = append(matched, v.CVE[len("providers/goog-vulnz/notes/"):])
+				}
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, matched)
+		})
+	}
+}
+
+func TestCompileInvalidVulnerabilityFilter(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"severity",
+		"severity >=",
+		"severity >= SEVERE",
+		"severity => HIGH",
+		"fixAvailable > true",
+		"fixAvailable == maybe",
+		"cve < CVE-1",
+		"score >= 7",
+		"severity >= HIGH AND",
+		"(severity >= HIGH",
+		"severity >= HIGH)",
+		`cve == "CVE-1`,
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := CompileVulnerabilityFilter(expr); err == nil {
+				t.Errorf("expected %q to be invalid", expr)
+			}
+		})
+	}
+}
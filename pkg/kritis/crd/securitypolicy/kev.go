@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+// knownExploited is the set of CVE IDs on the Known Exploited Vulnerabilities
+// list in use, denied by policies with DenyKnownExploitedCVEs
+var (
+	knownExploitedMu sync.RWMutex
+	knownExploited   = map[string]bool{}
+)
+
+// SetKnownExploitedCVEs sets the Known Exploited Vulnerabilities list, as
+// CVE IDs such as CVE-2021-44228
+func SetKnownExploitedCVEs(cves []string) {
+	set := map[string]bool{}
+	for _, cve := range cves {
+		set[cve] = true
+	}
+	knownExploitedMu.Lock()
+	defer knownExploitedMu.Unlock()
+	knownExploited = set
+}
+
+// isKnownExploited returns true if cve, either a CVE ID or a note name ending
+// in one, is on the Known Exploited Vulnerabilities list
+func isKnownExploited(cve string) bool {
+	knownExploitedMu.RLock()
+	defer knownExploitedMu.RUnlock()
+	return knownExploited[cve] || knownExploited[path.Base(cve)]
+}
+
+// kevCatalog is the JSON format of the CISA Known Exploited Vulnerabilities catalog
+type kevCatalog struct {
+	Vulnerabilities []struct {
+		CVEID string `json:"cveID"`
+	} `json:"vulnerabilities"`
+}
+
+// LoadKnownExploitedCVEs reads a Known Exploited Vulnerabilities list from
+// file, either in the JSON format of the CISA catalog or as one CVE ID per
+// line. Empty lines and lines starting with # are ignored.
+func LoadKnownExploitedCVEs(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKnownExploitedCVEs(data)
+}
+
+// ParseKnownExploitedCVEs parses a Known Exploited Vulnerabilities list, see LoadKnownExploitedCVEs
+func ParseKnownExploitedCVEs(data []byte) ([]string, error) {
+	cves := []string{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		catalog := kevCatalog{}
+		if err := json.Unmarshal(trimmed, &catalog); err != nil {
+			return nil, fmt.Errorf("error parsing known exploited vulnerabilities catalog: %v", err)
+		}
+		for _, v := range catalog.Vulnerabilities {
+			if v.CVEID != "" {
+				cves = append(cves, v.CVEID)
+			}
+		}
+		return cves, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cves = append(cves, line)
+	}
+	return cves, scanner.Err()
+}
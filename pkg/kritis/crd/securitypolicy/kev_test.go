@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestParseKnownExploitedCVEs(t *testing.T) {
+	var tests = []struct {
+		name      string
+		data      string
+		expected  []string
+		shouldErr bool
+	}{
+		{
+			name: "cisa catalog",
+			data: `{
+  "title": "CISA Catalog of Known Exploited Vulnerabilities",
+  "vulnerabilities": [
+    {"cveID": "CVE-2021-44228", "vendorProject": "Apache"},
+    {"cveID": "CVE-2017-5638", "vendorProject": "Apache"}
+  ]
+}`,
+			expected: []string{"CVE-2021-44228", "CVE-2017-5638"},
+		},
+		{
+			name:     "one per line",
+			data:     "# log4shell\nCVE-2021-44228\n\n  CVE-2017-5638  \n",
+			expected: []string{"CVE-2021-44228", "CVE-2017-5638"},
+		},
+		{
+			name:     "empty",
+			data:     "",
+			expected: []string{},
+		},
+		{
+			name:      "invalid catalog",
+			data:      `{"vulnerabilities": 1}`,
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cves, err := ParseKnownExploitedCVEs([]byte(test.data))
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, cves)
+		})
+	}
+}
+
+func TestIsKnownExploited(t *testing.T) {
+	SetKnownExploitedCVEs([]string{"CVE-2021-44228"})
+	defer SetKnownExploitedCVEs(nil)
+	var tests = []struct {
+		cve      string
+		expected bool
+	}{
+		{"CVE-2021-44228", true},
+		{"providers/goog-vulnz/notes/CVE-2021-44228", true},
+		{"providers/goog-vulnz/notes/CVE-2017-5638", false},
+	}
+	for _, test := range tests {
+		t.Run(test.cve, func(t *testing.T) {
+			if actual := isKnownExploited(test.cve); actual != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/sirupsen/logrus"
+)
+
+// opaTimeout is how long an OPA decision may take, well within the
+// admission webhook's timeout
+const opaTimeout = 2 * time.Second
+
+// opaPolicyModule is the name the OPA policy is compiled as
+const opaPolicyModule = "policy.rego"
+
+// opaDecisionPattern matches the paths of rules, such as kritis/deny
+var opaDecisionPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(/[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// opaCompiler is the compiled OPA policy, whose decisions policies with an
+// OPADecision query
+var (
+	opaMu       sync.RWMutex
+	opaCompiler *ast.Compiler
+)
+
+// CompileOPAPolicy parses and compiles the Rego module policy
+func CompileOPAPolicy(policy string) (*ast.Compiler, error) {
+	module, err := ast.ParseModule(opaPolicyModule, policy)
+	if err != nil {
+		return nil, err
+	}
+	if module == nil {
+		return nil, fmt.Errorf("OPA policy has no package")
+	}
+	compiler := ast.NewCompiler()
+	if compiler.Compile(map[string]*ast.Module{opaPolicyModule: module}); compiler.Failed() {
+		return nil, compiler.Errors
+	}
+	return compiler, nil
+}
+
+// SetOPAPolicy sets the Rego module, such as testdata/opa/policy.rego, whose
+// decisions policies with an OPADecision delegate to. An invalid policy is
+// logged and unsets it.
+func SetOPAPolicy(policy string) {
+	var compiler *ast.Compiler
+	if policy != "" {
+		var err error
+		if compiler, err = CompileOPAPolicy(policy); err != nil {
+			logrus.Errorf("error compiling OPA policy: %v", err)
+		}
+	}
+	opaMu.Lock()
+	defer opaMu.Unlock()
+	opaCompiler = compiler
+}
+
+// ValidateOPADecision returns an error if decision isn't the path of a rule,
+// such as kritis/deny
+func ValidateOPADecision(decision string) error {
+	if !opaDecisionPattern.MatchString(strings.Trim(decision, "/")) {
+		return fmt.Errorf("OPA decision %q must be the path of a rule, such as kritis/deny", decision)
+	}
+	return nil
+}
+
+// OPAInput is the input of the OPA decision about an image
+type OPAInput struct {
+	Image string `json:"image"`
+	// Policy is the namespace/name of the ImageSecurityPolicy delegating
+	// the decision
+	Policy          string             `json:"policy"`
+	Vulnerabilities []OPAVulnerability `json:"vulnerabilities"`
+	Builds          []OPABuild         `json:"builds"`
+}
+
+// OPAVulnerability is a vulnerability of the image in an OPAInput
+type OPAVulnerability struct {
+	CVE          string `json:"cve"`
+	Severity     string `json:"severity"`
+	FixAvailable bool   `json:"fixAvailable"`
+}
+
+// OPABuild is the build provenance of the image in an OPAInput
+type OPABuild struct {
+	Creator   string `json:"creator"`
+	SLSALevel int    `json:"slsaLevel"`
+	SourceURI string `json:"sourceUri"`
+}
+
+// opaViolations returns the violations of image according to the OPA
+// decision of isp. The decision must be a set of messages, such as those of
+// a deny[msg] rule, each of which is a violation.
+func opaViolations(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	if err := ValidateOPADecision(isp.Spec.OPADecision); err != nil {
+		return nil, err
+	}
+	opaMu.RLock()
+	compiler := opaCompiler
+	opaMu.RUnlock()
+	if compiler == nil {
+		return nil, fmt.Errorf("image security policy %s/%s has an OPA decision, but no OPA policy is configured", isp.Namespace, isp.Name)
+	}
+	input, err := opaInput(isp, image, client)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.Replace(strings.Trim(isp.Spec.OPADecision, "/"), "/", ".", -1)
+	ctx, cancel := context.WithTimeout(context.Background(), opaTimeout)
+	defer cancel()
+	rs, err := rego.New(
+		rego.Compiler(compiler),
+		rego.Query("data."+path),
+		rego.Input(input),
+	).Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating OPA decision %s: %v", isp.Spec.OPADecision, err)
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, fmt.Errorf("OPA decision %s is undefined", isp.Spec.OPADecision)
+	}
+	messages, ok := rs[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("OPA decision %s is not a set of messages", isp.Spec.OPADecision)
+	}
+	var violations []SecurityPolicyViolation
+	for _, m := range messages {
+		message, ok := m.(string)
+		if !ok {
+			return nil, fmt.Errorf("OPA decision %s is not a set of messages", isp.Spec.OPADecision)
+		}
+		violations = append(violations, SecurityPolicyViolation{
+			Violation: OPAViolation,
+			Reason:    OPAViolationReason(image, message),
+		})
+	}
+	return violations, nil
+}
+
+// opaInput returns the input of the OPA decision of isp about image
+func opaInput(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) (OPAInput, error) {
+	input := OPAInput{
+		Image:           image,
+		Policy:          fmt.Sprintf("%s/%s", isp.Namespace, isp.Name),
+		Vulnerabilities: []OPAVulnerability{},
+		Builds:          []OPABuild{},
+	}
+	vulnz, err := client.GetVulnerabilities(image)
+	if err != nil {
+		return OPAInput{}, err
+	}
+	for _, v := range vulnz {
+		v = withSeverityOverride(v)
+		input.Vulnerabilities = append(input.Vulnerabilities, OPAVulnerability{
+			CVE:          v.CVE,
+			Severity:     v.Severity,
+			FixAvailable: v.HasFixAvailable,
+		})
+	}
+	builds, err := client.GetBuildDetails(image)
+	if err != nil {
+		return OPAInput{}, err
+	}
+	for _, b := range builds {
+		input.Builds = append(input.Builds, OPABuild{
+			Creator:   b.Creator,
+			SLSALevel: b.SLSALevel,
+			SourceURI: b.SourceURI,
+		})
+	}
+	return input, nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/open-policy-agent/opa/rego"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// loadOPAPolicy sets the OPA policy to testdata/opa/policy.rego
+func loadOPAPolicy(t *testing.T) {
+	policy, err := ioutil.ReadFile(filepath.Join("testdata", "opa", "policy.rego"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompileOPAPolicy(string(policy)); err != nil {
+		t.Fatal(err)
+	}
+	SetOPAPolicy(string(policy))
+}
+
+func opaPolicy(decision string) v1beta1.ImageSecurityPolicy {
+	return v1beta1.ImageSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "opa"},
+		Spec:       v1beta1.ImageSecurityPolicySpec{OPADecision: decision},
+	}
+}
+
+var opaMetadata = mockVulnzClient{
+	vulnz: []metadata.Vulnerability{
+		{CVE: "CVE-2024-0001", Severity: "CRITICAL", HasFixAvailable: true},
+		{CVE: "CVE-2024-0002", Severity: "CRITICAL"},
+		{CVE: "CVE-2024-0003", Severity: "LOW", HasFixAvailable: true},
+	},
+}
+
+func TestOPAInput(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "opa", "input.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected OPAInput
+	if err := json.Unmarshal(data, &expected); err != nil {
+		t.Fatal(err)
+	}
+	input, err := opaInput(opaPolicy("kritis/deny"), testutil.QualifiedImage, opaMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(input, expected) {
+		t.Errorf("expected input %+v, got %+v", expected, input)
+	}
+}
+
+func TestOPASamplePolicy(t *testing.T) {
+	policy, err := ioutil.ReadFile(filepath.Join("testdata", "opa", "policy.rego"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "opa", "input.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var input interface{}
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := rego.New(
+		rego.Module("policy.rego", string(policy)),
+		rego.Query("data.kritis.deny"),
+		rego.Input(input),
+	).Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{"CVE-2024-0001 is CRITICAL and has a fix available", "no build provenance"}
+	if len(rs) != 1 || !reflect.DeepEqual(rs[0].Expressions[0].Value, expected) {
+		t.Errorf("expected deny %v, got %v", expected, rs)
+	}
+}
+
+func TestOPAViolations(t *testing.T) {
+	defer SetOPAPolicy("")
+
+	image := testutil.QualifiedImage
+	tests := []struct {
+		name      string
+		policy    bool
+		decision  string
+		client    metadata.MetadataFetcher
+		shouldErr bool
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:     "denied by sample policy",
+			policy:   true,
+			decision: "kritis/deny",
+			client:   opaMetadata,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: OPAViolation,
+					Reason:    OPAViolationReason(image, "CVE-2024-0001 is CRITICAL and has a fix available"),
+				},
+				{
+					Violation: OPAViolation,
+					Reason:    OPAViolationReason(image, "no build provenance"),
+				},
+			},
+		},
+		{
+			name:     "allowed by sample policy",
+			policy:   true,
+			decision: "/kritis/deny",
+			client: mockVulnzClient{
+				builds: []metadata.Build{{Creator: "ci@example.com", SLSALevel: 3}},
+			},
+		},
+		{
+			name:      "undefined decision",
+			policy:    true,
+			decision:  "kritis/allow",
+			client:    opaMetadata,
+			shouldErr: true,
+		},
+		{
+			name:      "decision isn't a set of messages",
+			policy:    true,
+			decision:  "kritis",
+			client:    opaMetadata,
+			shouldErr: true,
+		},
+		{
+			name:      "decision isn't a rule path",
+			policy:    true,
+			decision:  "kritis/deny?pretty=true",
+			client:    opaMetadata,
+			shouldErr: true,
+		},
+		{
+			name:      "no OPA policy",
+			decision:  "kritis/deny",
+			client:    opaMetadata,
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetOPAPolicy("")
+			if test.policy {
+				loadOPAPolicy(t)
+			}
+			violations, err := ValidateImageSecurityPolicy(opaPolicy(test.decision), image, test.client)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
+func TestValidateOPADecision(t *testing.T) {
+	for _, decision := range []string{"kritis/deny", "/kritis/deny", "kritis", "a_b/c1"} {
+		if err := ValidateOPADecision(decision); err != nil {
+			t.Errorf("expected %q to be valid: %v", decision, err)
+		}
+	}
+	for _, decision := range []string{"", "kritis.deny", "kritis/../deny", "kritis//deny", "kritis/deny?x=1", "1kritis/deny"} {
+		if err := ValidateOPADecision(decision); err == nil {
+			t.Errorf("expected %q to be invalid", decision)
+		}
+	}
+}
+
+func TestCompileOPAPolicy(t *testing.T) {
+	for _, policy := range []string{"", "deny[msg] { msg := 1 }", "package kritis\ndeny[msg] { msg := undefined_function(1) }"} {
+		if _, err := CompileOPAPolicy(policy); err == nil {
+			t.Errorf("expected OPA policy %q to be invalid", policy)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	kritisclient "github.com/grafeas/kritis/pkg/kritis/client/clientset/versioned/typed/kritis/v1beta1"
+	listers "github.com/grafeas/kritis/pkg/kritis/client/listers/kritis/v1beta1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// policyCacheResync is how often the informer re-delivers every cached ISP
+const policyCacheResync = 10 * time.Minute
+
+// listBackoff retries listing ISPs from the API server while the cache
+// isn't synced yet
+var listBackoff = wait.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
+}
+
+// PolicyCache serves ISPs from a local cache, indexed by namespace, which an
+// informer keeps up to date by watching the API server, so that admissions
+// don't each list them. Until the cache is synced, ISPs are listed from the
+// API server, retrying with exponential backoff.
+type PolicyCache struct {
+	client   kritisclient.ImageSecurityPoliciesGetter
+	informer cache.SharedIndexInformer
+	lister   listers.ImageSecurityPolicyLister
+}
+
+// NewPolicyCache returns a PolicyCache of the ISPs in all namespaces which
+// client gets, e.g. a clientset's KritisV1beta1(). It is empty until it is Run.
+func NewPolicyCache(client kritisclient.ImageSecurityPoliciesGetter) *PolicyCache {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.ImageSecurityPolicies(metav1.NamespaceAll).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.ImageSecurityPolicies(metav1.NamespaceAll).Watch(options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &v1beta1.ImageSecurityPolicy{}, policyCacheResync, cache.Indexers{
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+	})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    logInvalidPolicy,
+		UpdateFunc: func(_, obj interface{}) { logInvalidPolicy(obj) },
+	})
+	return &PolicyCache{
+		client:   client,
+		informer: informer,
+		lister:   listers.NewImageSecurityPolicyLister(informer.GetIndexer()),
+	}
+}
+
+// logInvalidPolicy logs obj if it is an ISP which validatePolicy rejects, as
+// it is cached, since listing the cache ignores it
+func logInvalidPolicy(obj interface{}) {
+	isp, ok := obj.(*v1beta1.ImageSecurityPolicy)
+	if !ok {
+		return
+	}
+	if err := validatePolicy(*isp); err != nil {
+		logrus.Errorf("ignoring image security policy %s/%s: %v", isp.Namespace, isp.Name, err)
+	}
+}
+
+// NewInClusterPolicyCache returns a PolicyCache of the ISPs of the cluster
+// kritis runs in
+func NewInClusterPolicyCache() (*PolicyCache, error) {
+	client, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicyCache(client.KritisV1beta1()), nil
+}
+
+// Run fills the cache and keeps it up to date until stop is closed
+func (c *PolicyCache) Run(stop <-chan struct{}) {
+	c.informer.Run(stop)
+}
+
+// HasSynced returns true once the cache was filled
+func (c *PolicyCache) HasSynced() bool {
+	return c.informer.HasSynced()
+}
+
+// ImageSecurityPolicies returns the ISPs in namespace, or in all namespaces
+// if it is empty, like the package function of the same name
+func (c *PolicyCache) ImageSecurityPolicies(namespace string) ([]v1beta1.ImageSecurityPolicy, error) {
+	if !c.HasSynced() {
+		logrus.Debug("image security policy cache isn't synced, listing them")
+		return c.list(namespace)
+	}
+	var cached []*v1beta1.ImageSecurityPolicy
+	var err error
+	if namespace == metav1.NamespaceAll {
+		cached, err = c.lister.List(labels.Everything())
+	} else {
+		cached, err = c.lister.ImageSecurityPolicies(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing cached image security policies: %v", err)
+	}
+	isps := make([]v1beta1.ImageSecurityPolicy, 0, len(cached))
+	for _, isp := range cached {
+		// Invalid policies were logged when they were cached
+		if validatePolicy(*isp) != nil {
+			continue
+		}
+		isps = append(isps, *isp.DeepCopy())
+	}
+	// List in the order of the API server
+	sort.Slice(isps, func(i, j int) bool {
+		if isps[i].Namespace != isps[j].Namespace {
+			return isps[i].Namespace < isps[j].Namespace
+		}
+		return isps[i].Name < isps[j].Name
+	})
+	return isps, nil
+}
+
+// list returns the ISPs in namespace from the API server, retrying with
+// exponential backoff
+func (c *PolicyCache) list(namespace string) ([]v1beta1.ImageSecurityPolicy, error) {
+	var list *v1beta1.ImageSecurityPolicyList
+	var lastErr error
+	err := wait.ExponentialBackoff(listBackoff, func() (bool, error) {
+		list, lastErr = c.client.ImageSecurityPolicies(namespace).List(metav1.ListOptions{})
+		if lastErr != nil {
+			logrus.Warnf("error listing image security policies, retrying: %v", lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing all image policy requirements: %v", lastErr)
+	}
+	return validPolicies(list.Items), nil
+}
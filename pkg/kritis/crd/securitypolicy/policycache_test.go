@@ -0,0 +1,190 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	kritisclient "github.com/grafeas/kritis/pkg/kritis/client/clientset/versioned/typed/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// fakePolicyAPI is an API server serving isps, which counts its calls
+type fakePolicyAPI struct {
+	mu       sync.Mutex
+	isps     []v1beta1.ImageSecurityPolicy
+	lists    int
+	watches  int
+	failures int
+	watcher  *watch.FakeWatcher
+}
+
+func newFakePolicyAPI(isps ...v1beta1.ImageSecurityPolicy) *fakePolicyAPI {
+	return &fakePolicyAPI{isps: isps, watcher: watch.NewFake()}
+}
+
+func (a *fakePolicyAPI) ImageSecurityPolicies(namespace string) kritisclient.ImageSecurityPolicyInterface {
+	return fakePolicies{api: a, namespace: namespace}
+}
+
+func (a *fakePolicyAPI) calls() (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lists, a.watches
+}
+
+// fakePolicies implements List and Watch of the ISPs in a namespace
+type fakePolicies struct {
+	kritisclient.ImageSecurityPolicyInterface
+	api       *fakePolicyAPI
+	namespace string
+}
+
+func (p fakePolicies) List(opts metav1.ListOptions) (*v1beta1.ImageSecurityPolicyList, error) {
+	p.api.mu.Lock()
+	defer p.api.mu.Unlock()
+	p.api.lists++
+	if p.api.failures > 0 {
+		p.api.failures--
+		return nil, fmt.Errorf("connection refused")
+	}
+	list := &v1beta1.ImageSecurityPolicyList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+	for _, isp := range p.api.isps {
+		if p.namespace == "" || isp.Namespace == p.namespace {
+			list.Items = append(list.Items, isp)
+		}
+	}
+	return list, nil
+}
+
+func (p fakePolicies) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	p.api.mu.Lock()
+	defer p.api.mu.Unlock()
+	p.api.watches++
+	return p.api.watcher, nil
+}
+
+func testPolicy(namespace, name string) v1beta1.ImageSecurityPolicy {
+	return v1beta1.ImageSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: "1"},
+	}
+}
+
+func policyNames(isps []v1beta1.ImageSecurityPolicy) []string {
+	names := []string{}
+	for _, isp := range isps {
+		names = append(names, fmt.Sprintf("%s/%s", isp.Namespace, isp.Name))
+	}
+	return names
+}
+
+func runSyncedPolicyCache(t *testing.T, api *fakePolicyAPI, stop chan struct{}) *PolicyCache {
+	c := NewPolicyCache(api)
+	go c.Run(stop)
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		return c.HasSynced(), nil
+	}); err != nil {
+		t.Fatal("policy cache didn't sync")
+	}
+	return c
+}
+
+func Test_PolicyCacheDoesntCallAPIOnceSynced(t *testing.T) {
+	api := newFakePolicyAPI(testPolicy("default", "b"), testPolicy("default", "a"), testPolicy("prod", "c"))
+	stop := make(chan struct{})
+	defer close(stop)
+	c := runSyncedPolicyCache(t, api, stop)
+	lists, watches := api.calls()
+	for i := 0; i < 5; i++ {
+		isps, err := c.ImageSecurityPolicies("default")
+		testutil.CheckErrorAndDeepEqual(t, false, err, []string{"default/a", "default/b"}, policyNames(isps))
+	}
+	isps, err := c.ImageSecurityPolicies("")
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{"default/a", "default/b", "prod/c"}, policyNames(isps))
+	afterLists, afterWatches := api.calls()
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []int{lists, watches}, []int{afterLists, afterWatches})
+}
+
+func Test_PolicyCacheWatchesChanges(t *testing.T) {
+	api := newFakePolicyAPI(testPolicy("default", "a"))
+	stop := make(chan struct{})
+	defer close(stop)
+	c := runSyncedPolicyCache(t, api, stop)
+	added := testPolicy("default", "b")
+	added.ResourceVersion = "2"
+	api.watcher.Add(&added)
+	var names []string
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		isps, err := c.ImageSecurityPolicies("default")
+		names = policyNames(isps)
+		return len(names) == 2, err
+	}); err != nil {
+		t.Fatalf("added policy wasn't cached, got %v", names)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"default/a", "default/b"}, names)
+	deleted := testPolicy("default", "a")
+	deleted.ResourceVersion = "3"
+	api.watcher.Delete(&deleted)
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		isps, err := c.ImageSecurityPolicies("default")
+		names = policyNames(isps)
+		return len(names) == 1, err
+	}); err != nil {
+		t.Fatalf("deleted policy is still cached, got %v", names)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"default/b"}, names)
+}
+
+func Test_PolicyCacheListsUntilSynced(t *testing.T) {
+	original := listBackoff
+	defer func() {
+		listBackoff = original
+	}()
+	listBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+	api := newFakePolicyAPI(testPolicy("default", "a"))
+	api.failures = 2
+	c := NewPolicyCache(api)
+	isps, err := c.ImageSecurityPolicies("default")
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{"default/a"}, policyNames(isps))
+	lists, _ := api.calls()
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 3, lists)
+
+	api.failures = 3
+	_, err = c.ImageSecurityPolicies("default")
+	testutil.CheckError(t, true, err)
+}
+
+func Test_PolicyCacheIgnoresInvalidPolicies(t *testing.T) {
+	invalid := testPolicy("default", "invalid")
+	invalid.Spec.PackageVulernerabilityRequirements.VulnerabilityFilter = "severity >="
+	api := newFakePolicyAPI(testPolicy("default", "a"), invalid)
+	isps, err := NewPolicyCache(api).ImageSecurityPolicies("default")
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{"default/a"}, policyNames(isps))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	c := runSyncedPolicyCache(t, api, stop)
+	isps, err = c.ImageSecurityPolicies("default")
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{"default/a"}, policyNames(isps))
+}
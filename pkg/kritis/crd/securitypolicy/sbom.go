@@ -0,0 +1,70 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"path"
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/sbom"
+	"github.com/sirupsen/logrus"
+)
+
+// sbomDatabase is the vulnerability database the SBOMs of images are
+// cross-referenced against by policies with EvaluateSBOM
+var (
+	sbomDatabaseMu sync.RWMutex
+	sbomDatabase   *sbom.Database
+)
+
+// SetSBOMDatabase sets the vulnerability database SBOM components are
+// cross-referenced against
+func SetSBOMDatabase(d *sbom.Database) {
+	sbomDatabaseMu.Lock()
+	defer sbomDatabaseMu.Unlock()
+	sbomDatabase = d
+}
+
+// withSBOMVulnerabilities adds to vulnz, the vulnerabilities the scanner
+// reported in image, those of the components of its SBOM which the scanner
+// didn't report
+func withSBOMVulnerabilities(image string, vulnz []metadata.Vulnerability) ([]metadata.Vulnerability, error) {
+	components, err := sbomComponents(image)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		logrus.Debugf("%s has no SBOM attached", image)
+		return vulnz, nil
+	}
+	sbomDatabaseMu.RLock()
+	found := sbomDatabase.Vulnerabilities(components)
+	sbomDatabaseMu.RUnlock()
+	reported := map[string]bool{}
+	for _, v := range vulnz {
+		reported[path.Base(v.CVE)] = true
+	}
+	for _, v := range found {
+		if reported[v.CVE] {
+			continue
+		}
+		logrus.Debugf("found CVE %s in the SBOM of %s", v.CVE, image)
+		vulnz = append(vulnz, v)
+	}
+	return vulnz, nil
+}
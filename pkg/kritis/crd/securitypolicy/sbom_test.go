@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/sbom"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func Test_EvaluateSBOM(t *testing.T) {
+	db, err := sbom.ParseDatabase([]byte(`[
+  {"package": "pkg:npm/lodash", "versions": ["4.17.20"], "cve": "CVE-2021-23337", "severity": "HIGH", "fixAvailable": true}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSBOMDatabase(db)
+	defer SetSBOMDatabase(nil)
+	lodash := []sbom.Component{
+		{Name: "express", Version: "4.17.1", PURL: "pkg:npm/express@4.17.1"},
+		{Name: "lodash", Version: "4.17.20", PURL: "pkg:npm/lodash@4.17.20"},
+	}
+	found := metadata.Vulnerability{CVE: "CVE-2021-23337", Severity: "HIGH", HasFixAvailable: true}
+	scanned := metadata.Vulnerability{CVE: "projects/goog-vulnz/notes/CVE-2021-23337", Severity: "HIGH", HasFixAvailable: true}
+	isp := v1beta1.ImageSecurityPolicy{
+		Spec: v1beta1.ImageSecurityPolicySpec{
+			PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+				MaximumSeverity: "MEDIUM",
+			},
+		},
+	}
+	var tests = []struct {
+		name       string
+		evaluate   bool
+		components []sbom.Component
+		// reported are the vulnerabilities the scanner reported
+		reported []metadata.Vulnerability
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:       "sbom not evaluated",
+			components: lodash,
+		},
+		{
+			name:       "vulnerable component missed by the scanner",
+			evaluate:   true,
+			components: lodash,
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: found,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, found, isp),
+				},
+			},
+		},
+		{
+			name:       "vulnerability also reported by the scanner",
+			evaluate:   true,
+			components: lodash,
+			reported:   []metadata.Vulnerability{scanned},
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: scanned,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, scanned, isp),
+				},
+			},
+		},
+		{
+			name:     "no sbom attached",
+			evaluate: true,
+		},
+	}
+	original := sbomComponents
+	defer func() {
+		sbomComponents = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sbomComponents = func(image string) ([]sbom.Component, error) {
+				return test.components, nil
+			}
+			isp.Spec.EvaluateSBOM = test.evaluate
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{vulnz: test.reported})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
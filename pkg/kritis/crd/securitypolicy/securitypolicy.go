@@ -18,43 +18,171 @@ package securitypolicy
 
 import (
 	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
 	clientset "github.com/grafeas/kritis/pkg/kritis/client/clientset/versioned"
 	"github.com/grafeas/kritis/pkg/kritis/constants"
 	"github.com/grafeas/kritis/pkg/kritis/kubectl/plugins/resolve"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/notary"
+	"github.com/grafeas/kritis/pkg/kritis/sbom"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+	"github.com/sirupsen/logrus"
 	ca "google.golang.org/genproto/googleapis/devtools/containeranalysis/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
 )
 
+// For testing
+var (
+	now             = time.Now
+	verifySignature = notary.Verify
+	imageSize       = util.ImageSize
+	imageLayers     = util.ImageLayerCount
+	imageUser       = util.ImageUser
+	imageLabels     = util.ImageLabels
+	exposedPorts    = util.ImageExposedPorts
+	sbomComponents  = sbom.Fetch
+)
+
 // ImageSecurityPolicies returns all ISP's in the specified namespaces
 // Pass in an empty string to get all ISPs in all namespaces
 func ImageSecurityPolicies(namespace string) ([]v1beta1.ImageSecurityPolicy, error) {
+	client, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	list, err := client.KritisV1beta1().ImageSecurityPolicies(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing all image policy requirements: %v", err)
+	}
+	return validPolicies(list.Items), nil
+}
+
+// WatchImageSecurityPolicies starts a watch on ISPs in all namespaces
+func WatchImageSecurityPolicies() (watch.Interface, error) {
+	client, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	w, err := client.KritisV1beta1().ImageSecurityPolicies("").Watch(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error watching image security policies: %v", err)
+	}
+	return w, nil
+}
+
+func inClusterClient() (clientset.Interface, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("error building config: %v", err)
 	}
-
 	client, err := clientset.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("error building clientset: %v", err)
 	}
-	list, err := client.KritisV1beta1().ImageSecurityPolicies(namespace).List(metav1.ListOptions{})
+	return client, nil
+}
+
+// UpdateImageSecurityPolicy updates isp in its namespace
+func UpdateImageSecurityPolicy(isp v1beta1.ImageSecurityPolicy) error {
+	client, err := inClusterClient()
 	if err != nil {
-		return nil, fmt.Errorf("error listing all image policy requirements: %v", err)
+		return err
+	}
+	if _, err := client.KritisV1beta1().ImageSecurityPolicies(isp.Namespace).Update(&isp); err != nil {
+		return fmt.Errorf("error updating image security policy %s/%s: %v", isp.Namespace, isp.Name, err)
+	}
+	return nil
+}
+
+// Sort orders isps by descending priority and then by name, which is the
+// order they are evaluated in
+func Sort(isps []v1beta1.ImageSecurityPolicy) {
+	sort.SliceStable(isps, func(i, j int) bool {
+		if isps[i].Spec.Priority != isps[j].Spec.Priority {
+			return isps[i].Spec.Priority > isps[j].Spec.Priority
+		}
+		if isps[i].Namespace != isps[j].Namespace {
+			return isps[i].Namespace < isps[j].Namespace
+		}
+		return isps[i].Name < isps[j].Name
+	})
+}
+
+// ValidateOption changes how ValidateImageSecurityPolicy validates an image
+type ValidateOption func(*validateOptions)
+
+type validateOptions struct {
+	stopAtFirstViolation bool
+	requestRescans       bool
+}
+
+// StopAtFirstViolation stops validating the vulnerabilities of an image once
+// it violates the policy. If the client is a metadata.VulnerabilityStreamer,
+// the pages of vulnerabilities after that aren't fetched, so only the
+// violations found until then are returned. It suits admission decisions,
+// which only need to know whether the image is denied.
+func StopAtFirstViolation() ValidateOption {
+	return func(o *validateOptions) {
+		o.stopAtFirstViolation = true
+	}
+}
+
+// RequestRescans asks the metadata backend to scan images again whose
+// metadata is missing or stale, if the policy sets RescanAfter. Callers which
+// must not have side effects, such as explaining a decision, don't pass it.
+func RequestRescans() ValidateOption {
+	return func(o *validateOptions) {
+		o.requestRescans = true
 	}
-	return list.Items, nil
 }
 
 // ValidateImageSecurityPolicy checks if an image satisfies ISP requirements
-// It returns a list of vulnerabilites that don't pass
-func ValidateImageSecurityPolicy(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
-	// First, check if image is whitelisted
+// It returns a list of vulnerabilites that don't pass.
+func ValidateImageSecurityPolicy(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, opts ...ValidateOption) ([]SecurityPolicyViolation, error) {
+	var o validateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// First, skip images the policy doesn't validate
+	if !Governs(isp, image) {
+		return nil, nil
+	}
+	// Next, deny images with malware, whether or not they are whitelisted
+	if isp.Spec.DenyMalware {
+		fetcher, ok := client.(metadata.MalwareFetcher)
+		if !ok {
+			return nil, fmt.Errorf("image security policy %s denies malware, but the metadata backend doesn't scan for it", isp.Name)
+		}
+		malware, err := fetcher.GetMalwareFindings(image)
+		if err != nil {
+			return nil, err
+		}
+		if len(malware) != 0 {
+			violations := []SecurityPolicyViolation{}
+			for _, m := range malware {
+				violations = append(violations, SecurityPolicyViolation{
+					Violation: MalwareViolation,
+					Reason:    MalwareViolationReason(image, m),
+				})
+			}
+			return violations, nil
+		}
+	}
+	// Next, check if image is whitelisted
 	if imageInWhitelist(isp, image) {
 		return nil, nil
 	}
+	// Next, check the image is referenced by digest, if required
+	if v := ValidateImageReference(isp, image); v != nil {
+		return v, nil
+	}
 	var violations []SecurityPolicyViolation
 	// Next, check if image in qualified
 	if !resolve.FullyQualifiedImage(image) {
@@ -64,55 +192,910 @@ func ValidateImageSecurityPolicy(isp v1beta1.ImageSecurityPolicy, image string,
 		})
 		return violations, nil
 	}
+	// Next, check the image is signed with Docker Content Trust
+	if trust := isp.Spec.RequireNotarySignature; trust != nil {
+		if err := verifySignature(trust.Server, trust.PublicKeyData, image); err != nil {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: MissingSignatureViolation,
+				Reason:    MissingSignatureViolationReason(image, err),
+			})
+			return violations, nil
+		}
+	}
+	// Next, check the image isn't too large
+	if maxSize := isp.Spec.MaxImageSizeBytes; maxSize > 0 {
+		size, err := imageSize(image)
+		if err != nil {
+			return nil, fmt.Errorf("error getting size of %s: %v", image, err)
+		}
+		if size > maxSize {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: ExceedsMaxImageSizeViolation,
+				Reason:    ExceedsMaxImageSizeViolationReason(image, size, maxSize),
+			})
+		}
+	}
+	// Next, check the image doesn't have too many layers
+	if maxLayers := isp.Spec.MaxLayers; maxLayers > 0 {
+		layers, err := imageLayers(image)
+		if err != nil {
+			return nil, fmt.Errorf("error getting layers of %s: %v", image, err)
+		}
+		if layers > maxLayers {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: ExceedsMaxLayersViolation,
+				Reason:    ExceedsMaxLayersViolationReason(image, layers, maxLayers),
+			})
+		}
+	}
+	// Next, check the image doesn't run as root. Pods can still run it as
+	// non-root, which the caller checks.
+	if isp.Spec.RequireNonRootImage {
+		user, err := imageUser(image)
+		if err != nil {
+			return nil, fmt.Errorf("error getting config of %s: %v", image, err)
+		}
+		if util.IsRootUser(user) {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: RootImageViolation,
+				Reason:    RootImageViolationReason(image, user),
+			})
+		}
+	}
+	// Next, check the image has the required labels
+	if required := isp.Spec.RequiredImageLabels; len(required) != 0 {
+		labels, err := imageLabels(image)
+		if err != nil {
+			return nil, fmt.Errorf("error getting config of %s: %v", image, err)
+		}
+		missing := []string{}
+		for _, l := range required {
+			if labels[l] == "" {
+				missing = append(missing, l)
+			}
+		}
+		if len(missing) != 0 {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: MissingImageLabelsViolation,
+				Reason:    MissingImageLabelsViolationReason(image, missing),
+			})
+		}
+	}
+	// Next, check the ports the image exposes
+	if len(isp.Spec.DisallowedExposedPorts) != 0 || isp.Spec.MaxExposedPorts > 0 {
+		ports, err := exposedPorts(image)
+		if err != nil {
+			return nil, fmt.Errorf("error getting config of %s: %v", image, err)
+		}
+		violations = append(violations, exposedPortViolations(isp, image, ports)...)
+	}
+	// Next, handle images pinned to a digest no scanner has seen
+	if isp.Spec.UnknownDigestAction != "" && util.IsDigestReference(image) {
+		known, err := client.HasMetadata(image)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			v, err := unknownDigestViolations(isp, image)
+			if err != nil {
+				return nil, err
+			}
+			requestRescan(o, isp, image, client)
+			return append(violations, v...), nil
+		}
+	}
+	// Next, check the image is known to the metadata store at all
+	if isp.Spec.DenyUnknownImages {
+		known, err := client.HasMetadata(image)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			requestRescan(o, isp, image, client)
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: UnknownImageViolation,
+				Reason:    UnknownImageViolationReason(image),
+			})
+			return violations, nil
+		}
+	}
+	// Next, check the latest scan of the image is complete
+	if isp.Spec.RequireCompleteScan {
+		v, err := incompleteScanViolations(image, client)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != 0 {
+			return append(violations, v...), nil
+		}
+	}
+	// Next, check the image was built at the minimum SLSA level
+	if isp.Spec.MinSLSALevel > 0 {
+		v, err := provenanceViolations(isp, image, client)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	// Next, check no secrets were detected in the image
+	if isp.Spec.DenyEmbeddedSecrets {
+		secrets, err := client.GetSecretFindings(image)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range secrets {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: EmbeddedSecretViolation,
+				Reason:    EmbeddedSecretViolationReason(image, s),
+			})
+		}
+	}
+	// Next, check the image isn't based on a disallowed operating system
+	if len(isp.Spec.DisallowedOperatingSystems) != 0 {
+		fetcher, ok := client.(metadata.OperatingSystemFetcher)
+		if !ok {
+			return nil, fmt.Errorf("image security policy %s disallows operating systems, but the metadata backend doesn't know those of images", isp.Name)
+		}
+		systems, err := fetcher.GetOperatingSystems(image)
+		if err != nil {
+			return nil, err
+		}
+		for _, cpe := range systems {
+			if disallowed, ok := disallowedOperatingSystem(isp, cpe); ok {
+				violations = append(violations, SecurityPolicyViolation{
+					Violation: DisallowedOperatingSystemViolation,
+					Reason:    DisallowedOperatingSystemViolationReason(image, cpe, disallowed),
+				})
+			}
+		}
+	}
+	// Next, check the image has the required kinds of metadata, and none of
+	// the denied ones
+	if len(isp.Spec.RequiredNoteKinds) != 0 || len(isp.Spec.DeniedNoteKinds) != 0 {
+		v, err := noteKindViolations(isp, image, client)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	// Delegate the decision on the image's metadata to OPA, if the policy does
+	if isp.Spec.OPADecision != "" {
+		v, err := opaViolations(isp, image, client)
+		if err != nil {
+			return nil, err
+		}
+		return append(violations, v...), nil
+	}
 	// Now, check vulnz in the image
-	vulnz, err := client.GetVulnerabilities(image)
+	filter, err := vulnerabilityFilter(isp)
+	if err != nil {
+		return nil, err
+	}
+	// Vulnerabilities are evaluated a page at a time, so that with
+	// StopAtFirstViolation the pages after the image is denied needn't be
+	// fetched. Evaluating the SBOM or the age of the metadata needs all of
+	// them at once.
+	stream := func(fn func([]metadata.Vulnerability) bool) error {
+		return metadata.StreamVulnerabilities(client, image, fn)
+	}
+	if isp.Spec.EvaluateSBOM || isp.Spec.RescanAfter != nil {
+		vulnz, err := client.GetVulnerabilities(image)
+		if err != nil {
+			return nil, err
+		}
+		if isp.Spec.EvaluateSBOM {
+			if vulnz, err = withSBOMVulnerabilities(image, vulnz); err != nil {
+				return nil, err
+			}
+		}
+		if isp.Spec.RescanAfter != nil {
+			stale, err := metadataStale(isp, image, client, vulnz)
+			if err != nil {
+				return nil, err
+			}
+			if stale {
+				requestRescan(o, isp, image, client)
+			}
+		}
+		stream = func(fn func([]metadata.Vulnerability) bool) error {
+			fn(vulnz)
+			return nil
+		}
+	}
+
+	counts := map[string]int{}
+	var disclosureErr error
+	err = stream(func(page []metadata.Vulnerability) bool {
+		for _, v := range page {
+			v = withSeverityOverride(v)
+			disclosed, err := disclosureTime(isp, v, client)
+			if err != nil {
+				disclosureErr = err
+				return false
+			}
+			violations = append(violations, vulnerabilityViolations(isp, image, filter, v, disclosed, counts)...)
+		}
+		return !o.stopAtFirstViolation || len(violations) == 0 && len(countViolations(isp, image, counts)) == 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	if disclosureErr != nil {
+		return nil, disclosureErr
+	}
+	// Finally, check the number of CVEs in each severity against its cap
+	violations = append(violations, countViolations(isp, image, counts)...)
+	return violations, nil
+}
+
+// vulnerabilityViolations returns the violations of isp by vulnerability v
+// of image, which was disclosed at disclosed if known, counting it in counts
+// by severity unless it's whitelisted or in its grace period
+func vulnerabilityViolations(isp v1beta1.ImageSecurityPolicy, image string, filter VulnerabilityFilter, v metadata.Vulnerability, disclosed time.Time, counts map[string]int) []SecurityPolicyViolation {
+	// First, deny known exploited CVEs whatever their severity, whitelists
+	// and grace period
+	if isp.Spec.DenyKnownExploitedCVEs && isKnownExploited(v.CVE) {
+		return []SecurityPolicyViolation{{
+			Vulnerability: v,
+			Violation:     KnownExploitedViolation,
+			Reason:        KnownExploitedViolationReason(image, v),
+		}}
+	}
+	// Next, check if the vulnerability is whitelisted
+	if cveInWhitelist(isp, image, v.CVE) {
+		return nil
+	}
+	// CVEs with a fix must be patched within the maximum age after their
+	// disclosure, whatever their severity and grace period
+	if maxAge := isp.Spec.PackageVulernerabilityRequirements.MaximumDisclosureAge; maxAge != nil && !disclosed.IsZero() && !now().Before(disclosed.Add(maxAge.Duration)) {
+		return []SecurityPolicyViolation{{
+			Vulnerability: v,
+			Violation:     DisclosureAgeViolation,
+			Reason:        DisclosureAgeViolationReason(image, v, disclosed, maxAge.Duration),
+		}}
+	}
+	// Newly published CVEs only warn until their grace period is over
+	if until, ok := inGracePeriod(isp, v); ok {
+		logrus.Warnf("found CVE %s in %s, which will violate %s once its grace period ends at %s", v.CVE, image, isp.Name, until.Format(time.RFC3339))
+		return nil
+	}
+	counts[v.Severity]++
+	// Check if the vulnerability matches the policy's filter
+	if filter != nil && filter(v) {
+		return []SecurityPolicyViolation{{
+			Vulnerability: v,
+			Violation:     FilterViolation,
+			Reason:        FilterViolationReason(image, v, isp),
+		}}
+	}
+	// Check ifFixesNotAvailable
+	if isp.Spec.PackageVulernerabilityRequirements.OnlyFixesNotAvailable && !v.HasFixAvailable {
+		return []SecurityPolicyViolation{{
+			Vulnerability: v,
+			Violation:     FixesNotAvailableViolation,
+			Reason:        FixesNotAvailableViolationReason(image, v),
+		}}
+	}
+	// Severities with a cap are only checked against it, by countViolations
+	if _, ok := isp.Spec.PackageVulernerabilityRequirements.MaximumCounts[v.Severity]; ok {
+		return nil
+	}
+	// Next, see if the severity is below or at threshold
+	if severityWithinThreshold(isp, v.Severity) {
+		return nil
+	}
+	// Else, it's a CVE in violation
+	return []SecurityPolicyViolation{{
+		Vulnerability: v,
+		Violation:     ExceedsMaxSeverityViolation,
+		Reason:        ExceedsMaxSeverityViolationReason(image, v, isp),
+	}}
+}
+
+// incompleteScanViolations returns a violation if the latest scan of image
+// didn't finish successfully. Without a metadata backend recording scans it
+// can't tell, and returns none.
+func incompleteScanViolations(image string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	fetcher, ok := client.(metadata.DiscoveryFetcher)
+	if !ok {
+		logrus.Warnf("the metadata backend doesn't record scans, so can't check the scan of %s is complete", image)
+		return nil, nil
+	}
+	discovery, err := fetcher.GetDiscovery(image)
 	if err != nil {
 		return nil, err
 	}
+	if discovery != nil && discovery.Complete {
+		return nil, nil
+	}
+	return []SecurityPolicyViolation{{
+		Violation: IncompleteScanViolation,
+		Reason:    IncompleteScanViolationReason(image, discovery),
+	}}, nil
+}
 
+// noteKindViolations returns the violations of the RequiredNoteKinds and
+// DeniedNoteKinds of isp by image
+func noteKindViolations(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	fetcher, ok := client.(metadata.NoteKindFetcher)
+	if !ok {
+		return nil, fmt.Errorf("image security policy %s requires or denies note kinds, but the metadata backend can't list them", isp.Name)
+	}
+	kinds, err := fetcher.GetNoteKinds(image)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, k := range kinds {
+		found[strings.ToUpper(k)] = true
+	}
+	var violations []SecurityPolicyViolation
+	for _, k := range isp.Spec.RequiredNoteKinds {
+		if !found[strings.ToUpper(k)] {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: MissingNoteKindViolation,
+				Reason:    MissingNoteKindViolationReason(image, k),
+			})
+		}
+	}
+	for _, k := range isp.Spec.DeniedNoteKinds {
+		if found[strings.ToUpper(k)] {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: DeniedNoteKindViolation,
+				Reason:    DeniedNoteKindViolationReason(image, k),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// metadataStale returns true if the metadata of image, whose vulnerabilities
+// are vulnz, is missing or older than the RescanAfter of isp. Its age is that
+// of the latest scan of image if the metadata backend records scans, since a
+// rescan finding no new vulnerabilities leaves vulnz as old as they were.
+func metadataStale(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher, vulnz []metadata.Vulnerability) (bool, error) {
+	if fetcher, ok := client.(metadata.DiscoveryFetcher); ok {
+		discovery, err := fetcher.GetDiscovery(image)
+		if err != nil {
+			return false, err
+		}
+		if discovery != nil && !discovery.Time.IsZero() {
+			return now().Sub(discovery.Time) > isp.Spec.RescanAfter.Duration, nil
+		}
+	}
+	if len(vulnz) == 0 {
+		known, err := client.HasMetadata(image)
+		return !known, err
+	}
+	var newest time.Time
 	for _, v := range vulnz {
-		// First, check if the vulnerability is whitelisted
-		if cveInWhitelist(isp, v.CVE) {
+		if v.CreateTime.After(newest) {
+			newest = v.CreateTime
+		}
+	}
+	// Without creation times the age of the metadata is unknown
+	if newest.IsZero() {
+		return false, nil
+	}
+	return now().Sub(newest) > isp.Spec.RescanAfter.Duration, nil
+}
+
+// requestRescan asks the metadata backend to scan image again, if o requests
+// rescans, isp sets RescanAfter and the backend supports it. Failing to
+// doesn't fail validation.
+func requestRescan(o validateOptions, isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) {
+	if !o.requestRescans || isp.Spec.RescanAfter == nil {
+		return
+	}
+	rescanner, ok := client.(metadata.Rescanner)
+	if !ok {
+		logrus.Debugf("metadata of %s is stale, but the metadata backend can't rescan it", image)
+		return
+	}
+	logrus.Infof("metadata of %s is stale, requesting a rescan", image)
+	if err := rescanner.RequestRescan(image); err != nil {
+		logrus.Warnf("error requesting a rescan of %s: %v", image, err)
+	}
+}
+
+// unknownDigestViolations returns the violations of image, which is pinned to
+// a digest without any metadata, according to isp's UnknownDigestAction
+func unknownDigestViolations(isp v1beta1.ImageSecurityPolicy, image string) ([]SecurityPolicyViolation, error) {
+	switch isp.Spec.UnknownDigestAction {
+	case v1beta1.UnknownDigestAllow:
+		logrus.Infof("no metadata found for %s, allowing it as the policy allows unknown digests", image)
+		return nil, nil
+	case v1beta1.UnknownDigestDeny:
+		return []SecurityPolicyViolation{{
+			Violation: UnknownDigestViolation,
+			Reason:    UnknownDigestViolationReason(image),
+		}}, nil
+	case v1beta1.UnknownDigestRequireAttestation:
+		return []SecurityPolicyViolation{{
+			Violation: MissingAttestationViolation,
+			Reason:    UnattestedUnknownDigestViolationReason(image),
+		}}, nil
+	}
+	return nil, fmt.Errorf("invalid unknownDigestAction %q in image security policy %s/%s", isp.Spec.UnknownDigestAction, isp.Namespace, isp.Name)
+}
+
+// ValidateImageReference checks if image, as a pod references it, satisfies
+// the ISP requirements on image references.
+// ValidateImageSecurityPolicy checks them too, but callers which resolve
+// images before validating them must check the references they resolved.
+func ValidateImageReference(isp v1beta1.ImageSecurityPolicy, image string) []SecurityPolicyViolation {
+	if !isp.Spec.RequireDigestReference || !Governs(isp, image) || imageInWhitelist(isp, image) || util.IsDigestReference(image) {
+		return nil
+	}
+	return []SecurityPolicyViolation{{
+		Violation: TagReferenceViolation,
+		Reason:    TagReferenceViolationReason(image),
+	}}
+}
+
+// ValidateInitContainerImage checks if image, as an init container of a pod
+// references it, satisfies the ISP requirements on init container images.
+// Callers must check them, as ValidateImageSecurityPolicy doesn't know which
+// containers run image.
+func ValidateInitContainerImage(isp v1beta1.ImageSecurityPolicy, image string) []SecurityPolicyViolation {
+	allowed := isp.Spec.AllowedInitContainerRegistries
+	if len(allowed) == 0 || imageInWhitelist(isp, image) || util.InRegistries(image, allowed) {
+		return nil
+	}
+	return []SecurityPolicyViolation{{
+		Violation: InitContainerRegistryViolation,
+		Reason:    InitContainerRegistryViolationReason(image, allowed),
+	}}
+}
+
+// ValidateTenantImages checks if images, which a pod in namespace runs, are
+// all from the namespace's tenant according to the ISP. Callers must check
+// it, as ValidateImageSecurityPolicy doesn't know the namespace of the pod.
+func ValidateTenantImages(isp v1beta1.ImageSecurityPolicy, namespace string, images []string) []SecurityPolicyViolation {
+	if isp.Spec.TenantRegistryPrefix == "" {
+		return nil
+	}
+	prefix := strings.Replace(isp.Spec.TenantRegistryPrefix, "{namespace}", namespace, -1)
+	var violations []SecurityPolicyViolation
+	for _, image := range images {
+		if imageInWhitelist(isp, image) || util.CheckGlobalWhitelist([]string{image}) || util.InRegistries(image, []string{prefix}) {
 			continue
 		}
-		// Check ifFixesNotAvailable
-		if isp.Spec.PackageVulernerabilityRequirements.OnlyFixesNotAvailable && !v.HasFixAvailable {
+		violations = append(violations, SecurityPolicyViolation{
+			Violation: CrossTenantImageViolation,
+			Reason:    CrossTenantImageViolationReason(image, namespace, prefix),
+		})
+	}
+	return violations
+}
+
+// ValidateServiceAccount checks if images, which a pod runs under
+// serviceAccount, may run under it according to the service account
+// bindings of the ISP. Images the ISP doesn't govern aren't checked. Callers
+// must check it, as ValidateImageSecurityPolicy doesn't know the service
+// account of the pod.
+func ValidateServiceAccount(isp v1beta1.ImageSecurityPolicy, serviceAccount string, images []string) []SecurityPolicyViolation {
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	var violations []SecurityPolicyViolation
+	for _, image := range images {
+		if !Governs(isp, image) {
+			continue
+		}
+		for _, b := range isp.Spec.ServiceAccountBindings {
+			if !bindingMatches(b, image) || bindingAllows(b, serviceAccount) {
+				continue
+			}
 			violations = append(violations, SecurityPolicyViolation{
-				Vulnerability: v,
-				Violation:     FixesNotAvailableViolation,
-				Reason:        FixesNotAvailableViolationReason(image, v),
+				Violation: ServiceAccountViolation,
+				Reason:    ServiceAccountViolationReason(image, serviceAccount, b.ServiceAccounts),
 			})
-			continue
+			break
+		}
+	}
+	return violations
+}
+
+// bindingMatches returns true if image matches any of the images of b
+func bindingMatches(b v1beta1.ServiceAccountBinding, image string) bool {
+	for _, pattern := range b.Images {
+		if imageMatches(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingAllows returns true if serviceAccount is one of those of b
+func bindingAllows(b v1beta1.ServiceAccountBinding, serviceAccount string) bool {
+	for _, sa := range b.ServiceAccounts {
+		if sa == serviceAccount {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateUnmatchedImages checks if images, which a pod runs, are each
+// validated or whitelisted by one of isps, the policies of its namespace, and
+// returns a violation for every other image if any of isps has the Deny
+// DefaultAction. Callers must check it, as ValidateImageSecurityPolicy only
+// knows of one policy.
+func ValidateUnmatchedImages(isps []v1beta1.ImageSecurityPolicy, images []string) ([]SecurityPolicyViolation, error) {
+	deny := false
+	for _, isp := range isps {
+		switch isp.Spec.DefaultAction {
+		case "", v1beta1.DefaultActionAllow:
+		case v1beta1.DefaultActionDeny:
+			deny = true
+		default:
+			return nil, fmt.Errorf("invalid defaultAction %q in image security policy %s/%s", isp.Spec.DefaultAction, isp.Namespace, isp.Name)
 		}
-		// Next, see if the severity is below or at threshold
-		if severityWithinThreshold(isp, v.Severity) {
+	}
+	if !deny {
+		return nil, nil
+	}
+	var violations []SecurityPolicyViolation
+	for _, image := range images {
+		if util.CheckGlobalWhitelist([]string{image}) || matched(isps, image) {
 			continue
 		}
-		// Else, add to list of CVEs in violation
 		violations = append(violations, SecurityPolicyViolation{
-			Vulnerability: v,
-			Violation:     ExceedsMaxSeverityViolation,
-			Reason:        ExceedsMaxSeverityViolationReason(image, v, isp),
+			Violation: UnmatchedImageViolation,
+			Reason:    UnmatchedImageViolationReason(image),
 		})
 	}
 	return violations, nil
 }
 
+// matched returns true if any of isps validates or whitelists image
+func matched(isps []v1beta1.ImageSecurityPolicy, image string) bool {
+	for _, isp := range isps {
+		if Governs(isp, image) || imageInWhitelist(isp, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// Governs returns true if isp validates image, because image matches its
+// Images or it doesn't restrict them
+func Governs(isp v1beta1.ImageSecurityPolicy, image string) bool {
+	if len(isp.Spec.Images) == 0 {
+		return true
+	}
+	for _, pattern := range isp.Spec.Images {
+		if imageMatches(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// exposedPortViolations returns the violations of isp by image exposing ports
+func exposedPortViolations(isp v1beta1.ImageSecurityPolicy, image string, ports []string) []SecurityPolicyViolation {
+	var violations []SecurityPolicyViolation
+	for _, p := range ports {
+		for _, disallowed := range isp.Spec.DisallowedExposedPorts {
+			if util.PortMatches(p, disallowed) {
+				violations = append(violations, SecurityPolicyViolation{
+					Violation: ExposedPortViolation,
+					Reason:    DisallowedPortViolationReason(image, p),
+				})
+				break
+			}
+		}
+	}
+	if max := isp.Spec.MaxExposedPorts; max > 0 && len(ports) > max {
+		violations = append(violations, SecurityPolicyViolation{
+			Violation: ExposedPortViolation,
+			Reason:    ExceedsMaxExposedPortsViolationReason(image, len(ports), max),
+		})
+	}
+	return violations
+}
+
+// disallowedOperatingSystem returns the entry of the disallowed operating
+// systems of isp which the operating system with CPE URI cpe matches, if any
+func disallowedOperatingSystem(isp v1beta1.ImageSecurityPolicy, cpe string) (string, bool) {
+	// cpe:/o:<vendor>:<product>:<version>
+	parts := strings.SplitN(strings.TrimPrefix(cpe, "cpe:/o:"), ":", 3)
+	productVersion := ""
+	if len(parts) == 3 {
+		productVersion = parts[1] + ":" + parts[2]
+	}
+	for _, d := range isp.Spec.DisallowedOperatingSystems {
+		if cpe == d || strings.HasPrefix(cpe, d+":") {
+			return d, true
+		}
+		if productVersion != "" && (productVersion == d || strings.HasPrefix(productVersion, d+".")) {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// vulnerabilityFilter compiles the vulnerability filter of isp, which is nil
+// if isp has none
+func vulnerabilityFilter(isp v1beta1.ImageSecurityPolicy) (VulnerabilityFilter, error) {
+	expr := isp.Spec.PackageVulernerabilityRequirements.VulnerabilityFilter
+	if expr == "" {
+		return nil, nil
+	}
+	f, err := CompileVulnerabilityFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("image security policy %s has invalid vulnerabilityFilter %q: %v", isp.Name, expr, err)
+	}
+	return f, nil
+}
+
+// provenanceViolations returns a violation if image has no build provenance,
+// or none of its builds meet the minimum SLSA level of isp
+func provenanceViolations(isp v1beta1.ImageSecurityPolicy, image string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	builds, err := client.GetBuildDetails(image)
+	if err != nil {
+		return nil, err
+	}
+	if len(builds) == 0 {
+		return []SecurityPolicyViolation{{
+			Violation: MissingProvenanceViolation,
+			Reason:    MissingProvenanceViolationReason(image),
+		}}, nil
+	}
+	level := 0
+	for _, b := range builds {
+		if b.SLSALevel > level {
+			level = b.SLSALevel
+		}
+	}
+	if level >= isp.Spec.MinSLSALevel {
+		return nil, nil
+	}
+	return []SecurityPolicyViolation{{
+		Violation: InsufficientSLSALevelViolation,
+		Reason:    InsufficientSLSALevelViolationReason(image, level, isp.Spec.MinSLSALevel),
+	}}, nil
+}
+
+// ValidatePodProvenance checks if images, the images of a pod, were all
+// built by the same builder, if isp requires consistent provenance.
+// Images whitelisted by isp aren't checked.
+func ValidatePodProvenance(isp v1beta1.ImageSecurityPolicy, images []string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	if !isp.Spec.RequireConsistentProvenance {
+		return nil, nil
+	}
+	builders := map[string][]string{}
+	// common are the builders of every image checked so far
+	var common map[string]bool
+	for _, image := range images {
+		if _, ok := builders[image]; ok || imageInWhitelist(isp, image) {
+			continue
+		}
+		builds, err := client.GetBuildDetails(image)
+		if err != nil {
+			return nil, err
+		}
+		creators := map[string]bool{}
+		builders[image] = []string{}
+		for _, b := range builds {
+			if !creators[b.Creator] {
+				creators[b.Creator] = true
+				builders[image] = append(builders[image], b.Creator)
+			}
+		}
+		if common == nil {
+			common = creators
+			continue
+		}
+		for c := range common {
+			if !creators[c] {
+				delete(common, c)
+			}
+		}
+	}
+	if len(builders) < 2 || len(common) != 0 {
+		return nil, nil
+	}
+	return []SecurityPolicyViolation{{
+		Violation: InconsistentProvenanceViolation,
+		Reason:    InconsistentProvenanceViolationReason(builders),
+	}}, nil
+}
+
+// ValidateSourceRepository checks if images, the images of a pod, were all
+// built from source, the source repository the pod declares, if isp requires
+// it. Images whitelisted by isp aren't checked.
+func ValidateSourceRepository(isp v1beta1.ImageSecurityPolicy, source string, images []string, client metadata.MetadataFetcher) ([]SecurityPolicyViolation, error) {
+	if !isp.Spec.RequireSourceRepository {
+		return nil, nil
+	}
+	var violations []SecurityPolicyViolation
+	checked := map[string]bool{}
+	for _, image := range images {
+		if checked[image] || imageInWhitelist(isp, image) {
+			continue
+		}
+		checked[image] = true
+		if source == "" {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: SourceRepositoryMismatchViolation,
+				Reason:    SourceRepositoryMismatchViolationReason(image, source, nil),
+			})
+			continue
+		}
+		builds, err := client.GetBuildDetails(image)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		sources := []string{}
+		for _, b := range builds {
+			if b.SourceURI == "" {
+				continue
+			}
+			if sameRepository(b.SourceURI, source) {
+				matched = true
+				break
+			}
+			sources = append(sources, b.SourceURI)
+		}
+		if !matched {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: SourceRepositoryMismatchViolation,
+				Reason:    SourceRepositoryMismatchViolationReason(image, source, sources),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// sameRepository returns true if the repository URIs a and b are the same,
+// whatever their scheme, the case of their host, and a trailing / or .git
+func sameRepository(a string, b string) bool {
+	return normalizeRepository(a) == normalizeRepository(b)
+}
+
+func normalizeRepository(uri string) string {
+	if i := strings.Index(uri, "://"); i != -1 {
+		uri = uri[i+len("://"):]
+	}
+	uri = strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".git")
+	parts := strings.SplitN(uri, "/", 2)
+	parts[0] = strings.ToLower(parts[0])
+	return strings.Join(parts, "/")
+}
+
+// disclosureTime returns when v was publicly disclosed, if isp has a
+// MaximumDisclosureAge it may violate because it has a fix available, or
+// the zero time otherwise
+func disclosureTime(isp v1beta1.ImageSecurityPolicy, v metadata.Vulnerability, client metadata.MetadataFetcher) (time.Time, error) {
+	if isp.Spec.PackageVulernerabilityRequirements.MaximumDisclosureAge == nil || !v.HasFixAvailable {
+		return time.Time{}, nil
+	}
+	fetcher, ok := client.(metadata.DisclosureFetcher)
+	if !ok {
+		return time.Time{}, fmt.Errorf("image security policy %s has a maximumDisclosureAge, but the metadata backend doesn't know when CVEs were disclosed", isp.Name)
+	}
+	return fetcher.GetDisclosureTime(v.CVE)
+}
+
+// inGracePeriod returns true and the end of the grace period if v is still
+// within the CVE grace period of isp
+func inGracePeriod(isp v1beta1.ImageSecurityPolicy, v metadata.Vulnerability) (time.Time, bool) {
+	grace := isp.Spec.PackageVulernerabilityRequirements.CVEGracePeriod
+	if grace == nil || v.CreateTime.IsZero() {
+		return time.Time{}, false
+	}
+	until := v.CreateTime.Add(grace.Duration)
+	return until, now().Before(until)
+}
+
+// countViolations returns a violation for each severity whose CVE count
+// exceeds its maximum, ordered from least to most severe
+func countViolations(isp v1beta1.ImageSecurityPolicy, image string, counts map[string]int) []SecurityPolicyViolation {
+	maxCounts := isp.Spec.PackageVulernerabilityRequirements.MaximumCounts
+	severities := []string{}
+	for severity := range maxCounts {
+		severities = append(severities, severity)
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		return ca.VulnerabilityType_Severity_value[severities[i]] < ca.VulnerabilityType_Severity_value[severities[j]]
+	})
+	var violations []SecurityPolicyViolation
+	for _, severity := range severities {
+		if counts[severity] > maxCounts[severity] {
+			violations = append(violations, SecurityPolicyViolation{
+				Violation: ExceedsMaxCountViolation,
+				Reason:    ExceedsMaxCountViolationReason(image, severity, counts[severity], maxCounts[severity]),
+			})
+		}
+	}
+	return violations
+}
+
 func imageInWhitelist(isp v1beta1.ImageSecurityPolicy, image string) bool {
+	normalized := normalizeImage(image)
 	for _, i := range isp.Spec.ImageWhitelist {
-		if i == image {
+		if normalizeImage(i) == normalized {
 			return true
 		}
 	}
 	return false
 }
 
-func cveInWhitelist(isp v1beta1.ImageSecurityPolicy, cve string) bool {
+// normalizeImage returns the canonical form of image, or image itself if it
+// can't be parsed so that it can still match itself exactly.
+func normalizeImage(image string) string {
+	normalized, err := util.NormalizeImage(image)
+	if err != nil {
+		return image
+	}
+	return normalized
+}
+
+func cveInWhitelist(isp v1beta1.ImageSecurityPolicy, image string, cve string) bool {
 	for _, w := range isp.Spec.PackageVulernerabilityRequirements.WhitelistCVEs {
 		if w == cve {
 			return true
 		}
 	}
+	for _, w := range isp.Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs {
+		if w.CVE != cve || expired(w) {
+			continue
+		}
+		for _, pattern := range w.Images {
+			if imageMatches(pattern, image) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expired returns true if w no longer whitelists its CVE
+func expired(w v1beta1.ScopedCVE) bool {
+	return w.Expires != nil && !now().Before(w.Expires.Time)
+}
+
+// ExpiredWhitelistCVEs returns the scoped CVE whitelist entries of isp which
+// have expired
+func ExpiredWhitelistCVEs(isp v1beta1.ImageSecurityPolicy) []v1beta1.ScopedCVE {
+	var entries []v1beta1.ScopedCVE
+	for _, w := range isp.Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs {
+		if expired(w) {
+			entries = append(entries, w)
+		}
+	}
+	return entries
+}
+
+// WithoutExpiredWhitelistCVEs returns a copy of isp without its expired
+// scoped CVE whitelist entries
+func WithoutExpiredWhitelistCVEs(isp v1beta1.ImageSecurityPolicy) v1beta1.ImageSecurityPolicy {
+	pruned := *isp.DeepCopy()
+	entries := []v1beta1.ScopedCVE{}
+	for _, w := range pruned.Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs {
+		if !expired(w) {
+			entries = append(entries, w)
+		}
+	}
+	pruned.Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs = entries
+	return pruned
+}
+
+// imageMatches returns true if image is the image reference pattern, or
+// matches it as a path.Match pattern either as is or normalized
+func imageMatches(pattern string, image string) bool {
+	normalized := normalizeImage(image)
+	if normalizeImage(pattern) == normalized {
+		return true
+	}
+	for _, i := range []string{image, normalized} {
+		if matched, err := path.Match(pattern, i); err == nil && matched {
+			return true
+		}
+	}
 	return false
 }
 
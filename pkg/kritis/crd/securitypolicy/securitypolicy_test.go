@@ -17,11 +17,14 @@ limitations under the License.
 package securitypolicy
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
 	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
@@ -48,6 +51,74 @@ func (m mockMetadataClient) GetVulnerabilities(containerImage string) ([]metadat
 	}, nil
 }
 
+func (m mockMetadataClient) HasMetadata(containerImage string) (bool, error) {
+	return true, nil
+}
+
+func (m mockMetadataClient) GetBuildDetails(containerImage string) ([]metadata.Build, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetAttestations(containerImage string) ([]metadata.PGPAttestation, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetSecretFindings(containerImage string) ([]metadata.SecretFinding, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetMalwareFindings(containerImage string) ([]metadata.MalwareFinding, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) GetOperatingSystems(containerImage string) ([]string, error) {
+	return nil, nil
+}
+
+func (m mockMetadataClient) CreateAttestationOccurence(noteName string, image string, signature string, keyID string) error {
+	return nil
+}
+
+// mockVulnzClient returns the given vulnerabilities for every image
+type mockVulnzClient struct {
+	mockMetadataClient
+	vulnz   []metadata.Vulnerability
+	builds  []metadata.Build
+	secrets []metadata.SecretFinding
+	malware []metadata.MalwareFinding
+	systems []string
+}
+
+func (m mockVulnzClient) GetVulnerabilities(containerImage string) ([]metadata.Vulnerability, error) {
+	return m.vulnz, nil
+}
+
+func (m mockVulnzClient) GetBuildDetails(containerImage string) ([]metadata.Build, error) {
+	return m.builds, nil
+}
+
+func (m mockVulnzClient) GetSecretFindings(containerImage string) ([]metadata.SecretFinding, error) {
+	return m.secrets, nil
+}
+
+func (m mockVulnzClient) GetMalwareFindings(containerImage string) ([]metadata.MalwareFinding, error) {
+	return m.malware, nil
+}
+
+func (m mockVulnzClient) GetOperatingSystems(containerImage string) ([]string, error) {
+	return m.systems, nil
+}
+
+func (m mockVulnzClient) HasMetadata(containerImage string) (bool, error) {
+	return len(m.vulnz) != 0, nil
+}
+
+// fetcherOnly hides the optional interfaces of a metadata client, as of a
+// backend with none of them
+type fetcherOnly struct {
+	metadata.MetadataFetcher
+}
+
 func Test_ValidISP(t *testing.T) {
 	isp := v1beta1.ImageSecurityPolicy{
 		Spec: v1beta1.ImageSecurityPolicySpec{
@@ -185,6 +256,299 @@ func Test_WhitelistedCVEAboveSeverityThreshold(t *testing.T) {
 	}
 }
 
+func Test_ScopedWhitelistCVEs(t *testing.T) {
+	digest := "@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	imageA := "gcr.io/project/a" + digest
+	imageB := "gcr.io/project/b" + digest
+	past := metav1.NewTime(time.Now().Add(-time.Minute))
+	future := metav1.NewTime(time.Now().Add(time.Hour))
+	var tests = []struct {
+		name     string
+		images   []string
+		expires  *metav1.Time
+		image    string
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:   "suppressed in the whitelisted image",
+			images: []string{imageA},
+			image:  imageA,
+		},
+		{
+			name:   "suppressed in images matching a pattern",
+			images: []string{"gcr.io/project/a@*"},
+			image:  imageA,
+		},
+		{
+			name:    "suppressed until it expires",
+			images:  []string{imageA},
+			expires: &future,
+			image:   imageA,
+		},
+		{
+			name:    "blocks once expired",
+			images:  []string{imageA},
+			expires: &past,
+			image:   imageA,
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: vulnz2,
+					Violation:     ExceedsMaxSeverityViolation,
+				},
+			},
+		},
+		{
+			name:   "blocks another image",
+			images: []string{imageA, "gcr.io/project/a@*"},
+			image:  imageB,
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: vulnz2,
+					Violation:     ExceedsMaxSeverityViolation,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "LOW",
+						ScopedWhitelistCVEs: []v1beta1.ScopedCVE{
+							{CVE: "cve2", Images: test.images, Expires: test.expires},
+						},
+					},
+				},
+			}
+			for i := range test.expected {
+				test.expected[i].Reason = ExceedsMaxSeverityViolationReason(test.image, vulnz2, isp)
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, test.image, mockMetadataClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, violations, test.expected)
+		})
+	}
+}
+
+func Test_ExpiredWhitelistCVEs(t *testing.T) {
+	past := metav1.NewTime(time.Now().Add(-time.Minute))
+	future := metav1.NewTime(time.Now().Add(time.Hour))
+	expired := v1beta1.ScopedCVE{CVE: "cve1", Images: []string{"gcr.io/project/a@*"}, Expires: &past}
+	current := []v1beta1.ScopedCVE{
+		{CVE: "cve2", Images: []string{"gcr.io/project/a@*"}, Expires: &future},
+		{CVE: "cve3", Images: []string{"gcr.io/project/a@*"}},
+	}
+	isp := v1beta1.ImageSecurityPolicy{
+		Spec: v1beta1.ImageSecurityPolicySpec{
+			PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+				ScopedWhitelistCVEs: []v1beta1.ScopedCVE{current[0], expired, current[1]},
+			},
+		},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []v1beta1.ScopedCVE{expired}, ExpiredWhitelistCVEs(isp))
+	pruned := WithoutExpiredWhitelistCVEs(isp)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, current, pruned.Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs)
+	if len(isp.Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs) != 3 {
+		t.Error("expected the original policy to be unchanged")
+	}
+}
+
+func Test_DenyKnownExploitedCVEs(t *testing.T) {
+	SetKnownExploitedCVEs([]string{"CVE-2021-44228"})
+	defer SetKnownExploitedCVEs(nil)
+	kev := metadata.Vulnerability{
+		CVE:             "providers/goog-vulnz/notes/CVE-2021-44228",
+		Severity:        "LOW",
+		HasFixAvailable: true,
+	}
+	client := mockVulnzClient{vulnz: []metadata.Vulnerability{vulnz1, kev}}
+	var tests = []struct {
+		name     string
+		deny     bool
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name: "low severity known exploited cve blocks",
+			deny: true,
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: kev,
+					Violation:     KnownExploitedViolation,
+					Reason:        KnownExploitedViolationReason(testutil.QualifiedImage, kev),
+				},
+			},
+		},
+		{
+			name: "not denied unless set",
+			deny: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DenyKnownExploitedCVEs: test.deny,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+						WhitelistCVEs:   []string{kev.CVE},
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_RequireDigestReference(t *testing.T) {
+	digest := "@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	var tests = []struct {
+		name      string
+		image     string
+		require   bool
+		whitelist []string
+		violated  bool
+	}{
+		{
+			name:     "tag only",
+			image:    "gcr.io/project/image:1.0",
+			require:  true,
+			violated: true,
+		},
+		{
+			name:    "digest",
+			image:   "gcr.io/project/image" + digest,
+			require: true,
+		},
+		{
+			name:    "tag and digest",
+			image:   "gcr.io/project/image:1.0" + digest,
+			require: true,
+		},
+		{
+			name:      "whitelisted tag",
+			image:     "gcr.io/project/image:1.0",
+			require:   true,
+			whitelist: []string{"gcr.io/project/image:1.0"},
+		},
+		{
+			name:  "tag not required",
+			image: "gcr.io/project/image:1.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					ImageWhitelist:         test.whitelist,
+					RequireDigestReference: test.require,
+				},
+			}
+			var expected []SecurityPolicyViolation
+			if test.violated {
+				expected = []SecurityPolicyViolation{{
+					Violation: TagReferenceViolation,
+					Reason:    TagReferenceViolationReason(test.image),
+				}}
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, expected, ValidateImageReference(isp, test.image))
+		})
+	}
+	// ValidateImageSecurityPolicy reports it before the image is unqualified
+	isp := v1beta1.ImageSecurityPolicy{
+		Spec: v1beta1.ImageSecurityPolicySpec{RequireDigestReference: true},
+	}
+	violations, err := ValidateImageSecurityPolicy(isp, "gcr.io/project/image:1.0", mockMetadataClient{})
+	expected := []SecurityPolicyViolation{{
+		Violation: TagReferenceViolation,
+		Reason:    TagReferenceViolationReason("gcr.io/project/image:1.0"),
+	}}
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, violations)
+}
+
+func Test_VulnerabilityFilter(t *testing.T) {
+	isp := v1beta1.ImageSecurityPolicy{
+		Spec: v1beta1.ImageSecurityPolicySpec{
+			PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+				MaximumSeverity:     "CRITICAL",
+				VulnerabilityFilter: "severity >= LOW AND fixAvailable == true",
+			},
+		},
+	}
+	violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockMetadataClient{})
+	expected := []SecurityPolicyViolation{
+		{
+			Vulnerability: vulnz1,
+			Violation:     FilterViolation,
+			Reason:        FilterViolationReason(testutil.QualifiedImage, vulnz1, isp),
+		},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, violations)
+
+	isp.Spec.PackageVulernerabilityRequirements.VulnerabilityFilter = "severity >="
+	_, err = ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockMetadataClient{})
+	testutil.CheckError(t, true, err)
+}
+
+func Test_DisallowedOperatingSystems(t *testing.T) {
+	disallowed := []string{"cpe:/o:centos:centos:6", "debian_linux:8"}
+	var tests = []struct {
+		name      string
+		systems   []string
+		client    metadata.MetadataFetcher
+		expected  []SecurityPolicyViolation
+		shouldErr bool
+	}{
+		{
+			name:    "end of life centos",
+			systems: []string{"cpe:/o:centos:centos:6"},
+			expected: []SecurityPolicyViolation{{
+				Violation: DisallowedOperatingSystemViolation,
+				Reason:    DisallowedOperatingSystemViolationReason(testutil.QualifiedImage, "cpe:/o:centos:centos:6", "cpe:/o:centos:centos:6"),
+			}},
+		},
+		{
+			name:    "end of life debian point release",
+			systems: []string{"cpe:/o:debian:debian_linux:8.11"},
+			expected: []SecurityPolicyViolation{{
+				Violation: DisallowedOperatingSystemViolation,
+				Reason:    DisallowedOperatingSystemViolationReason(testutil.QualifiedImage, "cpe:/o:debian:debian_linux:8.11", "debian_linux:8"),
+			}},
+		},
+		{
+			name:    "supported centos",
+			systems: []string{"cpe:/o:centos:centos:7"},
+		},
+		{
+			name:    "supported debian",
+			systems: []string{"cpe:/o:debian:debian_linux:80"},
+		},
+		{
+			name: "no operating system metadata",
+		},
+		{
+			name:      "backend doesn't know operating systems",
+			client:    fetcherOnly{mockVulnzClient{}},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DisallowedOperatingSystems: disallowed,
+				},
+			}
+			client := test.client
+			if client == nil {
+				client = mockVulnzClient{systems: test.systems}
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
 func Test_OnlyFixesNotAvailableFail(t *testing.T) {
 	isp := v1beta1.ImageSecurityPolicy{
 		Spec: v1beta1.ImageSecurityPolicySpec{
@@ -272,3 +636,1863 @@ func Test_severityWithinThreshold(t *testing.T) {
 		})
 	}
 }
+
+func Test_MaximumCounts(t *testing.T) {
+	vulnz := func(severity string, n int) []metadata.Vulnerability {
+		v := []metadata.Vulnerability{}
+		for i := 0; i < n; i++ {
+			v = append(v, metadata.Vulnerability{
+				CVE:             fmt.Sprintf("%s-cve%d", severity, i),
+				Severity:        severity,
+				HasFixAvailable: true,
+			})
+		}
+		return v
+	}
+	var tests = []struct {
+		name        string
+		maxSeverity string
+		maxCounts   map[string]int
+		vulnz       []metadata.Vulnerability
+		whitelist   []string
+		expected    []SecurityPolicyViolation
+	}{
+		{
+			name:      "below the cap",
+			maxCounts: map[string]int{"MEDIUM": 3},
+			vulnz:     vulnz("MEDIUM", 2),
+		},
+		{
+			name:      "at the cap",
+			maxCounts: map[string]int{"MEDIUM": 3},
+			vulnz:     vulnz("MEDIUM", 3),
+		},
+		{
+			name:      "one over the cap",
+			maxCounts: map[string]int{"MEDIUM": 3},
+			vulnz:     vulnz("MEDIUM", 4),
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxCountViolation,
+					Reason:    ExceedsMaxCountViolationReason(testutil.QualifiedImage, "MEDIUM", 4, 3),
+				},
+			},
+		},
+		{
+			name:      "whitelisted CVEs aren't counted",
+			maxCounts: map[string]int{"MEDIUM": 3},
+			vulnz:     vulnz("MEDIUM", 4),
+			whitelist: []string{"MEDIUM-cve0"},
+		},
+		{
+			name:      "zero cap",
+			maxCounts: map[string]int{"HIGH": 0},
+			vulnz:     append(vulnz("MEDIUM", 4), vulnz("HIGH", 1)...),
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxCountViolation,
+					Reason:    ExceedsMaxCountViolationReason(testutil.QualifiedImage, "HIGH", 1, 0),
+				},
+			},
+		},
+		{
+			name:      "caps apply per severity",
+			maxCounts: map[string]int{"CRITICAL": 1, "LOW": 1, "MEDIUM": 5},
+			vulnz:     append(append(vulnz("CRITICAL", 2), vulnz("LOW", 2)...), vulnz("MEDIUM", 5)...),
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxCountViolation,
+					Reason:    ExceedsMaxCountViolationReason(testutil.QualifiedImage, "LOW", 2, 1),
+				},
+				{
+					Violation: ExceedsMaxCountViolation,
+					Reason:    ExceedsMaxCountViolationReason(testutil.QualifiedImage, "CRITICAL", 2, 1),
+				},
+			},
+		},
+		{
+			name:        "cap replaces the maximum severity",
+			maxSeverity: "MEDIUM",
+			maxCounts:   map[string]int{"HIGH": 2},
+			vulnz:       vulnz("HIGH", 2),
+		},
+		{
+			name:        "over a cap above the maximum severity",
+			maxSeverity: "MEDIUM",
+			maxCounts:   map[string]int{"HIGH": 2},
+			vulnz:       vulnz("HIGH", 3),
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxCountViolation,
+					Reason:    ExceedsMaxCountViolationReason(testutil.QualifiedImage, "HIGH", 3, 2),
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.maxSeverity == "" {
+				test.maxSeverity = "CRITICAL"
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: test.maxSeverity,
+						MaximumCounts:   test.maxCounts,
+						WhitelistCVEs:   test.whitelist,
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{vulnz: test.vulnz})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_DenyUnknownImages(t *testing.T) {
+	var tests = []struct {
+		name     string
+		deny     bool
+		client   metadata.MetadataFetcher
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:   "unknown image admitted by default",
+			deny:   false,
+			client: mockVulnzClient{},
+		},
+		{
+			name:   "unknown image denied",
+			deny:   true,
+			client: mockVulnzClient{},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: UnknownImageViolation,
+					Reason:    UnknownImageViolationReason(testutil.QualifiedImage),
+				},
+			},
+		},
+		{
+			name:   "known image admitted",
+			deny:   true,
+			client: mockVulnzClient{vulnz: []metadata.Vulnerability{{CVE: "cve1", Severity: "LOW"}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DenyUnknownImages: test.deny,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, test.client)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_UnknownDigestAction(t *testing.T) {
+	var tests = []struct {
+		name        string
+		action      string
+		denyUnknown bool
+		client      metadata.MetadataFetcher
+		shouldErr   bool
+		expected    []SecurityPolicyViolation
+	}{
+		{
+			name:   "unknown digest validated by default",
+			client: mockVulnzClient{},
+		},
+		{
+			name:        "unknown digest denied by default with denyUnknownImages",
+			denyUnknown: true,
+			client:      mockVulnzClient{},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: UnknownImageViolation,
+					Reason:    UnknownImageViolationReason(testutil.QualifiedImage),
+				},
+			},
+		},
+		{
+			name:   "unknown digest denied",
+			action: v1beta1.UnknownDigestDeny,
+			client: mockVulnzClient{},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: UnknownDigestViolation,
+					Reason:    UnknownDigestViolationReason(testutil.QualifiedImage),
+				},
+			},
+		},
+		{
+			name:        "unknown digest allowed despite denyUnknownImages",
+			action:      v1beta1.UnknownDigestAllow,
+			denyUnknown: true,
+			client:      mockVulnzClient{},
+		},
+		{
+			name:   "unknown digest requires an attestation",
+			action: v1beta1.UnknownDigestRequireAttestation,
+			client: mockVulnzClient{},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingAttestationViolation,
+					Reason:    UnattestedUnknownDigestViolationReason(testutil.QualifiedImage),
+				},
+			},
+		},
+		{
+			name:   "known digest validated",
+			action: v1beta1.UnknownDigestDeny,
+			client: mockVulnzClient{vulnz: []metadata.Vulnerability{{CVE: "cve1", Severity: "LOW"}}},
+		},
+		{
+			name:      "invalid action",
+			action:    "Ignore",
+			client:    mockVulnzClient{},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DenyUnknownImages:   test.denyUnknown,
+					UnknownDigestAction: test.action,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, test.client)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
+// rescanningClient records the images it's asked to rescan
+type rescanningClient struct {
+	mockVulnzClient
+	rescanned *[]string
+}
+
+func (m rescanningClient) RequestRescan(containerImage string) error {
+	*m.rescanned = append(*m.rescanned, containerImage)
+	return nil
+}
+
+func Test_RescanStaleImages(t *testing.T) {
+	scanned := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	vulnz := []metadata.Vulnerability{{CVE: "cve1", Severity: "LOW", CreateTime: scanned}}
+	var tests = []struct {
+		name        string
+		now         time.Time
+		rescanAfter *metav1.Duration
+		deny        bool
+		vulnz       []metadata.Vulnerability
+		noRescans   bool
+		expected    []string
+	}{
+		{
+			name:        "fresh image",
+			now:         scanned.Add(time.Hour),
+			rescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+			vulnz:       vulnz,
+		},
+		{
+			name:        "stale image",
+			now:         scanned.Add(48 * time.Hour),
+			rescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+			vulnz:       vulnz,
+			expected:    []string{testutil.QualifiedImage},
+		},
+		{
+			name:        "stale image without requesting rescans",
+			now:         scanned.Add(48 * time.Hour),
+			rescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+			vulnz:       vulnz,
+			noRescans:   true,
+		},
+		{
+			name:  "stale image without rescanAfter",
+			now:   scanned.Add(48 * time.Hour),
+			vulnz: vulnz,
+		},
+		{
+			name:        "image without metadata",
+			now:         scanned,
+			rescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+			expected:    []string{testutil.QualifiedImage},
+		},
+		{
+			name:        "denied image without metadata",
+			now:         scanned,
+			rescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+			deny:        true,
+			expected:    []string{testutil.QualifiedImage},
+		},
+		{
+			name:        "creation times unknown",
+			now:         scanned.Add(48 * time.Hour),
+			rescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+			vulnz:       []metadata.Vulnerability{{CVE: "cve1", Severity: "LOW"}},
+		},
+	}
+	original := now
+	defer func() {
+		now = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = func() time.Time { return test.now }
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DenyUnknownImages: test.deny,
+					RescanAfter:       test.rescanAfter,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			var rescanned []string
+			client := rescanningClient{mockVulnzClient: mockVulnzClient{vulnz: test.vulnz}, rescanned: &rescanned}
+			opts := []ValidateOption{RequestRescans()}
+			if test.noRescans {
+				opts = nil
+			}
+			_, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client, opts...)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, rescanned)
+		})
+	}
+}
+
+// discoveryClient returns the latest scan of images it was constructed with
+type discoveryClient struct {
+	rescanningClient
+	discovery *metadata.Discovery
+}
+
+func (m discoveryClient) GetDiscovery(containerImage string) (*metadata.Discovery, error) {
+	return m.discovery, nil
+}
+
+func Test_RescanAfterLatestDiscovery(t *testing.T) {
+	vulnerable := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	vulnz := []metadata.Vulnerability{{CVE: "cve1", Severity: "LOW", CreateTime: vulnerable}}
+	var tests = []struct {
+		name      string
+		discovery *metadata.Discovery
+		expected  []string
+	}{
+		{
+			name:      "rescanned since the vulnerabilities were found",
+			discovery: &metadata.Discovery{Status: "FINISHED_SUCCESS", Complete: true, Time: vulnerable.Add(47 * time.Hour)},
+		},
+		{
+			name:      "not rescanned since",
+			discovery: &metadata.Discovery{Status: "FINISHED_SUCCESS", Complete: true, Time: vulnerable},
+			expected:  []string{testutil.QualifiedImage},
+		},
+		{
+			name:      "scan time unknown",
+			discovery: &metadata.Discovery{Status: "FINISHED_SUCCESS", Complete: true},
+			expected:  []string{testutil.QualifiedImage},
+		},
+		{
+			name:     "never scanned",
+			expected: []string{testutil.QualifiedImage},
+		},
+	}
+	original := now
+	defer func() {
+		now = original
+	}()
+	now = func() time.Time { return vulnerable.Add(48 * time.Hour) }
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					RescanAfter: &metav1.Duration{Duration: 24 * time.Hour},
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			var rescanned []string
+			client := discoveryClient{
+				rescanningClient: rescanningClient{mockVulnzClient: mockVulnzClient{vulnz: vulnz}, rescanned: &rescanned},
+				discovery:        test.discovery,
+			}
+			_, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client, RequestRescans())
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, rescanned)
+		})
+	}
+}
+
+func Test_RequireCompleteScan(t *testing.T) {
+	var tests = []struct {
+		name      string
+		discovery *metadata.Discovery
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:      "complete scan",
+			discovery: &metadata.Discovery{Status: "FINISHED_SUCCESS", Complete: true},
+		},
+		{
+			name:      "scanning",
+			discovery: &metadata.Discovery{Status: "SCANNING"},
+			expected: []SecurityPolicyViolation{{
+				Violation: IncompleteScanViolation,
+				Reason:    Violation(fmt.Sprintf("the latest scan of %s has status SCANNING, not FINISHED_SUCCESS", testutil.QualifiedImage)),
+			}},
+		},
+		{
+			name: "never scanned",
+			expected: []SecurityPolicyViolation{{
+				Violation: IncompleteScanViolation,
+				Reason:    Violation(fmt.Sprintf("%s has not been scanned", testutil.QualifiedImage)),
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					RequireCompleteScan: true,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			client := discoveryClient{discovery: test.discovery}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+	t.Run("backend not recording scans", func(t *testing.T) {
+		isp := v1beta1.ImageSecurityPolicy{
+			Spec: v1beta1.ImageSecurityPolicySpec{RequireCompleteScan: true},
+		}
+		violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+		testutil.CheckErrorAndDeepEqual(t, false, err, []SecurityPolicyViolation(nil), violations)
+	})
+}
+
+func Test_CVEGracePeriod(t *testing.T) {
+	published := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	grace := 72 * time.Hour
+	vuln := metadata.Vulnerability{
+		CVE:             "cve1",
+		Severity:        "HIGH",
+		HasFixAvailable: true,
+		CreateTime:      published,
+	}
+	var tests = []struct {
+		name     string
+		now      time.Time
+		vulnz    []metadata.Vulnerability
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:  "just published",
+			now:   published,
+			vulnz: []metadata.Vulnerability{vuln},
+		},
+		{
+			name:  "just before the grace period ends",
+			now:   published.Add(grace - time.Second),
+			vulnz: []metadata.Vulnerability{vuln},
+		},
+		{
+			name:  "when the grace period ends",
+			now:   published.Add(grace),
+			vulnz: []metadata.Vulnerability{vuln},
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: vuln,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        Violation(fmt.Sprintf("found CVE cve1 in %s, which has severity HIGH exceeding max severity LOW", testutil.QualifiedImage)),
+				},
+			},
+		},
+		{
+			name:  "unknown publication time",
+			now:   published,
+			vulnz: []metadata.Vulnerability{{CVE: "cve1", Severity: "HIGH", HasFixAvailable: true}},
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: metadata.Vulnerability{CVE: "cve1", Severity: "HIGH", HasFixAvailable: true},
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        Violation(fmt.Sprintf("found CVE cve1 in %s, which has severity HIGH exceeding max severity LOW", testutil.QualifiedImage)),
+				},
+			},
+		},
+	}
+	original := now
+	defer func() {
+		now = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = func() time.Time { return test.now }
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "LOW",
+						CVEGracePeriod:  &metav1.Duration{Duration: grace},
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{vulnz: test.vulnz})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+// mockDisclosureClient knows when the CVEs in disclosed were disclosed
+type mockDisclosureClient struct {
+	mockVulnzClient
+	disclosed map[string]time.Time
+}
+
+func (m mockDisclosureClient) GetDisclosureTime(cve string) (time.Time, error) {
+	return m.disclosed[cve], nil
+}
+
+func Test_MaximumDisclosureAge(t *testing.T) {
+	disclosed := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	// The occurrence was created long after the CVE was disclosed
+	scanned := disclosed.Add(90 * 24 * time.Hour)
+	maxAge := 30 * 24 * time.Hour
+	fixed := metadata.Vulnerability{CVE: "cve1", Severity: "LOW", HasFixAvailable: true, CreateTime: scanned}
+	unfixed := metadata.Vulnerability{CVE: "cve1", Severity: "LOW", CreateTime: scanned}
+	known := map[string]time.Time{"cve1": disclosed}
+	var tests = []struct {
+		name      string
+		now       time.Time
+		vulnz     []metadata.Vulnerability
+		whitelist []string
+		client    func(vulnz []metadata.Vulnerability) metadata.MetadataFetcher
+		shouldErr bool
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:  "just before the maximum age",
+			now:   disclosed.Add(maxAge - time.Second),
+			vulnz: []metadata.Vulnerability{fixed},
+		},
+		{
+			name:  "at the maximum age",
+			now:   disclosed.Add(maxAge),
+			vulnz: []metadata.Vulnerability{fixed},
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: fixed,
+					Violation:     DisclosureAgeViolation,
+					Reason:        DisclosureAgeViolationReason(testutil.QualifiedImage, fixed, disclosed, maxAge),
+				},
+			},
+		},
+		{
+			name:  "no fix available",
+			now:   scanned,
+			vulnz: []metadata.Vulnerability{unfixed},
+		},
+		{
+			name:      "whitelisted",
+			now:       scanned,
+			vulnz:     []metadata.Vulnerability{fixed},
+			whitelist: []string{"cve1"},
+		},
+		{
+			name:  "unknown disclosure time",
+			now:   scanned,
+			vulnz: []metadata.Vulnerability{{CVE: "cve2", Severity: "LOW", HasFixAvailable: true}},
+		},
+		{
+			name:  "backend without disclosure times",
+			now:   scanned,
+			vulnz: []metadata.Vulnerability{fixed},
+			client: func(vulnz []metadata.Vulnerability) metadata.MetadataFetcher {
+				return mockVulnzClient{vulnz: vulnz}
+			},
+			shouldErr: true,
+		},
+	}
+	original := now
+	defer func() {
+		now = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = func() time.Time { return test.now }
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity:      "CRITICAL",
+						WhitelistCVEs:        test.whitelist,
+						MaximumDisclosureAge: &metav1.Duration{Duration: maxAge},
+					},
+				},
+			}
+			var client metadata.MetadataFetcher = mockDisclosureClient{mockVulnzClient{vulnz: test.vulnz}, known}
+			if test.client != nil {
+				client = test.client(test.vulnz)
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
+// mockNoteKindClient has occurrences of kinds of notes
+type mockNoteKindClient struct {
+	mockVulnzClient
+	kinds []string
+}
+
+func (m mockNoteKindClient) GetNoteKinds(containerImage string) ([]string, error) {
+	return m.kinds, nil
+}
+
+func Test_NoteKinds(t *testing.T) {
+	image := testutil.QualifiedImage
+	var tests = []struct {
+		name      string
+		required  []string
+		denied    []string
+		kinds     []string
+		client    metadata.MetadataFetcher
+		shouldErr bool
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:     "required kind present",
+			required: []string{"BUILD_DETAILS"},
+			kinds:    []string{"BUILD_DETAILS", "PACKAGE_VULNERABILITY"},
+		},
+		{
+			name:     "required kind missing",
+			required: []string{"BUILD_DETAILS", "attestation_authority"},
+			kinds:    []string{"PACKAGE_VULNERABILITY", "ATTESTATION_AUTHORITY"},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingNoteKindViolation,
+					Reason:    MissingNoteKindViolationReason(image, "BUILD_DETAILS"),
+				},
+			},
+		},
+		{
+			name:   "denied kind absent",
+			denied: []string{"UPGRADE"},
+			kinds:  []string{"BUILD_DETAILS"},
+		},
+		{
+			name:   "denied kind present",
+			denied: []string{"UPGRADE", "DEPLOYABLE"},
+			kinds:  []string{"BUILD_DETAILS", "UPGRADE"},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: DeniedNoteKindViolation,
+					Reason:    DeniedNoteKindViolationReason(image, "UPGRADE"),
+				},
+			},
+		},
+		{
+			name:     "required and denied",
+			required: []string{"BUILD_DETAILS"},
+			denied:   []string{"UPGRADE"},
+			kinds:    []string{"UPGRADE"},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingNoteKindViolation,
+					Reason:    MissingNoteKindViolationReason(image, "BUILD_DETAILS"),
+				},
+				{
+					Violation: DeniedNoteKindViolation,
+					Reason:    DeniedNoteKindViolationReason(image, "UPGRADE"),
+				},
+			},
+		},
+		{
+			name:      "backend can't list note kinds",
+			required:  []string{"BUILD_DETAILS"},
+			client:    mockVulnzClient{},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					RequiredNoteKinds: test.required,
+					DeniedNoteKinds:   test.denied,
+				},
+			}
+			client := test.client
+			if client == nil {
+				client = mockNoteKindClient{kinds: test.kinds}
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, image, client)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	isp := func(name string, priority int) v1beta1.ImageSecurityPolicy {
+		return v1beta1.ImageSecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1beta1.ImageSecurityPolicySpec{Priority: priority},
+		}
+	}
+	isps := []v1beta1.ImageSecurityPolicy{isp("c", 0), isp("b", 0), isp("z", 10), isp("a", -1), isp("y", 10)}
+	Sort(isps)
+	expected := []v1beta1.ImageSecurityPolicy{isp("y", 10), isp("z", 10), isp("b", 0), isp("c", 0), isp("a", -1)}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, isps)
+}
+
+func Test_MinSLSALevel(t *testing.T) {
+	var tests = []struct {
+		name     string
+		minLevel int
+		builds   []metadata.Build
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:     "no minimum",
+			minLevel: 0,
+		},
+		{
+			name:     "missing provenance",
+			minLevel: 1,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingProvenanceViolation,
+					Reason:    MissingProvenanceViolationReason(testutil.QualifiedImage),
+				},
+			},
+		},
+		{
+			name:     "provenance without a level",
+			minLevel: 1,
+			builds:   []metadata.Build{{Creator: "ci"}},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: InsufficientSLSALevelViolation,
+					Reason:    InsufficientSLSALevelViolationReason(testutil.QualifiedImage, 0, 1),
+				},
+			},
+		},
+		{
+			name:     "below minimum",
+			minLevel: 3,
+			builds:   []metadata.Build{{SLSALevel: 2}},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: InsufficientSLSALevelViolation,
+					Reason:    InsufficientSLSALevelViolationReason(testutil.QualifiedImage, 2, 3),
+				},
+			},
+		},
+		{
+			name:     "at minimum",
+			minLevel: 3,
+			builds:   []metadata.Build{{SLSALevel: 3}},
+		},
+		{
+			name:     "one build above minimum",
+			minLevel: 3,
+			builds:   []metadata.Build{{SLSALevel: 1}, {SLSALevel: 4}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					MinSLSALevel: test.minLevel,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{builds: test.builds})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_DenyEmbeddedSecrets(t *testing.T) {
+	secret := metadata.SecretFinding{
+		Note:        "projects/scanner/notes/secret-aws-key",
+		Description: "AWS access key in /app/.env",
+	}
+	var tests = []struct {
+		name     string
+		deny     bool
+		secrets  []metadata.SecretFinding
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:    "secrets allowed",
+			secrets: []metadata.SecretFinding{secret},
+		},
+		{
+			name: "no secrets found",
+			deny: true,
+		},
+		{
+			name:    "secret found",
+			deny:    true,
+			secrets: []metadata.SecretFinding{secret},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: EmbeddedSecretViolation,
+					Reason:    EmbeddedSecretViolationReason(testutil.QualifiedImage, secret),
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DenyEmbeddedSecrets: test.deny,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{secrets: test.secrets})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_DenyMalware(t *testing.T) {
+	malware := metadata.MalwareFinding{
+		Note:        "projects/scanner/notes/malware-cryptominer",
+		Description: "xmrig in /usr/bin/worker",
+	}
+	violation := []SecurityPolicyViolation{
+		{
+			Violation: MalwareViolation,
+			Reason:    MalwareViolationReason(testutil.QualifiedImage, malware),
+		},
+	}
+	var tests = []struct {
+		name      string
+		deny      bool
+		whitelist []string
+		malware   []metadata.MalwareFinding
+		client    metadata.MetadataFetcher
+		expected  []SecurityPolicyViolation
+		shouldErr bool
+	}{
+		{
+			name:    "malware allowed",
+			malware: []metadata.MalwareFinding{malware},
+		},
+		{
+			name: "no malware found",
+			deny: true,
+		},
+		{
+			name:     "malware found",
+			deny:     true,
+			malware:  []metadata.MalwareFinding{malware},
+			expected: violation,
+		},
+		{
+			name:      "malware found in whitelisted image",
+			deny:      true,
+			whitelist: []string{testutil.QualifiedImage},
+			malware:   []metadata.MalwareFinding{malware},
+			expected:  violation,
+		},
+		{
+			name:      "backend doesn't scan for malware",
+			deny:      true,
+			client:    fetcherOnly{mockVulnzClient{}},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DenyMalware:    test.deny,
+					ImageWhitelist: test.whitelist,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						// Malware is denied regardless of severity settings
+						MaximumSeverity: "CRITICAL",
+					},
+				},
+			}
+			client := test.client
+			if client == nil {
+				client = mockVulnzClient{malware: test.malware}
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_RequireNotarySignature(t *testing.T) {
+	trust := &v1beta1.NotaryTrust{Server: "https://notary.example.com", PublicKeyData: "key"}
+	unsigned := fmt.Errorf("no signed target has digest sha256:0000")
+	var tests = []struct {
+		name      string
+		trust     *v1beta1.NotaryTrust
+		verifyErr error
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:      "signature not required",
+			verifyErr: unsigned,
+		},
+		{
+			name:  "signed image",
+			trust: trust,
+		},
+		{
+			name:      "unsigned image",
+			trust:     trust,
+			verifyErr: unsigned,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingSignatureViolation,
+					Reason:    MissingSignatureViolationReason(testutil.QualifiedImage, unsigned),
+				},
+			},
+		},
+	}
+	original := verifySignature
+	defer func() {
+		verifySignature = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifySignature = func(server string, publicKeyData string, image string) error {
+				if server != trust.Server || publicKeyData != trust.PublicKeyData {
+					t.Errorf("unexpected trust anchor %s %s", server, publicKeyData)
+				}
+				return test.verifyErr
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					RequireNotarySignature: test.trust,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_MaxImageSizeBytes(t *testing.T) {
+	var tests = []struct {
+		name     string
+		maxSize  int64
+		size     int64
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name: "no maximum",
+			size: 1 << 30,
+		},
+		{
+			name:    "below maximum",
+			maxSize: 100,
+			size:    99,
+		},
+		{
+			name:    "at maximum",
+			maxSize: 100,
+			size:    100,
+		},
+		{
+			name:    "above maximum",
+			maxSize: 100,
+			size:    101,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxImageSizeViolation,
+					Reason:    ExceedsMaxImageSizeViolationReason(testutil.QualifiedImage, 101, 100),
+				},
+			},
+		},
+	}
+	original := imageSize
+	defer func() {
+		imageSize = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageSize = func(image string) (int64, error) {
+				return test.size, nil
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					MaxImageSizeBytes: test.maxSize,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_MaxLayers(t *testing.T) {
+	var tests = []struct {
+		name      string
+		maxLayers int
+		layers    int
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:   "no maximum",
+			layers: 500,
+		},
+		{
+			name:      "below maximum",
+			maxLayers: 10,
+			layers:    9,
+		},
+		{
+			name:      "at maximum",
+			maxLayers: 10,
+			layers:    10,
+		},
+		{
+			name:      "above maximum",
+			maxLayers: 10,
+			layers:    11,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxLayersViolation,
+					Reason:    ExceedsMaxLayersViolationReason(testutil.QualifiedImage, 11, 10),
+				},
+			},
+		},
+	}
+	original := imageLayers
+	defer func() {
+		imageLayers = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageLayers = func(image string) (int, error) {
+				return test.layers, nil
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					MaxLayers: test.maxLayers,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_MaxLayersManifestError(t *testing.T) {
+	original := imageLayers
+	defer func() {
+		imageLayers = original
+	}()
+	imageLayers = func(image string) (int, error) {
+		return 0, fmt.Errorf("manifest unknown")
+	}
+	isp := v1beta1.ImageSecurityPolicy{
+		Spec: v1beta1.ImageSecurityPolicySpec{
+			MaxLayers: 10,
+		},
+	}
+	_, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+	testutil.CheckError(t, true, err)
+}
+
+func Test_RequireNonRootImage(t *testing.T) {
+	var tests = []struct {
+		name     string
+		require  bool
+		user     string
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name: "root image allowed",
+			user: "root",
+		},
+		{
+			name:    "non-root image",
+			require: true,
+			user:    "1000",
+		},
+		{
+			name:    "root image",
+			require: true,
+			user:    "root",
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: RootImageViolation,
+					Reason:    RootImageViolationReason(testutil.QualifiedImage, "root"),
+				},
+			},
+		},
+		{
+			name:    "image without a user",
+			require: true,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: RootImageViolation,
+					Reason:    RootImageViolationReason(testutil.QualifiedImage, ""),
+				},
+			},
+		},
+	}
+	original := imageUser
+	defer func() {
+		imageUser = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageUser = func(image string) (string, error) {
+				return test.user, nil
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					RequireNonRootImage: test.require,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_RequiredImageLabels(t *testing.T) {
+	source := "org.opencontainers.image.source"
+	revision := "org.opencontainers.image.revision"
+	var tests = []struct {
+		name     string
+		required []string
+		labels   map[string]string
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:   "no labels required",
+			labels: map[string]string{},
+		},
+		{
+			name:     "image with the required labels",
+			required: []string{source, revision},
+			labels:   map[string]string{source: "https://github.com/grafeas/kritis", revision: "0123abc", "maintainer": "kritis"},
+		},
+		{
+			name:     "image without a required label",
+			required: []string{source, revision},
+			labels:   map[string]string{source: "https://github.com/grafeas/kritis"},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingImageLabelsViolation,
+					Reason:    MissingImageLabelsViolationReason(testutil.QualifiedImage, []string{revision}),
+				},
+			},
+		},
+		{
+			name:     "image with an empty required label",
+			required: []string{source},
+			labels:   map[string]string{source: ""},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingImageLabelsViolation,
+					Reason:    MissingImageLabelsViolationReason(testutil.QualifiedImage, []string{source}),
+				},
+			},
+		},
+		{
+			name:     "image without labels",
+			required: []string{source, revision},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: MissingImageLabelsViolation,
+					Reason:    MissingImageLabelsViolationReason(testutil.QualifiedImage, []string{source, revision}),
+				},
+			},
+		},
+	}
+	original := imageLabels
+	defer func() {
+		imageLabels = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imageLabels = func(image string) (map[string]string, error) {
+				return test.labels, nil
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					RequiredImageLabels: test.required,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_ExposedPorts(t *testing.T) {
+	var tests = []struct {
+		name       string
+		disallowed []string
+		maxPorts   int
+		ports      []string
+		expected   []SecurityPolicyViolation
+	}{
+		{
+			name:  "no port requirements",
+			ports: []string{"23/tcp"},
+		},
+		{
+			name:       "allowed ports",
+			disallowed: []string{"23", "69/udp"},
+			ports:      []string{"443/tcp", "69/tcp", "8080/tcp"},
+		},
+		{
+			name:       "disallowed ports",
+			disallowed: []string{"23", "69/udp"},
+			ports:      []string{"23/tcp", "443/tcp", "69/udp"},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExposedPortViolation,
+					Reason:    DisallowedPortViolationReason(testutil.QualifiedImage, "23/tcp"),
+				},
+				{
+					Violation: ExposedPortViolation,
+					Reason:    DisallowedPortViolationReason(testutil.QualifiedImage, "69/udp"),
+				},
+			},
+		},
+		{
+			name:     "at maximum ports",
+			maxPorts: 2,
+			ports:    []string{"443/tcp", "80/tcp"},
+		},
+		{
+			name:     "above maximum ports",
+			maxPorts: 2,
+			ports:    []string{"443/tcp", "80/tcp", "8080/tcp"},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExposedPortViolation,
+					Reason:    ExceedsMaxExposedPortsViolationReason(testutil.QualifiedImage, 3, 2),
+				},
+			},
+		},
+	}
+	original := exposedPorts
+	defer func() {
+		exposedPorts = original
+	}()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exposedPorts = func(image string) ([]string, error) {
+				return test.ports, nil
+			}
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					DisallowedExposedPorts: test.disallowed,
+					MaxExposedPorts:        test.maxPorts,
+					PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+						MaximumSeverity: "MEDIUM",
+					},
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_AllowedInitContainerRegistries(t *testing.T) {
+	untrusted := "docker.io/someone/init@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	var tests = []struct {
+		name      string
+		allowed   []string
+		whitelist []string
+		image     string
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:  "any registry by default",
+			image: untrusted,
+		},
+		{
+			name:    "allowed registry",
+			allowed: []string{"gcr.io/image"},
+			image:   testutil.QualifiedImage,
+		},
+		{
+			name:    "disallowed registry",
+			allowed: []string{"gcr.io/image"},
+			image:   untrusted,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: InitContainerRegistryViolation,
+					Reason:    InitContainerRegistryViolationReason(untrusted, []string{"gcr.io/image"}),
+				},
+			},
+		},
+		{
+			name:      "whitelisted image",
+			allowed:   []string{"gcr.io/image"},
+			whitelist: []string{untrusted},
+			image:     untrusted,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					ImageWhitelist:                 test.whitelist,
+					AllowedInitContainerRegistries: test.allowed,
+				},
+			}
+			violations := ValidateInitContainerImage(isp, test.image)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, violations)
+		})
+	}
+}
+
+func Test_ValidateTenantImages(t *testing.T) {
+	own := "gcr.io/platform/team-a/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	other := "gcr.io/platform/team-b/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	similar := "gcr.io/platform/team-ab/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	var tests = []struct {
+		name      string
+		prefix    string
+		whitelist []string
+		images    []string
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:   "no tenant prefix",
+			images: []string{other},
+		},
+		{
+			name:   "same tenant",
+			prefix: "gcr.io/platform/{namespace}",
+			images: []string{own},
+		},
+		{
+			name:   "cross tenant",
+			prefix: "gcr.io/platform/{namespace}",
+			images: []string{own, other, similar},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: CrossTenantImageViolation,
+					Reason:    CrossTenantImageViolationReason(other, "team-a", "gcr.io/platform/team-a"),
+				},
+				{
+					Violation: CrossTenantImageViolation,
+					Reason:    CrossTenantImageViolationReason(similar, "team-a", "gcr.io/platform/team-a"),
+				},
+			},
+		},
+		{
+			name:      "whitelisted cross tenant image",
+			prefix:    "gcr.io/platform/{namespace}",
+			whitelist: []string{other},
+			images:    []string{own, other},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					ImageWhitelist:       test.whitelist,
+					TenantRegistryPrefix: test.prefix,
+				},
+			}
+			violations := ValidateTenantImages(isp, "team-a", test.images)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, violations)
+		})
+	}
+}
+
+func Test_ValidateServiceAccount(t *testing.T) {
+	sensitive := "gcr.io/my-project/payments/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	other := "gcr.io/my-project/web/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	bindings := []v1beta1.ServiceAccountBinding{
+		{
+			Images:          []string{"gcr.io/my-project/payments/*"},
+			ServiceAccounts: []string{"payments", "payments-batch"},
+		},
+	}
+	var tests = []struct {
+		name           string
+		serviceAccount string
+		governs        []string
+		images         []string
+		expected       []SecurityPolicyViolation
+	}{
+		{
+			name:           "approved service account",
+			serviceAccount: "payments-batch",
+			images:         []string{sensitive, other},
+		},
+		{
+			name:           "disapproved service account",
+			serviceAccount: "web",
+			images:         []string{sensitive, other},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ServiceAccountViolation,
+					Reason:    ServiceAccountViolationReason(sensitive, "web", []string{"payments", "payments-batch"}),
+				},
+			},
+		},
+		{
+			name:   "default service account",
+			images: []string{sensitive},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ServiceAccountViolation,
+					Reason:    ServiceAccountViolationReason(sensitive, "default", []string{"payments", "payments-batch"}),
+				},
+			},
+		},
+		{
+			name:           "unbound image",
+			serviceAccount: "web",
+			images:         []string{other},
+		},
+		{
+			name:           "ungoverned image",
+			serviceAccount: "web",
+			governs:        []string{"gcr.io/my-project/web/*"},
+			images:         []string{sensitive, other},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					Images:                 test.governs,
+					ServiceAccountBindings: bindings,
+				},
+			}
+			violations := ValidateServiceAccount(isp, test.serviceAccount, test.images)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, violations)
+		})
+	}
+}
+
+func Test_ValidateUnmatchedImages(t *testing.T) {
+	app := "gcr.io/my-project/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	sidecar := "gcr.io/sidecars/proxy@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	other := "gcr.io/other/image@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	var tests = []struct {
+		name          string
+		defaultAction string
+		images        []string
+		shouldErr     bool
+		expected      []SecurityPolicyViolation
+	}{
+		{
+			name:   "unmatched image allowed without default action",
+			images: []string{app, other},
+		},
+		{
+			name:          "unmatched image under allow default",
+			defaultAction: v1beta1.DefaultActionAllow,
+			images:        []string{app, other},
+		},
+		{
+			name:          "unmatched image under deny default",
+			defaultAction: v1beta1.DefaultActionDeny,
+			images:        []string{app, sidecar, other},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: UnmatchedImageViolation,
+					Reason:    UnmatchedImageViolationReason(other),
+				},
+			},
+		},
+		{
+			name:          "matched and whitelisted images under deny default",
+			defaultAction: v1beta1.DefaultActionDeny,
+			images:        []string{app, sidecar},
+		},
+		{
+			name:          "invalid default action",
+			defaultAction: "Block",
+			images:        []string{app},
+			shouldErr:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isps := []v1beta1.ImageSecurityPolicy{
+				{
+					Spec: v1beta1.ImageSecurityPolicySpec{
+						Images: []string{"gcr.io/my-project/*"},
+					},
+				},
+				{
+					Spec: v1beta1.ImageSecurityPolicySpec{
+						Images:         []string{"gcr.io/unused/*"},
+						ImageWhitelist: []string{sidecar},
+						DefaultAction:  test.defaultAction,
+					},
+				},
+			}
+			violations, err := ValidateUnmatchedImages(isps, test.images)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_PolicyImages(t *testing.T) {
+	app := "gcr.io/my-project/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	other := "gcr.io/other/image@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	var tests = []struct {
+		name     string
+		image    string
+		expected []SecurityPolicyViolation
+	}{
+		{
+			name:  "matched image validated",
+			image: app,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: UnknownImageViolation,
+					Reason:    UnknownImageViolationReason(app),
+				},
+			},
+		},
+		{
+			name:  "unmatched image skipped",
+			image: other,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					Images:            []string{"gcr.io/my-project/*"},
+					DenyUnknownImages: true,
+				},
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, test.image, mockVulnzClient{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+// mockStreamingClient returns vulnerabilities a page at a time, recording
+// how many pages were fetched
+type mockStreamingClient struct {
+	mockMetadataClient
+	pages   [][]metadata.Vulnerability
+	fetched *int
+}
+
+func (m mockStreamingClient) StreamVulnerabilities(containerImage string, fn func([]metadata.Vulnerability) bool) error {
+	for _, page := range m.pages {
+		*m.fetched++
+		if !fn(page) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func Test_StreamedVulnerabilities(t *testing.T) {
+	low := metadata.Vulnerability{CVE: "cve-low", Severity: "LOW"}
+	high := metadata.Vulnerability{CVE: "cve-high", Severity: "HIGH"}
+	requirements := v1beta1.PackageVulernerabilityRequirements{MaximumSeverity: "MEDIUM"}
+	var tests = []struct {
+		name          string
+		pages         [][]metadata.Vulnerability
+		maxCounts     map[string]int
+		stop          bool
+		expectedPages int
+		expected      []SecurityPolicyViolation
+	}{
+		{
+			name:          "all pages evaluated",
+			pages:         [][]metadata.Vulnerability{{low, low}, {low, low}, {low}},
+			stop:          true,
+			expectedPages: 3,
+		},
+		{
+			name:          "every violation without stopping",
+			pages:         [][]metadata.Vulnerability{{low, high}, {high}},
+			expectedPages: 2,
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: high,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, high, v1beta1.ImageSecurityPolicy{Spec: v1beta1.ImageSecurityPolicySpec{PackageVulernerabilityRequirements: requirements}}),
+				},
+				{
+					Vulnerability: high,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, high, v1beta1.ImageSecurityPolicy{Spec: v1beta1.ImageSecurityPolicySpec{PackageVulernerabilityRequirements: requirements}}),
+				},
+			},
+		},
+		{
+			name:          "stops after the page violating the policy",
+			pages:         [][]metadata.Vulnerability{{low, low}, {low, high}, {high}, {high}},
+			stop:          true,
+			expectedPages: 2,
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: high,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, high, v1beta1.ImageSecurityPolicy{Spec: v1beta1.ImageSecurityPolicySpec{PackageVulernerabilityRequirements: requirements}}),
+				},
+			},
+		},
+		{
+			name:          "stops after the page exceeding a count",
+			pages:         [][]metadata.Vulnerability{{low, low}, {low, low}, {low}},
+			maxCounts:     map[string]int{"LOW": 3},
+			stop:          true,
+			expectedPages: 2,
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: ExceedsMaxCountViolation,
+					Reason:    ExceedsMaxCountViolationReason(testutil.QualifiedImage, "LOW", 4, 3),
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					PackageVulernerabilityRequirements: requirements,
+				},
+			}
+			isp.Spec.PackageVulernerabilityRequirements.MaximumCounts = test.maxCounts
+			fetched := 0
+			client := mockStreamingClient{pages: test.pages, fetched: &fetched}
+			var opts []ValidateOption
+			if test.stop {
+				opts = append(opts, StopAtFirstViolation())
+			}
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client, opts...)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expectedPages, fetched)
+		})
+	}
+}
+
+// mockBuildsClient returns the builds of each image
+type mockBuildsClient struct {
+	mockMetadataClient
+	builds map[string][]metadata.Build
+}
+
+func (m mockBuildsClient) GetBuildDetails(containerImage string) ([]metadata.Build, error) {
+	return m.builds[containerImage], nil
+}
+
+func Test_ValidatePodProvenance(t *testing.T) {
+	app := "gcr.io/project/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	sidecar := "docker.io/someone/sidecar@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	var tests = []struct {
+		name      string
+		require   bool
+		whitelist []string
+		builds    map[string][]metadata.Build
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:    "consistent provenance",
+			require: true,
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project"}},
+				sidecar: {{Creator: "ci@project"}, {Creator: "other"}},
+			},
+		},
+		{
+			name:    "mixed provenance",
+			require: true,
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project"}},
+				sidecar: {{Creator: "someone"}},
+			},
+			expected: []SecurityPolicyViolation{{
+				Violation: InconsistentProvenanceViolation,
+				Reason: InconsistentProvenanceViolationReason(map[string][]string{
+					app:     {"ci@project"},
+					sidecar: {"someone"},
+				}),
+			}},
+		},
+		{
+			name:    "image without provenance",
+			require: true,
+			builds: map[string][]metadata.Build{
+				app: {{Creator: "ci@project"}},
+			},
+			expected: []SecurityPolicyViolation{{
+				Violation: InconsistentProvenanceViolation,
+				Reason: InconsistentProvenanceViolationReason(map[string][]string{
+					app:     {"ci@project"},
+					sidecar: {},
+				}),
+			}},
+		},
+		{
+			name:      "whitelisted image",
+			require:   true,
+			whitelist: []string{sidecar},
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project"}},
+				sidecar: {{Creator: "someone"}},
+			},
+		},
+		{
+			name: "not required",
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project"}},
+				sidecar: {{Creator: "someone"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					ImageWhitelist:              test.whitelist,
+					RequireConsistentProvenance: test.require,
+				},
+			}
+			violations, err := ValidatePodProvenance(isp, []string{app, sidecar}, mockBuildsClient{builds: test.builds})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
+
+func Test_ValidateSourceRepository(t *testing.T) {
+	app := "gcr.io/project/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	sidecar := "docker.io/someone/sidecar@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	var tests = []struct {
+		name      string
+		require   bool
+		source    string
+		whitelist []string
+		builds    map[string][]metadata.Build
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name:    "matching source repository",
+			require: true,
+			source:  "https://github.com/org/app",
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project", SourceURI: "https://github.com/org/app.git"}},
+				sidecar: {{Creator: "other", SourceURI: "https://github.com/org/other"}, {Creator: "ci@project", SourceURI: "https://GitHub.com/org/app/"}},
+			},
+		},
+		{
+			name:    "mismatched source repository",
+			require: true,
+			source:  "https://github.com/org/app",
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project", SourceURI: "https://github.com/org/app"}},
+				sidecar: {{Creator: "ci@project", SourceURI: "https://github.com/someone/fork"}},
+			},
+			expected: []SecurityPolicyViolation{{
+				Violation: SourceRepositoryMismatchViolation,
+				Reason:    Violation(fmt.Sprintf("%s was built from https://github.com/someone/fork instead of https://github.com/org/app", sidecar)),
+			}},
+		},
+		{
+			name:    "image without source provenance",
+			require: true,
+			source:  "https://github.com/org/app",
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project", SourceURI: "https://github.com/org/app"}},
+				sidecar: {{Creator: "ci@project"}},
+			},
+			expected: []SecurityPolicyViolation{{
+				Violation: SourceRepositoryMismatchViolation,
+				Reason:    SourceRepositoryMismatchViolationReason(sidecar, "https://github.com/org/app", nil),
+			}},
+		},
+		{
+			name:    "undeclared source repository",
+			require: true,
+			builds: map[string][]metadata.Build{
+				app: {{Creator: "ci@project", SourceURI: "https://github.com/org/app"}},
+			},
+			expected: []SecurityPolicyViolation{
+				{
+					Violation: SourceRepositoryMismatchViolation,
+					Reason:    SourceRepositoryMismatchViolationReason(app, "", nil),
+				},
+				{
+					Violation: SourceRepositoryMismatchViolation,
+					Reason:    SourceRepositoryMismatchViolationReason(sidecar, "", nil),
+				},
+			},
+		},
+		{
+			name:      "whitelisted image",
+			require:   true,
+			source:    "https://github.com/org/app",
+			whitelist: []string{sidecar},
+			builds: map[string][]metadata.Build{
+				app:     {{Creator: "ci@project", SourceURI: "https://github.com/org/app"}},
+				sidecar: {{Creator: "someone", SourceURI: "https://github.com/someone/fork"}},
+			},
+		},
+		{
+			name:   "not required",
+			source: "https://github.com/org/app",
+			builds: map[string][]metadata.Build{
+				sidecar: {{Creator: "someone", SourceURI: "https://github.com/someone/fork"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isp := v1beta1.ImageSecurityPolicy{
+				Spec: v1beta1.ImageSecurityPolicySpec{
+					ImageWhitelist:          test.whitelist,
+					RequireSourceRepository: test.require,
+				},
+			}
+			violations, err := ValidateSourceRepository(isp, test.source, []string{app, sidecar}, mockBuildsClient{builds: test.builds})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
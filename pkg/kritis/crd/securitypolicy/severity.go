@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	ca "google.golang.org/genproto/googleapis/devtools/containeranalysis/v1alpha1"
+)
+
+// severityOverrides are the severities of CVEs, by CVE ID, which replace
+// those the metadata backend reports
+var (
+	severityOverridesMu sync.RWMutex
+	severityOverrides   = map[string]string{}
+)
+
+// SetSeverityOverrides sets the severities of CVEs, by CVE ID such as
+// CVE-2021-44228, which replace those the metadata backend reports before
+// vulnerabilities are evaluated, so that an organization can re-score CVEs
+// for its environment. Severities are parsed by ParseSeverity, and invalid
+// ones are ignored.
+func SetSeverityOverrides(overrides map[string]string) {
+	parsed := map[string]string{}
+	for cve, severity := range overrides {
+		if s, err := ParseSeverity(severity); err == nil {
+			parsed[cve] = s
+		}
+	}
+	severityOverridesMu.Lock()
+	defer severityOverridesMu.Unlock()
+	severityOverrides = parsed
+}
+
+// ParseSeverity returns the severity named by s, e.g. CRITICAL, or the
+// severity of the CVSS v3 score s, e.g. 9.8 is CRITICAL and 5.3 MEDIUM
+func ParseSeverity(s string) (string, error) {
+	severity := strings.ToUpper(strings.TrimSpace(s))
+	if _, ok := ca.VulnerabilityType_Severity_value[severity]; ok && severity != ca.VulnerabilityType_SEVERITY_UNSPECIFIED.String() {
+		return severity, nil
+	}
+	score, err := strconv.ParseFloat(severity, 64)
+	if err != nil || score < 0 || score > 10 {
+		return "", fmt.Errorf("%q is neither a severity nor a CVSS score", s)
+	}
+	switch {
+	case score >= 9:
+		return ca.VulnerabilityType_CRITICAL.String(), nil
+	case score >= 7:
+		return ca.VulnerabilityType_HIGH.String(), nil
+	case score >= 4:
+		return ca.VulnerabilityType_MEDIUM.String(), nil
+	case score > 0:
+		return ca.VulnerabilityType_LOW.String(), nil
+	}
+	return ca.VulnerabilityType_MINIMAL.String(), nil
+}
+
+// withSeverityOverride returns v with the severity overriding the one of its
+// CVE, either a CVE ID or a note name ending in one, if any
+func withSeverityOverride(v metadata.Vulnerability) metadata.Vulnerability {
+	severityOverridesMu.RLock()
+	defer severityOverridesMu.RUnlock()
+	severity, ok := severityOverrides[v.CVE]
+	if !ok {
+		severity, ok = severityOverrides[path.Base(v.CVE)]
+	}
+	if ok {
+		v.Severity = severity
+	}
+	return v
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestParseSeverity(t *testing.T) {
+	var tests = []struct {
+		severity  string
+		expected  string
+		shouldErr bool
+	}{
+		{severity: "CRITICAL", expected: "CRITICAL"},
+		{severity: "high", expected: "HIGH"},
+		{severity: "10", expected: "CRITICAL"},
+		{severity: "9.0", expected: "CRITICAL"},
+		{severity: "8.9", expected: "HIGH"},
+		{severity: "5.3", expected: "MEDIUM"},
+		{severity: "0.1", expected: "LOW"},
+		{severity: "0", expected: "MINIMAL"},
+		{severity: "SEVERITY_UNSPECIFIED", shouldErr: true},
+		{severity: "SEVERE", shouldErr: true},
+		{severity: "10.1", shouldErr: true},
+		{severity: "", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.severity, func(t *testing.T) {
+			severity, err := ParseSeverity(test.severity)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, severity)
+		})
+	}
+}
+
+func Test_SeverityOverrides(t *testing.T) {
+	defer SetSeverityOverrides(nil)
+	medium := metadata.Vulnerability{
+		CVE:             "providers/goog-vulnz/notes/CVE-2022-22965",
+		Severity:        "MEDIUM",
+		HasFixAvailable: true,
+	}
+	critical := medium
+	critical.Severity = "CRITICAL"
+	client := mockVulnzClient{vulnz: []metadata.Vulnerability{medium}}
+	isp := v1beta1.ImageSecurityPolicy{
+		Spec: v1beta1.ImageSecurityPolicySpec{
+			PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+				MaximumSeverity: "MEDIUM",
+			},
+		},
+	}
+	var tests = []struct {
+		name      string
+		overrides map[string]string
+		expected  []SecurityPolicyViolation
+	}{
+		{
+			name: "reported severity within the threshold",
+		},
+		{
+			name:      "overridden severity exceeds the threshold",
+			overrides: map[string]string{"CVE-2022-22965": "CRITICAL"},
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: critical,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, critical, isp),
+				},
+			},
+		},
+		{
+			name:      "overridden score exceeds the threshold",
+			overrides: map[string]string{"CVE-2022-22965": "9.8"},
+			expected: []SecurityPolicyViolation{
+				{
+					Vulnerability: critical,
+					Violation:     ExceedsMaxSeverityViolation,
+					Reason:        ExceedsMaxSeverityViolationReason(testutil.QualifiedImage, critical, isp),
+				},
+			},
+		},
+		{
+			name:      "override of another CVE",
+			overrides: map[string]string{"CVE-2021-44228": "CRITICAL"},
+		},
+		{
+			name:      "overridden severity within the threshold",
+			overrides: map[string]string{"CVE-2022-22965": "LOW"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetSeverityOverrides(test.overrides)
+			violations, err := ValidateImageSecurityPolicy(isp, testutil.QualifiedImage, client)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, violations)
+		})
+	}
+}
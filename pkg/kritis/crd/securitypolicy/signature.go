@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/attestation"
+	"github.com/grafeas/kritis/pkg/kritis/constants"
+)
+
+// signedPolicy is the content of an ImageSecurityPolicy covered by its
+// signature. The namespace and name are covered so that a signature can't
+// be copied to another policy. Spec is normalized by normalizeJSON.
+type signedPolicy struct {
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Spec      interface{} `json:"spec"`
+}
+
+// SignImageSecurityPolicy returns the signature of isp with the given base64
+// encoded, armored PGP keys, to be set as its
+// constants.PolicySignatureAnnotation
+func SignImageSecurityPolicy(isp v1beta1.ImageSecurityPolicy, publicKey string, privateKey string) (string, error) {
+	message, err := policyMessage(isp)
+	if err != nil {
+		return "", err
+	}
+	return attestation.CreateMessageAttestation(publicKey, privateKey, message)
+}
+
+// VerifyPolicySignature checks isp carries a signature by publicKey of its
+// current content, so that it wasn't modified since a policy author signed it
+func VerifyPolicySignature(isp v1beta1.ImageSecurityPolicy, publicKey string) error {
+	signature, ok := isp.Annotations[constants.PolicySignatureAnnotation]
+	if !ok {
+		return fmt.Errorf("image security policy %s/%s is not signed", isp.Namespace, isp.Name)
+	}
+	signed, err := attestation.GetPlainMessage(publicKey, signature)
+	if err != nil {
+		return fmt.Errorf("signature of image security policy %s/%s is invalid: %v", isp.Namespace, isp.Name, err)
+	}
+	message, err := policyMessage(isp)
+	if err != nil {
+		return err
+	}
+	if string(signed) != message {
+		return fmt.Errorf("image security policy %s/%s was modified since it was signed", isp.Namespace, isp.Name)
+	}
+	return nil
+}
+
+// policyMessage returns the message signed by the signature of isp
+func policyMessage(isp v1beta1.ImageSecurityPolicy) (string, error) {
+	data, err := json.Marshal(isp.Spec)
+	if err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var spec interface{}
+	if err := decoder.Decode(&spec); err != nil {
+		return "", err
+	}
+	message, err := json.Marshal(signedPolicy{
+		Namespace: isp.Namespace,
+		Name:      isp.Name,
+		Spec:      normalizeJSON(spec),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
+
+// normalizeJSON returns the decoded JSON value v without nulls or empty
+// arrays and objects, so that a nil and an empty slice or map, which
+// encoding a policy as YAML and decoding it again may swap, are signed alike
+func normalizeJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		normalized := map[string]interface{}{}
+		for key, value := range v {
+			if value = normalizeJSON(value); value != nil {
+				normalized[key] = value
+			}
+		}
+		if len(normalized) == 0 {
+			return nil
+		}
+		return normalized
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		normalized := make([]interface{}, len(v))
+		for i, value := range v {
+			normalized[i] = normalizeJSON(value)
+		}
+		return normalized
+	}
+	return v
+}
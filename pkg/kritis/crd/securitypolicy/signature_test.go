@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package securitypolicy
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/constants"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVerifyPolicySignature(t *testing.T) {
+	publicKey, privateKey := createBase64KeyPair(t)
+	otherPublicKey, otherPrivateKey := createBase64KeyPair(t)
+	isp := v1beta1.ImageSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "strict", Namespace: "prod"},
+		Spec: v1beta1.ImageSecurityPolicySpec{
+			PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+				MaximumSeverity: "LOW",
+			},
+		},
+	}
+	signed := func(isp v1beta1.ImageSecurityPolicy, publicKey string, privateKey string) v1beta1.ImageSecurityPolicy {
+		signature, err := SignImageSecurityPolicy(isp, publicKey, privateKey)
+		testutil.CheckError(t, false, err)
+		isp = *isp.DeepCopy()
+		isp.Annotations = map[string]string{constants.PolicySignatureAnnotation: signature}
+		return isp
+	}
+	tampered := signed(isp, publicKey, privateKey)
+	tampered.Spec.PackageVulernerabilityRequirements.MaximumSeverity = "CRITICAL"
+	copied := signed(isp, publicKey, privateKey)
+	copied.Namespace = "dev"
+	emptied := signed(isp, publicKey, privateKey)
+	emptied.Spec.ImageWhitelist = []string{}
+	emptied.Spec.PackageVulernerabilityRequirements.MaximumCounts = map[string]int{}
+	var tests = []struct {
+		name      string
+		isp       v1beta1.ImageSecurityPolicy
+		shouldErr bool
+	}{
+		{
+			name: "validly signed policy",
+			isp:  signed(isp, publicKey, privateKey),
+		},
+		{
+			name: "empty instead of nil lists",
+			isp:  emptied,
+		},
+		{
+			name:      "tampered policy",
+			isp:       tampered,
+			shouldErr: true,
+		},
+		{
+			name:      "signature copied to another namespace",
+			isp:       copied,
+			shouldErr: true,
+		},
+		{
+			name:      "policy signed by another key",
+			isp:       signed(isp, otherPublicKey, otherPrivateKey),
+			shouldErr: true,
+		},
+		{
+			name:      "unsigned policy",
+			isp:       isp,
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyPolicySignature(test.isp, publicKey)
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+# Sample policy for ImageSecurityPolicies with opaDecision: kritis/deny.
+# Load it with `--opa-policy-file policy.rego`.
+package kritis
+
+deny[msg] {
+	v := input.vulnerabilities[_]
+	v.severity == "CRITICAL"
+	v.fixAvailable
+	msg := sprintf("%s is CRITICAL and has a fix available", [v.cve])
+}
+
+deny[msg] {
+	count(input.builds) == 0
+	msg := "no build provenance"
+}
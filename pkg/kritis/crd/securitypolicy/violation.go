@@ -21,6 +21,9 @@ import (
 	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
 	"github.com/grafeas/kritis/pkg/kritis/constants"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Violation string
@@ -31,6 +34,39 @@ const (
 	UnqualifiedImageViolation int = iota
 	FixesNotAvailableViolation
 	ExceedsMaxSeverityViolation
+	ExceedsMaxCountViolation
+	UnknownImageViolation
+	MissingProvenanceViolation
+	InsufficientSLSALevelViolation
+	EmbeddedSecretViolation
+	MissingSignatureViolation
+	ExceedsMaxImageSizeViolation
+	RootImageViolation
+	KnownExploitedViolation
+	TagReferenceViolation
+	FilterViolation
+	DisallowedOperatingSystemViolation
+	InitContainerRegistryViolation
+	NamespaceBudgetViolation
+	InconsistentProvenanceViolation
+	MalwareViolation
+	MissingImageLabelsViolation
+	UnattestedPrivilegedViolation
+	MissingAttestationViolation
+	IncompleteScanViolation
+	SourceRepositoryMismatchViolation
+	ExceedsMaxLayersViolation
+	UnknownDigestViolation
+	CrossTenantImageViolation
+	ExposedPortViolation
+	UnmatchedImageViolation
+	ServiceAccountViolation
+	OPAViolation
+	DisclosureAgeViolation
+	MissingNoteKindViolation
+	DeniedNoteKindViolation
+	UnsignedBuildTimestampViolation
+	StaleImageViolation
 )
 
 // SecurityPolicyViolation represents a vulnerability that violates an ISP
@@ -60,3 +96,220 @@ func ExceedsMaxSeverityViolationReason(image string, vulnz metadata.Vulnerabilit
 	return Violation(fmt.Sprintf("found CVE %s in %s, which has severity %s exceeding max severity %s", vulnz.CVE, image,
 		vulnz.Severity, maxSeverity))
 }
+
+// ExceedsMaxCountViolationReason returns a detailed reason if the number of CVEs of a severity exceeds its maximum
+func ExceedsMaxCountViolationReason(image string, severity string, count int, maxCount int) Violation {
+	return Violation(fmt.Sprintf("found %d CVEs with severity %s in %s, exceeding maximum count %d", count, severity, image, maxCount))
+}
+
+// UnknownImageViolationReason returns a detailed reason if there is no metadata for the image
+func UnknownImageViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("no metadata found for %s", image))
+}
+
+// UnknownDigestViolationReason returns a detailed reason if the image is
+// pinned to a digest which no scanner has seen
+func UnknownDigestViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("no metadata found for digest %s, which no scanner has seen", image))
+}
+
+// UnattestedUnknownDigestViolationReason returns a detailed reason if the
+// image is pinned to a digest which no scanner has seen, and has no valid
+// attestation the policy requires of such digests
+func UnattestedUnknownDigestViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("no metadata found for digest %s, which has no valid attestation the policy requires of unknown digests", image))
+}
+
+// IncompleteScanViolationReason returns a detailed reason if the latest scan
+// of the image, if any, didn't finish successfully
+func IncompleteScanViolationReason(image string, discovery *metadata.Discovery) Violation {
+	if discovery == nil {
+		return Violation(fmt.Sprintf("%s has not been scanned", image))
+	}
+	return Violation(fmt.Sprintf("the latest scan of %s has status %s, not FINISHED_SUCCESS", image, discovery.Status))
+}
+
+// MissingProvenanceViolationReason returns a detailed reason if there is no build provenance for the image
+func MissingProvenanceViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("no build provenance found for %s", image))
+}
+
+// InsufficientSLSALevelViolationReason returns a detailed reason if the image was built below the minimum SLSA level
+func InsufficientSLSALevelViolationReason(image string, level int, minLevel int) Violation {
+	return Violation(fmt.Sprintf("%s was built at SLSA level %d, below minimum level %d", image, level, minLevel))
+}
+
+// MalwareViolationReason returns a detailed reason if malware was detected in the image
+func MalwareViolationReason(image string, malware metadata.MalwareFinding) Violation {
+	if malware.Description != "" {
+		return Violation(fmt.Sprintf("found malware %s in %s: %s", malware.Note, image, malware.Description))
+	}
+	return Violation(fmt.Sprintf("found malware %s in %s", malware.Note, image))
+}
+
+// EmbeddedSecretViolationReason returns a detailed reason if a secret was detected in the image
+func EmbeddedSecretViolationReason(image string, secret metadata.SecretFinding) Violation {
+	if secret.Description != "" {
+		return Violation(fmt.Sprintf("found embedded secret %s in %s: %s", secret.Note, image, secret.Description))
+	}
+	return Violation(fmt.Sprintf("found embedded secret %s in %s", secret.Note, image))
+}
+
+// MissingSignatureViolationReason returns a detailed reason if the image has no valid Docker Content Trust signature
+func MissingSignatureViolationReason(image string, err error) Violation {
+	return Violation(fmt.Sprintf("%s has no valid Docker Content Trust signature: %v", image, err))
+}
+
+// ExceedsMaxImageSizeViolationReason returns a detailed reason if the image is larger than the maximum size
+func ExceedsMaxImageSizeViolationReason(image string, size int64, maxSize int64) Violation {
+	return Violation(fmt.Sprintf("%s is %d bytes, exceeding maximum size %d bytes", image, size, maxSize))
+}
+
+// ExceedsMaxLayersViolationReason returns a detailed reason if the image has more than the maximum number of layers
+func ExceedsMaxLayersViolationReason(image string, layers int, maxLayers int) Violation {
+	return Violation(fmt.Sprintf("%s has %d layers, exceeding maximum %d layers", image, layers, maxLayers))
+}
+
+// MissingImageLabelsViolationReason returns a detailed reason if the image's
+// config doesn't set required labels
+func MissingImageLabelsViolationReason(image string, missing []string) Violation {
+	return Violation(fmt.Sprintf("%s is missing required labels %s", image, strings.Join(missing, ", ")))
+}
+
+// UnattestedPrivilegedViolationReason returns a detailed reason if a pod with
+// elevated privileges runs an image without an attestation
+func UnattestedPrivilegedViolationReason(image string, privileges []string) Violation {
+	return Violation(fmt.Sprintf("%s has no valid attestation, which pods with elevated privileges require, and the pod has %s", image, strings.Join(privileges, ", ")))
+}
+
+// MissingAttestationViolationReason returns a detailed reason if a policy
+// requiring attestations is violated by an image without one
+func MissingAttestationViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("%s has no valid attestation, which the policy requires", image))
+}
+
+// UnsignedBuildTimestampViolationReason returns a detailed reason if a policy
+// with a maximum image age is violated by an image without a valid
+// attestation of its build timestamp
+func UnsignedBuildTimestampViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("%s has no valid attestation of its build timestamp, which the policy requires", image))
+}
+
+// StaleImageViolationReason returns a detailed reason if the signed build timestamp of an image is older than maxAge
+func StaleImageViolationReason(image string, built time.Time, maxAge time.Duration) Violation {
+	return Violation(fmt.Sprintf("%s was built at %s, longer than the maximum image age %s ago", image, built.Format(time.RFC3339), maxAge))
+}
+
+// DisallowedPortViolationReason returns a detailed reason if the image's config exposes a disallowed port
+func DisallowedPortViolationReason(image string, port string) Violation {
+	return Violation(fmt.Sprintf("%s exposes disallowed port %s", image, port))
+}
+
+// ExceedsMaxExposedPortsViolationReason returns a detailed reason if the image's config exposes more than the maximum number of ports
+func ExceedsMaxExposedPortsViolationReason(image string, ports int, maxPorts int) Violation {
+	return Violation(fmt.Sprintf("%s exposes %d ports, exceeding maximum %d ports", image, ports, maxPorts))
+}
+
+// RootImageViolationReason returns a detailed reason if the image runs as root
+func RootImageViolationReason(image string, user string) Violation {
+	if user == "" {
+		return Violation(fmt.Sprintf("%s runs as root, since its config doesn't set a user", image))
+	}
+	return Violation(fmt.Sprintf("%s runs as root user %q", image, user))
+}
+
+// KnownExploitedViolationReason returns a detailed reason if a CVE is on the Known Exploited Vulnerabilities list
+func KnownExploitedViolationReason(image string, vulnz metadata.Vulnerability) Violation {
+	return Violation(fmt.Sprintf("found CVE %s in %s, which is a known exploited vulnerability", vulnz.CVE, image))
+}
+
+// DisclosureAgeViolationReason returns a detailed reason if a CVE with a fix available is still unpatched longer than maxAge after its disclosure
+func DisclosureAgeViolationReason(image string, vulnz metadata.Vulnerability, disclosed time.Time, maxAge time.Duration) Violation {
+	return Violation(fmt.Sprintf("found CVE %s in %s, which was disclosed at %s and has a fix, but wasn't patched within %s", vulnz.CVE, image, disclosed.Format(time.RFC3339), maxAge))
+}
+
+// MissingNoteKindViolationReason returns a detailed reason if the image has no occurrence of a required kind of note
+func MissingNoteKindViolationReason(image string, kind string) Violation {
+	return Violation(fmt.Sprintf("%s has no %s occurrence, which is required", image, kind))
+}
+
+// DeniedNoteKindViolationReason returns a detailed reason if the image has an occurrence of a denied kind of note
+func DeniedNoteKindViolationReason(image string, kind string) Violation {
+	return Violation(fmt.Sprintf("%s has a %s occurrence, which is denied", image, kind))
+}
+
+// TagReferenceViolationReason returns a detailed reason if the image isn't referenced by digest
+func TagReferenceViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("%s is not referenced by digest", image))
+}
+
+// FilterViolationReason returns a detailed reason if a CVE matches the vulnerability filter
+func FilterViolationReason(image string, vulnz metadata.Vulnerability, isp v1beta1.ImageSecurityPolicy) Violation {
+	return Violation(fmt.Sprintf("found CVE %s in %s, which has severity %s and matches vulnerability filter %q", vulnz.CVE, image,
+		vulnz.Severity, isp.Spec.PackageVulernerabilityRequirements.VulnerabilityFilter))
+}
+
+// DisallowedOperatingSystemViolationReason returns a detailed reason if the image is based on a disallowed operating system
+func DisallowedOperatingSystemViolationReason(image string, cpe string, disallowed string) Violation {
+	return Violation(fmt.Sprintf("%s is based on operating system %s, which matches disallowed operating system %s", image, cpe, disallowed))
+}
+
+// InitContainerRegistryViolationReason returns a detailed reason if an init container image isn't from an allowed registry
+func InitContainerRegistryViolationReason(image string, allowed []string) Violation {
+	return Violation(fmt.Sprintf("init container image %s is not from an allowed registry %v", image, allowed))
+}
+
+// CrossTenantImageViolationReason returns a detailed reason if an image isn't from the registry prefix of the namespace's tenant
+func CrossTenantImageViolationReason(image string, namespace string, prefix string) Violation {
+	return Violation(fmt.Sprintf("%s is not from %s, which images in namespace %s must be from", image, prefix, namespace))
+}
+
+// UnmatchedImageViolationReason returns a detailed reason if no policy validates or whitelists an image under a default deny
+func UnmatchedImageViolationReason(image string) Violation {
+	return Violation(fmt.Sprintf("%s is not matched by any image security policy, and unmatched images are denied by default", image))
+}
+
+// ServiceAccountViolationReason returns a detailed reason if an image runs under a service account it isn't bound to
+func ServiceAccountViolationReason(image string, serviceAccount string, allowed []string) Violation {
+	return Violation(fmt.Sprintf("%s may only run under service accounts %v, not %s", image, allowed, serviceAccount))
+}
+
+// OPAViolationReason returns a detailed reason if the OPA decision of a policy denies an image with message
+func OPAViolationReason(image string, message string) Violation {
+	return Violation(fmt.Sprintf("%s is denied by OPA: %s", image, message))
+}
+
+// NamespaceBudgetViolationReason returns a detailed reason if a pod would exceed the vulnerability budget of its namespace
+func NamespaceBudgetViolationReason(namespace string, total int, max int) Violation {
+	return Violation(fmt.Sprintf("images running in namespace %s would have %d vulnerabilities, exceeding its budget of %d", namespace, total, max))
+}
+
+// SourceRepositoryMismatchViolationReason returns a detailed reason if the
+// image wasn't built from the source repository its pod declares, if any
+func SourceRepositoryMismatchViolationReason(image string, expected string, sources []string) Violation {
+	if expected == "" {
+		return Violation(fmt.Sprintf("the pod doesn't declare the source repository of %s with annotation %s, which workloads set on their pod template", image, constants.SourceRepositoryAnnotation))
+	}
+	if len(sources) == 0 {
+		return Violation(fmt.Sprintf("no build provenance with a source repository found for %s, which must be built from %s", image, expected))
+	}
+	return Violation(fmt.Sprintf("%s was built from %s instead of %s", image, strings.Join(sources, ", "), expected))
+}
+
+// InconsistentProvenanceViolationReason returns a detailed reason if the images of a pod weren't all built by the same builder
+func InconsistentProvenanceViolationReason(builders map[string][]string) Violation {
+	images := []string{}
+	for image := range builders {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	built := []string{}
+	for _, image := range images {
+		if len(builders[image]) == 0 {
+			built = append(built, fmt.Sprintf("%s has no build provenance", image))
+			continue
+		}
+		built = append(built, fmt.Sprintf("%s was built by %s", image, strings.Join(builders[image], ", ")))
+	}
+	return Violation(fmt.Sprintf("images weren't all built by the same builder: %s", strings.Join(built, "; ")))
+}
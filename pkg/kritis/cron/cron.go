@@ -18,6 +18,7 @@ package cron
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/grafeas/kritis/pkg/kritis/pods"
@@ -25,11 +26,13 @@ import (
 	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
 	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
 	"github.com/grafeas/kritis/pkg/kritis/metadata/containeranalysis"
+	"github.com/grafeas/kritis/pkg/kritis/util"
 	"github.com/grafeas/kritis/pkg/kritis/violation"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 // For testing
@@ -46,6 +49,22 @@ type Config struct {
 	ViolationChecker     violationChecker
 	ViolationStrategy    violation.Strategy
 	SecurityPolicyLister func(namespace string) ([]v1beta1.ImageSecurityPolicy, error)
+	// Workers is how many images are checked at once. Defaults to 1.
+	Workers int
+	// ChecksPerSecond limits how many images are checked per second across
+	// all workers, to spare the metadata backend. 0 means unlimited.
+	ChecksPerSecond float64
+	// VulnerabilityCounter counts the vulnerabilities of an image, to track
+	// the vulnerability budgets of namespaces. If nil, they aren't tracked.
+	VulnerabilityCounter func(image string) (int, error)
+	// PruneExpiredWhitelists removes expired CVE whitelist entries from
+	// ImageSecurityPolicies with SecurityPolicyUpdater, instead of only
+	// reporting them
+	PruneExpiredWhitelists bool
+	SecurityPolicyUpdater  func(v1beta1.ImageSecurityPolicy) error
+	// EventRecorder records events about ImageSecurityPolicies. If nil, no
+	// events are recorded.
+	EventRecorder eventRecorder
 }
 
 var (
@@ -55,14 +74,22 @@ var (
 func NewCronConfig(cs *kubernetes.Clientset, ca containeranalysis.ContainerAnalysis) *Config {
 
 	vc := func(image string, isp v1beta1.ImageSecurityPolicy) ([]securitypolicy.SecurityPolicyViolation, error) {
-		return securitypolicy.ValidateImageSecurityPolicy(isp, image, ca)
+		return securitypolicy.ValidateImageSecurityPolicy(isp, image, ca, securitypolicy.RequestRescans())
+	}
+
+	counter := func(image string) (int, error) {
+		vulnz, err := ca.GetVulnerabilities(image)
+		return len(vulnz), err
 	}
 
 	cfg := Config{
-		PodLister:            pods.Pods,
-		ViolationChecker:     vc,
-		ViolationStrategy:    defaultViolationStrategy,
-		SecurityPolicyLister: securitypolicy.ImageSecurityPolicies,
+		PodLister:             pods.Pods,
+		ViolationChecker:      vc,
+		ViolationStrategy:     defaultViolationStrategy,
+		SecurityPolicyLister:  securitypolicy.ImageSecurityPolicies,
+		VulnerabilityCounter:  counter,
+		SecurityPolicyUpdater: securitypolicy.UpdateImageSecurityPolicy,
+		EventRecorder:         newEventRecorder(cs),
 	}
 	return &cfg
 }
@@ -80,6 +107,9 @@ func Start(ctx context.Context, cfg Config, checkInterval time.Duration) {
 				logrus.Errorf("fetching image security policies: %s", err)
 				continue
 			}
+			if err := CheckExpiredWhitelists(cfg, isps); err != nil {
+				logrus.Errorf("error checking expired whitelists: %s", err)
+			}
 			if err := podChecker(cfg, isps); err != nil {
 				logrus.Errorf("error checking pods: %s", err)
 			}
@@ -89,25 +119,144 @@ func Start(ctx context.Context, cfg Config, checkInterval time.Duration) {
 	}
 }
 
+// imageCheck is an image to check against an ImageSecurityPolicy, and the
+// pods running it
+type imageCheck struct {
+	isp   v1beta1.ImageSecurityPolicy
+	image string
+	pods  []corev1.Pod
+}
+
 // CheckPods checks all running pods against defined policies.
+// Each image is checked once per policy, however many pods run it.
+// The vulnerabilities of the images running in namespaces with a
+// vulnerability budget are counted too.
 func CheckPods(cfg Config, isps []v1beta1.ImageSecurityPolicy) error {
+	checks := []*imageCheck{}
+	budgeted := map[string][]string{}
 	for _, isp := range isps {
 		ps, err := cfg.PodLister(isp.Namespace)
 		if err != nil {
 			return err
 		}
+		if isp.Spec.MaxNamespaceVulnerabilities > 0 {
+			if _, ok := budgeted[isp.Namespace]; !ok {
+				budgeted[isp.Namespace] = []string{}
+				for _, p := range ps {
+					budgeted[isp.Namespace] = append(budgeted[isp.Namespace], pods.Images(p)...)
+				}
+			}
+		}
+		byImage := map[string]*imageCheck{}
 		for _, p := range ps {
-			for _, c := range pods.Images(p) {
-				v, err := cfg.ViolationChecker(c, isp)
-				if err != nil {
-					return err
+			for _, image := range pods.Images(p) {
+				key := image
+				if normalized, err := util.NormalizeImage(image); err == nil {
+					key = normalized
+				}
+				check, ok := byImage[key]
+				if !ok {
+					check = &imageCheck{isp: isp, image: image}
+					byImage[key] = check
+					checks = append(checks, check)
 				}
-				if len(v) != 0 {
-					if err := cfg.ViolationStrategy.HandleViolation(c, &p, v); err != nil {
-						logrus.Errorf("handling violations: %s", err)
-					}
+				check.pods = append(check.pods, p)
+			}
+		}
+	}
+	if err := runChecks(cfg, checks); err != nil {
+		return err
+	}
+	return countNamespaceVulnerabilities(cfg, budgeted)
+}
+
+// countNamespaceVulnerabilities counts the vulnerabilities of each image
+// running in each namespace of budgeted, which lists the images its pods run
+func countNamespaceVulnerabilities(cfg Config, budgeted map[string][]string) error {
+	if cfg.VulnerabilityCounter == nil {
+		return nil
+	}
+	for namespace, images := range budgeted {
+		byImage := map[string]int{}
+		for _, image := range images {
+			if _, ok := byImage[image]; ok {
+				continue
+			}
+			n, err := cfg.VulnerabilityCounter(image)
+			if err != nil {
+				return err
+			}
+			byImage[image] = n
+		}
+		securitypolicy.SetNamespaceVulnerabilities(namespace, byImage)
+	}
+	return nil
+}
+
+// runChecks runs checks in cfg.Workers workers, at no more than
+// cfg.ChecksPerSecond. It returns the first error, after which no more
+// checks are started.
+func runChecks(cfg Config, checks []*imageCheck) error {
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if cfg.ChecksPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.ChecksPerSecond), 1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs := make(chan *imageCheck)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range jobs {
+				if err := runCheck(ctx, cfg, limiter, check); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
 				}
 			}
+		}()
+	}
+feed:
+	for _, check := range checks {
+		select {
+		case jobs <- check:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+// runCheck checks an image, once limiter allows it, and handles its
+// violations in every pod running it
+func runCheck(ctx context.Context, cfg Config, limiter *rate.Limiter, check *imageCheck) error {
+	if err := limiter.Wait(ctx); err != nil {
+		// Another check failed
+		return nil
+	}
+	v, err := cfg.ViolationChecker(check.image, check.isp)
+	if err != nil {
+		return err
+	}
+	if len(v) == 0 {
+		return nil
+	}
+	for i := range check.pods {
+		if err := cfg.ViolationStrategy.HandleViolation(check.image, &check.pods[i], v); err != nil {
+			logrus.Errorf("handling violations: %s", err)
 		}
 	}
 	return nil
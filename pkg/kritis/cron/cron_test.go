@@ -18,6 +18,8 @@ package cron
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -197,3 +199,187 @@ func TestCheckPods(t *testing.T) {
 		}
 	}
 }
+
+// countingChecker counts checks of each image, and the most run at once
+type countingChecker struct {
+	mu      sync.Mutex
+	checks  map[string]int
+	running int
+	maxRun  int
+}
+
+func (c *countingChecker) violationChecker(image string, isp v1beta1.ImageSecurityPolicy) ([]securitypolicy.SecurityPolicyViolation, error) {
+	c.mu.Lock()
+	c.checks[image]++
+	c.running++
+	if c.running > c.maxRun {
+		c.maxRun = c.running
+	}
+	c.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return nil, nil
+}
+
+func podWithImages(name string, images ...string) v1.Pod {
+	p := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	for _, image := range images {
+		p.Spec.Containers = append(p.Spec.Containers, v1.Container{Image: image})
+	}
+	return p
+}
+
+func TestCheckPodsDedupesImages(t *testing.T) {
+	shared := "gcr.io/foo/shared@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	lister := testLister{
+		pl: []v1.Pod{
+			podWithImages("a", shared, "gcr.io/foo/a@sha256:1111111111111111111111111111111111111111111111111111111111111111"),
+			podWithImages("b", shared),
+			podWithImages("c", "gcr.io/foo/shared@sha256:0000000000000000000000000000000000000000000000000000000000000000"),
+		},
+	}
+	checker := &countingChecker{checks: map[string]int{}}
+	cfg := Config{
+		ViolationChecker:  checker.violationChecker,
+		PodLister:         lister.list,
+		ViolationStrategy: &violation.MemoryStrategy{Violations: map[string]bool{}},
+	}
+	if err := CheckPods(cfg, isps); err != nil {
+		t.Fatalf("CheckPods() error = %v", err)
+	}
+	if checker.checks[shared] != 1 {
+		t.Errorf("expected %s to be checked once, got %d", shared, checker.checks[shared])
+	}
+	if len(checker.checks) != 2 {
+		t.Errorf("expected 2 images to be checked, got %v", checker.checks)
+	}
+}
+
+// violatingStrategy records the pods it handled violations in
+type violatingStrategy struct {
+	mu   sync.Mutex
+	pods []string
+}
+
+func (s *violatingStrategy) HandleViolation(image string, p *v1.Pod, v []securitypolicy.SecurityPolicyViolation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pods = append(s.pods, p.Name)
+	return nil
+}
+
+func TestCheckPodsHandlesEveryPod(t *testing.T) {
+	image := "gcr.io/foo/bar@sha256:baz"
+	lister := testLister{
+		pl: []v1.Pod{podWithImages("a", image), podWithImages("b", image)},
+	}
+	vulnz := imageViolations{imageMap: map[string]bool{image: true}}
+	strategy := &violatingStrategy{}
+	cfg := Config{
+		ViolationChecker:  vulnz.violationChecker,
+		PodLister:         lister.list,
+		ViolationStrategy: strategy,
+	}
+	if err := CheckPods(cfg, isps); err != nil {
+		t.Fatalf("CheckPods() error = %v", err)
+	}
+	if len(strategy.pods) != 2 {
+		t.Errorf("expected violations in pods a and b, got %v", strategy.pods)
+	}
+}
+
+func TestCheckPodsBoundedConcurrency(t *testing.T) {
+	lister := testLister{}
+	for i := 0; i < 12; i++ {
+		lister.pl = append(lister.pl, podWithImages(fmt.Sprintf("pod-%d", i), fmt.Sprintf("gcr.io/foo/image-%d@sha256:baz", i)))
+	}
+	checker := &countingChecker{checks: map[string]int{}}
+	cfg := Config{
+		ViolationChecker: checker.violationChecker,
+		PodLister:        lister.list,
+		Workers:          3,
+	}
+	if err := CheckPods(cfg, isps); err != nil {
+		t.Fatalf("CheckPods() error = %v", err)
+	}
+	if len(checker.checks) != 12 {
+		t.Errorf("expected 12 images to be checked, got %d", len(checker.checks))
+	}
+	if checker.maxRun > 3 {
+		t.Errorf("expected at most 3 concurrent checks, got %d", checker.maxRun)
+	}
+}
+
+func TestCheckPodsRateLimited(t *testing.T) {
+	lister := testLister{}
+	for i := 0; i < 3; i++ {
+		lister.pl = append(lister.pl, podWithImages(fmt.Sprintf("pod-%d", i), fmt.Sprintf("gcr.io/foo/image-%d@sha256:baz", i)))
+	}
+	cfg := Config{
+		ViolationChecker: noVulnz.violationChecker,
+		PodLister:        lister.list,
+		Workers:          3,
+		ChecksPerSecond:  20,
+	}
+	start := time.Now()
+	if err := CheckPods(cfg, isps); err != nil {
+		t.Fatalf("CheckPods() error = %v", err)
+	}
+	// The first check is allowed immediately, and the others every 50ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected 3 checks at 20 per second to take at least 100ms, took %s", elapsed)
+	}
+}
+
+func TestCheckPodsError(t *testing.T) {
+	cfg := Config{
+		ViolationChecker: func(image string, isp v1beta1.ImageSecurityPolicy) ([]securitypolicy.SecurityPolicyViolation, error) {
+			return nil, fmt.Errorf("metadata unavailable")
+		},
+		PodLister: testPods.list,
+		Workers:   2,
+	}
+	if err := CheckPods(cfg, isps); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestCheckPodsCountsNamespaceVulnerabilities(t *testing.T) {
+	a := "gcr.io/foo/a@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	b := "gcr.io/foo/b@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	lister := testLister{
+		pl: []v1.Pod{podWithImages("a", a, b), podWithImages("b", a)},
+	}
+	counted := map[string]int{}
+	checker := &countingChecker{checks: map[string]int{}}
+	cfg := Config{
+		ViolationChecker:  checker.violationChecker,
+		PodLister:         lister.list,
+		ViolationStrategy: &violation.MemoryStrategy{Violations: map[string]bool{}},
+		VulnerabilityCounter: func(image string) (int, error) {
+			counted[image]++
+			return 3, nil
+		},
+	}
+	budgeted := []v1beta1.ImageSecurityPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "budgeted"},
+			Spec:       v1beta1.ImageSecurityPolicySpec{MaxNamespaceVulnerabilities: 10},
+		},
+	}
+	if err := CheckPods(cfg, budgeted); err != nil {
+		t.Fatalf("CheckPods() error = %v", err)
+	}
+	if counted[a] != 1 || counted[b] != 1 {
+		t.Errorf("expected each image to be counted once, got %v", counted)
+	}
+	byImage, ok := securitypolicy.NamespaceVulnerabilities("budgeted")
+	if !ok || len(byImage) != 2 {
+		t.Errorf("expected vulnerabilities of 2 images in namespace budgeted, got %v", byImage)
+	}
+	if _, ok := securitypolicy.NamespaceVulnerabilities("foo"); ok {
+		t.Error("expected vulnerabilities in namespace foo, which has no budget, not to be counted")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cron
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metrics"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ExpiredWhitelistEntryReason is the reason of events reporting an
+	// expired CVE whitelist entry
+	ExpiredWhitelistEntryReason = "ExpiredWhitelistEntry"
+	// PrunedWhitelistEntryReason is the reason of events reporting an
+	// expired CVE whitelist entry was removed
+	PrunedWhitelistEntryReason = "PrunedWhitelistEntry"
+)
+
+// eventRecorder records an event about an ImageSecurityPolicy
+type eventRecorder func(isp v1beta1.ImageSecurityPolicy, reason string, message string) error
+
+// CheckExpiredWhitelists reports the expired CVE whitelist entries of isps
+// as events and metrics, and removes them from their ImageSecurityPolicy if
+// cfg.PruneExpiredWhitelists is set.
+func CheckExpiredWhitelists(cfg Config, isps []v1beta1.ImageSecurityPolicy) error {
+	total := 0
+	for _, isp := range isps {
+		expired := securitypolicy.ExpiredWhitelistCVEs(isp)
+		if len(expired) == 0 {
+			continue
+		}
+		total += len(expired)
+		reason := ExpiredWhitelistEntryReason
+		if cfg.PruneExpiredWhitelists {
+			if err := cfg.SecurityPolicyUpdater(securitypolicy.WithoutExpiredWhitelistCVEs(isp)); err != nil {
+				return err
+			}
+			reason = PrunedWhitelistEntryReason
+		}
+		for _, w := range expired {
+			message := fmt.Sprintf("whitelist entry for %s in %v expired at %s", w.CVE, w.Images, w.Expires.Format(time.RFC3339))
+			if cfg.PruneExpiredWhitelists {
+				message = fmt.Sprintf("removed %s", message)
+			}
+			logrus.Warnf("image security policy %s/%s: %s", isp.Namespace, isp.Name, message)
+			if cfg.EventRecorder == nil {
+				continue
+			}
+			if err := cfg.EventRecorder(isp, reason, message); err != nil {
+				logrus.Errorf("recording event: %s", err)
+			}
+		}
+	}
+	metrics.SetExpiredWhitelistEntries(total)
+	return nil
+}
+
+// newEventRecorder returns an eventRecorder creating warning events with cs
+func newEventRecorder(cs kubernetes.Interface) eventRecorder {
+	return func(isp v1beta1.ImageSecurityPolicy, reason string, message string) error {
+		now := metav1.Now()
+		e := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: isp.Name + ".",
+				Namespace:    isp.Namespace,
+			},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion:      v1beta1.SchemeGroupVersion.String(),
+				Kind:            "ImageSecurityPolicy",
+				Namespace:       isp.Namespace,
+				Name:            isp.Name,
+				UID:             isp.UID,
+				ResourceVersion: isp.ResourceVersion,
+			},
+			Reason:         reason,
+			Message:        message,
+			Type:           corev1.EventTypeWarning,
+			Source:         corev1.EventSource{Component: "kritis"},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}
+		_, err := cs.CoreV1().Events(isp.Namespace).Create(e)
+		return err
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/apis/kritis/v1beta1"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckExpiredWhitelists(t *testing.T) {
+	past := metav1.NewTime(time.Now().Add(-time.Minute))
+	future := metav1.NewTime(time.Now().Add(time.Hour))
+	current := v1beta1.ScopedCVE{CVE: "cve2", Images: []string{"gcr.io/foo/a@*"}, Expires: &future}
+	policies := []v1beta1.ImageSecurityPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "expired"},
+			Spec: v1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+					ScopedWhitelistCVEs: []v1beta1.ScopedCVE{
+						{CVE: "cve1", Images: []string{"gcr.io/foo/a@*"}, Expires: &past},
+						current,
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "current"},
+			Spec: v1beta1.ImageSecurityPolicySpec{
+				PackageVulernerabilityRequirements: v1beta1.PackageVulernerabilityRequirements{
+					ScopedWhitelistCVEs: []v1beta1.ScopedCVE{current},
+				},
+			},
+		},
+	}
+	var tests = []struct {
+		name    string
+		prune   bool
+		reason  string
+		updated []v1beta1.ScopedCVE
+	}{
+		{
+			name:   "reported",
+			reason: ExpiredWhitelistEntryReason,
+		},
+		{
+			name:    "pruned",
+			prune:   true,
+			reason:  PrunedWhitelistEntryReason,
+			updated: []v1beta1.ScopedCVE{current},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var updated []v1beta1.ImageSecurityPolicy
+			events := map[string][]string{}
+			cfg := Config{
+				PruneExpiredWhitelists: test.prune,
+				SecurityPolicyUpdater: func(isp v1beta1.ImageSecurityPolicy) error {
+					updated = append(updated, isp)
+					return nil
+				},
+				EventRecorder: func(isp v1beta1.ImageSecurityPolicy, reason string, message string) error {
+					events[isp.Name] = append(events[isp.Name], reason)
+					return nil
+				},
+			}
+			if err := CheckExpiredWhitelists(cfg, policies); err != nil {
+				t.Fatalf("CheckExpiredWhitelists() error = %v", err)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, map[string][]string{"expired": {test.reason}}, events)
+			if !test.prune {
+				if len(updated) != 0 {
+					t.Errorf("expected no policies to be updated, got %v", updated)
+				}
+				return
+			}
+			if len(updated) != 1 || updated[0].Name != "expired" {
+				t.Fatalf("expected only policy expired to be updated, got %v", updated)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.updated, updated[0].Spec.PackageVulernerabilityRequirements.ScopedWhitelistCVEs)
+		})
+	}
+}
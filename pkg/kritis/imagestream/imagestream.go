@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imagestream resolves images pulled from the OpenShift internal
+// registry to the external images their ImageStreams were imported from,
+// which is where vulnerability metadata is stored.
+package imagestream
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// internalRegistry matches the service hostnames of the OpenShift internal
+// registry in OpenShift 4 and 3.x respectively
+var internalRegistry = regexp.MustCompile(`^(image-registry\.openshift-image-registry|docker-registry\.default)\.svc(\.cluster\.local)?(:[0-9]+)?$`)
+
+// Reference is an image pulled from the internal registry by digest
+type Reference struct {
+	Namespace string
+	Name      string
+	Digest    string
+}
+
+// ParseReference returns the ImageStream reference of image, and false if
+// image is not a digest-pinned image in the OpenShift internal registry
+func ParseReference(image string) (Reference, bool) {
+	parts := strings.Split(image, "@")
+	if len(parts) != 2 {
+		return Reference{}, false
+	}
+	path := strings.Split(parts[0], "/")
+	if len(path) != 3 || !internalRegistry.MatchString(path[0]) {
+		return Reference{}, false
+	}
+	name := path[2]
+	// The digest pins the image, so any tag is irrelevant
+	if i := strings.Index(name, ":"); i != -1 {
+		name = name[:i]
+	}
+	return Reference{Namespace: path[1], Name: name, Digest: parts[1]}, true
+}
+
+// ImageStream holds the parts of an image.openshift.io/v1 ImageStream needed
+// to find the source of an image
+type ImageStream struct {
+	Status struct {
+		Tags []struct {
+			Tag   string `json:"tag"`
+			Items []struct {
+				DockerImageReference string `json:"dockerImageReference"`
+				Image                string `json:"image"`
+			} `json:"items"`
+		} `json:"tags"`
+	} `json:"status"`
+}
+
+// SourceImage returns the external image digest was imported from
+func (is ImageStream) SourceImage(digest string) (string, error) {
+	for _, tag := range is.Status.Tags {
+		for _, item := range tag.Items {
+			if item.Image == digest {
+				return item.DockerImageReference, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no tag in image stream has image %s", digest)
+}
+
+// Resolve returns the external source of image if it is pulled from an
+// ImageStream in the OpenShift internal registry, and image otherwise
+func Resolve(image string) (string, error) {
+	ref, ok := ParseReference(image)
+	if !ok {
+		return image, nil
+	}
+	is, err := get(ref.Namespace, ref.Name)
+	if err != nil {
+		return "", err
+	}
+	return is.SourceImage(ref.Digest)
+}
+
+// The clientset of the cluster, created on first use and shared by every
+// resolution, rather than created for each image. If creating it fails, it
+// is created again on next use.
+var (
+	clientsetMu sync.Mutex
+	clientset   kubernetes.Interface
+)
+
+// inClusterClientset returns the shared clientset of the cluster
+func inClusterClientset() (kubernetes.Interface, error) {
+	clientsetMu.Lock()
+	defer clientsetMu.Unlock()
+	if clientset != nil {
+		return clientset, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error building config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building clientset: %v", err)
+	}
+	clientset = client
+	return client, nil
+}
+
+func get(namespace, name string) (*ImageStream, error) {
+	client, err := inClusterClientset()
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.CoreV1().RESTClient().Get().
+		AbsPath("/apis/image.openshift.io/v1/namespaces", namespace, "imagestreams", name).
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("error getting image stream %s/%s: %v", namespace, name, err)
+	}
+	is := ImageStream{}
+	if err := json.Unmarshal(data, &is); err != nil {
+		return nil, err
+	}
+	return &is, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagestream
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+const (
+	digest = "sha256:2f1f9ba0e3d2a1b6c1a5f24a7c4b1e0f1b1c8c2d7e5c9a3b0d4e6f8a1b2c3d4e"
+	source = "quay.io/acme/frontend@" + digest
+)
+
+func TestParseReference(t *testing.T) {
+	var tests = []struct {
+		name     string
+		image    string
+		expected Reference
+		ok       bool
+	}{
+		{
+			name:     "openshift 4 registry",
+			image:    "image-registry.openshift-image-registry.svc:5000/shop/frontend@" + digest,
+			expected: Reference{Namespace: "shop", Name: "frontend", Digest: digest},
+			ok:       true,
+		},
+		{
+			name:     "openshift 3 registry with tag",
+			image:    "docker-registry.default.svc:5000/shop/frontend:v1@" + digest,
+			expected: Reference{Namespace: "shop", Name: "frontend", Digest: digest},
+			ok:       true,
+		},
+		{
+			name:     "fully qualified service name",
+			image:    "image-registry.openshift-image-registry.svc.cluster.local:5000/shop/frontend@" + digest,
+			expected: Reference{Namespace: "shop", Name: "frontend", Digest: digest},
+			ok:       true,
+		},
+		{
+			name:  "not pinned by digest",
+			image: "image-registry.openshift-image-registry.svc:5000/shop/frontend:v1",
+		},
+		{
+			name:  "external registry",
+			image: source,
+		},
+		{
+			name:  "lookalike host",
+			image: "image-registry.openshift-image-registry.svc.evil.com/shop/frontend@" + digest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ref, ok := ParseReference(test.image)
+			if ok != test.ok {
+				t.Fatalf("expected ok to be %t, got %t", test.ok, ok)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, ref)
+		})
+	}
+}
+
+func TestSourceImage(t *testing.T) {
+	data := `{
+		"kind": "ImageStream",
+		"apiVersion": "image.openshift.io/v1",
+		"metadata": {"name": "frontend", "namespace": "shop"},
+		"status": {
+			"tags": [
+				{"tag": "v1", "items": [
+					{"dockerImageReference": "quay.io/acme/frontend@sha256:0000", "image": "sha256:0000"}
+				]},
+				{"tag": "v2", "items": [
+					{"dockerImageReference": "` + source + `", "image": "` + digest + `"},
+					{"dockerImageReference": "quay.io/acme/frontend@sha256:0000", "image": "sha256:0000"}
+				]}
+			]
+		}
+	}`
+	is := ImageStream{}
+	if err := json.Unmarshal([]byte(data), &is); err != nil {
+		t.Fatal(err)
+	}
+	image, err := is.SourceImage(digest)
+	testutil.CheckErrorAndDeepEqual(t, false, err, source, image)
+
+	_, err = is.SourceImage("sha256:1111")
+	testutil.CheckError(t, true, err)
+}
+
+func TestResolveExternalImage(t *testing.T) {
+	image, err := Resolve(source)
+	testutil.CheckErrorAndDeepEqual(t, false, err, source, image)
+}
@@ -17,11 +17,10 @@ limitations under the License.
 package resolve
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/grafeas/kritis/pkg/kritis/util"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 )
@@ -71,22 +70,7 @@ func executeSubstitution(contents string) (string, error) {
 }
 
 // For testing
-var resolver = func(image string) (string, error) {
-	tag, err := name.NewTag(image, name.WeakValidation)
-	if err != nil {
-		return "", err
-	}
-	sourceImage, err := remote.Image(tag)
-	if err != nil {
-		return "", err
-	}
-	digest, err := sourceImage.Digest()
-	if err != nil {
-		return "", err
-	}
-	digestName := fmt.Sprintf("%s@sha256:%s", tag.Context(), digest.Hex)
-	return digestName, nil
-}
+var resolver util.DigestResolver = util.RegistryResolver{}
 
 // recursiveGetTaggedImages recursively gets all images referenced by tags
 // instead of digests
@@ -125,7 +109,7 @@ func FullyQualifiedImage(image string) bool {
 func resolveTagsToDigests(images []string) (map[string]string, error) {
 	resolvedImages := map[string]string{}
 	for _, image := range images {
-		digestName, err := resolver(image)
+		digestName, err := resolver.ResolveDigest(image)
 		if err != nil {
 			return nil, err
 		}
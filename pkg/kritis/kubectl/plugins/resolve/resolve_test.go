@@ -18,6 +18,7 @@ package resolve
 import (
 	"fmt"
 	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"github.com/grafeas/kritis/pkg/kritis/util"
 	"gopkg.in/yaml.v2"
 	"sort"
 	"testing"
@@ -92,39 +93,21 @@ func Test_recursiveGetTaggedImages(t *testing.T) {
 	}
 }
 
-type fakeResolver struct {
-	tagMap map[string]string
-}
-
-func newFakeResolver() fakeResolver {
-	f := fakeResolver{
-		tagMap: map[string]string{},
-	}
-	return f
-}
-
-func (f *fakeResolver) resolve(image string) (string, error) {
-	digest, ok := f.tagMap[image]
-	if !ok {
-		return "", fmt.Errorf("image %s not found", image)
-	}
-	return digest, nil
-}
-
-func setResolver(f func(string) (string, error)) func() {
+func setResolver(r util.DigestResolver) func() {
 	oldResolver := resolver
-	resolver = f
+	resolver = r
 	return func() {
 		resolver = oldResolver
 	}
 }
 
 func Test_resolveTagsToDigests(t *testing.T) {
-	r := newFakeResolver()
-	r.tagMap["gcr.io/google-appengine/debian9:2017-09-07-161610"] = "gcr.io/google-appengine/debian9@sha256:foo"
-	r.tagMap["golang:1.10"] = "index.docker.io/library/golang@sha256:bar"
+	r := testutil.NewFakeDigestResolver(map[string]string{
+		"gcr.io/google-appengine/debian9:2017-09-07-161610": "gcr.io/google-appengine/debian9@sha256:foo",
+		"golang:1.10": "index.docker.io/library/golang@sha256:bar",
+	})
 
-	defer setResolver(r.resolve)()
+	defer setResolver(r)()
 
 	tests := []struct {
 		name     string
@@ -226,11 +209,12 @@ func Test_recursiveReplaceImage(t *testing.T) {
 }
 
 func Test_MultiYaml(t *testing.T) {
-	r := newFakeResolver()
-	r.tagMap["image:tag"] = "image:digest"
-	r.tagMap["image:tag2"] = "image:digest2"
+	r := testutil.NewFakeDigestResolver(map[string]string{
+		"image:tag":  "image:digest",
+		"image:tag2": "image:digest2",
+	})
 
-	defer setResolver(r.resolve)()
+	defer setResolver(r)()
 	multiYaml := `apiVersion: v1
 kind: Pod
 metadata:
@@ -0,0 +1,51 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+// BatchFetcher is implemented by MetadataFetchers which can get the
+// vulnerabilities of several images in a single query
+type BatchFetcher interface {
+	// Get Package Vulnerabilities for each image, keyed by image
+	FetchVulnerabilitiesBatch(images []string) (map[string][]Vulnerability, error)
+}
+
+// Prefetch gets the vulnerabilities of images in one batch if client is a
+// BatchFetcher, and returns a MetadataFetcher which serves them without
+// querying client again. Other clients are returned unchanged.
+func Prefetch(client MetadataFetcher, images []string) (MetadataFetcher, error) {
+	b, ok := client.(BatchFetcher)
+	if !ok || len(images) == 0 {
+		return client, nil
+	}
+	vulnz, err := b.FetchVulnerabilitiesBatch(images)
+	if err != nil {
+		return nil, err
+	}
+	return prefetched{MetadataFetcher: client, vulnz: vulnz}, nil
+}
+
+type prefetched struct {
+	MetadataFetcher
+	vulnz map[string][]Vulnerability
+}
+
+func (p prefetched) GetVulnerabilities(containerImage string) ([]Vulnerability, error) {
+	if v, ok := p.vulnz[containerImage]; ok {
+		return v, nil
+	}
+	return p.MetadataFetcher.GetVulnerabilities(containerImage)
+}
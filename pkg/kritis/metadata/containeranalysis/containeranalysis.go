@@ -19,17 +19,42 @@ package containeranalysis
 import (
 	gen "cloud.google.com/go/devtools/containeranalysis/apiv1alpha1"
 	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/util"
 	"golang.org/x/net/context"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 	containeranalysispb "google.golang.org/genproto/googleapis/devtools/containeranalysis/v1alpha1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	PkgVulnerability = "PACKAGE_VULNERABILITY"
-	PageSize         = int32(100)
+	PkgVulnerability     = "PACKAGE_VULNERABILITY"
+	AttestationAuthority = "ATTESTATION_AUTHORITY"
+	BuildDetails         = "BUILD_DETAILS"
+	Discovery            = "DISCOVERY"
+	PackageManager       = "PACKAGE_MANAGER"
+	PageSize             = int32(100)
+
+	// SLSALevelOption is the build option in which builders record the SLSA
+	// build level of a build
+	SLSALevelOption = "slsa_level"
+
+	// SecretFindingNotePrefix is the prefix of the IDs of the discovery notes
+	// under which scanners record secrets detected in images
+	SecretFindingNotePrefix = "secret"
+	// MalwareFindingNotePrefix is the prefix of the IDs of the discovery
+	// notes under which scanners record malware detected in images
+	MalwareFindingNotePrefix = "malware"
 )
 
 // The ContainerAnalysis struct implements MetadataFetcher Interface.
@@ -38,9 +63,22 @@ type ContainerAnalysis struct {
 	ctx    context.Context
 }
 
-func NewContainerAnalysisClient() (*ContainerAnalysis, error) {
+// disclosures caches when CVEs were disclosed by the name of their note
+var disclosures sync.Map
+
+// projects maps image repository prefixes to the project holding their metadata
+var projects map[string]string
+
+// SetProjects maps image repository prefixes, e.g.
+// registry.example.com/team, to the project holding metadata of the images
+// in them, overriding the project derived from the image's path
+func SetProjects(p map[string]string) {
+	projects = p
+}
+
+func NewContainerAnalysisClient(opts ...option.ClientOption) (*ContainerAnalysis, error) {
 	ctx := context.Background()
-	client, err := gen.NewClient(ctx)
+	client, err := gen.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -50,38 +88,586 @@ func NewContainerAnalysisClient() (*ContainerAnalysis, error) {
 	}, nil
 }
 
+// Close closes the connection to the Container Analysis API
+func (c ContainerAnalysis) Close() error {
+	return c.client.Close()
+}
+
 // GetVulnerabilites gets Package Vulnerabilities Occurrences for a specified image.
 func (c ContainerAnalysis) GetVulnerabilities(containerImage string) ([]metadata.Vulnerability, error) {
-	// Make sure container image is a GCR image
-	ref, err := name.ParseReference(containerImage, name.WeakValidation)
+	vulnz := []metadata.Vulnerability{}
+	err := c.StreamVulnerabilities(containerImage, func(page []metadata.Vulnerability) bool {
+		vulnz = append(vulnz, page...)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-	if !isRegistryGCR(ref.Context().RegistryStr()) {
-		return nil, fmt.Errorf("%s is not a valid image hosted in GCR", containerImage)
+	return vulnz, nil
+}
+
+// StreamVulnerabilities calls fn with each page of Package Vulnerabilities
+// Occurrences for a specified image, until there are no more or fn returns
+// false. The pages after that are never fetched.
+func (c ContainerAnalysis) StreamVulnerabilities(containerImage string, fn func([]metadata.Vulnerability) bool) error {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return err
 	}
-	project := strings.Split(containerImage, "/")[1]
 
 	req := &containeranalysispb.ListOccurrencesRequest{
 		Filter:   fmt.Sprintf("resource_url=%q AND kind=%q", fmt.Sprintf("https://%s", containerImage), PkgVulnerability),
 		PageSize: PageSize,
 		Parent:   fmt.Sprintf("projects/%s", project),
 	}
-	it := c.client.ListOccurrences(c.ctx, req)
-	vulnz := []metadata.Vulnerability{}
+	return streamVulnerabilities(c.client.ListOccurrences(c.ctx, req), int(PageSize), fn)
+}
+
+// occurrenceIterator iterates over the results of ListOccurrences, fetching
+// the next page of them once those already fetched are exhausted
+type occurrenceIterator interface {
+	Next() (*containeranalysispb.Occurrence, error)
+}
+
+// streamVulnerabilities calls fn with the vulnerabilities of the occurrences
+// of it, pageSize at a time, until there are no more or fn returns false
+func streamVulnerabilities(it occurrenceIterator, pageSize int, fn func([]metadata.Vulnerability) bool) error {
+	page := []metadata.Vulnerability{}
 	for {
 		occ, err := it.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
+			return err
+		}
+		page = append(page, GetVulnerabilityFromOccurence(occ))
+		// Hand over a full page before asking it for the next occurrence,
+		// which would fetch the next page
+		if len(page) == pageSize {
+			if !fn(page) {
+				return nil
+			}
+			page = []metadata.Vulnerability{}
+		}
+	}
+	if len(page) != 0 {
+		fn(page)
+	}
+	return nil
+}
+
+// FetchVulnerabilitiesBatch gets Package Vulnerabilities Occurrences for
+// several images, with one query per project hosting them.
+// If the backend rejects the combined filter, images are queried one by one.
+func (c ContainerAnalysis) FetchVulnerabilitiesBatch(images []string) (map[string][]metadata.Vulnerability, error) {
+	// Map resource urls back to the images they were requested as
+	byProject := map[string]map[string][]string{}
+	for _, image := range images {
+		normalized, project, err := gcrImage(image, projects)
+		if err != nil {
+			return nil, err
+		}
+		if byProject[project] == nil {
+			byProject[project] = map[string][]string{}
+		}
+		url := fmt.Sprintf("https://%s", normalized)
+		byProject[project][url] = append(byProject[project][url], image)
+	}
+	vulnz := map[string][]metadata.Vulnerability{}
+	for project, urls := range byProject {
+		found, err := c.listVulnerabilities(project, batchFilter(urls))
+		if batchUnsupported(err) {
+			for url, imgs := range urls {
+				v, err := c.GetVulnerabilities(imgs[0])
+				if err != nil {
+					return nil, err
+				}
+				found[url] = v
+			}
+		} else if err != nil {
 			return nil, err
 		}
-		vulnz = append(vulnz, GetVulnerabilityFromOccurence(occ))
+		for url, imgs := range urls {
+			for _, image := range imgs {
+				vulnz[image] = append([]metadata.Vulnerability{}, found[url]...)
+			}
+		}
 	}
 	return vulnz, nil
 }
 
+// listVulnerabilities returns the vulnerabilities matching filter in project, keyed by resource url
+func (c ContainerAnalysis) listVulnerabilities(project string, filter string) (map[string][]metadata.Vulnerability, error) {
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   filter,
+		PageSize: PageSize,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	found := map[string][]metadata.Vulnerability{}
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			return found, nil
+		}
+		if err != nil {
+			return found, err
+		}
+		found[occ.GetResourceUrl()] = append(found[occ.GetResourceUrl()], GetVulnerabilityFromOccurence(occ))
+	}
+}
+
+// batchFilter returns a filter matching vulnerabilities of any of the resource urls
+func batchFilter(urls map[string][]string) string {
+	sorted := []string{}
+	for url := range urls {
+		sorted = append(sorted, fmt.Sprintf("resource_url=%q", url))
+	}
+	sort.Strings(sorted)
+	return fmt.Sprintf("kind=%q AND (%s)", PkgVulnerability, strings.Join(sorted, " OR "))
+}
+
+// batchUnsupported returns true if err means the backend can't serve a combined filter
+func batchUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// HasMetadata returns true if there are any Occurrences for a specified image.
+func (c ContainerAnalysis) HasMetadata(containerImage string) (bool, error) {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return false, err
+	}
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   fmt.Sprintf("resource_url=%q", fmt.Sprintf("https://%s", containerImage)),
+		PageSize: 1,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	_, err = it.Next()
+	if err == iterator.Done {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetBuildDetails gets Build Details Occurrences for a specified image.
+func (c ContainerAnalysis) GetBuildDetails(containerImage string) ([]metadata.Build, error) {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return nil, err
+	}
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   fmt.Sprintf("resource_url=%q AND kind=%q", fmt.Sprintf("https://%s", containerImage), BuildDetails),
+		PageSize: PageSize,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	builds := []metadata.Build{}
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		p := occ.GetBuildDetails().GetProvenance()
+		if p == nil {
+			continue
+		}
+		builds = append(builds, GetBuildFromProvenance(p))
+	}
+	return builds, nil
+}
+
+// GetBuildFromProvenance converts a build provenance into a metadata.Build.
+func GetBuildFromProvenance(p *containeranalysispb.BuildProvenance) metadata.Build {
+	level, err := strconv.Atoi(p.GetBuildOptions()[SLSALevelOption])
+	if err != nil {
+		level = 0
+	}
+	return metadata.Build{
+		Creator:        p.GetCreator(),
+		BuilderVersion: p.GetBuilderVersion(),
+		SLSALevel:      level,
+		SourceURI:      sourceURI(p.GetSourceProvenance()),
+	}
+}
+
+// sourceURI returns the URI of the repository of a build's source, which is a
+// Git repository or a Cloud Source Repository, or "" if it's neither
+func sourceURI(s *containeranalysispb.Source) string {
+	if git := s.GetContext().GetGit(); git != nil {
+		return git.GetUrl()
+	}
+	if repo := s.GetContext().GetCloudRepo().GetRepoId().GetProjectRepoId(); repo != nil {
+		return cloudSourceRepository(repo.GetProjectId(), repo.GetRepoName())
+	}
+	if repo := s.GetRepoSource(); repo != nil {
+		return cloudSourceRepository(repo.GetProjectId(), repo.GetRepoName())
+	}
+	return ""
+}
+
+func cloudSourceRepository(project string, repo string) string {
+	return fmt.Sprintf("https://source.developers.google.com/p/%s/r/%s", project, repo)
+}
+
+// GetAttestations gets PGP signed Attestation Occurrences for a specified image.
+// Attestations are looked up by digest, so attestations of the same digest in
+// any repository of the image's project are returned.
+func (c ContainerAnalysis) GetAttestations(containerImage string) ([]metadata.PGPAttestation, error) {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return nil, err
+	}
+	digest, byDigest := imageDigest(containerImage)
+	filter := fmt.Sprintf("resource_url=%q AND kind=%q", fmt.Sprintf("https://%s", containerImage), AttestationAuthority)
+	if byDigest {
+		filter = fmt.Sprintf("kind=%q", AttestationAuthority)
+	}
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   filter,
+		PageSize: PageSize,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	atts := []metadata.PGPAttestation{}
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if byDigest && !strings.HasSuffix(occ.GetResourceUrl(), "@"+digest) {
+			continue
+		}
+		pgp := occ.GetAttestation().GetPgpSignedAttestation()
+		if pgp == nil {
+			continue
+		}
+		atts = append(atts, metadata.PGPAttestation{
+			Signature:      pgp.GetSignature(),
+			KeyID:          pgp.GetPgpKeyId(),
+			NoteName:       occ.GetNoteName(),
+			OccurrenceName: occ.GetName(),
+		})
+	}
+	return atts, nil
+}
+
+// GetSecretFindings gets the secrets detected in a specified image, which are
+// recorded as Discovery Occurrences of notes with IDs starting with
+// SecretFindingNotePrefix.
+func (c ContainerAnalysis) GetSecretFindings(containerImage string) ([]metadata.SecretFinding, error) {
+	occs, err := c.findings(containerImage, SecretFindingNotePrefix)
+	if err != nil {
+		return nil, err
+	}
+	findings := []metadata.SecretFinding{}
+	for _, occ := range occs {
+		findings = append(findings, metadata.SecretFinding{
+			Note:        occ.GetNoteName(),
+			Description: occ.GetRemediation(),
+		})
+	}
+	return findings, nil
+}
+
+// GetMalwareFindings gets the malware detected in a specified image, which is
+// recorded as Discovery Occurrences of notes with IDs starting with
+// MalwareFindingNotePrefix.
+func (c ContainerAnalysis) GetMalwareFindings(containerImage string) ([]metadata.MalwareFinding, error) {
+	occs, err := c.findings(containerImage, MalwareFindingNotePrefix)
+	if err != nil {
+		return nil, err
+	}
+	findings := []metadata.MalwareFinding{}
+	for _, occ := range occs {
+		findings = append(findings, metadata.MalwareFinding{
+			Note:        occ.GetNoteName(),
+			Description: occ.GetRemediation(),
+		})
+	}
+	return findings, nil
+}
+
+// findings lists the Discovery Occurrences of a specified image of notes
+// with IDs starting with notePrefix
+func (c ContainerAnalysis) findings(containerImage string, notePrefix string) ([]*containeranalysispb.Occurrence, error) {
+	return c.discoveries(containerImage, func(occ *containeranalysispb.Occurrence) bool {
+		return isFinding(occ, notePrefix)
+	})
+}
+
+// discoveries lists the Discovery Occurrences of a specified image for which
+// keep returns true
+func (c ContainerAnalysis) discoveries(containerImage string, keep func(*containeranalysispb.Occurrence) bool) ([]*containeranalysispb.Occurrence, error) {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return nil, err
+	}
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   fmt.Sprintf("resource_url=%q AND kind=%q", fmt.Sprintf("https://%s", containerImage), Discovery),
+		PageSize: PageSize,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	occs := []*containeranalysispb.Occurrence{}
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keep(occ) {
+			occs = append(occs, occ)
+		}
+	}
+	return occs, nil
+}
+
+// isFinding returns true if occ is a Discovery Occurrence of a finding note
+// with an ID starting with notePrefix, i.e. one named
+// projects/<project>/notes/<notePrefix>...
+func isFinding(occ *containeranalysispb.Occurrence, notePrefix string) bool {
+	if occ.GetDiscovered() == nil {
+		return false
+	}
+	parts := strings.Split(occ.GetNoteName(), "/")
+	return strings.HasPrefix(parts[len(parts)-1], notePrefix)
+}
+
+// isScan returns true if occ is a Discovery Occurrence recording a scan of
+// its image, rather than a finding of one
+func isScan(occ *containeranalysispb.Occurrence) bool {
+	return occ.GetDiscovered() != nil && !isFinding(occ, SecretFindingNotePrefix) && !isFinding(occ, MalwareFindingNotePrefix)
+}
+
+// GetDiscovery gets the latest scan of a specified image. An image is scanned
+// again over time, each scan being recorded as a Discovery Occurrence, so the
+// newest of them decides whether its scan is complete and how fresh it is.
+func (c ContainerAnalysis) GetDiscovery(containerImage string) (*metadata.Discovery, error) {
+	occs, err := c.discoveries(containerImage, isScan)
+	if err != nil {
+		return nil, err
+	}
+	occ := latestDiscovery(occs)
+	if occ == nil {
+		return nil, nil
+	}
+	status := occ.GetDiscovered().GetAnalysisStatus()
+	return &metadata.Discovery{
+		Status:   status.String(),
+		Complete: status == containeranalysispb.Discovery_Discovered_FINISHED_SUCCESS,
+		Time:     occurrenceTime(occ),
+	}, nil
+}
+
+// latestDiscovery returns the most recently updated of occs, or nil if there
+// are none. Occurrences whose time is unknown are the oldest.
+func latestDiscovery(occs []*containeranalysispb.Occurrence) *containeranalysispb.Occurrence {
+	var latest *containeranalysispb.Occurrence
+	var latestTime time.Time
+	for _, occ := range occs {
+		if t := occurrenceTime(occ); latest == nil || t.After(latestTime) {
+			latest, latestTime = occ, t
+		}
+	}
+	return latest
+}
+
+// occurrenceTime returns when occ was last updated, or created if it never
+// was, or the zero time if neither is known
+func occurrenceTime(occ *containeranalysispb.Occurrence) time.Time {
+	for _, ts := range []*timestamp.Timestamp{occ.GetUpdateTime(), occ.GetCreateTime()} {
+		if ts == nil {
+			continue
+		}
+		if t, err := ptypes.Timestamp(ts); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// GetDisclosureTime gets when a CVE was publicly disclosed, from the
+// vulnerability note its occurrences are of
+func (c ContainerAnalysis) GetDisclosureTime(cve string) (time.Time, error) {
+	if t, ok := disclosures.Load(cve); ok {
+		return t.(time.Time), nil
+	}
+	note, err := c.client.GetNote(c.ctx, &containeranalysispb.GetNoteRequest{Name: cve})
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := disclosureTime(note)
+	disclosures.Store(cve, t)
+	return t, nil
+}
+
+// disclosureTime returns when the CVE of a vulnerability note was disclosed,
+// which is when its provider created the note, or the zero time if unknown
+func disclosureTime(note *containeranalysispb.Note) time.Time {
+	if note.GetCreateTime() == nil {
+		return time.Time{}
+	}
+	t, err := ptypes.Timestamp(note.GetCreateTime())
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// GetNoteKinds gets the kinds of the notes of all Occurrences of a
+// specified image, in order
+func (c ContainerAnalysis) GetNoteKinds(containerImage string) ([]string, error) {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return nil, err
+	}
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   fmt.Sprintf("resource_url=%q", fmt.Sprintf("https://%s", containerImage)),
+		PageSize: PageSize,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	occs := []*containeranalysispb.Occurrence{}
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		occs = append(occs, occ)
+	}
+	return noteKinds(occs), nil
+}
+
+// noteKinds returns the distinct kinds of occs, in order
+func noteKinds(occs []*containeranalysispb.Occurrence) []string {
+	seen := map[string]bool{}
+	kinds := []string{}
+	for _, occ := range occs {
+		kind := occ.GetKind().String()
+		if occ.GetKind() == containeranalysispb.Note_KIND_UNSPECIFIED || seen[kind] {
+			continue
+		}
+		seen[kind] = true
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// GetOperatingSystems gets the CPE URIs of the operating systems, e.g.
+// cpe:/o:debian:debian_linux:9, which packages in a specified image were
+// installed from, according to its Package Manager Occurrences.
+func (c ContainerAnalysis) GetOperatingSystems(containerImage string) ([]string, error) {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return nil, err
+	}
+	req := &containeranalysispb.ListOccurrencesRequest{
+		Filter:   fmt.Sprintf("resource_url=%q AND kind=%q", fmt.Sprintf("https://%s", containerImage), PackageManager),
+		PageSize: PageSize,
+		Parent:   fmt.Sprintf("projects/%s", project),
+	}
+	it := c.client.ListOccurrences(c.ctx, req)
+	seen := map[string]bool{}
+	systems := []string{}
+	for {
+		occ, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, cpe := range operatingSystems(occ) {
+			if !seen[cpe] {
+				seen[cpe] = true
+				systems = append(systems, cpe)
+			}
+		}
+	}
+	return systems, nil
+}
+
+// operatingSystems returns the CPE URIs of the operating systems the package
+// of a Package Manager Occurrence was installed from
+func operatingSystems(occ *containeranalysispb.Occurrence) []string {
+	systems := []string{}
+	for _, l := range occ.GetInstallation().GetLocation() {
+		if strings.HasPrefix(l.GetCpeUri(), "cpe:/o:") {
+			systems = append(systems, l.GetCpeUri())
+		}
+	}
+	return systems
+}
+
+// CreateAttestationOccurence creates a PGP signed Attestation Occurrence for
+// a container image under the given attestation authority note.
+func (c ContainerAnalysis) CreateAttestationOccurence(noteName string, containerImage string, signature string, keyID string) error {
+	containerImage, project, err := gcrImage(containerImage, projects)
+	if err != nil {
+		return err
+	}
+	pgpSignedAttestation := &containeranalysispb.PgpSignedAttestation{
+		Signature:   signature,
+		ContentType: containeranalysispb.PgpSignedAttestation_SIMPLE_SIGNING_JSON,
+		KeyId: &containeranalysispb.PgpSignedAttestation_PgpKeyId{
+			PgpKeyId: keyID,
+		},
+	}
+	occ := &containeranalysispb.Occurrence{
+		ResourceUrl: fmt.Sprintf("https://%s", containerImage),
+		NoteName:    noteName,
+		Details: &containeranalysispb.Occurrence_Attestation{
+			Attestation: &containeranalysispb.AttestationAuthority_Attestation{
+				Signature: &containeranalysispb.AttestationAuthority_Attestation_PgpSignedAttestation{
+					PgpSignedAttestation: pgpSignedAttestation,
+				},
+			},
+		},
+	}
+	req := &containeranalysispb.CreateOccurrenceRequest{
+		Parent:     fmt.Sprintf("projects/%s", project),
+		Occurrence: occ,
+	}
+	_, err = c.client.CreateOccurrence(c.ctx, req)
+	return err
+}
+
+// DeleteOccurrence deletes the occurrence with the given name. Occurrences
+// which no longer exist are ignored, as another replica may have deleted the
+// same duplicate attestation.
+func (c ContainerAnalysis) DeleteOccurrence(name string) error {
+	err := c.client.DeleteOccurrence(c.ctx, &containeranalysispb.DeleteOccurrenceRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
 func GetVulnerabilityFromOccurence(occ *containeranalysispb.Occurrence) metadata.Vulnerability {
 	vulnDetails := occ.GetDetails().(*containeranalysispb.Occurrence_VulnerabilityDetails).VulnerabilityDetails
 	hasFixAvailable := isFixAvaliable(vulnDetails.GetPackageIssue())
@@ -90,6 +676,9 @@ func GetVulnerabilityFromOccurence(occ *containeranalysispb.Occurrence) metadata
 		HasFixAvailable: hasFixAvailable,
 		CVE:             occ.GetNoteName(),
 	}
+	if createTime, err := ptypes.Timestamp(occ.GetCreateTime()); err == nil {
+		vulnerability.CreateTime = createTime
+	}
 	return vulnerability
 }
 
@@ -103,6 +692,69 @@ func isFixAvaliable(pis []*containeranalysispb.VulnerabilityType_PackageIssue) b
 	return true
 }
 
+// gcrImage normalizes a container image and finds the project holding its
+// metadata. Images in a repository mapped by projects use the mapped project,
+// with the longest matching repository prefix winning. Otherwise, the image
+// must be hosted in GCR or Artifact Registry, where the project is the first
+// component of its repository.
+// It returns the normalized image and the project.
+func gcrImage(containerImage string, projects map[string]string) (string, string, error) {
+	containerImage, err := util.NormalizeImage(containerImage)
+	if err != nil {
+		return "", "", err
+	}
+	ref, err := name.ParseReference(containerImage, name.WeakValidation)
+	if err != nil {
+		return "", "", err
+	}
+	if project, ok := mappedProject(ref.Context().Name(), projects); ok {
+		return containerImage, project, nil
+	}
+	registry := ref.Context().RegistryStr()
+	if !isRegistryGCR(registry) && !isRegistryArtifactRegistry(registry) {
+		return "", "", fmt.Errorf("%s is not a valid image hosted in GCR or Artifact Registry, and its repository isn't mapped to a project", containerImage)
+	}
+	path := strings.Split(ref.Context().RepositoryStr(), "/")
+	project := path[0]
+	// Domain-scoped projects are hosted at gcr.io/example.com/project
+	if strings.Contains(project, ".") && len(path) > 2 {
+		project = fmt.Sprintf("%s:%s", path[0], path[1])
+	}
+	return containerImage, project, nil
+}
+
+// mappedProject returns the project which projects maps the longest prefix
+// of repository to
+func mappedProject(repository string, projects map[string]string) (string, bool) {
+	match, project := "", ""
+	for prefix, p := range projects {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if repository != prefix && !strings.HasPrefix(repository, prefix+"/") {
+			continue
+		}
+		if len(prefix) > len(match) {
+			match, project = prefix, p
+		}
+	}
+	return project, match != ""
+}
+
+// isRegistryArtifactRegistry returns true for Artifact Registry Docker
+// registries, e.g. us-central1-docker.pkg.dev
+func isRegistryArtifactRegistry(r string) bool {
+	return strings.HasSuffix(r, "-docker.pkg.dev")
+}
+
+// imageDigest returns the digest image is referenced by, and false if it
+// isn't referenced by digest
+func imageDigest(image string) (string, bool) {
+	digest, err := name.NewDigest(image, name.WeakValidation)
+	if err != nil {
+		return "", false
+	}
+	return digest.DigestStr(), true
+}
+
 func isRegistryGCR(r string) bool {
 	registry := strings.Split(r, ".")
 	if len(registry) < 2 {
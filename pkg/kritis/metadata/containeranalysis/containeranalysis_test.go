@@ -17,11 +17,15 @@ limitations under the License.
 package containeranalysis
 
 import (
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/grafeas/kritis/pkg/kritis/metadata"
 	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"google.golang.org/api/iterator"
 	containeranalysispb "google.golang.org/genproto/googleapis/devtools/containeranalysis/v1alpha1"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var tcGetVuln = []struct {
@@ -115,3 +119,321 @@ func Test_isRegistryGCR(t *testing.T) {
 		})
 	}
 }
+
+func TestBatchFilter(t *testing.T) {
+	urls := map[string][]string{
+		"https://gcr.io/project/b@sha256:0000": {"gcr.io/project/b@sha256:0000"},
+		"https://gcr.io/project/a@sha256:0000": {"gcr.io/project/a@sha256:0000"},
+	}
+	expected := `kind="PACKAGE_VULNERABILITY" AND (resource_url="https://gcr.io/project/a@sha256:0000" OR resource_url="https://gcr.io/project/b@sha256:0000")`
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, batchFilter(urls))
+}
+
+func TestGetBuildFromProvenance(t *testing.T) {
+	var tests = []struct {
+		name     string
+		options  map[string]string
+		expected int
+	}{
+		{"level 3", map[string]string{SLSALevelOption: "3"}, 3},
+		{"no level", nil, 0},
+		{"invalid level", map[string]string{SLSALevelOption: "three"}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &containeranalysispb.BuildProvenance{
+				Creator:      "ci@my-project.iam.gserviceaccount.com",
+				BuildOptions: test.options,
+			}
+			expected := metadata.Build{
+				Creator:   "ci@my-project.iam.gserviceaccount.com",
+				SLSALevel: test.expected,
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, expected, GetBuildFromProvenance(p))
+		})
+	}
+}
+
+func TestSourceURI(t *testing.T) {
+	var tests = []struct {
+		name     string
+		source   *containeranalysispb.Source
+		expected string
+	}{
+		{
+			name: "git",
+			source: &containeranalysispb.Source{Context: &containeranalysispb.SourceContext{
+				Context: &containeranalysispb.SourceContext_Git{Git: &containeranalysispb.GitSourceContext{Url: "https://github.com/org/app"}},
+			}},
+			expected: "https://github.com/org/app",
+		},
+		{
+			name: "cloud source repository",
+			source: &containeranalysispb.Source{Context: &containeranalysispb.SourceContext{
+				Context: &containeranalysispb.SourceContext_CloudRepo{CloudRepo: &containeranalysispb.CloudRepoSourceContext{
+					RepoId: &containeranalysispb.RepoId{Id: &containeranalysispb.RepoId_ProjectRepoId{
+						ProjectRepoId: &containeranalysispb.ProjectRepoId{ProjectId: "project", RepoName: "app"},
+					}},
+				}},
+			}},
+			expected: "https://source.developers.google.com/p/project/r/app",
+		},
+		{
+			name: "repo source",
+			source: &containeranalysispb.Source{Source: &containeranalysispb.Source_RepoSource{
+				RepoSource: &containeranalysispb.RepoSource{ProjectId: "project", RepoName: "app"},
+			}},
+			expected: "https://source.developers.google.com/p/project/r/app",
+		},
+		{
+			name:   "storage source",
+			source: &containeranalysispb.Source{Source: &containeranalysispb.Source_StorageSource{}},
+		},
+		{
+			name: "no source",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, sourceURI(test.source))
+		})
+	}
+}
+
+func TestIsFinding(t *testing.T) {
+	discovered := &containeranalysispb.Occurrence_Discovered{
+		Discovered: &containeranalysispb.Discovery_Discovered{},
+	}
+	var tests = []struct {
+		name     string
+		occ      *containeranalysispb.Occurrence
+		prefix   string
+		expected bool
+	}{
+		{"secret finding", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/secret-aws-key", Details: discovered}, SecretFindingNotePrefix, true},
+		{"malware finding", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/malware-cryptominer", Details: discovered}, MalwareFindingNotePrefix, true},
+		{"finding of another kind", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/secret-aws-key", Details: discovered}, MalwareFindingNotePrefix, false},
+		{"other discovery", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/package-scan", Details: discovered}, SecretFindingNotePrefix, false},
+		{"not a discovery", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/secret-aws-key"}, SecretFindingNotePrefix, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, isFinding(test.occ, test.prefix))
+		})
+	}
+}
+
+func TestIsScan(t *testing.T) {
+	discovered := &containeranalysispb.Occurrence_Discovered{
+		Discovered: &containeranalysispb.Discovery_Discovered{},
+	}
+	var tests = []struct {
+		name     string
+		occ      *containeranalysispb.Occurrence
+		expected bool
+	}{
+		{"scan", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/package-scan", Details: discovered}, true},
+		{"secret finding", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/secret-aws-key", Details: discovered}, false},
+		{"malware finding", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/malware-cryptominer", Details: discovered}, false},
+		{"not a discovery", &containeranalysispb.Occurrence{NoteName: "projects/scanner/notes/package-scan"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, isScan(test.occ))
+		})
+	}
+}
+
+func TestLatestDiscovery(t *testing.T) {
+	scan := func(name string, created, updated int64) *containeranalysispb.Occurrence {
+		occ := &containeranalysispb.Occurrence{
+			Name: name,
+			Details: &containeranalysispb.Occurrence_Discovered{
+				Discovered: &containeranalysispb.Discovery_Discovered{},
+			},
+		}
+		if created != 0 {
+			occ.CreateTime = &timestamp.Timestamp{Seconds: created}
+		}
+		if updated != 0 {
+			occ.UpdateTime = &timestamp.Timestamp{Seconds: updated}
+		}
+		return occ
+	}
+	var tests = []struct {
+		name     string
+		occs     []*containeranalysispb.Occurrence
+		expected string
+	}{
+		{"no scans", nil, ""},
+		{"one scan", []*containeranalysispb.Occurrence{scan("first", 100, 0)}, "first"},
+		{"newest created wins", []*containeranalysispb.Occurrence{scan("first", 100, 0), scan("second", 200, 0), scan("third", 150, 0)}, "second"},
+		{"newest updated wins", []*containeranalysispb.Occurrence{scan("first", 100, 300), scan("second", 200, 0)}, "first"},
+		{"unknown times are the oldest", []*containeranalysispb.Occurrence{scan("first", 0, 0), scan("second", 100, 0), scan("third", 0, 0)}, "second"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, latestDiscovery(test.occs).GetName())
+		})
+	}
+}
+
+func TestNoteKinds(t *testing.T) {
+	occs := []*containeranalysispb.Occurrence{
+		{Kind: containeranalysispb.Note_PACKAGE_VULNERABILITY},
+		{Kind: containeranalysispb.Note_BUILD_DETAILS},
+		{Kind: containeranalysispb.Note_PACKAGE_VULNERABILITY},
+		{Kind: containeranalysispb.Note_KIND_UNSPECIFIED},
+		{Kind: containeranalysispb.Note_DISCOVERY},
+	}
+	expected := []string{"BUILD_DETAILS", "DISCOVERY", "PACKAGE_VULNERABILITY"}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, noteKinds(occs))
+}
+
+func TestDisclosureTime(t *testing.T) {
+	disclosed := time.Date(2018, time.July, 1, 12, 0, 0, 0, time.UTC)
+	ts := &timestamp.Timestamp{Seconds: disclosed.Unix()}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, disclosed, disclosureTime(&containeranalysispb.Note{CreateTime: ts}))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, time.Time{}, disclosureTime(&containeranalysispb.Note{}))
+}
+
+func TestOperatingSystems(t *testing.T) {
+	installation := func(cpes ...string) *containeranalysispb.Occurrence {
+		locations := []*containeranalysispb.PackageManager_Location{}
+		for _, cpe := range cpes {
+			locations = append(locations, &containeranalysispb.PackageManager_Location{CpeUri: cpe})
+		}
+		return &containeranalysispb.Occurrence{
+			Details: &containeranalysispb.Occurrence_Installation{
+				Installation: &containeranalysispb.PackageManager_Installation{Name: "openssl", Location: locations},
+			},
+		}
+	}
+	var tests = []struct {
+		name     string
+		occ      *containeranalysispb.Occurrence
+		expected []string
+	}{
+		{"operating system", installation("cpe:/o:centos:centos:6"), []string{"cpe:/o:centos:centos:6"}},
+		{"application locations are skipped", installation("cpe:/a:openssl:openssl:1.0", "cpe:/o:debian:debian_linux:9"), []string{"cpe:/o:debian:debian_linux:9"}},
+		{"not an installation", &containeranalysispb.Occurrence{}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, operatingSystems(test.occ))
+		})
+	}
+}
+
+func TestGCRImage(t *testing.T) {
+	projects := map[string]string{
+		"registry.example.com/team":      "team-project",
+		"registry.example.com/team/prod": "prod-project",
+		"gcr.io/mirror":                  "mirror-project",
+	}
+	digest := strings.Repeat("0", 64)
+	var tests = []struct {
+		name      string
+		image     string
+		shouldErr bool
+		expected  string
+	}{
+		{"gcr image", "gcr.io/my-project/image@sha256:" + digest, false, "my-project"},
+		{"regional gcr image", "eu.gcr.io/other-project/nested/image@sha256:" + digest, false, "other-project"},
+		{"domain-scoped project", "gcr.io/example.com/my-project/image@sha256:" + digest, false, "example.com:my-project"},
+		{"artifact registry image", "us-central1-docker.pkg.dev/ar-project/repo/image@sha256:" + digest, false, "ar-project"},
+		{"mapped repository", "registry.example.com/team/image@sha256:" + digest, false, "team-project"},
+		{"longest mapped prefix", "registry.example.com/team/prod/image@sha256:" + digest, false, "prod-project"},
+		{"mapping overrides gcr", "gcr.io/mirror/image@sha256:" + digest, false, "mirror-project"},
+		{"prefix matches whole components", "registry.example.com/teams/image@sha256:" + digest, true, ""},
+		{"unmapped registry", "index.docker.io/library/nginx@sha256:" + digest, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, project, err := gcrImage(test.image, projects)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, project)
+		})
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("1", 64)
+	var tests = []struct {
+		name     string
+		image    string
+		expected string
+		byDigest bool
+	}{
+		{"staging image", "gcr.io/project/staging/app@" + digest, digest, true},
+		{"promoted image", "gcr.io/project/prod/app@" + digest, digest, true},
+		{"tagged image", "gcr.io/project/prod/app:latest", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, byDigest := imageDigest(test.image)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, actual)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.byDigest, byDigest)
+		})
+	}
+}
+
+// pagedIterator returns occurrences from pages, fetching a page only once
+// those fetched before are exhausted, like the ListOccurrences iterator
+type pagedIterator struct {
+	pages   [][]*containeranalysispb.Occurrence
+	fetched int
+	buffer  []*containeranalysispb.Occurrence
+}
+
+func (it *pagedIterator) Next() (*containeranalysispb.Occurrence, error) {
+	if len(it.buffer) == 0 {
+		if it.fetched == len(it.pages) {
+			return nil, iterator.Done
+		}
+		it.buffer = it.pages[it.fetched]
+		it.fetched++
+	}
+	occ := it.buffer[0]
+	it.buffer = it.buffer[1:]
+	return occ, nil
+}
+
+func TestStreamVulnerabilities(t *testing.T) {
+	pages := [][]*containeranalysispb.Occurrence{}
+	for p := 0; p < 5; p++ {
+		page := []*containeranalysispb.Occurrence{}
+		for i := 0; i < 3; i++ {
+			page = append(page, &containeranalysispb.Occurrence{
+				NoteName: "CVE-1",
+				Details: &containeranalysispb.Occurrence_VulnerabilityDetails{
+					VulnerabilityDetails: &containeranalysispb.VulnerabilityType_VulnerabilityDetails{},
+				},
+			})
+		}
+		pages = append(pages, page)
+	}
+	var tests = []struct {
+		name          string
+		stopAfter     int
+		expectedCalls int
+		expectedPages int
+	}{
+		{"all pages", 0, 5, 5},
+		{"stops before fetching the remaining pages", 2, 2, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := &pagedIterator{pages: pages}
+			calls := 0
+			err := streamVulnerabilities(it, 3, func(page []metadata.Vulnerability) bool {
+				calls++
+				if len(page) != 3 {
+					t.Errorf("expected pages of 3 vulnerabilities, got %d", len(page))
+				}
+				return calls != test.stopAfter
+			})
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedCalls, calls)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expectedPages, it.fetched)
+		})
+	}
+}
@@ -16,13 +16,131 @@ limitations under the License.
 
 package metadata
 
+import "time"
+
 type MetadataFetcher interface {
 	// Get Package Vulnerabilites
 	GetVulnerabilities(containerImage string) ([]Vulnerability, error)
+	// Check if there are occurrences of any kind for an image
+	HasMetadata(containerImage string) (bool, error)
+	// Get Build Provenance for an image
+	GetBuildDetails(containerImage string) ([]Build, error)
+	// Get PGP signed Attestations for an image
+	GetAttestations(containerImage string) ([]PGPAttestation, error)
+	// Get embedded secrets detected in an image
+	GetSecretFindings(containerImage string) ([]SecretFinding, error)
+	// Create a PGP signed Attestation Occurrence for an image under a note
+	CreateAttestationOccurence(noteName string, containerImage string, signature string, keyID string) error
+}
+
+// Rescanner is implemented by MetadataFetchers whose backend can be asked to
+// scan an image again, so that an image without recent metadata has it the
+// next time it's validated
+type Rescanner interface {
+	// Request a new scan of an image
+	RequestRescan(containerImage string) error
+}
+
+// OccurrenceDeleter is implemented by MetadataFetchers whose backend can
+// delete occurrences, so that duplicate attestations created concurrently by
+// several replicas can be cleaned up
+type OccurrenceDeleter interface {
+	// Delete an occurrence by its name, as in PGPAttestation.OccurrenceName
+	DeleteOccurrence(name string) error
+}
+
+// DiscoveryFetcher is implemented by MetadataFetchers whose backend records
+// its scans of images, so that decisions about whether an image's metadata is
+// complete and fresh can be based on its latest scan
+type DiscoveryFetcher interface {
+	// Get the latest scan of an image, or nil if it was never scanned
+	GetDiscovery(containerImage string) (*Discovery, error)
+}
+
+// DisclosureFetcher is implemented by MetadataFetchers whose backend knows
+// when CVEs were publicly disclosed, so that deadlines for patching them can
+// be keyed to their disclosure rather than to when an image was scanned
+type DisclosureFetcher interface {
+	// Get when a CVE, as named by a Vulnerability, was publicly disclosed,
+	// or the zero time if it isn't known
+	GetDisclosureTime(cve string) (time.Time, error)
+}
+
+// NoteKindFetcher is implemented by MetadataFetchers which can list every
+// kind of metadata recorded about an image, so that policies can require or
+// deny metadata of any kind
+type NoteKindFetcher interface {
+	// Get the kinds of the notes of the Occurrences of an image, such as
+	// PACKAGE_VULNERABILITY or BUILD_DETAILS
+	GetNoteKinds(containerImage string) ([]string, error)
+}
+
+// MalwareFetcher is implemented by MetadataFetchers whose backend scans
+// images for malware, so that policies can deny images with malware
+type MalwareFetcher interface {
+	// Get malware detected in an image
+	GetMalwareFindings(containerImage string) ([]MalwareFinding, error)
+}
+
+// OperatingSystemFetcher is implemented by MetadataFetchers whose backend
+// knows which operating systems images are based on, so that policies can
+// deny unsupported ones
+type OperatingSystemFetcher interface {
+	// Get the CPE URIs of the operating systems packages in an image were installed from
+	GetOperatingSystems(containerImage string) ([]string, error)
+}
+
+// Discovery is a scan of an image by the metadata backend
+type Discovery struct {
+	// Status is the backend's status of the scan, e.g. FINISHED_SUCCESS
+	Status string
+	// Complete is true if the scan finished successfully
+	Complete bool
+	// Time is when the scan was last updated, if known
+	Time time.Time
 }
 
 type Vulnerability struct {
 	Severity        string
 	HasFixAvailable bool
 	CVE             string
+	// CreateTime is when the vulnerability occurrence was created, if known
+	CreateTime time.Time
+}
+
+// PGPAttestation is a PGP signed attestation of an image
+type PGPAttestation struct {
+	Signature string
+	KeyID     string
+	// NoteName is the name of the attestation authority note it was made under
+	NoteName string
+	// OccurrenceName is the name of the occurrence holding it, if known
+	OccurrenceName string
+}
+
+// SecretFinding is a secret, such as a credential, detected in an image
+type SecretFinding struct {
+	// Note is the name of the note describing the kind of secret
+	Note string
+	// Description is the scanner's description of the finding, if any
+	Description string
+}
+
+// MalwareFinding is malware, such as a virus or a cryptominer, detected in
+// an image
+type MalwareFinding struct {
+	// Note is the name of the note describing the kind of malware
+	Note string
+	// Description is the scanner's description of the finding, if any
+	Description string
+}
+
+// Build is the provenance of a build which produced an image
+type Build struct {
+	Creator        string
+	BuilderVersion string
+	// SLSALevel is the SLSA build level the builder attested, or 0 if it didn't
+	SLSALevel int
+	// SourceURI is the URI of the repository the image was built from, if known
+	SourceURI string
 }
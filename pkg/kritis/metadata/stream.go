@@ -0,0 +1,45 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+// VulnerabilityStreamer is implemented by MetadataFetchers which can get the
+// vulnerabilities of an image a page at a time, so that images with
+// thousands of them needn't be held in memory at once, and the pages after
+// one which denies an image needn't be fetched at all
+type VulnerabilityStreamer interface {
+	// Call fn with each page of Package Vulnerabilities of an image, until
+	// there are no more or fn returns false
+	StreamVulnerabilities(containerImage string, fn func([]Vulnerability) bool) error
+}
+
+// StreamVulnerabilities calls fn with each page of the vulnerabilities of
+// image, until there are no more or fn returns false, if client is a
+// VulnerabilityStreamer. Other clients' vulnerabilities are passed to fn as
+// a single page.
+func StreamVulnerabilities(client MetadataFetcher, image string, fn func([]Vulnerability) bool) error {
+	if s, ok := client.(VulnerabilityStreamer); ok {
+		return s.StreamVulnerabilities(image, fn)
+	}
+	vulnz, err := client.GetVulnerabilities(image)
+	if err != nil {
+		return err
+	}
+	if len(vulnz) != 0 {
+		fn(vulnz)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/version"
+)
+
+// ContentType is the OpenMetrics compatible text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// For testing
+var (
+	buildVersion = version.Version
+	buildCommit  = version.Commit
+)
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the
+// admission duration histogram
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The admission metrics, by namespace label
+var (
+	admissionMu sync.Mutex
+	// violations is the number of policy violations found
+	violations = map[string]uint64{}
+	// admissions is the number of admission decisions, by whether they
+	// allowed the pod
+	admissions = map[admissionKey]uint64{}
+	// durations is how long admission decisions took
+	durations = map[string]*histogram{}
+)
+
+type admissionKey struct {
+	namespace string
+	allowed   bool
+}
+
+// histogram is a cumulative histogram over durationBuckets
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// seriesNamespaces returns the namespace labels the admission metrics have
+// series for
+func seriesNamespaces() []string {
+	admissionMu.Lock()
+	defer admissionMu.Unlock()
+	seen := map[string]bool{}
+	for label := range violations {
+		seen[label] = true
+	}
+	for k := range admissions {
+		seen[k.namespace] = true
+	}
+	for label := range durations {
+		seen[label] = true
+	}
+	namespaces := make([]string, 0, len(seen))
+	for label := range seen {
+		namespaces = append(namespaces, label)
+	}
+	return namespaces
+}
+
+// AddViolations counts n policy violations found in an image of a pod in
+// namespace
+func AddViolations(namespace string, n int) {
+	label := namespaceLabel(namespace)
+	admissionMu.Lock()
+	defer admissionMu.Unlock()
+	violations[label] += uint64(n)
+}
+
+// ObserveAdmission records the decision to admit a pod in namespace or not,
+// which took d
+func ObserveAdmission(namespace string, allowed bool, d time.Duration) {
+	label := namespaceLabel(namespace)
+	admissionMu.Lock()
+	defer admissionMu.Unlock()
+	admissions[admissionKey{namespace: label, allowed: allowed}]++
+	h, ok := durations[label]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		durations[label] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// expiredWhitelistEntries is the number of expired CVE whitelist entries
+// last found in ImageSecurityPolicies
+var expiredWhitelistEntries uint64
+
+// SetExpiredWhitelistEntries records that n expired CVE whitelist entries
+// were found in ImageSecurityPolicies
+func SetExpiredWhitelistEntries(n int) {
+	atomic.StoreUint64(&expiredWhitelistEntries, uint64(n))
+}
+
+// attestationFailures is the number of images whose attestations couldn't
+// be created even after retrying
+var attestationFailures uint64
+
+// AddAttestationFailures counts n images whose attestations were given up on
+func AddAttestationFailures(n int) {
+	atomic.AddUint64(&attestationFailures, uint64(n))
+}
+
+// Handler serves kritis metrics in the text exposition format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	write(w)
+}
+
+func write(w io.Writer) {
+	fmt.Fprintln(w, "# HELP kritis_build_info A metric with a constant '1' value labeled by the version and commit kritis was built from.")
+	fmt.Fprintln(w, "# TYPE kritis_build_info gauge")
+	fmt.Fprintf(w, "kritis_build_info{version=\"%s\",commit=\"%s\"} 1\n", escape(buildVersion()), escape(buildCommit()))
+	writeAdmissions(w)
+	fmt.Fprintln(w, "# HELP kritis_expired_whitelist_entries Expired CVE whitelist entries found in image security policies by the last check.")
+	fmt.Fprintln(w, "# TYPE kritis_expired_whitelist_entries gauge")
+	fmt.Fprintf(w, "kritis_expired_whitelist_entries %d\n", atomic.LoadUint64(&expiredWhitelistEntries))
+	fmt.Fprintln(w, "# HELP kritis_attestation_failures_total Images whose attestations couldn't be created after retrying, and were dead-lettered.")
+	fmt.Fprintln(w, "# TYPE kritis_attestation_failures_total counter")
+	fmt.Fprintf(w, "kritis_attestation_failures_total %d\n", atomic.LoadUint64(&attestationFailures))
+}
+
+// writeAdmissions writes the admission metrics, ordered by namespace label
+func writeAdmissions(w io.Writer) {
+	admissionMu.Lock()
+	defer admissionMu.Unlock()
+	fmt.Fprintln(w, "# HELP kritis_policy_violations_total Policy violations found in images, including those omitted from responses, by namespace.")
+	fmt.Fprintln(w, "# TYPE kritis_policy_violations_total counter")
+	for _, ns := range sortedKeys(violations) {
+		fmt.Fprintf(w, "kritis_policy_violations_total{namespace=\"%s\"} %d\n", escape(ns), violations[ns])
+	}
+	fmt.Fprintln(w, "# HELP kritis_admission_decisions_total Pods admitted or denied, by namespace.")
+	fmt.Fprintln(w, "# TYPE kritis_admission_decisions_total counter")
+	keys := make([]admissionKey, 0, len(admissions))
+	for k := range admissions {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return !keys[i].allowed && keys[j].allowed
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "kritis_admission_decisions_total{namespace=\"%s\",allowed=\"%t\"} %d\n", escape(k.namespace), k.allowed, admissions[k])
+	}
+	fmt.Fprintln(w, "# HELP kritis_admission_duration_seconds How long admission decisions took, by namespace.")
+	fmt.Fprintln(w, "# TYPE kritis_admission_duration_seconds histogram")
+	names := make([]string, 0, len(durations))
+	for ns := range durations {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	for _, ns := range names {
+		h := durations[ns]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "kritis_admission_duration_seconds_bucket{namespace=\"%s\",le=\"%g\"} %d\n", escape(ns), bound, h.buckets[i])
+		}
+		fmt.Fprintf(w, "kritis_admission_duration_seconds_bucket{namespace=\"%s\",le=\"+Inf\"} %d\n", escape(ns), h.count)
+		fmt.Fprintf(w, "kritis_admission_duration_seconds_sum{namespace=\"%s\"} %g\n", escape(ns), h.sum)
+		fmt.Fprintf(w, "kritis_admission_duration_seconds_count{namespace=\"%s\"} %d\n", escape(ns), h.count)
+	}
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value as required by the exposition format
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
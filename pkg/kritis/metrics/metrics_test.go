@@ -0,0 +1,223 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestBuildInfo(t *testing.T) {
+	originalVersion, originalCommit := buildVersion, buildCommit
+	defer func() { buildVersion, buildCommit = originalVersion, originalCommit }()
+	buildVersion = func() string { return "v0.1.0" }
+	buildCommit = func() string { return `abc"123` }
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected content type %q, got %q", ContentType, ct)
+	}
+	expected := `kritis_build_info{version="v0.1.0",commit="abc\"123"} 1`
+	lines := strings.Split(w.Body.String(), "\n")
+	found := false
+	for _, l := range lines {
+		if l == expected {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %s in metrics, got:\n%s", expected, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "# TYPE kritis_build_info gauge\n") {
+		t.Errorf("expected kritis_build_info to be a gauge, got:\n%s", w.Body.String())
+	}
+}
+
+// resetNamespaced clears the namespaced metrics and namespace labels for a
+// test, returning a func restoring them
+func resetNamespaced() func() {
+	admissionMu.Lock()
+	v, a, d := violations, admissions, durations
+	violations, admissions, durations = map[string]uint64{}, map[admissionKey]uint64{}, map[string]*histogram{}
+	admissionMu.Unlock()
+	namespacesMu.Lock()
+	allowlist, limit, labeled := namespaceAllowlist, namespaceLimit, labeledNamespaces
+	labeledNamespaces = map[string]bool{}
+	namespacesMu.Unlock()
+	return func() {
+		admissionMu.Lock()
+		violations, admissions, durations = v, a, d
+		admissionMu.Unlock()
+		namespacesMu.Lock()
+		namespaceAllowlist, namespaceLimit, labeledNamespaces = allowlist, limit, labeled
+		namespacesMu.Unlock()
+	}
+}
+
+// scrape returns the metrics as served
+func scrape() string {
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestViolations(t *testing.T) {
+	defer resetNamespaced()()
+	SetNamespaceLabels(nil, 0)
+	AddViolations("default", 250)
+	AddViolations("default", 1)
+	AddViolations("team-a", 2)
+
+	body := scrape()
+	for _, expected := range []string{
+		"\nkritis_policy_violations_total{namespace=\"default\"} 251\n",
+		"\nkritis_policy_violations_total{namespace=\"team-a\"} 2\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in metrics, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestObserveAdmission(t *testing.T) {
+	defer resetNamespaced()()
+	SetNamespaceLabels(nil, 0)
+	ObserveAdmission("default", true, 20*time.Millisecond)
+	ObserveAdmission("default", false, 2*time.Second)
+	ObserveAdmission("default", true, 20*time.Millisecond)
+
+	body := scrape()
+	for _, expected := range []string{
+		"\nkritis_admission_decisions_total{namespace=\"default\",allowed=\"false\"} 1\n",
+		"\nkritis_admission_decisions_total{namespace=\"default\",allowed=\"true\"} 2\n",
+		"\nkritis_admission_duration_seconds_bucket{namespace=\"default\",le=\"0.01\"} 0\n",
+		"\nkritis_admission_duration_seconds_bucket{namespace=\"default\",le=\"0.025\"} 2\n",
+		"\nkritis_admission_duration_seconds_bucket{namespace=\"default\",le=\"2.5\"} 3\n",
+		"\nkritis_admission_duration_seconds_bucket{namespace=\"default\",le=\"+Inf\"} 3\n",
+		"\nkritis_admission_duration_seconds_sum{namespace=\"default\"} 2.04\n",
+		"\nkritis_admission_duration_seconds_count{namespace=\"default\"} 3\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in metrics, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestNamespaceLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowlist  []string
+		limit      int
+		namespaces []string
+		expected   []string
+	}{
+		{
+			name:       "first namespaces up to the limit",
+			limit:      2,
+			namespaces: []string{"a", "b", "a", "c", "b", "d"},
+			expected:   []string{"a", "b", "a", OtherNamespaces, "b", OtherNamespaces},
+		},
+		{
+			name:       "allowlist",
+			allowlist:  []string{"kube-system", "team-*"},
+			limit:      1,
+			namespaces: []string{"default", "team-a", "team-b", "kube-system"},
+			expected:   []string{OtherNamespaces, "team-a", "team-b", "kube-system"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer resetNamespaced()()
+			SetNamespaceLabels(test.allowlist, test.limit)
+			labels := []string{}
+			for _, ns := range test.namespaces {
+				labels = append(labels, namespaceLabel(ns))
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, labels)
+		})
+	}
+}
+
+func TestNamespaceLabelBound(t *testing.T) {
+	defer resetNamespaced()()
+	SetNamespaceLabels(nil, 3)
+	for i := 0; i < 100; i++ {
+		ns := fmt.Sprintf("tenant-%d", i)
+		AddViolations(ns, 1)
+		ObserveAdmission(ns, false, time.Millisecond)
+	}
+	body := scrape()
+	if n := strings.Count(body, "\nkritis_policy_violations_total{"); n != 4 {
+		t.Errorf("expected 3 namespaces and %s labeling violations, got %d:\n%s", OtherNamespaces, n, body)
+	}
+	expected := fmt.Sprintf("\nkritis_admission_decisions_total{namespace=\"%s\",allowed=\"false\"} 97\n", OtherNamespaces)
+	if !strings.Contains(body, expected) {
+		t.Errorf("expected %q in metrics, got:\n%s", expected, body)
+	}
+}
+
+func TestSetNamespaceLabelsAgain(t *testing.T) {
+	defer resetNamespaced()()
+	SetNamespaceLabels(nil, 2)
+	ObserveAdmission("a", true, time.Millisecond)
+	ObserveAdmission("b", true, time.Millisecond)
+	// Setting the same bounds, as every change of the options does, keeps
+	// the namespaces already labeled
+	SetNamespaceLabels(nil, 2)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, OtherNamespaces, namespaceLabel("c"))
+	// New bounds count the namespaces which already have metrics
+	SetNamespaceLabels(nil, 3)
+	labels := []string{namespaceLabel("c"), namespaceLabel("d"), namespaceLabel("a")}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"c", OtherNamespaces, "a"}, labels)
+}
+
+func TestAttestationFailures(t *testing.T) {
+	original := atomic.LoadUint64(&attestationFailures)
+	defer atomic.StoreUint64(&attestationFailures, original)
+	atomic.StoreUint64(&attestationFailures, 0)
+	AddAttestationFailures(1)
+	AddAttestationFailures(2)
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(w.Body.String(), "\nkritis_attestation_failures_total 3\n") {
+		t.Errorf("expected 3 attestation failures in metrics, got:\n%s", w.Body.String())
+	}
+}
+
+func TestExpiredWhitelistEntries(t *testing.T) {
+	original := atomic.LoadUint64(&expiredWhitelistEntries)
+	defer atomic.StoreUint64(&expiredWhitelistEntries, original)
+	SetExpiredWhitelistEntries(3)
+	SetExpiredWhitelistEntries(2)
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(w.Body.String(), "\nkritis_expired_whitelist_entries 2\n") {
+		t.Errorf("expected 2 expired whitelist entries in metrics, got:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"path"
+	"reflect"
+	"sync"
+)
+
+const (
+	// OtherNamespaces labels the metrics of namespaces which don't get their
+	// own label
+	OtherNamespaces = "_other"
+	// DefaultNamespaceLimit is how many namespaces get their own label
+	// without an allowlist
+	DefaultNamespaceLimit = 50
+)
+
+// The namespaces metrics are labeled with, which are bounded so that a
+// cluster with many namespaces doesn't explode the number of series
+var (
+	namespacesMu       sync.Mutex
+	namespaceAllowlist []string
+	namespaceLimit     = DefaultNamespaceLimit
+	labeledNamespaces  = map[string]bool{}
+)
+
+// SetNamespaceLabels bounds the namespaces metrics are labeled with. If
+// allowlist isn't empty, only namespaces matching its patterns, as in
+// path.Match, get their own label. Otherwise the first limit namespaces
+// seen do, or DefaultNamespaceLimit if limit isn't positive, counting those
+// which already have metrics. Metrics of other namespaces are labeled
+// OtherNamespaces. Setting the same bounds again keeps the namespaces seen.
+func SetNamespaceLabels(allowlist []string, limit int) {
+	if limit <= 0 {
+		limit = DefaultNamespaceLimit
+	}
+	existing := seriesNamespaces()
+	namespacesMu.Lock()
+	defer namespacesMu.Unlock()
+	if limit == namespaceLimit && reflect.DeepEqual(allowlist, namespaceAllowlist) {
+		return
+	}
+	namespaceAllowlist = allowlist
+	namespaceLimit = limit
+	labeledNamespaces = map[string]bool{}
+	for _, namespace := range existing {
+		if namespace != OtherNamespaces {
+			labeledNamespaces[namespace] = true
+		}
+	}
+}
+
+// namespaceLabel returns the label of the metrics of namespace
+func namespaceLabel(namespace string) string {
+	namespacesMu.Lock()
+	defer namespacesMu.Unlock()
+	if len(namespaceAllowlist) != 0 {
+		for _, pattern := range namespaceAllowlist {
+			if ok, _ := path.Match(pattern, namespace); ok {
+				return namespace
+			}
+		}
+		return OtherNamespaces
+	}
+	if labeledNamespaces[namespace] {
+		return namespace
+	}
+	if len(labeledNamespaces) >= namespaceLimit {
+		return OtherNamespaces
+	}
+	labeledNamespaces[namespace] = true
+	return namespace
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notary verifies Docker Content Trust signatures of images, which
+// are stored as signed TUF targets metadata in a Notary v1 server.
+package notary
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+)
+
+// ecdsaMethod is the TUF signature method of ECDSA P-256 signatures, which
+// is what Docker Content Trust signs targets metadata with
+const ecdsaMethod = "ecdsa"
+
+// For testing
+var (
+	now = time.Now
+	// fetchTimeout is how long fetching trust data may take
+	fetchTimeout = 5 * time.Second
+	// maxTargetsSize is the largest targets metadata fetched, well above
+	// that of repositories with many signed tags, so that a misbehaving
+	// server can't exhaust the webhook's memory
+	maxTargetsSize int64 = 8 << 20
+)
+
+// Signed is a TUF metadata file together with its signatures
+type Signed struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []Signature     `json:"signatures"`
+}
+
+// Signature is a signature of the canonical JSON of Signed.Signed
+type Signature struct {
+	KeyID  string `json:"keyid"`
+	Method string `json:"method"`
+	Sig    string `json:"sig"`
+}
+
+// Targets is the signed content of targets metadata
+type Targets struct {
+	Expires time.Time         `json:"expires"`
+	Targets map[string]Target `json:"targets"`
+}
+
+// Target is a signed tag of an image
+type Target struct {
+	Hashes map[string]string `json:"hashes"`
+	Length int64             `json:"length"`
+}
+
+// Verify returns nil if image, which must be referenced by digest, is a
+// target of the trust data of its repository in the Notary server at server,
+// and the trust data is signed by publicKeyData, a PEM encoded ECDSA public
+// key or certificate of the targets role. A certificate is only trusted
+// within its validity period.
+func Verify(server string, publicKeyData string, image string) error {
+	digest, err := name.NewDigest(image, name.WeakValidation)
+	if err != nil {
+		return err
+	}
+	key, err := parsePublicKey(publicKeyData)
+	if err != nil {
+		return err
+	}
+	signed, err := fetchTargets(server, digest.Context().Name())
+	if err != nil {
+		return err
+	}
+	if err := verifySignatures(signed, key); err != nil {
+		return err
+	}
+	targets := Targets{}
+	if err := json.Unmarshal(signed.Signed, &targets); err != nil {
+		return fmt.Errorf("error parsing targets metadata: %v", err)
+	}
+	if now().After(targets.Expires) {
+		return fmt.Errorf("trust data of %s expired at %s", digest.Context().Name(), targets.Expires.Format(time.RFC3339))
+	}
+	return checkTarget(targets, digest.DigestStr())
+}
+
+// fetchTargets gets the targets metadata of the repository gun from server
+func fetchTargets(server string, gun string) (*Signed, error) {
+	url := fmt.Sprintf("%s/v2/%s/_trust/tuf/targets.json", strings.TrimSuffix(server, "/"), gun)
+	client := &http.Client{Transport: util.RegistryTransport(), Timeout: fetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching trust data of %s: %v", gun, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s has no trust data", gun)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching trust data of %s: %s", gun, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTargetsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxTargetsSize {
+		return nil, fmt.Errorf("trust data of %s is larger than %d bytes", gun, maxTargetsSize)
+	}
+	signed := Signed{}
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("error parsing trust data of %s: %v", gun, err)
+	}
+	return &signed, nil
+}
+
+// verifySignatures returns nil if any signature of signed is by key
+func verifySignatures(signed *Signed, key *ecdsa.PublicKey) error {
+	message, err := canonicalJSON(signed.Signed)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(message)
+	for _, s := range signed.Signatures {
+		if s.Method != ecdsaMethod {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil || len(sig) != 64 {
+			continue
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		ss := new(big.Int).SetBytes(sig[32:])
+		if ecdsa.Verify(key, hash[:], r, ss) {
+			return nil
+		}
+	}
+	return fmt.Errorf("trust data is not signed by the trusted key")
+}
+
+// checkTarget returns nil if a target of targets has the sha256 digest
+func checkTarget(targets Targets, digest string) error {
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	expected, err := hex.DecodeString(hexDigest)
+	if err != nil {
+		return fmt.Errorf("invalid digest %s: %v", digest, err)
+	}
+	for _, t := range targets.Targets {
+		hash, err := base64.StdEncoding.DecodeString(t.Hashes["sha256"])
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(hash, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no signed target has digest %s", digest)
+}
+
+// canonicalJSON returns data with sorted keys and no insignificant
+// whitespace, which is the form TUF metadata is signed in
+func canonicalJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	e := json.NewEncoder(buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// checkValidity returns an error if cert was expired or not yet valid at t,
+// the time a signature made with its key was made, in which case the
+// signature isn't trusted
+func checkValidity(cert *x509.Certificate, t time.Time) error {
+	if t.After(cert.NotAfter) {
+		return fmt.Errorf("signing certificate %q expired at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	}
+	if t.Before(cert.NotBefore) {
+		return fmt.Errorf("signing certificate %q is not valid until %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// parsePublicKey parses a PEM encoded ECDSA public key or certificate
+func parsePublicKey(publicKeyData string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyData))
+	if block == nil {
+		return nil, fmt.Errorf("trust anchor is not PEM encoded")
+	}
+	var key interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		// TUF metadata doesn't record when it was signed, only that it was
+		// signed by the time it's fetched, so the certificate must still be
+		// valid now
+		if err := checkValidity(cert, now()); err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	default:
+		var err error
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("trust anchor is not an ECDSA key")
+	}
+	return ecKey, nil
+}
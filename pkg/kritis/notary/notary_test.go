@@ -0,0 +1,223 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notary
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+const signedDigest = "1111111111111111111111111111111111111111111111111111111111111111"
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signTargets returns targets metadata listing digest, signed by priv
+func signTargets(t *testing.T, priv *ecdsa.PrivateKey, digest string, expires time.Time) []byte {
+	hash, err := hex.DecodeString(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := json.Marshal(map[string]interface{}{
+		"_type":   "Targets",
+		"expires": expires,
+		"targets": map[string]Target{
+			"latest": {Hashes: map[string]string{"sha256": base64.StdEncoding.EncodeToString(hash)}, Length: 1024},
+		},
+		"version": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := canonicalJSON(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(message)
+	r, s, err := ecdsa.Sign(rand.Reader, priv, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(pad(r.Bytes()), pad(s.Bytes())...)
+	data, err := json.Marshal(Signed{
+		Signed:     signed,
+		Signatures: []Signature{{KeyID: "targets", Method: ecdsaMethod, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func pad(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// fakeTrustServer serves data as the targets metadata of gcr.io/project/signed
+func fakeTrustServer(data []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/gcr.io/project/signed/_trust/tuf/targets.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+}
+
+func TestVerify(t *testing.T) {
+	priv, pub := newKey(t)
+	_, otherPub := newKey(t)
+	expires := time.Now().Add(time.Hour)
+	server := fakeTrustServer(signTargets(t, priv, signedDigest, expires))
+	defer server.Close()
+	var tests = []struct {
+		name      string
+		key       string
+		image     string
+		shouldErr bool
+	}{
+		{"signed image", pub, fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest), false},
+		{"unsigned digest", pub, fmt.Sprintf("gcr.io/project/signed@sha256:%s", strings.Repeat("2", 64)), true},
+		{"untrusted key", otherPub, fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest), true},
+		{"no trust data", pub, fmt.Sprintf("gcr.io/project/unsigned@sha256:%s", signedDigest), true},
+		{"image not referenced by digest", pub, "gcr.io/project/signed:latest", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckError(t, test.shouldErr, Verify(server.URL, test.key, test.image))
+		})
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	priv, pub := newKey(t)
+	expires := time.Now().Add(-time.Hour)
+	server := fakeTrustServer(signTargets(t, priv, signedDigest, expires))
+	defer server.Close()
+	err := Verify(server.URL, pub, fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest))
+	testutil.CheckError(t, true, err)
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	_, pub := newKey(t)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	defer func(d time.Duration) { fetchTimeout = d }(fetchTimeout)
+	fetchTimeout = 10 * time.Millisecond
+	err := Verify(server.URL, pub, fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest))
+	testutil.CheckError(t, true, err)
+}
+
+func TestVerifyTooLarge(t *testing.T) {
+	priv, pub := newKey(t)
+	data := signTargets(t, priv, signedDigest, time.Now().Add(time.Hour))
+	server := fakeTrustServer(data)
+	defer server.Close()
+	image := fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest)
+	defer func(size int64) { maxTargetsSize = size }(maxTargetsSize)
+	maxTargetsSize = int64(len(data))
+	testutil.CheckError(t, false, Verify(server.URL, pub, image))
+	maxTargetsSize = int64(len(data) - 1)
+	testutil.CheckError(t, true, Verify(server.URL, pub, image))
+}
+
+// newCertificate returns a self-signed certificate for the public key of
+// priv, valid from notBefore to notAfter
+func newCertificate(t *testing.T, priv *ecdsa.PrivateKey, notBefore time.Time, notAfter time.Time) string {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gcr.io/project/signed"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestVerifyExpiredCertificate(t *testing.T) {
+	priv, _ := newKey(t)
+	issued := time.Now().Add(-48 * time.Hour)
+	cert := newCertificate(t, priv, issued, issued.Add(24*time.Hour))
+	server := fakeTrustServer(signTargets(t, priv, signedDigest, time.Now().Add(time.Hour)))
+	defer server.Close()
+	err := Verify(server.URL, cert, fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest))
+	testutil.CheckError(t, true, err)
+}
+
+func TestVerifyCertificateValidity(t *testing.T) {
+	priv, _ := newKey(t)
+	issued := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := newCertificate(t, priv, issued, issued.Add(24*time.Hour))
+	server := fakeTrustServer(signTargets(t, priv, signedDigest, issued.Add(30*24*time.Hour)))
+	defer server.Close()
+	original := now
+	defer func() {
+		now = original
+	}()
+	var tests = []struct {
+		name      string
+		now       time.Time
+		shouldErr bool
+	}{
+		{"valid certificate", issued.Add(time.Hour), false},
+		{"expired certificate", issued.Add(25 * time.Hour), true},
+		{"certificate not yet valid", issued.Add(-time.Hour), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = func() time.Time {
+				return test.now
+			}
+			err := Verify(server.URL, cert, fmt.Sprintf("gcr.io/project/signed@sha256:%s", signedDigest))
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// ParseImagePath parses a JSONPath template selecting images in a custom
+// resource, e.g. {.spec.templates[*].container.image}. Keys missing from a
+// resource select nothing.
+func ParseImagePath(path string) (*jsonpath.JSONPath, error) {
+	j := jsonpath.New("images").AllowMissingKeys(true)
+	if err := j.Parse(path); err != nil {
+		return nil, fmt.Errorf("invalid image path %q: %v", path, err)
+	}
+	return j, nil
+}
+
+// FromCustomResource returns a pod running the images selected by paths in
+// the custom resource raw, such as an Argo Workflow, so that custom resources
+// embedding images can be validated the same way as pods. The pod has the
+// name, namespace and annotations of the resource.
+func FromCustomResource(raw []byte, paths []string) (*corev1.Pod, error) {
+	resource := struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(raw, &resource); err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	images := []string{}
+	for _, path := range paths {
+		// A JSONPath can't be executed concurrently, so parse it every time
+		j, err := ParseImagePath(path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := selectedImages(j, data)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting images with %q: %v", path, err)
+		}
+		images = append(images, found...)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        resource.Metadata.Name,
+			Namespace:   resource.Metadata.Namespace,
+			Annotations: resource.Metadata.Annotations,
+		},
+	}
+	for i, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:  fmt.Sprintf("image-%d", i),
+			Image: image,
+		})
+	}
+	return pod, nil
+}
+
+// selectedImages returns the images j selects in data, which must be strings
+func selectedImages(j *jsonpath.JSONPath, data interface{}) ([]string, error) {
+	results, err := j.FindResults(data)
+	if err != nil {
+		return nil, err
+	}
+	images := []string{}
+	for _, values := range results {
+		for _, v := range values {
+			if v.Kind() == reflect.Interface {
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.String {
+				return nil, fmt.Errorf("selected %s instead of an image", v.Kind())
+			}
+			if v.String() != "" {
+				images = append(images, v.String())
+			}
+		}
+	}
+	return images, nil
+}
@@ -18,6 +18,11 @@ package pods
 
 import (
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -32,6 +37,9 @@ var (
 	patchFunction = applyPatch
 )
 
+// digestPattern matches the digest of an image reference, e.g. sha256:abcd
+var digestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
 // Pods returns a list of pods in a namespace
 func Pods(namespace string) ([]corev1.Pod, error) {
 	clientset, err := getClientSet()
@@ -54,6 +62,116 @@ func Images(pod corev1.Pod) []string {
 	return images
 }
 
+// NeverPulled returns true if every container in pod running image has the
+// imagePullPolicy Never, so image must already be on the node
+func NeverPulled(pod corev1.Pod, image string) bool {
+	found := false
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if c.Image != image {
+			continue
+		}
+		found = true
+		if c.ImagePullPolicy != corev1.PullNever {
+			return false
+		}
+	}
+	return found
+}
+
+// Unresolved returns true if image isn't a literal image reference, such as
+// a $(IMAGE) or {{ .Values.image }} placeholder a controller was meant to
+// substitute, so it can't be validated as the image the node will run
+func Unresolved(image string) bool {
+	if i := strings.Index(image, "@"); i >= 0 {
+		if !digestPattern.MatchString(image[i+1:]) {
+			return true
+		}
+		image = image[:i]
+	}
+	_, err := name.NewTag(image, name.WeakValidation)
+	return err != nil
+}
+
+// RunsAsNonRoot returns true if every container in pod running image is
+// forced to run as non-root by its securityContext or the pod's
+func RunsAsNonRoot(pod corev1.Pod, image string) bool {
+	found := false
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if c.Image != image {
+			continue
+		}
+		found = true
+		if !containerRunsAsNonRoot(pod.Spec.SecurityContext, c.SecurityContext) {
+			return false
+		}
+	}
+	return found
+}
+
+// Privileges returns the elevated privileges pod runs with, such as
+// privileged containers, host namespaces or hostPath volumes, which give its
+// containers access to the node
+func Privileges(pod corev1.Pod) []string {
+	privileges := []string{}
+	if pod.Spec.HostNetwork {
+		privileges = append(privileges, "host network")
+	}
+	if pod.Spec.HostPID {
+		privileges = append(privileges, "host PID namespace")
+	}
+	if pod.Spec.HostIPC {
+		privileges = append(privileges, "host IPC namespace")
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.HostPath != nil {
+			privileges = append(privileges, fmt.Sprintf("hostPath volume %s", v.Name))
+		}
+	}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		sc := c.SecurityContext
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			privileges = append(privileges, fmt.Sprintf("privileged container %s", c.Name))
+		}
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				if capability == "ALL" || capability == "SYS_ADMIN" {
+					privileges = append(privileges, fmt.Sprintf("capability %s of container %s", capability, c.Name))
+				}
+			}
+		}
+	}
+	return privileges
+}
+
+// containerRunsAsNonRoot returns true if a container with container
+// securityContext, in a pod with pod securityContext, can't run as root.
+// Container settings take precedence over pod settings.
+func containerRunsAsNonRoot(pod *corev1.PodSecurityContext, container *corev1.SecurityContext) bool {
+	var runAsNonRoot *bool
+	var runAsUser *int64
+	if pod != nil {
+		runAsNonRoot, runAsUser = pod.RunAsNonRoot, pod.RunAsUser
+	}
+	if container != nil {
+		if container.RunAsNonRoot != nil {
+			runAsNonRoot = container.RunAsNonRoot
+		}
+		if container.RunAsUser != nil {
+			runAsUser = container.RunAsUser
+		}
+	}
+	if runAsUser != nil {
+		return *runAsUser != 0
+	}
+	return runAsNonRoot != nil && *runAsNonRoot
+}
+
 func getPatch(modifiedPod *corev1.Pod, originalJSON []byte) ([]byte, error) {
 	modifiedJSON, err := json.Marshal(modifiedPod)
 	if err != nil {
@@ -20,8 +20,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"testing"
 )
 
@@ -48,6 +52,305 @@ func Test_Images(t *testing.T) {
 	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, actual)
 }
 
+func Test_TemplateImages(t *testing.T) {
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{
+					Image: "image1",
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Image: "image2",
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name      string
+		obj       runtime.Object
+		expected  []string
+		shouldErr bool
+	}{
+		{
+			name: "deployment",
+			obj: &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{Template: template},
+			},
+			expected: []string{"image1", "image2"},
+		},
+		{
+			name: "cronjob",
+			obj: &batchv1beta1.CronJob{
+				Spec: batchv1beta1.CronJobSpec{
+					JobTemplate: batchv1beta1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{Template: template},
+					},
+				},
+			},
+			expected: []string{"image1", "image2"},
+		},
+		{
+			name:      "pod has no template",
+			obj:       &corev1.Pod{},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := TemplateImages(test.obj)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, actual)
+		})
+	}
+}
+
+func Test_FromTemplate(t *testing.T) {
+	cronJob := &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cron",
+			Namespace:   "ns",
+			Annotations: map[string]string{"kritis.grafeas.io/breakglass": "true"},
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Image: "image"}},
+						},
+					},
+				},
+			},
+		},
+	}
+	expected := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cron",
+			Namespace:   "ns",
+			Annotations: map[string]string{"kritis.grafeas.io/breakglass": "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Image: "image"}},
+		},
+	}
+	actual, err := FromTemplate(cronJob)
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, actual)
+}
+
+// argoWorkflow is an Argo Workflow whose templates run containers, a script
+// and steps, which run no image of their own
+const argoWorkflow = `{
+  "apiVersion": "argoproj.io/v1alpha1",
+  "kind": "Workflow",
+  "metadata": {
+    "name": "build",
+    "namespace": "ci",
+    "annotations": {"kritis.grafeas.io/breakglass": "true"}
+  },
+  "spec": {
+    "entrypoint": "main",
+    "templates": [
+      {"name": "main", "steps": [[{"name": "test", "template": "test"}], [{"name": "report", "template": "report"}]]},
+      {"name": "test", "container": {"image": "gcr.io/project/test@sha256:123"}},
+      {"name": "report", "script": {"image": "gcr.io/project/python@sha256:456", "source": "print(1)"}}
+    ]
+  }
+}`
+
+func Test_FromCustomResource(t *testing.T) {
+	meta := metav1.ObjectMeta{
+		Name:        "build",
+		Namespace:   "ci",
+		Annotations: map[string]string{"kritis.grafeas.io/breakglass": "true"},
+	}
+	var tests = []struct {
+		name      string
+		paths     []string
+		shouldErr bool
+		expected  *corev1.Pod
+	}{
+		{
+			name:  "container images",
+			paths: []string{"{.spec.templates[*].container.image}"},
+			expected: &corev1.Pod{
+				ObjectMeta: meta,
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "image-0", Image: "gcr.io/project/test@sha256:123"}},
+				},
+			},
+		},
+		{
+			name:  "container and script images",
+			paths: []string{"{.spec.templates[*].container.image}", "{.spec.templates[*].script.image}"},
+			expected: &corev1.Pod{
+				ObjectMeta: meta,
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "image-0", Image: "gcr.io/project/test@sha256:123"},
+						{Name: "image-1", Image: "gcr.io/project/python@sha256:456"},
+					},
+				},
+			},
+		},
+		{
+			name:     "missing keys",
+			paths:    []string{"{.spec.templates[*].sidecars[*].image}"},
+			expected: &corev1.Pod{ObjectMeta: meta},
+		},
+		{
+			name:      "not an image",
+			paths:     []string{"{.spec.templates[0].steps}"},
+			shouldErr: true,
+		},
+		{
+			name:      "invalid path",
+			paths:     []string{"{.spec.templates[*"},
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := FromCustomResource([]byte(argoWorkflow), test.paths)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, actual)
+		})
+	}
+}
+
+func Test_RunsAsNonRoot(t *testing.T) {
+	yes, no := true, false
+	root, user := int64(0), int64(1000)
+	var tests = []struct {
+		name      string
+		pod       *corev1.PodSecurityContext
+		container *corev1.SecurityContext
+		expected  bool
+	}{
+		{"no security context", nil, nil, false},
+		{"pod runs as non-root", &corev1.PodSecurityContext{RunAsNonRoot: &yes}, nil, true},
+		{"pod runs as user", &corev1.PodSecurityContext{RunAsUser: &user}, nil, true},
+		{"pod runs as root user", &corev1.PodSecurityContext{RunAsUser: &root}, nil, false},
+		{"container runs as non-root", nil, &corev1.SecurityContext{RunAsNonRoot: &yes}, true},
+		{"container overrides pod", &corev1.PodSecurityContext{RunAsNonRoot: &yes}, &corev1.SecurityContext{RunAsNonRoot: &no}, false},
+		{"container user overrides pod", &corev1.PodSecurityContext{RunAsUser: &root}, &corev1.SecurityContext{RunAsUser: &user}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				Spec: corev1.PodSpec{
+					SecurityContext: test.pod,
+					Containers: []corev1.Container{
+						{Image: "image", SecurityContext: test.container},
+						{Image: "other"},
+					},
+				},
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, RunsAsNonRoot(pod, "image"))
+		})
+	}
+}
+
+func Test_RunsAsNonRootEveryContainer(t *testing.T) {
+	yes := true
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Image: "image"}},
+			Containers:     []corev1.Container{{Image: "image", SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &yes}}},
+		},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, RunsAsNonRoot(pod, "image"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, RunsAsNonRoot(pod, "missing"))
+}
+
+func Test_Privileges(t *testing.T) {
+	yes, no := true, false
+	var tests = []struct {
+		name     string
+		spec     corev1.PodSpec
+		expected []string
+	}{
+		{
+			name:     "unprivileged pod",
+			spec:     corev1.PodSpec{Containers: []corev1.Container{{Name: "app", SecurityContext: &corev1.SecurityContext{Privileged: &no}}}},
+			expected: []string{},
+		},
+		{
+			name:     "privileged container",
+			spec:     corev1.PodSpec{Containers: []corev1.Container{{Name: "app", SecurityContext: &corev1.SecurityContext{Privileged: &yes}}}},
+			expected: []string{"privileged container app"},
+		},
+		{
+			name: "host namespaces and volumes",
+			spec: corev1.PodSpec{
+				HostNetwork: true,
+				HostPID:     true,
+				Volumes: []corev1.Volume{
+					{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+					{Name: "docker", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run/docker.sock"}}},
+				},
+			},
+			expected: []string{"host network", "host PID namespace", "hostPath volume docker"},
+		},
+		{
+			name: "added capabilities",
+			spec: corev1.PodSpec{InitContainers: []corev1.Container{{
+				Name: "setup",
+				SecurityContext: &corev1.SecurityContext{
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE", "SYS_ADMIN"}},
+				},
+			}}},
+			expected: []string{"capability SYS_ADMIN of container setup"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, Privileges(corev1.Pod{Spec: test.spec}))
+		})
+	}
+}
+
+func Test_NeverPulled(t *testing.T) {
+	pod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Image: "local", ImagePullPolicy: corev1.PullNever}},
+			Containers: []corev1.Container{
+				{Image: "local", ImagePullPolicy: corev1.PullNever},
+				{Image: "shared", ImagePullPolicy: corev1.PullNever},
+				{Image: "shared", ImagePullPolicy: corev1.PullIfNotPresent},
+				{Image: "remote"},
+			},
+		},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, true, NeverPulled(pod, "local"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, NeverPulled(pod, "shared"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, NeverPulled(pod, "remote"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, NeverPulled(pod, "missing"))
+}
+
+func Test_Unresolved(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected bool
+	}{
+		{"gcr.io/project/image:tag", false},
+		{"gcr.io/project/image@sha256:0000000000000000000000000000000000000000000000000000000000000000", false},
+		{"image", false},
+		{"localhost:5000/image", false},
+		{"gcr.io/project/image@sha256:abcd", false},
+		{"gcr.io/project/image@$(DIGEST)", true},
+		{"$(IMAGE)", true},
+		{"gcr.io/project/${APP}:latest", true},
+		{"{{ .Values.image }}", true},
+		{"IMAGE_PLACEHOLDER", true},
+		{"", true},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, Unresolved(test.image))
+		})
+	}
+}
+
 func Test_AddPatch(t *testing.T) {
 	tests := []struct {
 		name                string
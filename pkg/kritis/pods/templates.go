@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	batchv2alpha1 "k8s.io/api/batch/v2alpha1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PodTemplate returns the metadata of a workload and the pod template it
+// creates pods from. CronJobs are unwrapped through their job template.
+func PodTemplate(obj runtime.Object) (*metav1.ObjectMeta, *corev1.PodTemplateSpec, error) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1.ReplicaSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1.StatefulSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1.DaemonSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1beta1.Deployment:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1beta1.StatefulSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1beta2.Deployment:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1beta2.ReplicaSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1beta2.StatefulSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *appsv1beta2.DaemonSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *extensionsv1beta1.Deployment:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *extensionsv1beta1.ReplicaSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *extensionsv1beta1.DaemonSet:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return nil, nil, fmt.Errorf("replication controller %s has no pod template", o.Name)
+		}
+		return &o.ObjectMeta, o.Spec.Template, nil
+	case *corev1.PodTemplate:
+		return &o.ObjectMeta, &o.Template, nil
+	case *batchv1.Job:
+		return &o.ObjectMeta, &o.Spec.Template, nil
+	case *batchv1beta1.CronJob:
+		return &o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template, nil
+	case *batchv2alpha1.CronJob:
+		return &o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template, nil
+	}
+	return nil, nil, fmt.Errorf("%T does not have a pod template", obj)
+}
+
+// TemplateImages returns a list of images in a workload's pod template
+func TemplateImages(obj runtime.Object) ([]string, error) {
+	_, template, err := PodTemplate(obj)
+	if err != nil {
+		return nil, err
+	}
+	return Images(corev1.Pod{Spec: template.Spec}), nil
+}
+
+// FromTemplate returns the pod a workload would create, so that workloads
+// can be validated the same way as pods. The pod is in the workload's
+// namespace and has the annotations of both the workload and its template.
+func FromTemplate(obj runtime.Object) (*corev1.Pod, error) {
+	meta, template, err := PodTemplate(obj)
+	if err != nil {
+		return nil, err
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	if pod.Name == "" {
+		pod.Name = meta.Name
+	}
+	pod.Namespace = meta.Namespace
+	if len(meta.Annotations) != 0 && pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	for k, v := range meta.Annotations {
+		if _, ok := pod.Annotations[k]; !ok {
+			pod.Annotations[k] = v
+		}
+	}
+	return pod, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+)
+
+// Advisory is a vulnerability of some versions of a package
+type Advisory struct {
+	// Package is the package URL of the package without a version, such as
+	// pkg:npm/lodash, or for components without one, their name
+	Package string `json:"package"`
+	// Versions are the affected versions of the package
+	Versions     []string `json:"versions"`
+	CVE          string   `json:"cve"`
+	Severity     string   `json:"severity"`
+	FixAvailable bool     `json:"fixAvailable"`
+}
+
+// Database is a vulnerability database SBOM components are cross-referenced
+// against. A nil *Database is valid and has no advisories.
+type Database struct {
+	advisories map[string][]Advisory
+}
+
+// LoadDatabase reads a vulnerability database from file, see ParseDatabase
+func LoadDatabase(file string) (*Database, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDatabase(data)
+}
+
+// ParseDatabase parses a vulnerability database, a JSON list of Advisories
+func ParseDatabase(data []byte) (*Database, error) {
+	advisories := []Advisory{}
+	if err := json.Unmarshal(data, &advisories); err != nil {
+		return nil, err
+	}
+	d := &Database{advisories: map[string][]Advisory{}}
+	for _, a := range advisories {
+		if a.Package == "" || a.CVE == "" {
+			return nil, fmt.Errorf("advisory %+v must have a package and a CVE", a)
+		}
+		d.advisories[a.Package] = append(d.advisories[a.Package], a)
+	}
+	return d, nil
+}
+
+// Vulnerabilities returns the vulnerabilities of components according to
+// the database, each CVE once
+func (d *Database) Vulnerabilities(components []Component) []metadata.Vulnerability {
+	if d == nil {
+		return nil
+	}
+	found := map[string]bool{}
+	vulnz := []metadata.Vulnerability{}
+	for _, c := range components {
+		for _, a := range d.advisories[packageOf(c)] {
+			if found[a.CVE] || !affected(a, c.Version) {
+				continue
+			}
+			found[a.CVE] = true
+			vulnz = append(vulnz, metadata.Vulnerability{
+				CVE:             a.CVE,
+				Severity:        a.Severity,
+				HasFixAvailable: a.FixAvailable,
+			})
+		}
+	}
+	return vulnz
+}
+
+// packageOf returns the package URL of c without its version, qualifiers
+// and subpath, or its name if it has no package URL
+func packageOf(c Component) string {
+	if c.PURL == "" {
+		return c.Name
+	}
+	p := c.PURL
+	for _, sep := range []string{"#", "?", "@"} {
+		if i := strings.LastIndex(p, sep); i != -1 {
+			p = p[:i]
+		}
+	}
+	return p
+}
+
+// affected returns true if version of a package is one of the versions a
+// affects
+func affected(a Advisory, version string) bool {
+	for _, v := range a.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+const database = `[
+  {"package": "pkg:npm/lodash", "versions": ["4.17.19", "4.17.20"], "cve": "CVE-2021-23337", "severity": "HIGH", "fixAvailable": true},
+  {"package": "pkg:npm/express", "versions": ["4.16.0"], "cve": "CVE-2000-0001", "severity": "LOW"},
+  {"package": "openssl", "versions": ["1.1.1k"], "cve": "CVE-2021-3711", "severity": "CRITICAL", "fixAvailable": true},
+  {"package": "pkg:maven/org.apache.logging.log4j/log4j-core", "versions": ["2.14.1"], "cve": "CVE-2021-44228", "severity": "CRITICAL", "fixAvailable": true}
+]`
+
+func TestVulnerabilities(t *testing.T) {
+	db, err := ParseDatabase([]byte(database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name     string
+		sbom     string
+		expected []metadata.Vulnerability
+	}{
+		{
+			name: "vulnerable transitive dependency",
+			sbom: cycloneDXSBOM,
+			expected: []metadata.Vulnerability{
+				{CVE: "CVE-2021-23337", Severity: "HIGH", HasFixAvailable: true},
+				{CVE: "CVE-2021-3711", Severity: "CRITICAL", HasFixAvailable: true},
+			},
+		},
+		{
+			name: "package url with qualifiers",
+			sbom: spdxSBOM,
+			expected: []metadata.Vulnerability{
+				{CVE: "CVE-2021-44228", Severity: "CRITICAL", HasFixAvailable: true},
+			},
+		},
+		{
+			name:     "no vulnerable versions",
+			sbom:     `{"bomFormat": "CycloneDX", "components": [{"name": "lodash", "version": "4.17.21", "purl": "pkg:npm/lodash@4.17.21"}]}`,
+			expected: []metadata.Vulnerability{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			components, err := Parse([]byte(test.sbom))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, db.Vulnerabilities(components))
+		})
+	}
+	// A nil database has no advisories
+	var nilDB *Database
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 0, len(nilDB.Vulnerabilities([]Component{{Name: "openssl", Version: "1.1.1k"}})))
+}
+
+func TestParseDatabase(t *testing.T) {
+	var tests = []struct {
+		name      string
+		data      string
+		shouldErr bool
+	}{
+		{"valid", database, false},
+		{"advisory without a cve", `[{"package": "openssl", "versions": ["1.1.1k"]}]`, true},
+		{"not a list", `{"package": "openssl"}`, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseDatabase([]byte(test.data))
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sbom parses the Software Bills of Materials attached to images and
+// cross-references their components against a vulnerability database, to
+// find vulnerabilities in transitive dependencies scanners may miss.
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/grafeas/kritis/pkg/kritis/util"
+)
+
+// maxSize is the largest SBOM fetched, well above those of large images,
+// so that a huge layer can't exhaust the webhook's memory. It is a variable
+// for testing.
+var maxSize int64 = 32 << 20
+
+// Component is a package listed in an SBOM
+type Component struct {
+	Name    string
+	Version string
+	// PURL is the package URL of the component, such as
+	// pkg:npm/lodash@4.17.20, if the SBOM has one
+	PURL string
+}
+
+// cycloneDX is the subset of the CycloneDX JSON format kritis reads
+type cycloneDX struct {
+	BOMFormat  string               `json:"bomFormat"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Name       string               `json:"name"`
+	Version    string               `json:"version"`
+	PURL       string               `json:"purl"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+// spdx is the subset of the SPDX JSON format kritis reads
+type spdx struct {
+	SPDXVersion string `json:"spdxVersion"`
+	Packages    []struct {
+		Name         string `json:"name"`
+		VersionInfo  string `json:"versionInfo"`
+		ExternalRefs []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+}
+
+// Parse parses the components of an SBOM in the CycloneDX or SPDX JSON
+// format, including the components nested in others
+func Parse(data []byte) ([]Component, error) {
+	format := struct {
+		BOMFormat   string `json:"bomFormat"`
+		SPDXVersion string `json:"spdxVersion"`
+	}{}
+	if err := json.Unmarshal(data, &format); err != nil {
+		return nil, err
+	}
+	switch {
+	case format.BOMFormat == "CycloneDX":
+		bom := cycloneDX{}
+		if err := json.Unmarshal(data, &bom); err != nil {
+			return nil, err
+		}
+		return cycloneDXComponents(bom.Components), nil
+	case format.SPDXVersion != "":
+		doc := spdx{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		components := []Component{}
+		for _, p := range doc.Packages {
+			c := Component{Name: p.Name, Version: p.VersionInfo}
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					c.PURL = ref.ReferenceLocator
+				}
+			}
+			components = append(components, c)
+		}
+		return components, nil
+	}
+	return nil, fmt.Errorf("SBOM is neither CycloneDX nor SPDX JSON")
+}
+
+func cycloneDXComponents(nested []cycloneDXComponent) []Component {
+	components := []Component{}
+	for _, c := range nested {
+		components = append(components, Component{Name: c.Name, Version: c.Version, PURL: c.PURL})
+		components = append(components, cycloneDXComponents(c.Components)...)
+	}
+	return components
+}
+
+// Fetch returns the components of the SBOM attached to image, which is
+// referenced by digest, as by cosign attach sbom: the single layer of the
+// image tagged sha256-<hex>.sbom in its repository, fetched with the
+// registry credentials. It returns no components if image has no SBOM
+// attached, and an error if the SBOM is larger than maxSize.
+func Fetch(image string) ([]Component, error) {
+	digest, err := name.NewDigest(image, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := name.NewTag(fmt.Sprintf("%s:%s.sbom", digest.Context(), strings.Replace(digest.DigestStr(), ":", "-", 1)), name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	img, err := util.RemoteImage(tag)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("SBOM of %s has %d layers, expected 1", image, len(layers))
+	}
+	r, err := layers[0].Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("SBOM of %s is larger than %d bytes", image, maxSize)
+	}
+	return Parse(data)
+}
+
+// notFound returns true if err means the registry has no such manifest
+func notFound(err error) bool {
+	e, ok := err.(*remote.Error)
+	if !ok {
+		return false
+	}
+	for _, d := range e.Errors {
+		if d.Code == remote.ManifestUnknownErrorCode {
+			return true
+		}
+	}
+	return false
+}
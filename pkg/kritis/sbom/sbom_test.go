@@ -0,0 +1,172 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+// cycloneDXSBOM lists lodash as a dependency of express, as a transitive
+// dependency a scanner may miss
+const cycloneDXSBOM = `{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.4",
+  "components": [
+    {
+      "name": "express",
+      "version": "4.17.1",
+      "purl": "pkg:npm/express@4.17.1",
+      "components": [
+        {"name": "lodash", "version": "4.17.20", "purl": "pkg:npm/lodash@4.17.20"}
+      ]
+    },
+    {"name": "openssl", "version": "1.1.1k"}
+  ]
+}`
+
+const spdxSBOM = `{
+  "spdxVersion": "SPDX-2.3",
+  "packages": [
+    {
+      "name": "log4j-core",
+      "versionInfo": "2.14.1",
+      "externalRefs": [
+        {"referenceType": "purl", "referenceLocator": "pkg:maven/org.apache.logging.log4j/log4j-core@2.14.1?type=jar"}
+      ]
+    }
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		name      string
+		sbom      string
+		shouldErr bool
+		expected  []Component
+	}{
+		{
+			name: "cyclonedx",
+			sbom: cycloneDXSBOM,
+			expected: []Component{
+				{Name: "express", Version: "4.17.1", PURL: "pkg:npm/express@4.17.1"},
+				{Name: "lodash", Version: "4.17.20", PURL: "pkg:npm/lodash@4.17.20"},
+				{Name: "openssl", Version: "1.1.1k"},
+			},
+		},
+		{
+			name: "spdx",
+			sbom: spdxSBOM,
+			expected: []Component{
+				{Name: "log4j-core", Version: "2.14.1", PURL: "pkg:maven/org.apache.logging.log4j/log4j-core@2.14.1?type=jar"},
+			},
+		},
+		{
+			name:      "unknown format",
+			sbom:      `{"packages": []}`,
+			shouldErr: true,
+		},
+		{
+			name:      "not json",
+			sbom:      "express 4.17.1",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			components, err := Parse([]byte(test.sbom))
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, components)
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	layer := []byte(cycloneDXSBOM)
+	layerDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+	config := []byte(`{}`)
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	manifest := fmt.Sprintf(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+  "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": %d, "digest": "%s"},
+  "layers": [{"mediaType": "application/vnd.cyclonedx+json", "size": %d, "digest": "%s"}]
+}`, len(config), configDigest, len(layer), layerDigest)
+	signed := strings.Repeat("1", 64)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case fmt.Sprintf("/v2/app/manifests/sha256-%s.sbom", signed):
+			w.Write([]byte(manifest))
+		case "/v2/app/blobs/" + layerDigest:
+			w.Write(layer)
+		case "/v2/app/blobs/" + configDigest:
+			w.Write(config)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": [{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}]}`))
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	var tests = []struct {
+		name      string
+		image     string
+		maxSize   int64
+		expected  []Component
+		shouldErr bool
+	}{
+		{
+			name:  "attached sbom",
+			image: fmt.Sprintf("%s/app@sha256:%s", host, signed),
+			expected: []Component{
+				{Name: "express", Version: "4.17.1", PURL: "pkg:npm/express@4.17.1"},
+				{Name: "lodash", Version: "4.17.20", PURL: "pkg:npm/lodash@4.17.20"},
+				{Name: "openssl", Version: "1.1.1k"},
+			},
+		},
+		{
+			name:  "no sbom",
+			image: fmt.Sprintf("%s/app@sha256:%s", host, strings.Repeat("2", 64)),
+		},
+		{
+			name:      "sbom too large",
+			image:     fmt.Sprintf("%s/app@sha256:%s", host, signed),
+			maxSize:   int64(len(layer) - 1),
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.maxSize != 0 {
+				original := maxSize
+				defer func() {
+					maxSize = original
+				}()
+				maxSize = test.maxSize
+			}
+			components, err := Fetch(test.image)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, components)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	kubernetesutil "github.com/grafeas/kritis/pkg/kritis/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PublicKey is the secret data key holding the armored PGP public key
+	PublicKey = "public"
+	// PrivateKey is the secret data key holding the armored PGP private key
+	PrivateKey = "private"
+)
+
+// PGPSigningSecret holds the base64 encoded PGP key pair used for signing
+// attestations
+type PGPSigningSecret struct {
+	PublicKey  string
+	PrivateKey string
+	SecretName string
+}
+
+// Fetch fetches the PGP signing secret with the given name in a namespace.
+// The secret should contain the armored keys under "public" and "private".
+func Fetch(namespace string, name string) (*PGPSigningSecret, error) {
+	c, err := kubernetesutil.GetClientset()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := secret.Data[PublicKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %q key", namespace, name, PublicKey)
+	}
+	priv, ok := secret.Data[PrivateKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %q key", namespace, name, PrivateKey)
+	}
+	return &PGPSigningSecret{
+		PublicKey:  base64.StdEncoding.EncodeToString(pub),
+		PrivateKey: base64.StdEncoding.EncodeToString(priv),
+		SecretName: name,
+	}, nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FakeDigestResolver resolves tags from a fixed map instead of a registry.
+// It implements util.DigestResolver.
+type FakeDigestResolver struct {
+	mu      sync.Mutex
+	digests map[string]string
+	calls   int
+}
+
+// NewFakeDigestResolver returns a FakeDigestResolver which resolves each
+// key of digests to its value
+func NewFakeDigestResolver(digests map[string]string) *FakeDigestResolver {
+	return &FakeDigestResolver{digests: digests}
+}
+
+// ResolveDigest returns the digest image maps to, or an error if it isn't mapped
+func (f *FakeDigestResolver) ResolveDigest(image string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	digest, ok := f.digests[image]
+	if !ok {
+		return "", fmt.Errorf("image %s not found", image)
+	}
+	return digest, nil
+}
+
+// Calls returns how many times ResolveDigest has been called
+func (f *FakeDigestResolver) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
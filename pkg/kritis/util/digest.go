@@ -0,0 +1,56 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// DigestResolver resolves images referenced by tag to digests
+type DigestResolver interface {
+	// ResolveDigest returns image as image@sha256:digest
+	ResolveDigest(image string) (string, error)
+}
+
+// RegistryResolver is a DigestResolver which looks tags up in their registry
+type RegistryResolver struct{}
+
+// ResolveDigest implements DigestResolver
+func (RegistryResolver) ResolveDigest(image string) (string, error) {
+	return ResolveDigest(image)
+}
+
+// ResolveDigest returns image, which is referenced by tag, as
+// image@sha256:digest by looking up the tag in its registry
+func ResolveDigest(image string) (string, error) {
+	tag, err := name.NewTag(image, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	sourceImage, err := RemoteImage(tag)
+	if err != nil {
+		return "", err
+	}
+	digest, err := sourceImage.Digest()
+	if err != nil {
+		return "", err
+	}
+	digestName := fmt.Sprintf("%s@sha256:%s", tag.Context(), digest.Hex)
+	return digestName, nil
+}
@@ -23,6 +23,14 @@ import (
 	"github.com/grafeas/kritis/pkg/kritis/constants"
 )
 
+// BuilderKey is the optional field of an AtomicContainerSig holding the
+// identity of the build pipeline which created the attestation
+const BuilderKey = "builder"
+
+// BuildTimestampKey is the optional field of an AtomicContainerSig holding
+// the time, in RFC 3339 format, the build pipeline built the image
+const BuildTimestampKey = "buildTimestamp"
+
 // AtomicContainerSig represents Red Hat’s Atomic Host attestation signature format
 // defined here https://github.com/aweiteka/image/blob/e5a20d98fe698732df2b142846d007b45873627f/docs/signature.md
 type AtomicContainerSig struct {
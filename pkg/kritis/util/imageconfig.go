@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ImageUser returns the User in the config of image, which is referenced by
+// digest, as fetched from its registry
+func ImageUser(image string) (string, error) {
+	config, err := imageConfig(image)
+	if err != nil {
+		return "", err
+	}
+	return config.Config.User, nil
+}
+
+// ImageLabels returns the Labels in the config of image, which is referenced
+// by digest, as fetched from its registry
+func ImageLabels(image string) (map[string]string, error) {
+	config, err := imageConfig(image)
+	if err != nil {
+		return nil, err
+	}
+	return config.Config.Labels, nil
+}
+
+// ImageExposedPorts returns the ExposedPorts in the config of image, which is
+// referenced by digest, as fetched from its registry, e.g. 80/tcp, in order
+func ImageExposedPorts(image string) ([]string, error) {
+	config, err := imageConfig(image)
+	if err != nil {
+		return nil, err
+	}
+	ports := []string{}
+	for p := range config.Config.ExposedPorts {
+		ports = append(ports, p)
+	}
+	sort.Strings(ports)
+	return ports, nil
+}
+
+// PortMatches returns true if the exposed port, such as 23/tcp or 23, is
+// matched by pattern, which is a port with a protocol, or a port matching
+// it over any protocol. Exposed ports without a protocol are TCP.
+func PortMatches(port string, pattern string) bool {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+	if !strings.Contains(pattern, "/") {
+		return strings.SplitN(port, "/", 2)[0] == pattern
+	}
+	return strings.EqualFold(port, pattern)
+}
+
+func imageConfig(image string) (*v1.ConfigFile, error) {
+	digest, err := name.NewDigest(image, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	img, err := RemoteImage(digest)
+	if err != nil {
+		return nil, err
+	}
+	return img.ConfigFile()
+}
+
+// IsRootUser returns true if an image config User of user runs as root.
+// An empty user defaults to root.
+func IsRootUser(user string) bool {
+	if i := strings.Index(user, ":"); i != -1 {
+		user = user[:i]
+	}
+	return user == "" || user == "root" || user == "0"
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestIsRootUser(t *testing.T) {
+	var tests = []struct {
+		user     string
+		expected bool
+	}{
+		{"", true},
+		{"root", true},
+		{"0", true},
+		{"0:0", true},
+		{"root:staff", true},
+		{"1000", false},
+		{"1000:0", false},
+		{"app", false},
+	}
+	for _, test := range tests {
+		t.Run(test.user, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, IsRootUser(test.user))
+		})
+	}
+}
+
+func TestPortMatches(t *testing.T) {
+	var tests = []struct {
+		port     string
+		pattern  string
+		expected bool
+	}{
+		{"23/tcp", "23", true},
+		{"23/udp", "23", true},
+		{"23", "23/tcp", true},
+		{"23/tcp", "23/tcp", true},
+		{"23/udp", "23/tcp", false},
+		{"2323/tcp", "23", false},
+		{"80/tcp", "23", false},
+	}
+	for _, test := range tests {
+		t.Run(test.port+" "+test.pattern, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, PortMatches(test.port, test.pattern))
+		})
+	}
+}
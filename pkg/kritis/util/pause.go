@@ -0,0 +1,50 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/grafeas/kritis/pkg/kritis/constants"
+	"github.com/sirupsen/logrus"
+)
+
+// pauseImages are the pause images in use
+var pauseImages = constants.PauseImages
+
+// SetPauseImages overrides the default constants.PauseImages
+func SetPauseImages(images []string) {
+	pauseImages = images
+}
+
+// IsPauseImage returns true if image is any tag or digest of a pause image
+func IsPauseImage(image string) bool {
+	imageRepo, err := normalizedRepository(image)
+	if err != nil {
+		logrus.Errorf("couldn't check if %s is a pause image: %v", image, err)
+		return false
+	}
+	for _, p := range pauseImages {
+		pauseRepo, err := normalizedRepository(p)
+		if err != nil {
+			logrus.Errorf("couldn't parse pause image %s: %v", p, err)
+			continue
+		}
+		if pauseRepo == imageRepo {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func Test_IsPauseImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		expected bool
+	}{
+		{"tagged pause image", "registry.k8s.io/pause:3.9", true},
+		{"pause image by digest", "k8s.gcr.io/pause@sha256:0000000000000000000000000000000000000000000000000000000000000000", true},
+		{"another image in the registry", "registry.k8s.io/kube-proxy:v1.28.0", false},
+		{"image named pause elsewhere", "gcr.io/my-project/pause:3.9", false},
+		{"invalid image", "Not An Image", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, IsPauseImage(test.image))
+		})
+	}
+}
+
+func Test_SetPauseImages(t *testing.T) {
+	defer SetPauseImages(pauseImages)
+	SetPauseImages([]string{"gcr.io/my-project/sandbox"})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, true, IsPauseImage("gcr.io/my-project/sandbox:1.0"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, IsPauseImage("registry.k8s.io/pause:3.9"))
+}
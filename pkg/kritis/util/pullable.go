@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RegistryCredentials are the credentials to pull images from a registry with
+type RegistryCredentials struct {
+	Username string
+	Password string
+}
+
+// dockerConfigEntry is the entry of a registry in a Docker config file
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Auth is the base64 encoded username:password
+	Auth string `json:"auth"`
+}
+
+// ParseDockerConfig returns the credentials in data, the contents of a
+// .dockerconfigjson or legacy .dockercfg, such as those of imagePullSecrets,
+// by registry host
+func ParseDockerConfig(data []byte) (map[string]RegistryCredentials, error) {
+	config := struct {
+		Auths map[string]dockerConfigEntry `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	entries := config.Auths
+	if entries == nil {
+		// A .dockercfg is only the auths
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+	}
+	creds := map[string]RegistryCredentials{}
+	for host, e := range entries {
+		c := RegistryCredentials{Username: e.Username, Password: e.Password}
+		if e.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of %s: %v", host, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth of %s: not username:password", host)
+			}
+			c = RegistryCredentials{Username: parts[0], Password: parts[1]}
+		}
+		creds[registryHost(host)] = c
+	}
+	return creds, nil
+}
+
+// registryHost returns the host of a registry as a Docker config names it,
+// e.g. https://index.docker.io/v1/ or gcr.io
+func registryHost(registry string) string {
+	if strings.Contains(registry, "://") {
+		if u, err := url.Parse(registry); err == nil {
+			registry = u.Host
+		}
+	}
+	host := strings.SplitN(registry, "/", 2)[0]
+	if host == "docker.io" {
+		return name.DefaultRegistry
+	}
+	return host
+}
+
+// registryAuth returns the authenticator of the credentials in creds for the
+// registry host of repo, or the anonymous one if there are none
+func registryAuth(repo name.Repository, creds map[string]RegistryCredentials) authn.Authenticator {
+	if c, ok := creds[repo.RegistryStr()]; ok {
+		return &authn.Basic{Username: c.Username, Password: c.Password}
+	}
+	return authn.Anonymous
+}
+
+// CheckPullable returns an error if the manifest of image can't be fetched
+// from its registry, authenticating with the credentials in creds for its
+// registry host, if any. IsImageNotFound tells if it is because the image
+// doesn't exist.
+func CheckPullable(image string, creds map[string]RegistryCredentials) error {
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return err
+	}
+	img, err := remote.Image(ref, remote.WithTransport(registryTransport), remote.WithAuth(registryAuth(ref.Context(), creds)))
+	if err != nil {
+		return err
+	}
+	_, err = img.RawManifest()
+	return err
+}
+
+// IsImageNotFound returns true if err, returned by CheckPullable, means the
+// registry doesn't have the image or its repository, rather than that the
+// registry couldn't be reached or refused the request
+func IsImageNotFound(err error) bool {
+	if e, ok := err.(*remote.Error); ok {
+		for _, d := range e.Errors {
+			if d.Code == remote.ManifestUnknownErrorCode || d.Code == remote.NameUnknownErrorCode {
+				return true
+			}
+		}
+		return false
+	}
+	// Registries responding without a structured error only give the status
+	return err != nil && strings.HasPrefix(err.Error(), fmt.Sprintf("unsupported status code %d;", http.StatusNotFound))
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestParseDockerConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		shouldErr bool
+		expected  map[string]RegistryCredentials
+	}{
+		{
+			name: "dockerconfigjson",
+			data: `{"auths": {"https://index.docker.io/v1/": {"auth": "dXNlcjpwYTpzcw=="}, "gcr.io": {"username": "_json_key", "password": "key"}}}`,
+			expected: map[string]RegistryCredentials{
+				"index.docker.io": {Username: "user", Password: "pa:ss"},
+				"gcr.io":          {Username: "_json_key", Password: "key"},
+			},
+		},
+		{
+			name: "dockercfg",
+			data: `{"docker.io": {"username": "user", "password": "pass"}, "registry.example.com:5000": {"auth": "dXNlcjpwYXNz"}}`,
+			expected: map[string]RegistryCredentials{
+				"index.docker.io":           {Username: "user", Password: "pass"},
+				"registry.example.com:5000": {Username: "user", Password: "pass"},
+			},
+		},
+		{
+			name:      "invalid auth",
+			data:      `{"auths": {"gcr.io": {"auth": "dXNlcg=="}}}`,
+			shouldErr: true,
+		},
+		{
+			name:      "not JSON",
+			data:      `auths`,
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds, err := ParseDockerConfig([]byte(test.data))
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, creds)
+		})
+	}
+}
+
+func TestCheckPullable(t *testing.T) {
+	manifest := `{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json", "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": 2, "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}, "layers": []}`
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/team/app/manifests/v1":
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			fmt.Fprint(w, manifest)
+		case "/v2/team/plain/manifests/v1":
+			w.WriteHeader(http.StatusNotFound)
+		case "/v2/team/broken/manifests/v1":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": [{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}]}`)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	creds := map[string]RegistryCredentials{host: {Username: "user", Password: "pass"}}
+
+	tests := []struct {
+		name      string
+		image     string
+		creds     map[string]RegistryCredentials
+		shouldErr bool
+		notFound  bool
+	}{
+		{
+			name:  "existing image",
+			image: host + "/team/app:v1",
+			creds: creds,
+		},
+		{
+			name:      "nonexistent image",
+			image:     host + "/team/app:v2",
+			creds:     creds,
+			shouldErr: true,
+			notFound:  true,
+		},
+		{
+			name:      "nonexistent image without a structured error",
+			image:     host + "/team/plain:v1",
+			creds:     creds,
+			shouldErr: true,
+			notFound:  true,
+		},
+		{
+			name:      "registry error",
+			image:     host + "/team/broken:v1",
+			creds:     creds,
+			shouldErr: true,
+		},
+		{
+			name:      "without credentials",
+			image:     host + "/team/app:v1",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckPullable(test.image, test.creds)
+			testutil.CheckError(t, test.shouldErr, err)
+			if IsImageNotFound(err) != test.notFound {
+				t.Errorf("expected IsImageNotFound to be %t for %v", test.notFound, err)
+			}
+		})
+	}
+}
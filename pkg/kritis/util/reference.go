@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const digestDelim = "@"
+
+// NormalizeImage returns the canonical form of an image reference, so that
+// equivalent references compare equal wherever images are matched or keyed.
+// The registry defaults to index.docker.io, official images get the library
+// namespace, a missing tag becomes latest, and when both a tag and a digest
+// are present only the digest is kept, e.g.
+//
+//	nginx                               -> index.docker.io/library/nginx:latest
+//	gcr.io/p/img:tag@sha256:<digest>    -> gcr.io/p/img@sha256:<digest>
+func NormalizeImage(image string) (string, error) {
+	parts := strings.Split(image, digestDelim)
+	if len(parts) > 2 {
+		return "", name.NewErrBadName("%s contains more than one %q", image, digestDelim)
+	}
+	// Parsing the part before the digest as a tag drops any tag it has
+	tag, err := name.NewTag(parts[0], name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 1 {
+		return tag.Name(), nil
+	}
+	digest, err := name.NewDigest(tag.Context().Name()+digestDelim+parts[1], name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	return digest.Name(), nil
+}
+
+// IsDigestReference returns true if image is pinned to a digest, with or
+// without a tag, e.g. gcr.io/p/img@sha256:<digest> or gcr.io/p/img:tag@sha256:<digest>
+func IsDigestReference(image string) bool {
+	if !strings.Contains(image, digestDelim) {
+		return false
+	}
+	_, err := NormalizeImage(image)
+	return err == nil
+}
+
+// InRegistries returns true if image is in any of registries, each of which
+// is a registry such as gcr.io, or a repository prefix starting with its
+// registry such as gcr.io/my-project
+func InRegistries(image string, registries []string) bool {
+	repository, err := normalizedRepository(image)
+	if err != nil {
+		return false
+	}
+	for _, r := range registries {
+		parts := strings.SplitN(strings.TrimSuffix(r, "/"), "/", 2)
+		registry, err := name.NewRegistry(parts[0], name.WeakValidation)
+		if err != nil {
+			continue
+		}
+		prefix := registry.Name()
+		if len(parts) == 2 {
+			prefix += "/" + parts[1]
+		}
+		if repository == prefix || strings.HasPrefix(repository, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+const testDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+func TestNormalizeImage(t *testing.T) {
+	var tests = []struct {
+		name      string
+		image     string
+		expected  string
+		shouldErr bool
+	}{
+		{
+			name:     "implicit latest tag",
+			image:    "gcr.io/p/img",
+			expected: "gcr.io/p/img:latest",
+		},
+		{
+			name:     "explicit tag",
+			image:    "gcr.io/p/img:latest",
+			expected: "gcr.io/p/img:latest",
+		},
+		{
+			name:     "implicit docker.io host and library namespace",
+			image:    "nginx",
+			expected: "index.docker.io/library/nginx:latest",
+		},
+		{
+			name:     "docker.io alias",
+			image:    "docker.io/library/nginx",
+			expected: "index.docker.io/library/nginx:latest",
+		},
+		{
+			name:     "implicit docker.io host with namespace",
+			image:    "grafeas/kritis:v1",
+			expected: "index.docker.io/grafeas/kritis:v1",
+		},
+		{
+			name:     "registry with port",
+			image:    "localhost:5000/img",
+			expected: "localhost:5000/img:latest",
+		},
+		{
+			name:     "digest",
+			image:    "gcr.io/p/img@" + testDigest,
+			expected: "gcr.io/p/img@" + testDigest,
+		},
+		{
+			name:     "tag and digest keeps digest",
+			image:    "gcr.io/p/img:tag@" + testDigest,
+			expected: "gcr.io/p/img@" + testDigest,
+		},
+		{
+			name:     "library image by digest",
+			image:    "nginx@" + testDigest,
+			expected: "index.docker.io/library/nginx@" + testDigest,
+		},
+		{
+			name:      "invalid digest",
+			image:     "gcr.io/p/img@sha256:123",
+			shouldErr: true,
+		},
+		{
+			name:      "empty",
+			image:     "",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := NormalizeImage(test.image)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, actual)
+		})
+	}
+}
+
+func TestIsDigestReference(t *testing.T) {
+	var tests = []struct {
+		name     string
+		image    string
+		expected bool
+	}{
+		{
+			name:     "tag",
+			image:    "gcr.io/p/img:tag",
+			expected: false,
+		},
+		{
+			name:     "implicit latest tag",
+			image:    "gcr.io/p/img",
+			expected: false,
+		},
+		{
+			name:     "digest",
+			image:    "gcr.io/p/img@" + testDigest,
+			expected: true,
+		},
+		{
+			name:     "tag and digest",
+			image:    "gcr.io/p/img:tag@" + testDigest,
+			expected: true,
+		},
+		{
+			name:     "invalid digest",
+			image:    "gcr.io/p/img@sha256:0000",
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := IsDigestReference(test.image); actual != test.expected {
+				t.Errorf("expected IsDigestReference(%s) to be %t, got %t", test.image, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestInRegistries(t *testing.T) {
+	registries := []string{"gcr.io/my-project", "docker.io/library", "registry.example.com/"}
+	var tests = []struct {
+		name     string
+		image    string
+		expected bool
+	}{
+		{
+			name:     "repository in a prefix",
+			image:    "gcr.io/my-project/app@" + testDigest,
+			expected: true,
+		},
+		{
+			name:     "nested repository in a prefix",
+			image:    "gcr.io/my-project/team/app:tag",
+			expected: true,
+		},
+		{
+			name:     "repository sharing a prefix's name",
+			image:    "gcr.io/my-project-fork/app:tag",
+			expected: false,
+		},
+		{
+			name:     "other project in the registry",
+			image:    "gcr.io/other-project/app:tag",
+			expected: false,
+		},
+		{
+			name:     "official image",
+			image:    "nginx",
+			expected: true,
+		},
+		{
+			name:     "docker hub user image",
+			image:    "someone/nginx",
+			expected: false,
+		},
+		{
+			name:     "registry",
+			image:    "registry.example.com/anything/app:tag",
+			expected: true,
+		},
+		{
+			name:     "invalid image",
+			image:    "gcr.io/my-project/UPPER",
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := InRegistries(test.image, registries); actual != test.expected {
+				t.Errorf("expected InRegistries(%s) to be %t, got %t", test.image, test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ImageSize returns the size in bytes of image, which is referenced by
+// digest, as the total size of its config and compressed layers according
+// to its manifest in its registry
+func ImageSize(image string) (int64, error) {
+	digest, err := name.NewDigest(image, name.WeakValidation)
+	if err != nil {
+		return 0, err
+	}
+	img, err := RemoteImage(digest)
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	return ManifestSize(manifest), nil
+}
+
+// ImageLayerCount returns the number of layers of image, which is referenced
+// by digest, according to its manifest in its registry
+func ImageLayerCount(image string) (int, error) {
+	digest, err := name.NewDigest(image, name.WeakValidation)
+	if err != nil {
+		return 0, err
+	}
+	img, err := RemoteImage(digest)
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	return len(manifest.Layers), nil
+}
+
+// ManifestSize returns the total size in bytes of the config and layers of manifest
+func ManifestSize(manifest *v1.Manifest) int64 {
+	size := manifest.Config.Size
+	for _, l := range manifest.Layers {
+		size += l.Size
+	}
+	return size
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestManifestSize(t *testing.T) {
+	manifest := &v1.Manifest{
+		Config: v1.Descriptor{Size: 1000},
+		Layers: []v1.Descriptor{{Size: 20000}, {Size: 300}},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, int64(21300), ManifestSize(manifest))
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RegistryTransportOptions configures how registries are reached
+type RegistryTransportOptions struct {
+	// CAFile is the file with the PEM encoded CAs registries' certificates
+	// may be signed by, in addition to the system's, e.g. the CA of a proxy
+	// intercepting TLS. If empty, only the system's are trusted.
+	CAFile string
+	// Proxy returns the proxy to reach a registry through, as in
+	// http.Transport. If nil, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	// environment variables apply.
+	Proxy func(*http.Request) (*url.URL, error)
+}
+
+// registryTransport is the transport manifests and configs are fetched from
+// registries with
+var registryTransport http.RoundTripper = http.DefaultTransport
+
+// SetRegistryTransport overrides the transport used to reach registries
+func SetRegistryTransport(t http.RoundTripper) {
+	registryTransport = t
+}
+
+// registryCredentials are the credentials the webhook authenticates to
+// registries with, by registry host
+var registryCredentials map[string]RegistryCredentials
+
+// SetRegistryCredentials sets the credentials images are fetched from
+// registries with by RemoteImage, by registry host. Registries without
+// credentials are reached anonymously.
+func SetRegistryCredentials(creds map[string]RegistryCredentials) {
+	registryCredentials = creds
+}
+
+// RegistryTransport returns the transport used to reach registries, and
+// services alongside them such as Notary servers
+func RegistryTransport() http.RoundTripper {
+	return registryTransport
+}
+
+// NewRegistryTransport returns a transport to reach registries with
+func NewRegistryTransport(o RegistryTransportOptions) (*http.Transport, error) {
+	proxy := o.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	// The same settings as http.DefaultTransport
+	t := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if o.CAFile == "" {
+		return t, nil
+	}
+	data, err := ioutil.ReadFile(o.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading registry CA file: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in registry CA file %s", o.CAFile)
+	}
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return t, nil
+}
+
+// timeoutTransport cancels each request of base, including reading its
+// response, after timeout
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// NewTimeoutTransport returns a transport making requests with base, each of
+// which, including reading its response, is canceled after timeout. It bounds
+// registry calls made without a context.
+func NewTimeoutTransport(base http.RoundTripper, timeout time.Duration) http.RoundTripper {
+	return &timeoutTransport{base: base, timeout: timeout}
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelingBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelingBody cancels the context of its request once closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// RemoteImage returns the image at ref in its registry, reached with the
+// registry transport and authenticated with the registry credentials
+func RemoteImage(ref name.Reference) (v1.Image, error) {
+	return remote.Image(ref, remote.WithTransport(registryTransport), remote.WithAuth(registryAuth(ref.Context(), registryCredentials)))
+}
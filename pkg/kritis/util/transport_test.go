@@ -0,0 +1,232 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+// connectProxy tunnels CONNECT requests to target, whatever host they are
+// for, and records the hosts
+type connectProxy struct {
+	target string
+	mu     sync.Mutex
+	hosts  []string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.hosts = append(p.hosts, r.Host)
+	p.mu.Unlock()
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+func TestRegistryTransport(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/app/manifests/v1":
+			w.Write(manifest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	proxy := &connectProxy{target: registry.Listener.Addr().String()}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+	proxyURL, err := url.Parse(proxyServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "registry-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: registry.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	original := registryTransport
+	defer SetRegistryTransport(original)
+
+	var tests = []struct {
+		name      string
+		caFile    string
+		shouldErr bool
+		expected  string
+	}{
+		{
+			name:     "trusts the custom CA",
+			caFile:   caFile,
+			expected: "example.com/app@sha256:bafebd36189ad3688b7b3915ea55d461e0bfcfbdde11e54b0a123999fb6be50f",
+		},
+		{
+			name:      "without the custom CA",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy.hosts = nil
+			transport, err := NewRegistryTransport(RegistryTransportOptions{
+				CAFile: test.caFile,
+				Proxy:  http.ProxyURL(proxyURL),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetRegistryTransport(transport)
+			// The test server's certificate is for example.com, which the
+			// proxy tunnels to it
+			digest, err := ResolveDigest("example.com/app:v1")
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, digest)
+			if len(proxy.hosts) == 0 || proxy.hosts[0] != "example.com:443" {
+				t.Errorf("expected requests to go through the proxy, it got %v", proxy.hosts)
+			}
+		})
+	}
+}
+
+func TestRegistryTransportInvalidCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRegistryTransport(RegistryTransportOptions{CAFile: caFile})
+	testutil.CheckError(t, true, err)
+	_, err = NewRegistryTransport(RegistryTransportOptions{CAFile: filepath.Join(dir, "missing.pem")})
+	testutil.CheckError(t, true, err)
+}
+
+func TestTimeoutTransport(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-done
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	defer close(done)
+	client := &http.Client{Transport: NewTimeoutTransport(http.DefaultTransport, 50*time.Millisecond)}
+
+	resp, err := client.Get(server.URL + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	testutil.CheckErrorAndDeepEqual(t, false, err, "ok", string(body))
+
+	_, err = client.Get(server.URL + "/slow")
+	testutil.CheckError(t, true, err)
+}
+
+func TestRemoteImageCredentials(t *testing.T) {
+	manifest := `{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json", "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": 2, "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}, "layers": []}`
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/team/app/manifests/v1":
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			io.WriteString(w, manifest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	ref, err := name.ParseReference(host+"/team/app:v1", name.WeakValidation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetRegistryCredentials(nil)
+
+	for _, test := range []struct {
+		name      string
+		creds     map[string]RegistryCredentials
+		shouldErr bool
+	}{
+		{
+			name:  "with credentials",
+			creds: map[string]RegistryCredentials{host: {Username: "user", Password: "pass"}},
+		},
+		{
+			name:      "without credentials",
+			shouldErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			SetRegistryCredentials(test.creds)
+			img, err := RemoteImage(ref)
+			if err == nil {
+				_, err = img.RawManifest()
+			}
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
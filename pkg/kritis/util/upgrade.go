@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// tagListTTL is how long the tags of a repository are reused for
+	tagListTTL = 10 * time.Minute
+	// tagListTimeout bounds each request listing the tags of a repository,
+	// as upgrade suggestions are made while admitting pods
+	tagListTimeout = 2 * time.Second
+)
+
+// tagLists caches the tags of repositories, by repository and the user they
+// were listed as, since they may differ with the credentials
+var tagLists = &tagCache{entries: map[string]tagCacheEntry{}}
+
+type tagCache struct {
+	mu      sync.Mutex
+	entries map[string]tagCacheEntry
+}
+
+type tagCacheEntry struct {
+	tags    []string
+	expires time.Time
+}
+
+func (c *tagCache) get(key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.tags, true
+}
+
+func (c *tagCache) add(key string, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = tagCacheEntry{tags: tags, expires: time.Now().Add(tagListTTL)}
+}
+
+// versionPattern matches tags of the form [v]major.minor.patch[suffix], e.g.
+// 1.2.3, v1.2.3 or 1.2.3-alpine
+var versionPattern = regexp.MustCompile(`^(v?)(\d+)\.(\d+)\.(\d+)(.*)$`)
+
+// version is a tag parsed by versionPattern
+type version struct {
+	prefix, suffix      string
+	major, minor, patch int
+}
+
+func parseVersion(tag string) (*version, bool) {
+	m := versionPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, false
+	}
+	v := &version{prefix: m[1], suffix: m[5]}
+	for i, n := range []*int{&v.major, &v.minor, &v.patch} {
+		var err error
+		if *n, err = strconv.Atoi(m[i+2]); err != nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// patchOf returns true if v is a later patch release of the same major and
+// minor version as base, tagged the same way
+func (v *version) patchOf(base *version) bool {
+	return v.prefix == base.prefix && v.suffix == base.suffix &&
+		v.major == base.major && v.minor == base.minor && v.patch > base.patch
+}
+
+// NewerPatchTag returns the newest tag in the repository of image which is a
+// later patch release of the version image is tagged with, e.g. 1.2.5 for an
+// image tagged 1.2.3 or 1.2.4, or "" if there is none or image isn't tagged
+// with a version. Tags are listed through the registry transport, with the
+// credentials in creds for the image's registry host, if any, and reused for
+// tagListTTL.
+func NewerPatchTag(image string, creds map[string]RegistryCredentials) (string, error) {
+	// A tag pinned to a digest, e.g. gcr.io/app:1.2.3@sha256:..., still
+	// names the version
+	tag, err := name.NewTag(strings.SplitN(image, "@", 2)[0], name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	current, ok := parseVersion(tag.TagStr())
+	if !ok {
+		return "", nil
+	}
+	tags, err := listTags(tag.Context(), creds)
+	if err != nil {
+		return "", err
+	}
+	newest, newestTag := current, ""
+	for _, t := range tags {
+		if v, ok := parseVersion(t); ok && v.patchOf(newest) {
+			newest, newestTag = v, t
+		}
+	}
+	return newestTag, nil
+}
+
+// listTags returns the tags of repo, listed with the credentials in creds
+// for its registry host, from tagLists if they were listed recently
+func listTags(repo name.Repository, creds map[string]RegistryCredentials) ([]string, error) {
+	key := repo.String() + " " + creds[repo.RegistryStr()].Username
+	if tags, ok := tagLists.get(key); ok {
+		return tags, nil
+	}
+	tags, err := remote.List(repo, registryAuth(repo, creds), NewTimeoutTransport(registryTransport, tagListTimeout))
+	if err != nil {
+		return nil, err
+	}
+	tagLists.add(key, tags)
+	return tags, nil
+}
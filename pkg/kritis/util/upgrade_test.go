@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+)
+
+func TestNewerPatchTag(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/team/app/tags/list":
+			fmt.Fprint(w, `{"name": "team/app", "tags": ["latest", "1.2.3", "1.2.4", "1.2.10", "1.3.0", "2.0.0", "v1.2.5", "1.2.11-alpine", "1.2.3-alpine"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": [{"code": "NAME_UNKNOWN", "message": "repository name not known to registry"}]}`)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+
+	tests := []struct {
+		name      string
+		image     string
+		expected  string
+		shouldErr bool
+	}{
+		{
+			name:     "newer patch release",
+			image:    host + "/team/app:1.2.3",
+			expected: "1.2.10",
+		},
+		{
+			name:     "tag pinned to a digest",
+			image:    host + "/team/app:1.2.4@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			expected: "1.2.10",
+		},
+		{
+			name:  "newest patch release",
+			image: host + "/team/app:1.2.10",
+		},
+		{
+			name:     "tagged with a suffix",
+			image:    host + "/team/app:1.2.3-alpine",
+			expected: "1.2.11-alpine",
+		},
+		{
+			name:     "tagged with a v prefix",
+			image:    host + "/team/app:v1.2.3",
+			expected: "v1.2.5",
+		},
+		{
+			name:  "not tagged with a version",
+			image: host + "/team/app:latest",
+		},
+		{
+			name:  "referenced by digest",
+			image: host + "/team/app@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		{
+			name:      "unknown repository",
+			image:     host + "/team/other:1.0.0",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tag, err := NewerPatchTag(test.image, nil)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, tag)
+		})
+	}
+}
+
+func TestNewerPatchTagCredentials(t *testing.T) {
+	lists := 0
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/team/app/tags/list":
+			lists++
+			fmt.Fprint(w, `{"name": "team/app", "tags": ["1.2.3", "1.2.4"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	image := host + "/team/app:1.2.3"
+	creds := map[string]RegistryCredentials{host: {Username: "user", Password: "pass"}}
+
+	for i := 0; i < 2; i++ {
+		tag, err := NewerPatchTag(image, creds)
+		testutil.CheckErrorAndDeepEqual(t, false, err, "1.2.4", tag)
+	}
+	// The tags are listed once, and reused
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, lists)
+	// but not for anonymous callers
+	if _, err := NewerPatchTag(image, nil); err == nil {
+		t.Error("expected an error listing tags without credentials")
+	}
+}
@@ -20,9 +20,21 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/grafeas/kritis/pkg/kritis/constants"
 	"github.com/sirupsen/logrus"
-	"reflect"
 )
 
+// globalWhitelist is the list of whitelisted images in use
+var globalWhitelist = constants.GlobalImageWhitelist
+
+// SetGlobalWhitelist overrides the default constants.GlobalImageWhitelist
+func SetGlobalWhitelist(images []string) {
+	globalWhitelist = images
+}
+
+// GlobalWhitelist returns the list of whitelisted images in use
+func GlobalWhitelist() []string {
+	return globalWhitelist
+}
+
 // CheckGlobalWhitelist returns true if all images are globally whitelisted
 func CheckGlobalWhitelist(images []string) bool {
 	for _, image := range images {
@@ -38,19 +50,33 @@ func CheckGlobalWhitelist(images []string) bool {
 }
 
 func imageInWhitelist(image string) (bool, error) {
-	for _, w := range constants.GlobalImageWhitelist {
-		whitelistRef, err := name.ParseReference(w, name.WeakValidation)
-		if err != nil {
-			return false, err
-		}
-		imageRef, err := name.ParseReference(image, name.WeakValidation)
+	imageRepo, err := normalizedRepository(image)
+	if err != nil {
+		return false, err
+	}
+	for _, w := range globalWhitelist {
+		whitelistRepo, err := normalizedRepository(w)
 		if err != nil {
 			return false, err
 		}
 		// Make sure images have the same context
-		if reflect.DeepEqual(whitelistRef.Context(), imageRef.Context()) {
+		if whitelistRepo == imageRepo {
 			return true, nil
 		}
 	}
 	return false, nil
 }
+
+// normalizedRepository returns the canonical repository of image, without
+// its tag or digest
+func normalizedRepository(image string) (string, error) {
+	normalized, err := NormalizeImage(image)
+	if err != nil {
+		return "", err
+	}
+	ref, err := name.ParseReference(normalized, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Name(), nil
+}
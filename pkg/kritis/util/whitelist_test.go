@@ -75,3 +75,30 @@ func Test_imageInWhitelist(t *testing.T) {
 		})
 	}
 }
+
+func Test_SetGlobalWhitelist(t *testing.T) {
+	defer SetGlobalWhitelist(globalWhitelist)
+	SetGlobalWhitelist([]string{"gcr.io/my-project/kritis-server"})
+	tests := []struct {
+		name     string
+		image    string
+		expected bool
+	}{
+		{
+			name:     "overridden image",
+			image:    "gcr.io/my-project/kritis-server:tag",
+			expected: true,
+		},
+		{
+			name:     "default image",
+			image:    "gcr.io/kritis-project/kritis-server:tag",
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := CheckGlobalWhitelist([]string{test.image})
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, actual)
+		})
+	}
+}
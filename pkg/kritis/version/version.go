@@ -0,0 +1,33 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+// These are set at build time with -ldflags "-X"
+var (
+	version = "unknown"
+	commit  = "unknown"
+)
+
+// Version returns the version kritis was built from
+func Version() string {
+	return version
+}
+
+// Commit returns the git commit kritis was built from
+func Commit() string {
+	return commit
+}
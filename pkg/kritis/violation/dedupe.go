@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// DedupeStrategy hands violations to another Strategy once per image digest
+// within a window, suppressing identical violations of the same digest in
+// other pods, e.g. of every replica of a deployment. Different violations
+// of a digest, such as a newly found CVE, are handed on.
+type DedupeStrategy struct {
+	strategy Strategy
+	now      func() time.Time
+	mu       sync.Mutex
+	window   time.Duration
+	// handled maps the digests and violations handed on to when
+	handled map[string]time.Time
+}
+
+// NewDedupeStrategy returns a DedupeStrategy handing violations to strategy,
+// which suppresses no repeats until a window is set
+func NewDedupeStrategy(strategy Strategy) *DedupeStrategy {
+	return &DedupeStrategy{
+		strategy: strategy,
+		now:      time.Now,
+		handled:  map[string]time.Time{},
+	}
+}
+
+// SetWindow sets how long repeats of violations handed on are suppressed.
+// 0 suppresses none.
+func (d *DedupeStrategy) SetWindow(window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.window = window
+}
+
+// HandleViolation hands the violations of image in pod on, unless identical
+// violations of its digest were handed on within the window
+func (d *DedupeStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	if d.suppress(dedupeKey(image, violations)) {
+		logrus.Debugf("suppressing repeated violations of %s in pod %s, ns %s", image, pod.Name, pod.Namespace)
+		return nil
+	}
+	return d.strategy.HandleViolation(image, pod, violations)
+}
+
+// suppress returns true if key was handled within the window, and records
+// it as handled now otherwise
+func (d *DedupeStrategy) suppress(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.window <= 0 {
+		return false
+	}
+	now := d.now()
+	for k, t := range d.handled {
+		if now.Sub(t) >= d.window {
+			delete(d.handled, k)
+		}
+	}
+	if _, ok := d.handled[key]; ok {
+		return true
+	}
+	d.handled[key] = now
+	return false
+}
+
+// dedupeKey identifies violations of image, which is referenced by digest
+func dedupeKey(image string, violations []securitypolicy.SecurityPolicyViolation) string {
+	key := []string{image}
+	for _, v := range violations {
+		key = append(key, string(v.Reason))
+	}
+	return strings.Join(key, "\n")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+)
+
+// countingStrategy counts the violations handed to it per image
+type countingStrategy struct {
+	handled map[string]int
+}
+
+func (c *countingStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	c.handled[image]++
+	return nil
+}
+
+func TestDedupeStrategy(t *testing.T) {
+	image := testutil.QualifiedImage
+	cve := []securitypolicy.SecurityPolicyViolation{{
+		Violation: securitypolicy.ExceedsMaxSeverityViolation,
+		Reason:    "found CVE-1",
+	}}
+	otherCVE := []securitypolicy.SecurityPolicyViolation{{
+		Violation: securitypolicy.ExceedsMaxSeverityViolation,
+		Reason:    "found CVE-2",
+	}}
+	var tests = []struct {
+		name   string
+		window time.Duration
+		// violations are handled one minute apart
+		violations [][]securitypolicy.SecurityPolicyViolation
+		expected   int
+	}{
+		{
+			name:       "repeats within the window",
+			window:     time.Hour,
+			violations: [][]securitypolicy.SecurityPolicyViolation{cve, cve, cve, cve},
+			expected:   1,
+		},
+		{
+			name:       "repeats after the window",
+			window:     2 * time.Minute,
+			violations: [][]securitypolicy.SecurityPolicyViolation{cve, cve, cve, cve},
+			expected:   2,
+		},
+		{
+			name:       "different violations",
+			window:     time.Hour,
+			violations: [][]securitypolicy.SecurityPolicyViolation{cve, otherCVE, cve},
+			expected:   2,
+		},
+		{
+			name:       "no window",
+			violations: [][]securitypolicy.SecurityPolicyViolation{cve, cve, cve},
+			expected:   3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := &countingStrategy{handled: map[string]int{}}
+			d := NewDedupeStrategy(counter)
+			now := time.Now()
+			d.now = func() time.Time { return now }
+			d.SetWindow(test.window)
+			for i, v := range test.violations {
+				pod := &v1.Pod{}
+				pod.Name = fmt.Sprintf("pod-%d", i)
+				if err := d.HandleViolation(image, pod, v); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(time.Minute)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, counter.handled[image])
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultQueueSize is how many violations a QueueStrategy buffers
+	DefaultQueueSize = 100
+	// DefaultQueueWorkers is how many violations a QueueStrategy handles at once
+	DefaultQueueWorkers = 2
+)
+
+type violationJob struct {
+	image      string
+	pod        *v1.Pod
+	violations []securitypolicy.SecurityPolicyViolation
+}
+
+// QueueStrategy hands violations to another Strategy in background workers,
+// so that a slow notification doesn't delay the admission decision.
+// The queue is bounded; when it is full, the oldest violation is dropped.
+type QueueStrategy struct {
+	strategy Strategy
+	jobs     chan violationJob
+	workers  int
+	once     sync.Once
+	// mu serializes making room in jobs, so concurrent senders don't each
+	// drop a violation to make room for one
+	mu sync.Mutex
+}
+
+// NewQueueStrategy returns a QueueStrategy buffering up to size violations
+// for workers background workers handling them with strategy
+func NewQueueStrategy(strategy Strategy, size int, workers int) *QueueStrategy {
+	return &QueueStrategy{
+		strategy: strategy,
+		jobs:     make(chan violationJob, size),
+		workers:  workers,
+	}
+}
+
+// HandleViolation queues the violations of image in pod and returns
+// immediately, starting the workers on first use
+func (q *QueueStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	q.once.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			go q.work()
+		}
+	})
+	// The caller may modify pod and violations once this returns
+	job := violationJob{
+		image:      image,
+		pod:        pod.DeepCopy(),
+		violations: append([]securitypolicy.SecurityPolicyViolation{}, violations...),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		select {
+		case q.jobs <- job:
+			return nil
+		default:
+		}
+		select {
+		case dropped := <-q.jobs:
+			logrus.Errorf("violation queue is full, dropping violations of %s in pod %s", dropped.image, dropped.pod.Name)
+		default:
+		}
+	}
+}
+
+func (q *QueueStrategy) work() {
+	for job := range q.jobs {
+		if err := q.strategy.HandleViolation(job.image, job.pod, job.violations); err != nil {
+			logrus.Errorf("error handling violations of %s in pod %s: %v", job.image, job.pod.Name, err)
+		}
+	}
+}
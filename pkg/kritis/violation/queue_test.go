@@ -0,0 +1,78 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+)
+
+// blockingStrategy records violations once release is closed
+type blockingStrategy struct {
+	release chan struct{}
+	handled chan string
+}
+
+func newBlockingStrategy() *blockingStrategy {
+	return &blockingStrategy{
+		release: make(chan struct{}),
+		handled: make(chan string, 10),
+	}
+}
+
+func (b *blockingStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	<-b.release
+	b.handled <- image
+	return nil
+}
+
+func TestQueueStrategy(t *testing.T) {
+	b := newBlockingStrategy()
+	q := NewQueueStrategy(b, 10, 1)
+	done := make(chan struct{})
+	go func() {
+		q.HandleViolation("image", &v1.Pod{}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("HandleViolation waited on the slow strategy")
+	}
+	close(b.release)
+	select {
+	case image := <-b.handled:
+		testutil.CheckErrorAndDeepEqual(t, false, nil, "image", image)
+	case <-time.After(5 * time.Second):
+		t.Fatal("violation was never handled")
+	}
+}
+
+func TestQueueStrategyDropsOldest(t *testing.T) {
+	b := newBlockingStrategy()
+	// No workers, so queued violations stay queued
+	q := NewQueueStrategy(b, 2, 0)
+	for _, image := range []string{"a", "b", "c"} {
+		q.HandleViolation(image, &v1.Pod{}, nil)
+	}
+	queued := []string{(<-q.jobs).image, (<-q.jobs).image}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"b", "c"}, queued)
+}
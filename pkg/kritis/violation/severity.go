@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	ca "google.golang.org/genproto/googleapis/devtools/containeranalysis/v1alpha1"
+	"k8s.io/api/core/v1"
+)
+
+// Route hands violations whose maximum severity is at least MinSeverity to
+// Strategy
+type Route struct {
+	MinSeverity string
+	Strategy    Strategy
+}
+
+type route struct {
+	minSeverity int32
+	strategy    Strategy
+}
+
+// SeverityStrategy hands violations to a Strategy depending on the maximum
+// severity of the vulnerabilities among them, e.g. to page for CRITICAL
+// vulnerabilities but only log MEDIUM ones. Violations which don't match any
+// Route, including those without vulnerabilities, are handed to the fallback.
+type SeverityStrategy struct {
+	fallback Strategy
+	mu       sync.RWMutex
+	// routes are sorted by decreasing minimum severity
+	routes []route
+}
+
+// NewSeverityStrategy returns a SeverityStrategy without routes, handing
+// every violation to fallback until routes are set
+func NewSeverityStrategy(fallback Strategy) *SeverityStrategy {
+	return &SeverityStrategy{fallback: fallback}
+}
+
+// ParseSeverity returns the value of a vulnerability severity name, such as
+// MEDIUM or CRITICAL
+func ParseSeverity(severity string) (int32, error) {
+	s, ok := ca.VulnerabilityType_Severity_value[strings.ToUpper(severity)]
+	if !ok {
+		return 0, fmt.Errorf("unknown severity %q", severity)
+	}
+	return s, nil
+}
+
+// SetRoutes replaces the routes of s. Violations are handed to the route
+// with the highest MinSeverity they reach.
+func (s *SeverityStrategy) SetRoutes(routes []Route) error {
+	parsed := []route{}
+	for _, r := range routes {
+		severity, err := ParseSeverity(r.MinSeverity)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, route{minSeverity: severity, strategy: r.Strategy})
+	}
+	sort.SliceStable(parsed, func(i, j int) bool {
+		return parsed[i].minSeverity > parsed[j].minSeverity
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = parsed
+	return nil
+}
+
+// HandleViolation hands the violations of image in pod to the strategy of
+// the route matching their maximum severity
+func (s *SeverityStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	return s.strategy(maxSeverity(violations)).HandleViolation(image, pod, violations)
+}
+
+func (s *SeverityStrategy) strategy(severity int32) Strategy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.routes {
+		if severity >= r.minSeverity {
+			return r.strategy
+		}
+	}
+	return s.fallback
+}
+
+// maxSeverity returns the highest severity of the vulnerabilities among
+// violations, or SEVERITY_UNSPECIFIED if there are none
+func maxSeverity(violations []securitypolicy.SecurityPolicyViolation) int32 {
+	max := int32(ca.VulnerabilityType_SEVERITY_UNSPECIFIED)
+	for _, v := range violations {
+		if s := ca.VulnerabilityType_Severity_value[v.Vulnerability.Severity]; s > max {
+			max = s
+		}
+	}
+	return max
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/metadata"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+)
+
+func TestSeverityStrategy(t *testing.T) {
+	vulnerability := func(severity string) securitypolicy.SecurityPolicyViolation {
+		return securitypolicy.SecurityPolicyViolation{
+			Vulnerability: metadata.Vulnerability{Severity: severity},
+			Violation:     securitypolicy.ExceedsMaxSeverityViolation,
+		}
+	}
+	var tests = []struct {
+		name       string
+		violations []securitypolicy.SecurityPolicyViolation
+		paged      bool
+		urgent     bool
+		logged     bool
+	}{
+		{
+			name:       "critical pages",
+			violations: []securitypolicy.SecurityPolicyViolation{vulnerability("MEDIUM"), vulnerability("CRITICAL")},
+			paged:      true,
+		},
+		{
+			name:       "high goes to the highest route it reaches",
+			violations: []securitypolicy.SecurityPolicyViolation{vulnerability("HIGH")},
+			urgent:     true,
+		},
+		{
+			name:       "medium only logs",
+			violations: []securitypolicy.SecurityPolicyViolation{vulnerability("MEDIUM")},
+			logged:     true,
+		},
+		{
+			name: "violations without vulnerabilities only log",
+			violations: []securitypolicy.SecurityPolicyViolation{
+				{Violation: securitypolicy.UnqualifiedImageViolation},
+			},
+			logged: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paging := &MemoryStrategy{Violations: map[string]bool{}}
+			urgent := &MemoryStrategy{Violations: map[string]bool{}}
+			logging := &MemoryStrategy{Violations: map[string]bool{}}
+			s := NewSeverityStrategy(logging)
+			err := s.SetRoutes([]Route{
+				{MinSeverity: "HIGH", Strategy: urgent},
+				{MinSeverity: "critical", Strategy: paging},
+			})
+			testutil.CheckError(t, false, err)
+			testutil.CheckError(t, false, s.HandleViolation("image", &v1.Pod{}, test.violations))
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.paged, paging.Violations["image"])
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.urgent, urgent.Violations["image"])
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.logged, logging.Violations["image"])
+		})
+	}
+}
+
+func TestSeverityStrategyUnknownSeverity(t *testing.T) {
+	s := NewSeverityStrategy(&LoggingStrategy{})
+	err := s.SetRoutes([]Route{{MinSeverity: "URGENT", Strategy: &LoggingStrategy{}}})
+	testutil.CheckError(t, true, err)
+}
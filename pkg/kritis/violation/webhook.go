@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"k8s.io/api/core/v1"
+)
+
+// webhookTimeout is how long a WebhookStrategy waits on the webhook
+const webhookTimeout = 10 * time.Second
+
+// Notification is the JSON body a WebhookStrategy posts
+type Notification struct {
+	Image      string   `json:"image"`
+	Namespace  string   `json:"namespace"`
+	Pod        string   `json:"pod"`
+	Violations []string `json:"violations"`
+}
+
+// WebhookStrategy posts violations as a Notification to a webhook, e.g. one
+// paging the on-call engineer
+type WebhookStrategy struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookStrategy returns a WebhookStrategy posting to url
+func NewWebhookStrategy(url string) *WebhookStrategy {
+	return &WebhookStrategy{
+		URL:    url,
+		Client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (w *WebhookStrategy) HandleViolation(image string, pod *v1.Pod, violations []securitypolicy.SecurityPolicyViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	n := Notification{
+		Image:      image,
+		Namespace:  pod.Namespace,
+		Pod:        pod.Name,
+		Violations: []string{},
+	}
+	for _, v := range violations {
+		n.Violations = append(n.Violations, string(v.Reason))
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package violation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafeas/kritis/pkg/kritis/crd/securitypolicy"
+	"github.com/grafeas/kritis/pkg/kritis/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWebhookStrategy(t *testing.T) {
+	notifications := []Notification{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := Notification{}
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		notifications = append(notifications, n)
+	}))
+	defer server.Close()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "prod"}}
+	violations := []securitypolicy.SecurityPolicyViolation{{Reason: "found CVE-2021-44228"}}
+	w := NewWebhookStrategy(server.URL)
+	testutil.CheckError(t, false, w.HandleViolation("image", pod, violations))
+	// Nothing is posted without violations
+	testutil.CheckError(t, false, w.HandleViolation("image", pod, nil))
+	expected := []Notification{{
+		Image:      "image",
+		Namespace:  "prod",
+		Pod:        "app",
+		Violations: []string{"found CVE-2021-44228"},
+	}}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, notifications)
+}
+
+func TestWebhookStrategyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	violations := []securitypolicy.SecurityPolicyViolation{{Reason: "found CVE-2021-44228"}}
+	testutil.CheckError(t, true, NewWebhookStrategy(server.URL).HandleViolation("image", &v1.Pod{}, violations))
+}